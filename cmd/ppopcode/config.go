@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ppopcode/ppopcode/internal/config"
+	"github.com/ppopcode/ppopcode/internal/secrets"
+	"golang.org/x/term"
+)
+
+// loadConfig loads and validates the configuration. Warnings are printed
+// and do not make it fail.
+func loadConfig(opts config.LoadOptions) (*config.Config, error) {
+	cfg, err := config.LoadLayers(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	for _, w := range cfg.Warnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	return cfg, nil
+}
+
+// printConfigError prints a config error with one problem per line
+func printConfigError(err error) {
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) {
+		fmt.Fprintf(os.Stderr, "Error: could not load config: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: the config has %d problem(s):\n", len(invalid.Problems))
+	for _, p := range invalid.Problems {
+		fmt.Fprintf(os.Stderr, "  %s\n", p)
+	}
+}
+
+const configUsage = `Usage:
+  ppopcode config validate
+  ppopcode config secret set <name>     (reads the value from stdin)
+  ppopcode config secret list
+  ppopcode config secret delete <name>`
+
+// runConfigCommand runs "ppopcode config <command>" and returns the exit code
+func runConfigCommand(args []string, opts config.LoadOptions) int {
+	switch {
+	case len(args) == 1 && args[0] == "validate":
+		return runValidate(opts)
+	case len(args) >= 2 && args[0] == "secret":
+		return runSecretCommand(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, configUsage)
+	return 2
+}
+
+// runValidate checks the config and lists the files it was read from
+func runValidate(opts config.LoadOptions) int {
+	if _, err := loadConfig(opts); err != nil {
+		printConfigError(err)
+		return 1
+	}
+
+	fmt.Println("Config is valid. Files read:")
+	for _, file := range []struct {
+		layer config.Layer
+		path  string
+	}{{config.LayerGlobal, opts.GlobalPath}, {config.LayerProject, opts.ProjectPath}} {
+		if file.path == "" {
+			continue
+		}
+		state := "not found"
+		if _, err := os.Stat(file.path); err == nil {
+			state = "ok"
+		}
+		fmt.Printf("  %-8s %s (%s)\n", file.layer, file.path, state)
+	}
+	return 0
+}
+
+// runSecretCommand manages the encrypted secrets store
+func runSecretCommand(args []string) int {
+	store := secrets.DefaultStore()
+	var err error
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		var names []string
+		if names, err = store.Names(); err == nil {
+			for _, name := range names {
+				fmt.Println(name)
+			}
+		}
+	case args[0] == "set" && len(args) == 2:
+		var value secrets.Secret
+		if value, err = readSecret(args[1]); err == nil {
+			if err = store.Set(args[1], value); err == nil {
+				fmt.Printf("Saved %s to %s. Use it with api_key_secret: %s\n", args[1], store.Path(), args[1])
+			}
+		}
+	case args[0] == "delete" && len(args) == 2:
+		if err = store.Delete(args[1]); err == nil {
+			fmt.Printf("Deleted %s\n", args[1])
+		}
+	default:
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readSecret reads a secret from the terminal without echoing it, or from
+// piped stdin
+func readSecret(name string) (secrets.Secret, error) {
+	var data []byte
+	var err error
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "Value for %s: ", name)
+		data, err = term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+	} else {
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", errors.New("no value given")
+	}
+	return secrets.Secret(value), nil
+}
@@ -1,40 +1,129 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ppopcode/ppopcode/internal/budget"
 	"github.com/ppopcode/ppopcode/internal/config"
+	"github.com/ppopcode/ppopcode/internal/cursor"
 	"github.com/ppopcode/ppopcode/internal/orchestrator"
 	"github.com/ppopcode/ppopcode/internal/session"
+	"github.com/ppopcode/ppopcode/internal/snapshot"
 	"github.com/ppopcode/ppopcode/internal/tui"
 )
 
+// configPollInterval is how often the config files are checked for changes
+const configPollInterval = 2 * time.Second
+
+// settingFlags collects repeated --set key=value flags
+type settingFlags []string
+
+func (f *settingFlags) String() string { return strings.Join(*f, ", ") }
+
+func (f *settingFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func main() {
-	// Get config path
+	var settings settingFlags
+	flag.Var(&settings, "set", "override a setting, e.g. --set agents.sonnet.model=claude-opus-4-5 (repeatable)")
+	record := flag.String("record", "", "record agent requests to this cassette file")
+	replay := flag.String("replay", "", "answer agent requests from this cassette file")
+	flag.Parse()
+
 	homeDir, _ := os.UserHomeDir()
-	configPath := filepath.Join(homeDir, ".ppopcode", "config.yaml")
+	workDir, _ := os.Getwd()
 
-	// Load configuration (will use defaults if file doesn't exist)
-	cfg, err := config.Load(configPath)
+	// Configuration layers, lowest first: built-in defaults,
+	// ~/.ppopcode/config.yaml, .ppopcode/config.yaml in the working
+	// directory, PPOPCODE_* environment variables and the flags
+	opts := config.DefaultLoadOptions(workDir)
+	if *record != "" || *replay != "" {
+		opts.Flags = append(opts.Flags, "cassette.record="+*record, "cassette.replay="+*replay)
+	}
+	opts.Flags = append(opts.Flags, settings...)
+
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "config" {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+			os.Exit(2)
+		}
+		os.Exit(runConfigCommand(args[1:], opts))
+	}
+
+	cfg, err := loadConfig(opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Could not load config: %v\n", err)
-		cfg = config.DefaultConfig()
+		printConfigError(err)
+		fmt.Fprintln(os.Stderr, "Fix the config and run 'ppopcode config validate' to check it.")
+		os.Exit(1)
 	}
 
 	// Initialize session manager
-	historyDir := filepath.Join(homeDir, cfg.Session.HistoryDir)
-	sess := session.NewManager(historyDir, cfg.Session.MaxHistory)
+	sess := session.NewManager(cfg.Session.Path(), cfg.Session.MaxHistory)
 
 	// Initialize orchestrator with agent configs
-	agentConfigs := cfg.ToAgentConfigs()
-	orch := orchestrator.New(agentConfigs)
+	orchSettings, err := cfg.ToSettings(workDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Could not load system prompts: %v\n", err)
+	}
+	orch := orchestrator.New(orchSettings.Agents)
+
+	// Planner/implementer/reviewer pipeline, optionally editing via Cursor
+	orch.SetPipeline(orchSettings.Pipeline)
+	orch.SetFallbacks(orchSettings.Fallbacks)
+	if err := orch.SetRoutes(orchSettings.Routes); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Could not set routes: %v\n", err)
+	}
+	orch.SetEditor(cursor.NewBridge(workDir))
+
+	// Record or replay agent requests, e.g. to reproduce a flaky workflow
+	// run. Validation has ruled out setting both.
+	switch cassette := cfg.Cassette; {
+	case cassette.Record != "":
+		if err := orch.Record(cassette.Record); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case cassette.Replay != "":
+		if err := orch.Replay(cassette.Replay, cassette.Realtime); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Enforce spending limits when any are configured
+	if limits := cfg.ToBudgetLimits(); !limits.IsZero() {
+		orch.SetBudget(budget.NewTracker(limits, filepath.Join(homeDir, ".ppopcode", "budget.json")))
+	}
 
 	// Create app with dependencies
 	app := tui.NewAppWithDeps(orch, sess, cfg)
 
+	// Snapshot the working tree before agent edits so /undo can restore it
+	if !cfg.Undo.Disabled {
+		snapshots, err := snapshot.Open(workDir, cfg.Undo.Keep)
+		switch {
+		case err == nil:
+			app.SetSnapshots(snapshots)
+		case !errors.Is(err, snapshot.ErrNotRepository):
+			fmt.Fprintf(os.Stderr, "Warning: Could not open undo snapshots: %v\n", err)
+		}
+	}
+
+	// Apply changes to the config files without a restart
+	ctx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	app.WatchConfig(cfg.Watch(ctx, configPollInterval), workDir)
+
 	// Create program with alt screen (full terminal takeover)
 	p := tea.NewProgram(
 		app,
@@ -2,36 +2,67 @@ package agents
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+
+	"github.com/ppopcode/ppopcode/internal/secrets"
 )
 
 type AgentType string
 
 const (
-	AgentTypeClaude AgentType = "claude"
+	AgentTypeClaude    AgentType = "claude"
+	AgentTypeClaudeAPI AgentType = "claude-api"
+	AgentTypeMock      AgentType = "mock" // replays a fixture, for tests
 )
 
+// ErrCancelled is returned when a request is stopped by context cancellation
+var ErrCancelled = errors.New("request cancelled")
+
 type AgentConfig struct {
 	Name     string
 	Type     AgentType
 	Model    string
-	APIKey   string
+	APIKey   secrets.Secret // printed as [redacted]
 	BaseURL  string
 	MaxTokens int
+
+	// SystemPrompt is sent with every request. The CLI appends it to its
+	// own system prompt unless ReplaceSystemPrompt is set.
+	SystemPrompt        string
+	ReplaceSystemPrompt bool
+
+	// Claude CLI permissions
+	PermissionMode  PermissionMode
+	AllowedTools    []string // e.g. "Read", "Bash(git diff:*)"
+	DisallowedTools []string
+	AddDirs         []string // directories outside the working directory Claude may access
+	MCPConfig       string   // path to an MCP servers config file
+
+	// MaxConcurrent is how many requests the orchestrator sends to the
+	// agent at once; further requests wait in a queue. 0 means 1.
+	MaxConcurrent int
+
+	// Fixture is the YAML or JSON script a mock agent replays
+	Fixture string
 }
 
 type Response struct {
 	Content    string
 	Model      string
 	TokensUsed int
+	Usage      Usage // token usage and cost, when reported by the agent
 	Error      error
 }
 
 // StreamChunk represents a chunk of streaming output
 type StreamChunk struct {
 	Content string
-	Type    string // "thinking", "output", "error", "status"
+	Type    string // "thinking", "output", "error", "status", "usage", "tool_use", "tool_result"
 	Done    bool
+	Usage   *Usage     // running usage of the request, for "usage" chunks
+	Tool    *ToolEvent // the tool call, for "tool_use" and "tool_result" chunks
 }
 
 type Agent interface {
@@ -46,6 +77,10 @@ func NewAgent(config AgentConfig) (Agent, error) {
 	switch config.Type {
 	case AgentTypeClaude:
 		return NewClaudeAgent(config)
+	case AgentTypeClaudeAPI:
+		return NewClaudeAPIAgent(config)
+	case AgentTypeMock:
+		return NewMockAgent(config)
 	default:
 		return nil, fmt.Errorf("unknown agent type: %s", config.Type)
 	}
@@ -53,7 +88,10 @@ func NewAgent(config AgentConfig) (Agent, error) {
 
 type BaseAgent struct {
 	config AgentConfig
-	status string
+
+	mu      sync.Mutex // guards status, running and config.Model
+	status  string
+	running int // requests in flight
 }
 
 func (a *BaseAgent) Name() string {
@@ -61,13 +99,45 @@ func (a *BaseAgent) Name() string {
 }
 
 func (a *BaseAgent) Model() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	return a.config.Model
 }
 
+// SetModel changes the model used for subsequent requests
+func (a *BaseAgent) SetModel(model string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.config.Model = model
+}
+
+// Status returns "processing" while requests are in flight, otherwise the
+// status last set with SetStatus
 func (a *BaseAgent) Status() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running > 0 {
+		return "processing"
+	}
 	return a.status
 }
 
 func (a *BaseAgent) SetStatus(status string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.status = status
 }
+
+// begin marks a request as in flight and returns the func that ends it.
+// Counting requests keeps the agent busy until the last of several
+// concurrent requests is done.
+func (a *BaseAgent) begin() func() {
+	a.mu.Lock()
+	a.running++
+	a.mu.Unlock()
+	return func() {
+		a.mu.Lock()
+		a.running--
+		a.mu.Unlock()
+	}
+}
@@ -1,12 +1,26 @@
 package agents
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestAgentTypes(t *testing.T) {
 	types := []AgentType{
 		AgentTypeClaude,
+		AgentTypeClaudeAPI,
+		AgentTypeMock,
 	}
 
 	for _, agentType := range types {
@@ -159,3 +173,769 @@ func TestStreamChunk(t *testing.T) {
 		t.Error("chunk.Done should be false")
 	}
 }
+
+func TestBaseAgentSetModel(t *testing.T) {
+	base := &BaseAgent{config: AgentConfig{Model: "old-model"}}
+
+	base.SetModel("new-model")
+	if base.Model() != "new-model" {
+		t.Errorf("Model() after SetModel = %q, want %q", base.Model(), "new-model")
+	}
+}
+
+func TestBaseAgentConcurrentRequests(t *testing.T) {
+	base := &BaseAgent{status: "ready"}
+
+	endFirst := base.begin()
+	endSecond := base.begin()
+	endFirst()
+	if base.Status() != "processing" {
+		t.Errorf("Status() with one request left = %q, want processing", base.Status())
+	}
+	endSecond()
+	if base.Status() != "ready" {
+		t.Errorf("Status() after all requests = %q, want ready", base.Status())
+	}
+}
+
+func TestClaudeAgentBuildArgs(t *testing.T) {
+	agent := &ClaudeAgent{
+		BaseAgent: BaseAgent{config: AgentConfig{Name: "test", Model: "claude-sonnet"}},
+	}
+
+	args := strings.Join(agent.buildArgs(context.Background(), "hello", "stream-json"), " ")
+	for _, want := range []string{"-p hello", "--output-format stream-json", "--verbose", "--continue", "--model claude-sonnet"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("buildArgs() = %q, missing %q", args, want)
+		}
+	}
+
+	agent.SetModel("")
+	args = strings.Join(agent.buildArgs(context.Background(), "hello", "text"), " ")
+	if strings.Contains(args, "--model") || strings.Contains(args, "--verbose") {
+		t.Errorf("buildArgs() = %q, should omit --model and --verbose", args)
+	}
+	if strings.Contains(args, "--permission-mode") || strings.Contains(args, "Tools") {
+		t.Errorf("buildArgs() = %q, should omit unset permission flags", args)
+	}
+}
+
+func TestClaudeAgentBuildArgsPermissions(t *testing.T) {
+	agent := &ClaudeAgent{
+		BaseAgent: BaseAgent{config: AgentConfig{
+			Name:            "test",
+			PermissionMode:  PermissionAcceptEdits,
+			AllowedTools:    []string{"Read", "Bash(git diff:*)"},
+			DisallowedTools: []string{"WebFetch"},
+			AddDirs:         []string{"../shared", "/tmp/docs"},
+			MCPConfig:       ".mcp.json",
+		}},
+	}
+
+	args := agent.buildArgs(context.Background(), "hello", "stream-json")
+	joined := strings.Join(args, " ")
+	for _, want := range []string{
+		"--permission-mode acceptEdits",
+		"--disallowedTools WebFetch",
+		"--add-dir ../shared /tmp/docs",
+		"--mcp-config .mcp.json",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("buildArgs() = %q, missing %q", joined, want)
+		}
+	}
+	// The allowlist is a single argument so patterns may contain spaces
+	found := false
+	for i, arg := range args {
+		if arg == "--allowedTools" && i+1 < len(args) && args[i+1] == "Read,Bash(git diff:*)" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("buildArgs() = %q, missing allowed tools", joined)
+	}
+
+	// Plan mode on the context overrides the configured mode
+	joined = strings.Join(agent.buildArgs(WithPermissionMode(context.Background(), PermissionPlan), "hello", "json"), " ")
+	if !strings.Contains(joined, "--permission-mode plan") || strings.Contains(joined, "acceptEdits") {
+		t.Errorf("buildArgs() with plan mode = %q", joined)
+	}
+}
+
+func TestParseClaudeStreamLineResult(t *testing.T) {
+	line := `{"type":"result","subtype":"success","result":"hi","total_cost_usd":0.0123,"usage":{"input_tokens":10,"output_tokens":20,"cache_creation_input_tokens":30,"cache_read_input_tokens":40}}`
+
+	chunks, usage := parseClaudeStreamLine(line, &toolCalls{})
+	if len(chunks) != 0 {
+		t.Errorf("result event should not produce output, got %+v", chunks)
+	}
+	if usage == nil {
+		t.Fatal("result event should report usage")
+	}
+	want := Usage{InputTokens: 10, OutputTokens: 20, CacheCreationTokens: 30, CacheReadTokens: 40, CostUSD: 0.0123, Requests: 1}
+	if *usage != want {
+		t.Errorf("usage = %+v, want %+v", *usage, want)
+	}
+
+	chunks, usage = parseClaudeStreamLine(`{"type":"assistant","message":{"content":[{"type":"text","text":"hello"}]}}`, &toolCalls{})
+	if len(chunks) != 1 || chunks[0].Content != "hello" || usage != nil {
+		t.Errorf("assistant event = %+v, %v; want text and no usage", chunks, usage)
+	}
+}
+
+func TestParseClaudeStreamLineTools(t *testing.T) {
+	tools := &toolCalls{}
+
+	chunks, _ := parseClaudeStreamLine(`{"type":"assistant","message":{"content":[{"type":"text","text":"Let me check."},{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"go test ./...\nls","description":"Run tests"}}]}}`, tools)
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks, want text and tool_use", len(chunks))
+	}
+	use := chunks[1]
+	if use.Type != "tool_use" || use.Tool == nil {
+		t.Fatalf("chunk = %+v, want tool_use", use)
+	}
+	if use.Tool.Name != "Bash" || use.Tool.Input != "go test ./..." || use.Tool.Status != ToolRunning {
+		t.Errorf("tool = %+v", *use.Tool)
+	}
+
+	chunks, _ = parseClaudeStreamLine(`{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"\nFAIL\tpkg","is_error":true}]}}`, tools)
+	if len(chunks) != 1 || chunks[0].Type != "tool_result" {
+		t.Fatalf("chunks = %+v, want one tool_result", chunks)
+	}
+	result := chunks[0].Tool
+	if result.Name != "Bash" || result.Input != "go test ./..." || result.Status != ToolFailed || result.Result != "FAIL\tpkg" {
+		t.Errorf("result = %+v", *result)
+	}
+
+	// Results may carry content blocks, and user events a plain string
+	tools.start(claudeContentBlock{ID: "toolu_2", Name: "Read", Input: []byte(`{"file_path":"main.go"}`)})
+	chunks, _ = parseClaudeStreamLine(`{"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"toolu_2","content":[{"type":"text","text":"package main"}]}]}}`, tools)
+	if len(chunks) != 1 || chunks[0].Tool.Status != ToolSucceeded || chunks[0].Content != "Read main.go" || chunks[0].Tool.Result != "package main" {
+		t.Errorf("chunks = %+v", chunks)
+	}
+	chunks, _ = parseClaudeStreamLine(`{"type":"user","message":{"content":"hello"}}`, tools)
+	if len(chunks) != 0 {
+		t.Errorf("plain user message should produce no chunks, got %+v", chunks)
+	}
+}
+
+func TestSummarizeToolInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"Read", `{"file_path":"internal/tui/chat.go"}`, "internal/tui/chat.go"},
+		{"Grep", `{"pattern":"func main","path":"cmd"}`, "func main in cmd"},
+		{"WebFetch", `{"url":"https://example.com","prompt":"summarize"}`, "https://example.com"},
+		{"TodoWrite", `{"todos":[{},{}]}`, "(2 items)"},
+		{"Unknown", `{"count":3}`, ""},
+	}
+	for _, tt := range tests {
+		if got := summarizeToolInput(tt.name, []byte(tt.input)); got != tt.want {
+			t.Errorf("summarizeToolInput(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUsageAdd(t *testing.T) {
+	var total Usage
+	total.Add(Usage{InputTokens: 100, OutputTokens: 50, CostUSD: 0.5, Requests: 1})
+	total.Add(Usage{InputTokens: 1000, CacheReadTokens: 200, CostUSD: 0.25, Requests: 1})
+
+	if total.Total() != 1350 {
+		t.Errorf("Total() = %d, want 1350", total.Total())
+	}
+	if total.Requests != 2 {
+		t.Errorf("Requests = %d, want 2", total.Requests)
+	}
+	if got := total.String(); got != "1.4k tokens · $0.7500" {
+		t.Errorf("String() = %q", got)
+	}
+	if total.IsZero() || !(Usage{}).IsZero() {
+		t.Error("IsZero() mismatch")
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	u := Usage{InputTokens: 1_000_000, OutputTokens: 1_000_000}
+
+	tests := map[string]float64{
+		"claude-sonnet-4-20250514":  18,
+		"claude-opus-4-1-20250805":  90,
+		"claude-3-5-haiku-20241022": 4.8,
+		"unknown-model":             18,
+	}
+	for model, want := range tests {
+		if got := EstimateCost(model, u); math.Abs(got-want) > 1e-9 {
+			t.Errorf("EstimateCost(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func newTestAPIAgent(t *testing.T, handler http.HandlerFunc) *ClaudeAPIAgent {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	agent, err := NewClaudeAPIAgent(AgentConfig{
+		Name:    "api",
+		Type:    AgentTypeClaudeAPI,
+		Model:   "claude-sonnet-4-20250514",
+		APIKey:  "test-key",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewClaudeAPIAgent() error = %v", err)
+	}
+	return agent
+}
+
+func TestClaudeAPIAgentExecute(t *testing.T) {
+	agent := newTestAPIAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "test-key" {
+			t.Errorf("x-api-key = %q", r.Header.Get("x-api-key"))
+		}
+		fmt.Fprint(w, `{"content":[{"type":"text","text":"Hello!"}],"usage":{"input_tokens":1000,"output_tokens":100}}`)
+	})
+
+	resp, err := agent.Execute(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if resp.Content != "Hello!" {
+		t.Errorf("Content = %q, want %q", resp.Content, "Hello!")
+	}
+	if resp.Usage.InputTokens != 1000 || resp.Usage.OutputTokens != 100 || resp.TokensUsed != 1100 {
+		t.Errorf("Usage = %+v, TokensUsed = %d", resp.Usage, resp.TokensUsed)
+	}
+	if want := EstimateCost("claude-sonnet-4-20250514", resp.Usage); resp.Usage.CostUSD != want {
+		t.Errorf("CostUSD = %v, want %v", resp.Usage.CostUSD, want)
+	}
+}
+
+func TestClaudeAPIAgentExecuteStream(t *testing.T) {
+	agent := newTestAPIAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		events := []string{
+			`{"type":"message_start","message":{"usage":{"input_tokens":50,"output_tokens":1}}}`,
+			`{"type":"content_block_delta","delta":{"type":"text_delta","text":"Hel"}}`,
+			`{"type":"content_block_delta","delta":{"type":"text_delta","text":"lo"}}`,
+			`{"type":"message_delta","usage":{"output_tokens":7}}`,
+			`{"type":"message_stop"}`,
+		}
+		for _, e := range events {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", e)
+		}
+	})
+
+	stream := make(chan StreamChunk, 100)
+	resp, err := agent.ExecuteStream(context.Background(), "hi", stream)
+	if err != nil {
+		t.Fatalf("ExecuteStream() error = %v", err)
+	}
+
+	var output strings.Builder
+	for chunk := range stream {
+		if chunk.Type == "output" {
+			output.WriteString(chunk.Content)
+		}
+	}
+	if output.String() != "Hello" || resp.Content != "Hello" {
+		t.Errorf("streamed %q, content %q; want Hello", output.String(), resp.Content)
+	}
+	if resp.Usage.InputTokens != 50 || resp.Usage.OutputTokens != 7 {
+		t.Errorf("Usage = %+v, want 50 in / 7 out", resp.Usage)
+	}
+}
+
+func TestClaudeAPIAgentError(t *testing.T) {
+	agent := newTestAPIAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+	})
+
+	_, err := agent.Execute(context.Background(), "hi")
+	if err == nil || !strings.Contains(err.Error(), "invalid x-api-key") {
+		t.Errorf("Execute() error = %v, want API error message", err)
+	}
+	if kind := ClassifyError(err); kind != ErrorAuth {
+		t.Errorf("ClassifyError() = %q, want %q", kind, ErrorAuth)
+	}
+}
+
+func TestClaudeAgentBuildArgsSystemPrompt(t *testing.T) {
+	agent := &ClaudeAgent{
+		BaseAgent: BaseAgent{config: AgentConfig{Name: "test", SystemPrompt: "Be terse."}},
+	}
+
+	args := agent.buildArgs(WithPersona(context.Background(), "Review like a security auditor."), "hello", "json")
+	want := "Be terse.\n\nReview like a security auditor."
+	if !containsPair(args, "--append-system-prompt", want) {
+		t.Errorf("buildArgs() = %q, want --append-system-prompt %q", args, want)
+	}
+
+	agent.config.ReplaceSystemPrompt = true
+	args = agent.buildArgs(context.Background(), "hello", "json")
+	if !containsPair(args, "--system-prompt", "Be terse.") {
+		t.Errorf("buildArgs() = %q, want --system-prompt", args)
+	}
+}
+
+func containsPair(args []string, flag, value string) bool {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag && args[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClaudeAPIAgentSystemPrompt(t *testing.T) {
+	var system string
+	agent := newTestAPIAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		var req apiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		system = req.System
+		fmt.Fprint(w, `{"content":[{"type":"text","text":"ok"}],"usage":{}}`)
+	})
+	agent.config.SystemPrompt = "Be terse."
+
+	if _, err := agent.Execute(WithPersona(context.Background(), "Explain like a teacher."), "hi"); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if system != "Be terse.\n\nExplain like a teacher." {
+		t.Errorf("system = %q", system)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err       error
+		want      ErrorKind
+		retryable bool
+	}{
+		{&APIError{StatusCode: 429, Type: "rate_limit_error", Message: "slow down"}, ErrorRateLimit, true},
+		{fmt.Errorf("wrapped: %w", &APIError{Type: "overloaded_error", Message: "Overloaded"}), ErrorOverloaded, true},
+		{&APIError{StatusCode: 401, Message: "bad key"}, ErrorAuth, true},
+		{&APIError{StatusCode: 400, Type: "invalid_request_error", Message: "prompt too long"}, ErrorInvalid, false},
+		{fmt.Errorf("agent sonnet: %w", ErrCLINotFound), ErrorUnavailable, true},
+		{fmt.Errorf("agent api: %w", ErrNoAPIKey), ErrorAuth, true},
+		{fmt.Errorf("%w: %w", ErrCancelled, context.Canceled), ErrorCancelled, false},
+		{errors.New("claude error: exit status 1: API Error: Rate limit reached"), ErrorRateLimit, true},
+		{errors.New("claude error: exit status 2"), ErrorUnknown, true},
+		{errors.New("claude error: exit status 1: API Error: 529 Overloaded"), ErrorOverloaded, true},
+		{errors.New("claude error: exit status 1: API Error: 429 {\"type\":\"error\"}"), ErrorRateLimit, true},
+		{errors.New("request failed with status code 401"), ErrorAuth, true},
+		{errors.New("claude error: error at line 4290"), ErrorUnknown, true},
+		{errors.New("claude error: syntax error on line 429 of main.go"), ErrorUnknown, true},
+		{errors.New("claude error: prompt is 529 tokens over, request req_401abc"), ErrorUnknown, true},
+		{errors.New("claude error: cannot open /tmp/401/out.json"), ErrorUnknown, true},
+	}
+	for _, tt := range tests {
+		got := ClassifyError(tt.err)
+		if got != tt.want || got.Retryable() != tt.retryable {
+			t.Errorf("ClassifyError(%v) = %q (retryable %v), want %q (retryable %v)", tt.err, got, got.Retryable(), tt.want, tt.retryable)
+		}
+	}
+}
+
+func TestVerifyClaudeLogin(t *testing.T) {
+	for _, env := range []string{"ANTHROPIC_API_KEY", "CLAUDE_CODE_OAUTH_TOKEN", "CLAUDE_CODE_USE_BEDROCK", "CLAUDE_CODE_USE_VERTEX"} {
+		t.Setenv(env, "")
+	}
+	dir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", dir)
+
+	if status := verifyClaudeLogin(""); status.CLIFound || status.LoggedIn {
+		t.Errorf("without CLI: %+v", status)
+	}
+	if status := verifyClaudeLogin("/bin/claude"); !status.CLIFound || status.LoggedIn {
+		t.Errorf("without credentials: %+v, want not logged in", status)
+	}
+
+	expired := fmt.Sprintf(`{"claudeAiOauth":{"accessToken":"a","expiresAt":%d}}`, time.Now().Add(-time.Hour).UnixMilli())
+	os.WriteFile(filepath.Join(dir, ".credentials.json"), []byte(expired), 0600)
+	if status := verifyClaudeLogin("/bin/claude"); status.LoggedIn || !strings.Contains(status.Message, "expired") {
+		t.Errorf("expired token: %+v", status)
+	}
+
+	os.WriteFile(filepath.Join(dir, ".credentials.json"), []byte(`{"claudeAiOauth":{"accessToken":"a","refreshToken":"r","expiresAt":1}}`), 0600)
+	os.WriteFile(filepath.Join(dir, ".claude.json"), []byte(`{"oauthAccount":{"emailAddress":"dev@example.com"}}`), 0600)
+	if status := verifyClaudeLogin("/bin/claude"); !status.LoggedIn || status.Account != "dev@example.com" {
+		t.Errorf("refreshable token: %+v, want logged in as dev@example.com", status)
+	}
+
+	os.Remove(filepath.Join(dir, ".credentials.json"))
+	if status := verifyClaudeLogin("/bin/claude"); !status.LoggedIn {
+		t.Errorf("account without credentials file (keychain): %+v, want logged in", status)
+	}
+
+	t.Setenv("ANTHROPIC_API_KEY", "sk-test")
+	if status := verifyClaudeLogin("/bin/claude"); !status.LoggedIn || status.Account != "API key" {
+		t.Errorf("API key: %+v", status)
+	}
+}
+
+func TestLoginCache(t *testing.T) {
+	var mu sync.Mutex
+	checks := 0
+	gate := make(chan struct{})
+	cache := &loginCache{check: func() ClaudeLoginStatus {
+		<-gate
+		mu.Lock()
+		defer mu.Unlock()
+		checks++
+		return ClaudeLoginStatus{CLIFound: true, LoggedIn: checks == 1}
+	}}
+
+	if _, ok := cache.cached(); ok {
+		t.Fatal("cached() before the first check should not be ok")
+	}
+	first, second := cache.fresh(), cache.fresh()
+	close(gate)
+	<-first
+	<-second
+	if status, ok := cache.cached(); !ok || !status.LoggedIn || status.CheckedAt.IsZero() {
+		t.Errorf("cached() = %+v, %v", status, ok)
+	}
+
+	<-cache.fresh()
+	if checks != 1 {
+		t.Errorf("ran %d checks, want one shared and then cached", checks)
+	}
+
+	<-cache.recheck()
+	if status, _ := cache.cached(); status.LoggedIn || checks != 2 {
+		t.Errorf("recheck() = %+v after %d checks, want a new result", status, checks)
+	}
+
+	// A request that went through overrides a negative check
+	cache.confirm()
+	if status, _ := cache.cached(); !status.LoggedIn {
+		t.Errorf("cached() after confirm() = %+v, want logged in", status)
+	}
+}
+
+func TestLoginCacheAuthFailure(t *testing.T) {
+	gate := make(chan struct{})
+	cache := &loginCache{check: func() ClaudeLoginStatus {
+		<-gate
+		// The credentials file still looks valid
+		return ClaudeLoginStatus{CLIFound: true, LoggedIn: true, Account: "me@example.com"}
+	}}
+	close(gate)
+	<-cache.fresh()
+
+	// A rejected request marks the CLI logged out, also over a check
+	// that was already running
+	gate = make(chan struct{})
+	running := cache.recheck()
+	cache.fail(errors.New("claude error: exit status 1: 401 Invalid bearer token"))
+	close(gate)
+	<-running
+	status, ok := cache.cached()
+	if !ok || status.LoggedIn || !strings.Contains(status.Message, "Invalid bearer token") || status.Account != "me@example.com" {
+		t.Errorf("cached() after fail() = %+v, %v, want logged out with the error", status, ok)
+	}
+	<-cache.fresh()
+	if status, _ := cache.cached(); status.LoggedIn {
+		t.Error("fresh() should keep the failure until it expires")
+	}
+
+	// A request that goes through clears it
+	cache.confirm()
+	if status, _ := cache.cached(); !status.LoggedIn {
+		t.Errorf("cached() after confirm() = %+v, want logged in", status)
+	}
+}
+
+// writeFixture writes a mock agent fixture and returns its path
+func writeFixture(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMockAgentExecuteStream(t *testing.T) {
+	fixture := writeFixture(t, "mock.yaml", `
+responses:
+  - match: "(?i)login"
+    chunks:
+      - {type: thinking, content: "Looking at the form"}
+      - {type: tool_use, tool: {name: Read, input: login.go}}
+      - {type: tool_result, tool: {name: Read}}
+      - {type: output, content: "Login "}
+      - {type: output, content: "form added", delay: 1ms}
+    usage: {input_tokens: 10, output_tokens: 4, cost_usd: 0.01}
+  - content: fallback answer
+`)
+	agent, err := NewAgent(AgentConfig{Name: "mock", Type: AgentTypeMock, Fixture: fixture})
+	if err != nil {
+		t.Fatalf("NewAgent(mock) error: %v", err)
+	}
+	if agent.Model() != "mock" {
+		t.Errorf("Model() = %q, want default %q", agent.Model(), "mock")
+	}
+
+	stream := make(chan StreamChunk, 20)
+	resp, err := agent.ExecuteStream(context.Background(), "Add a LOGIN form", stream)
+	if err != nil {
+		t.Fatalf("ExecuteStream() error: %v", err)
+	}
+	var types []string
+	for chunk := range stream {
+		types = append(types, chunk.Type)
+		if chunk.Type == "tool_result" && chunk.Tool.Status != ToolSucceeded {
+			t.Errorf("tool_result status = %q, want %q", chunk.Tool.Status, ToolSucceeded)
+		}
+	}
+	want := "thinking tool_use tool_result output output usage status"
+	if got := strings.Join(types, " "); got != want {
+		t.Errorf("chunk types = %q, want %q", got, want)
+	}
+	if resp.Content != "Login form added" || resp.Usage.OutputTokens != 4 || resp.Usage.Requests != 1 {
+		t.Errorf("response = %+v", resp)
+	}
+
+	// The login response is used up; the catch-all answers from now on
+	resp, err = agent.Execute(context.Background(), "login again")
+	if err != nil || resp.Content != "fallback answer" {
+		t.Errorf("second request = %+v, %v, want the catch-all", resp, err)
+	}
+	if _, err := agent.Execute(context.Background(), "one more"); err == nil {
+		t.Error("Execute() should fail once the fixture is used up")
+	}
+}
+
+func TestMockAgentRepeatAndErrors(t *testing.T) {
+	fixture := writeFixture(t, "mock.json", `{"responses": [
+		{"match": "busy", "error": "overloaded_error: try again later"},
+		{"content": "ok", "repeat": true}
+	]}`)
+	agent, err := NewMockAgent(AgentConfig{Name: "mock", Model: "fake-model", Fixture: fixture})
+	if err != nil {
+		t.Fatalf("NewMockAgent() error: %v", err)
+	}
+	if agent.Model() != "fake-model" {
+		t.Errorf("Model() = %q", agent.Model())
+	}
+
+	_, err = agent.Execute(context.Background(), "are you busy?")
+	if err == nil || ClassifyError(err) != ErrorOverloaded {
+		t.Errorf("error = %v, want an overloaded error", err)
+	}
+	for i := 0; i < 3; i++ {
+		if resp, err := agent.Execute(context.Background(), "hello"); err != nil || resp.Content != "ok" {
+			t.Errorf("repeat response %d = %+v, %v", i, resp, err)
+		}
+	}
+}
+
+func TestMockAgentCancelled(t *testing.T) {
+	agent, err := NewMockAgentWithScript(AgentConfig{Name: "mock"}, &MockScript{Responses: []MockResponse{{
+		Chunks: []MockChunk{
+			{Type: "output", Content: "partial"},
+			{Type: "output", Content: " never sent", Delay: time.Minute},
+		},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := make(chan StreamChunk)
+	done := make(chan struct{})
+	var resp *Response
+	go func() {
+		defer close(done)
+		resp, err = agent.ExecuteStream(ctx, "go", stream)
+	}()
+	<-stream
+	cancel()
+	for range stream {
+	}
+	<-done
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("error = %v, want ErrCancelled", err)
+	}
+	if resp == nil || resp.Content != "partial" {
+		t.Errorf("response = %+v, want the partial output", resp)
+	}
+}
+
+func TestMockAgentBadFixture(t *testing.T) {
+	if _, err := NewAgent(AgentConfig{Name: "mock", Type: AgentTypeMock}); err == nil {
+		t.Error("NewAgent() without a fixture should fail")
+	}
+	if _, err := NewAgent(AgentConfig{Name: "mock", Type: AgentTypeMock, Fixture: "/nonexistent.yaml"}); err == nil {
+		t.Error("NewAgent() with a missing fixture should fail")
+	}
+	fixture := writeFixture(t, "bad.yaml", "responses:\n  - match: \"(\"\n")
+	if _, err := NewAgent(AgentConfig{Name: "mock", Type: AgentTypeMock, Fixture: fixture}); err == nil {
+		t.Error("NewAgent() with an invalid match pattern should fail")
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	mock, err := NewMockAgentWithScript(AgentConfig{Name: "sonnet", Model: "claude-test"}, &MockScript{Responses: []MockResponse{
+		{Match: "fail", Error: "rate limit exceeded"},
+		{Match: "hello", Content: "first"},
+		{Match: "hello", Chunks: []MockChunk{
+			{Type: "thinking", Content: "again?"},
+			{Type: "tool_use", Tool: &ToolEvent{Name: "Read", Input: "main.go"}},
+			{Type: "output", Content: "second"},
+		}, Usage: Usage{InputTokens: 7, OutputTokens: 2}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cassettes", "run.json")
+	recorder, err := NewCassetteRecorder(path)
+	if err != nil {
+		t.Fatalf("NewCassetteRecorder() error: %v", err)
+	}
+	recording := NewRecordingAgent(mock, recorder)
+	if Unwrap(recording) != Agent(mock) {
+		t.Error("Unwrap() should return the recorded agent")
+	}
+
+	collect := func(agent Agent, prompt string) ([]StreamChunk, *Response, error) {
+		stream := make(chan StreamChunk, 20)
+		resp, err := agent.ExecuteStream(context.Background(), prompt, stream)
+		var chunks []StreamChunk
+		for chunk := range stream {
+			chunks = append(chunks, chunk)
+		}
+		return chunks, resp, err
+	}
+
+	if _, err := recording.Execute(context.Background(), "hello"); err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	recorded, recordedResp, err := collect(recording, "hello")
+	if err != nil {
+		t.Fatalf("ExecuteStream() error: %v", err)
+	}
+	if _, _, err := collect(recording, "fail"); err == nil {
+		t.Fatal("recorded request should fail")
+	}
+
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette() error: %v", err)
+	}
+	if len(cassette.Interactions) != 3 {
+		t.Fatalf("recorded %d interactions, want 3", len(cassette.Interactions))
+	}
+	second := cassette.Interactions[1]
+	if second.Agent != "sonnet" || second.PromptHash != PromptHash("hello") || len(second.Chunks) != len(recorded) {
+		t.Errorf("interaction = %+v", second)
+	}
+	if cassette.Interactions[2].ErrorKind != ErrorRateLimit {
+		t.Errorf("error kind = %q, want %q", cassette.Interactions[2].ErrorKind, ErrorRateLimit)
+	}
+
+	replay := NewReplayAgent(AgentConfig{Name: "sonnet"}, cassette, false)
+	if replay.Model() != "claude-test" {
+		t.Errorf("Model() = %q, want the recorded model", replay.Model())
+	}
+	if resp, err := replay.Execute(context.Background(), "hello"); err != nil || resp.Content != "first" {
+		t.Errorf("first replay = %+v, %v", resp, err)
+	}
+	replayed, replayedResp, err := collect(replay, "hello")
+	if err != nil {
+		t.Fatalf("second replay error: %v", err)
+	}
+	if fmt.Sprint(replayed) != fmt.Sprint(recorded) {
+		t.Errorf("replayed chunks = %v, want %v", replayed, recorded)
+	}
+	if replayedResp.Content != recordedResp.Content || replayedResp.Usage != recordedResp.Usage {
+		t.Errorf("replayed response = %+v, want %+v", replayedResp, recordedResp)
+	}
+
+	_, err = replay.Execute(context.Background(), "fail")
+	if err == nil || ClassifyError(err) != ErrorRateLimit || err.Error() != "rate limit exceeded" {
+		t.Errorf("replayed error = %v, want the recorded rate limit error", err)
+	}
+	if _, err := replay.Execute(context.Background(), "hello"); err == nil {
+		t.Error("a prompt should not be answered more often than it was recorded")
+	}
+}
+
+func TestReplayKeepsErrorKinds(t *testing.T) {
+	cassette := &Cassette{Interactions: []Interaction{
+		{Agent: "sonnet", PromptHash: PromptHash("long"), Error: "prompt is too long", ErrorKind: ErrorInvalid},
+		{Agent: "sonnet", PromptHash: PromptHash("stop"), Error: "request cancelled", ErrorKind: ErrorCancelled},
+	}}
+	replay := NewReplayAgent(AgentConfig{Name: "sonnet"}, cassette, false)
+
+	if _, err := replay.Execute(context.Background(), "long"); ClassifyError(err) != ErrorInvalid {
+		t.Errorf("error = %v (%s), want an invalid request", err, ClassifyError(err))
+	}
+	if _, err := replay.Execute(context.Background(), "stop"); !errors.Is(err, ErrCancelled) {
+		t.Errorf("error = %v, want ErrCancelled", err)
+	}
+}
+
+func TestReplayRealtime(t *testing.T) {
+	cassette := &Cassette{Interactions: []Interaction{{
+		Agent:      "sonnet",
+		PromptHash: PromptHash("slow"),
+		Chunks:     []RecordedChunk{{Offset: time.Minute, Type: "output", Content: "late"}},
+		Content:    "late",
+	}}}
+	replay := NewReplayAgent(AgentConfig{Name: "sonnet"}, cassette, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := replay.Execute(ctx, "slow"); !errors.Is(err, ErrCancelled) {
+		t.Errorf("error = %v, want the realtime delay to be cancelled", err)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/v1/models" || r.Header.Get("x-api-key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": {"type": "authentication_error", "message": "invalid x-api-key"}}`)
+			return
+		}
+		if r.URL.Query().Get("after_id") == "" {
+			fmt.Fprint(w, `{"data": [{"id": "claude-new"}], "has_more": true, "last_id": "claude-new"}`)
+			return
+		}
+		fmt.Fprint(w, `{"data": [{"id": "claude-old"}], "has_more": false}`)
+	}))
+	defer server.Close()
+
+	config := AgentConfig{Type: AgentTypeClaudeAPI, BaseURL: server.URL, APIKey: "test-key"}
+	list := ListModels(context.Background(), config)
+	if !list.Fetched || strings.Join(list.Models, ",") != "claude-new,claude-old" {
+		t.Errorf("ListModels() = %+v, want both pages", list)
+	}
+	if ListModels(context.Background(), config); requests != 2 {
+		t.Errorf("requests = %d, want the second list from the cache", requests)
+	}
+
+	// Failures and missing keys fall back to the built-in list
+	config.APIKey = "wrong-key"
+	list = ListModels(context.Background(), config)
+	if list.Fetched || list.Err == nil || ClassifyError(list.Err) != ErrorAuth || len(list.Models) == 0 {
+		t.Errorf("ListModels() with a bad key = %+v", list)
+	}
+	config.APIKey = ""
+	if list = ListModels(context.Background(), config); list.Fetched || list.Err != nil || len(list.Models) == 0 {
+		t.Errorf("ListModels() without a key = %+v", list)
+	}
+	if list = ListModels(context.Background(), AgentConfig{Type: AgentTypeMock}); strings.Join(list.Models, ",") != "mock" {
+		t.Errorf("ListModels() for a mock agent = %+v", list)
+	}
+}
@@ -0,0 +1,324 @@
+package agents
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// cassetteVersion is the version of the cassette file format
+const cassetteVersion = 1
+
+// Cassette is a recording of agent requests, written by RecordingAgent and
+// served back by ReplayAgent
+type Cassette struct {
+	Version      int           `json:"version"`
+	RecordedAt   time.Time     `json:"recorded_at"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request
+type Interaction struct {
+	Agent      string          `json:"agent"`
+	Model      string          `json:"model"`
+	PromptHash string          `json:"prompt_hash"`
+	Prompt     string          `json:"prompt"`
+	Chunks     []RecordedChunk `json:"chunks,omitempty"` // empty for requests made with Execute
+	Content    string          `json:"content,omitempty"`
+	Usage      Usage           `json:"usage"`
+	Error      string          `json:"error,omitempty"`
+	ErrorKind  ErrorKind       `json:"error_kind,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	Duration   time.Duration   `json:"duration"`
+}
+
+// RecordedChunk is a stream chunk and when it arrived
+type RecordedChunk struct {
+	Offset  time.Duration `json:"offset"` // since the request started
+	Type    string        `json:"type"`
+	Content string        `json:"content,omitempty"`
+	Done    bool          `json:"done,omitempty"`
+	Usage   *Usage        `json:"usage,omitempty"`
+	Tool    *ToolEvent    `json:"tool,omitempty"`
+}
+
+// PromptHash returns the key interactions are looked up by
+func PromptHash(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadCassette reads a cassette file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	if cassette.Version > cassetteVersion {
+		return nil, fmt.Errorf("cassette %s has unsupported version %d", path, cassette.Version)
+	}
+	return &cassette, nil
+}
+
+// CassetteRecorder collects the interactions of one or more recording
+// agents and rewrites the cassette file after each one, so a crashed run
+// keeps everything recorded up to that point
+type CassetteRecorder struct {
+	mu       sync.Mutex
+	path     string
+	cassette Cassette
+}
+
+// NewCassetteRecorder starts a new cassette at path, replacing any file
+// already there
+func NewCassetteRecorder(path string) (*CassetteRecorder, error) {
+	r := &CassetteRecorder{
+		path:     path,
+		cassette: Cassette{Version: cassetteVersion, RecordedAt: time.Now()},
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Path returns the cassette file
+func (r *CassetteRecorder) Path() string {
+	return r.path
+}
+
+func (r *CassetteRecorder) add(interaction Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	return r.save()
+}
+
+// save writes the cassette. Callers hold r.mu.
+func (r *CassetteRecorder) save() error {
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, data, 0644)
+}
+
+// RecordingAgent passes requests to another agent and records them
+type RecordingAgent struct {
+	Agent
+	recorder *CassetteRecorder
+}
+
+// NewRecordingAgent records the requests sent to agent
+func NewRecordingAgent(agent Agent, recorder *CassetteRecorder) *RecordingAgent {
+	return &RecordingAgent{Agent: agent, recorder: recorder}
+}
+
+// Unwrap returns the recorded agent
+func (a *RecordingAgent) Unwrap() Agent {
+	return a.Agent
+}
+
+// SetModel changes the model of the recorded agent, if it supports that
+func (a *RecordingAgent) SetModel(model string) {
+	if setter, ok := a.Agent.(interface{ SetModel(string) }); ok {
+		setter.SetModel(model)
+	}
+}
+
+func (a *RecordingAgent) Execute(ctx context.Context, prompt string) (*Response, error) {
+	interaction := a.start(prompt)
+	resp, err := a.Agent.Execute(ctx, prompt)
+	a.finish(interaction, resp, err)
+	return resp, err
+}
+
+func (a *RecordingAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- StreamChunk) (*Response, error) {
+	defer close(stream)
+	interaction := a.start(prompt)
+
+	inner := make(chan StreamChunk)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for chunk := range inner {
+			interaction.Chunks = append(interaction.Chunks, RecordedChunk{
+				Offset:  time.Since(interaction.StartedAt),
+				Type:    chunk.Type,
+				Content: chunk.Content,
+				Done:    chunk.Done,
+				Usage:   chunk.Usage,
+				Tool:    chunk.Tool,
+			})
+			stream <- chunk
+		}
+	}()
+
+	resp, err := a.Agent.ExecuteStream(ctx, prompt, inner)
+	<-forwarded
+	a.finish(interaction, resp, err)
+	return resp, err
+}
+
+func (a *RecordingAgent) start(prompt string) *Interaction {
+	return &Interaction{
+		Agent:      a.Name(),
+		Model:      a.Model(),
+		PromptHash: PromptHash(prompt),
+		Prompt:     prompt,
+		StartedAt:  time.Now(),
+	}
+}
+
+// finish records the outcome. A cassette that cannot be written does not
+// fail the request.
+func (a *RecordingAgent) finish(interaction *Interaction, resp *Response, err error) {
+	interaction.Duration = time.Since(interaction.StartedAt)
+	if resp != nil {
+		interaction.Content = resp.Content
+		interaction.Usage = resp.Usage
+		if resp.Model != "" {
+			interaction.Model = resp.Model
+		}
+	}
+	if err != nil {
+		interaction.Error = err.Error()
+		interaction.ErrorKind = ClassifyError(err)
+	}
+	if err := a.recorder.add(*interaction); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write cassette: %v\n", err)
+	}
+}
+
+// ReplayAgent answers from a cassette instead of calling Claude. Requests
+// are matched by agent name and prompt hash; a prompt recorded several
+// times is answered in the recorded order.
+type ReplayAgent struct {
+	BaseAgent
+	realtime bool
+
+	// pendingMu guards pending; BaseAgent.mu guards the agent state
+	pendingMu sync.Mutex
+	pending   map[string][]Interaction // by prompt hash
+}
+
+// NewReplayAgent serves the interactions recorded for config.Name. With
+// realtime set, chunks are delayed as they were when recorded.
+func NewReplayAgent(config AgentConfig, cassette *Cassette, realtime bool) *ReplayAgent {
+	agent := &ReplayAgent{
+		BaseAgent: BaseAgent{config: config, status: "ready"},
+		realtime:  realtime,
+		pending:   make(map[string][]Interaction),
+	}
+	for _, interaction := range cassette.Interactions {
+		if interaction.Agent != config.Name {
+			continue
+		}
+		agent.pending[interaction.PromptHash] = append(agent.pending[interaction.PromptHash], interaction)
+		if agent.config.Model == "" {
+			agent.config.Model = interaction.Model
+		}
+	}
+	return agent
+}
+
+// next takes the next recorded interaction for prompt
+func (a *ReplayAgent) next(prompt string) (Interaction, bool) {
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	hash := PromptHash(prompt)
+	queue := a.pending[hash]
+	if len(queue) == 0 {
+		return Interaction{}, false
+	}
+	a.pending[hash] = queue[1:]
+	return queue[0], true
+}
+
+func (a *ReplayAgent) Execute(ctx context.Context, prompt string) (*Response, error) {
+	stream := make(chan StreamChunk)
+	go func() {
+		for range stream {
+		}
+	}()
+	return a.ExecuteStream(ctx, prompt, stream)
+}
+
+func (a *ReplayAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- StreamChunk) (*Response, error) {
+	defer close(stream)
+	defer a.begin()()
+
+	interaction, ok := a.next(prompt)
+	if !ok {
+		err := fmt.Errorf("replay: no recorded response from %s for prompt %s", a.config.Name, PromptHash(prompt)[:12])
+		stream <- StreamChunk{Content: err.Error(), Type: "error", Done: true}
+		return nil, err
+	}
+
+	var elapsed time.Duration
+	for _, c := range interaction.Chunks {
+		if a.realtime {
+			if !sleep(ctx, c.Offset-elapsed) {
+				stream <- StreamChunk{Content: "Cancelled", Type: "status", Done: true}
+				return nil, fmt.Errorf("%w: %w", ErrCancelled, ctx.Err())
+			}
+			elapsed = c.Offset
+		}
+		stream <- StreamChunk{Content: c.Content, Type: c.Type, Done: c.Done, Usage: c.Usage, Tool: c.Tool}
+	}
+
+	var resp *Response
+	if interaction.Content != "" || interaction.Error == "" {
+		resp = &Response{
+			Content:    interaction.Content,
+			Model:      interaction.Model,
+			TokensUsed: interaction.Usage.Total(),
+			Usage:      interaction.Usage,
+		}
+	}
+	if interaction.Error == "" {
+		return resp, nil
+	}
+	return resp, &replayedError{message: interaction.Error, kind: interaction.ErrorKind}
+}
+
+// replayedError is a recorded error. It keeps the recorded kind, so the
+// orchestrator retries and gives up exactly as it did when recording.
+type replayedError struct {
+	message string
+	kind    ErrorKind
+}
+
+func (e *replayedError) Error() string { return e.message }
+
+func (e *replayedError) ErrorKind() ErrorKind { return e.kind }
+
+func (e *replayedError) Is(target error) bool {
+	return target == ErrCancelled && e.kind == ErrorCancelled
+}
+
+// Unwrap returns the agent inside a wrapper such as RecordingAgent
+func Unwrap(agent Agent) Agent {
+	for {
+		wrapper, ok := agent.(interface{ Unwrap() Agent })
+		if !ok {
+			return agent
+		}
+		agent = wrapper.Unwrap()
+	}
+}
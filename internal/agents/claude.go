@@ -13,20 +13,18 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
+// cancelGracePeriod is how long the CLI gets to exit after an interrupt
+// before it is killed
+const cancelGracePeriod = 3 * time.Second
+
 type ClaudeAgent struct {
 	BaseAgent
 	cliPath string
 }
 
-// ClaudeLoginStatus represents the login status of Claude CLI
-type ClaudeLoginStatus struct {
-	LoggedIn bool
-	Message  string
-	CLIFound bool
-}
-
 func NewClaudeAgent(config AgentConfig) (*ClaudeAgent, error) {
 	agent := &ClaudeAgent{
 		BaseAgent: BaseAgent{
@@ -43,36 +41,24 @@ func NewClaudeAgent(config AgentConfig) (*ClaudeAgent, error) {
 	}
 	agent.cliPath = cliPath
 
-	// Don't check login status at startup - it's slow (5s timeout)
-	// Login will be checked when Execute() is called
+	// Don't check login status at startup - it's slow (5s timeout).
+	// Requests are not refused on it either: the check is a guess from
+	// files and environment, so the CLI's own auth error is what counts.
 	agent.status = "ready"
 	return agent, nil
 }
 
 func (a *ClaudeAgent) Execute(ctx context.Context, prompt string) (*Response, error) {
 	if a.cliPath == "" {
-		return &Response{
-			Content: fmt.Sprintf("[%s] Claude CLI not found. Please install Claude CLI and run 'claude login'.\n\nYour request: %s", a.config.Name, prompt),
-			Model:   a.config.Model,
-		}, nil
+		return nil, fmt.Errorf("agent %s: %w", a.config.Name, ErrCLINotFound)
 	}
 
-	status := CheckClaudeLogin()
-	if !status.LoggedIn {
-		return &Response{
-			Content: fmt.Sprintf("[%s] Not logged in to Claude. Please run 'claude login' first.\n\nYour request: %s", a.config.Name, prompt),
-			Model:   a.config.Model,
-		}, nil
-	}
-
-	a.SetStatus("processing")
-	defer a.SetStatus("ready")
+	defer a.begin()()
 
-	// Build command arguments
-	// --continue: maintain conversation context across calls
-	args := []string{"-p", prompt, "--output-format", "text", "--continue"}
+	args := a.buildArgs(ctx, prompt, "json")
 
 	cmd := exec.CommandContext(ctx, a.cliPath, args...)
+	configureGracefulCancel(cmd)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -82,15 +68,36 @@ func (a *ClaudeAgent) Execute(ctx context.Context, prompt string) (*Response, er
 	if err != nil {
 		// Check if it's a context cancellation
 		if ctx.Err() != nil {
-			return nil, fmt.Errorf("request cancelled: %w", ctx.Err())
+			return &Response{
+				Content: strings.TrimSpace(stdout.String()),
+				Model:   a.Model(),
+			}, fmt.Errorf("%w: %w", ErrCancelled, ctx.Err())
 		}
-		return nil, fmt.Errorf("claude cli error: %w\nstderr: %s", err, stderr.String())
+		err = fmt.Errorf("claude cli error: %w\nstderr: %s", err, stderr.String())
+		failOnAuthError(err)
+		return nil, err
 	}
 
-	return &Response{
+	response := &Response{
 		Content: strings.TrimSpace(stdout.String()),
-		Model:   a.config.Model,
-	}, nil
+		Model:   a.Model(),
+	}
+
+	// JSON output is a single result event carrying the reply and its usage
+	var event claudeStreamEvent
+	if err := json.Unmarshal(stdout.Bytes(), &event); err == nil && event.Type == "result" {
+		if event.IsError {
+			err := fmt.Errorf("claude error: %s", event.Result)
+			failOnAuthError(err)
+			return nil, err
+		}
+		response.Content = strings.TrimSpace(event.Result)
+		response.Usage = event.usage()
+		response.TokensUsed = response.Usage.Total()
+	}
+
+	confirmClaudeLogin()
+	return response, nil
 }
 
 // ExecuteStream executes the prompt and streams output in real-time
@@ -98,33 +105,19 @@ func (a *ClaudeAgent) ExecuteStream(ctx context.Context, prompt string, stream c
 	defer close(stream)
 
 	if a.cliPath == "" {
-		stream <- StreamChunk{Content: "Claude CLI not found", Type: "error", Done: true}
-		return &Response{
-			Content: fmt.Sprintf("[%s] Claude CLI not found.", a.config.Name),
-			Model:   a.config.Model,
-		}, nil
-	}
-
-	status := CheckClaudeLogin()
-	if !status.LoggedIn {
-		stream <- StreamChunk{Content: "Not logged in to Claude", Type: "error", Done: true}
-		return &Response{
-			Content: fmt.Sprintf("[%s] Not logged in to Claude.", a.config.Name),
-			Model:   a.config.Model,
-		}, nil
+		err := fmt.Errorf("agent %s: %w", a.config.Name, ErrCLINotFound)
+		stream <- StreamChunk{Content: err.Error(), Type: "error", Done: true}
+		return nil, err
 	}
 
-	a.SetStatus("processing")
-	defer a.SetStatus("ready")
+	defer a.begin()()
 
 	stream <- StreamChunk{Content: "Starting Claude...", Type: "status"}
 
-	// Build command arguments - use streaming output
-	// Note: stream-json requires --verbose flag
-	// --continue: maintain conversation context across calls
-	args := []string{"-p", prompt, "--output-format", "stream-json", "--verbose", "--continue"}
+	args := a.buildArgs(ctx, prompt, "stream-json")
 
 	cmd := exec.CommandContext(ctx, a.cliPath, args...)
+	configureGracefulCancel(cmd)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -144,6 +137,10 @@ func (a *ClaudeAgent) ExecuteStream(ctx context.Context, prompt string, stream c
 	}
 
 	var fullOutput strings.Builder
+	var usage Usage
+	var errorText, lastStderr string // why the CLI failed, if it did
+	turns := &turnUsage{model: a.Model()}
+	tools := &toolCalls{}
 	var wg sync.WaitGroup
 
 	// Read stdout in real-time
@@ -165,10 +162,29 @@ func (a *ClaudeAgent) ExecuteStream(ctx context.Context, prompt string, stream c
 			}
 
 			// Parse streaming JSON output from Claude CLI
-			chunkType, content := parseClaudeStreamLine(line)
-			if content != "" {
-				fullOutput.WriteString(content)
-				stream <- StreamChunk{Content: content, Type: chunkType}
+			event, ok := decodeClaudeStreamLine(line)
+			if !ok {
+				fullOutput.WriteString(line)
+				stream <- StreamChunk{Content: line, Type: "output"}
+				continue
+			}
+
+			if event.Type == "result" && event.IsError {
+				errorText = event.Result
+			}
+			if total, changed := turns.observe(event); changed {
+				stream <- StreamChunk{Type: "usage", Usage: &total}
+			}
+
+			chunks, result := parseClaudeStreamEvent(event, tools)
+			if result != nil {
+				usage = *result
+			}
+			for _, chunk := range chunks {
+				if chunk.Type == "output" {
+					fullOutput.WriteString(chunk.Content)
+				}
+				stream <- chunk
 			}
 		}
 	}()
@@ -185,97 +201,290 @@ func (a *ClaudeAgent) ExecuteStream(ctx context.Context, prompt string, stream c
 			}
 			line = strings.TrimSpace(line)
 			if line != "" {
+				lastStderr = line
 				stream <- StreamChunk{Content: line, Type: "thinking"}
 			}
 		}
 	}()
 
-	// Wait for command to complete
-	cmdErr := cmd.Wait()
-
-	// Wait for all readers to finish BEFORE closing stream
+	// Wait closes the pipes, so the readers must finish first or the last
+	// line, the result event with the usage, can be lost
 	wg.Wait()
+	cmdErr := cmd.Wait()
 
 	if cmdErr != nil {
 		if ctx.Err() != nil {
+			// Keep whatever was streamed before the interrupt
 			stream <- StreamChunk{Content: "Cancelled", Type: "status", Done: true}
-			return nil, fmt.Errorf("request cancelled: %w", ctx.Err())
+			return &Response{
+				Content:    strings.TrimSpace(fullOutput.String()),
+				Model:      a.Model(),
+				TokensUsed: usage.Total(),
+				Usage:      usage,
+			}, fmt.Errorf("%w: %w", ErrCancelled, ctx.Err())
+		}
+		if errorText == "" {
+			errorText = lastStderr
+		}
+		if errorText != "" {
+			cmdErr = fmt.Errorf("%w: %s", cmdErr, errorText)
 		}
 		stream <- StreamChunk{Content: fmt.Sprintf("Error: %v", cmdErr), Type: "error", Done: true}
-		return nil, fmt.Errorf("claude error: %w", cmdErr)
+		err := fmt.Errorf("claude error: %w", cmdErr)
+		failOnAuthError(err)
+		return nil, err
+	}
+	if errorText != "" {
+		stream <- StreamChunk{Content: "Error: " + errorText, Type: "error", Done: true}
+		err := fmt.Errorf("claude error: %s", errorText)
+		failOnAuthError(err)
+		return nil, err
 	}
 
+	confirmClaudeLogin()
 	stream <- StreamChunk{Content: "Done", Type: "status", Done: true}
 
 	return &Response{
-		Content: strings.TrimSpace(fullOutput.String()),
-		Model:   a.config.Model,
+		Content:    strings.TrimSpace(fullOutput.String()),
+		Model:      a.Model(),
+		TokensUsed: usage.Total(),
+		Usage:      usage,
 	}, nil
 }
 
+// configureGracefulCancel makes context cancellation interrupt the CLI first so it
+// can shut down cleanly, and kill it only if it does not exit in time
+func configureGracefulCancel(cmd *exec.Cmd) {
+	if runtime.GOOS != "windows" {
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(os.Interrupt)
+		}
+	}
+	cmd.WaitDelay = cancelGracePeriod
+}
+
+// buildArgs builds the Claude CLI arguments for a prompt. The permission
+// mode on ctx, if any, overrides the configured one.
+func (a *ClaudeAgent) buildArgs(ctx context.Context, prompt, outputFormat string) []string {
+	args := []string{"-p", prompt, "--output-format", outputFormat}
+	// Note: stream-json requires --verbose flag
+	if outputFormat == "stream-json" {
+		args = append(args, "--verbose")
+	}
+	// --continue: maintain conversation context across calls
+	args = append(args, "--continue")
+	if a.Model() != "" {
+		args = append(args, "--model", a.Model())
+	}
+	if system := systemPrompt(ctx, a.config); system != "" {
+		if a.config.ReplaceSystemPrompt {
+			args = append(args, "--system-prompt", system)
+		} else {
+			args = append(args, "--append-system-prompt", system)
+		}
+	}
+	if mode := permissionModeFrom(ctx, a.config.PermissionMode); mode != "" {
+		args = append(args, "--permission-mode", string(mode))
+	}
+	if len(a.config.AllowedTools) > 0 {
+		args = append(args, "--allowedTools", strings.Join(a.config.AllowedTools, ","))
+	}
+	if len(a.config.DisallowedTools) > 0 {
+		args = append(args, "--disallowedTools", strings.Join(a.config.DisallowedTools, ","))
+	}
+	// --add-dir and --mcp-config take several values, which end at the next flag
+	if len(a.config.AddDirs) > 0 {
+		args = append(args, "--add-dir")
+		args = append(args, a.config.AddDirs...)
+	}
+	if a.config.MCPConfig != "" {
+		args = append(args, "--mcp-config", a.config.MCPConfig)
+	}
+	return args
+}
+
 // claudeStreamEvent represents the JSON structure from Claude CLI stream-json output
 type claudeStreamEvent struct {
 	Type    string `json:"type"`
 	Subtype string `json:"subtype,omitempty"`
 	Result  string `json:"result,omitempty"`
-	Message struct {
-		Content []struct {
-			Type string `json:"type"`
-			Text string `json:"text,omitempty"`
-		} `json:"content,omitempty"`
+	IsError bool   `json:"is_error,omitempty"` // the result is an error message, e.g. a rate limit
+	// Result events report usage and cost for the whole request
+	TotalCostUSD float64     `json:"total_cost_usd,omitempty"`
+	CostUSD      float64     `json:"cost_usd,omitempty"` // older CLI versions
+	NumTurns     int         `json:"num_turns,omitempty"`
+	Usage        claudeUsage `json:"usage,omitempty"`
+	Message      struct {
+		ID      string        `json:"id,omitempty"`
+		Usage   claudeUsage   `json:"usage,omitempty"`
+		Content claudeContent `json:"content,omitempty"`
 	} `json:"message,omitempty"`
 }
 
-// parseClaudeStreamLine parses a line from Claude CLI stream-json output
-func parseClaudeStreamLine(line string) (chunkType, content string) {
+// claudeContent is the content of an assistant or user message. User
+// messages may carry a plain string instead of blocks.
+type claudeContent []claudeContentBlock
+
+func (c *claudeContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = claudeContent{{Type: "text", Text: text}}
+		return nil
+	}
+	var blocks []claudeContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
+}
+
+// claudeContentBlock is a text, thinking, tool_use or tool_result block
+type claudeContentBlock struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Thinking string `json:"thinking,omitempty"`
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+	// tool_result
+	ToolUseID string        `json:"tool_use_id,omitempty"`
+	Content   claudeContent `json:"content,omitempty"`
+	IsError   bool          `json:"is_error,omitempty"`
+}
+
+// resultText returns the text of a tool_result block
+func (b claudeContentBlock) resultText() string {
+	var parts []string
+	for _, c := range b.Content {
+		if c.Text != "" {
+			parts = append(parts, c.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// claudeUsage is the token usage reported by the CLI
+type claudeUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+func (u claudeUsage) toUsage() Usage {
+	return Usage{
+		InputTokens:         u.InputTokens,
+		OutputTokens:        u.OutputTokens,
+		CacheCreationTokens: u.CacheCreationInputTokens,
+		CacheReadTokens:     u.CacheReadInputTokens,
+	}
+}
+
+// usage returns the usage reported by a result event
+func (e claudeStreamEvent) usage() Usage {
+	u := e.Usage.toUsage()
+	u.CostUSD = e.TotalCostUSD
+	if u.CostUSD == 0 {
+		u.CostUSD = e.CostUSD
+	}
+	u.Requests = 1
+	return u
+}
+
+// turnUsage sums the usage of the API calls made during a CLI request.
+// Assistant events repeat their message's usage for every content block,
+// so usage is tracked per message ID.
+type turnUsage struct {
+	model     string
+	byMessage map[string]Usage
+}
+
+// observe records the usage of an assistant event and returns the
+// running total, with cost estimated from list prices
+func (t *turnUsage) observe(event claudeStreamEvent) (Usage, bool) {
+	if event.Type != "assistant" || event.Message.ID == "" {
+		return Usage{}, false
+	}
+	u := event.Message.Usage.toUsage()
+	if u.IsZero() || t.byMessage[event.Message.ID] == u {
+		return Usage{}, false
+	}
+	if t.byMessage == nil {
+		t.byMessage = make(map[string]Usage)
+	}
+	t.byMessage[event.Message.ID] = u
+
+	var total Usage
+	for _, mu := range t.byMessage {
+		total.Add(mu)
+	}
+	total.CostUSD = EstimateCost(t.model, total)
+	return total, true
+}
+
+// parseClaudeStreamLine parses a line from Claude CLI stream-json output.
+// usage is non-nil for the final result event.
+func parseClaudeStreamLine(line string, tools *toolCalls) (chunks []StreamChunk, usage *Usage) {
+	event, ok := decodeClaudeStreamLine(line)
+	if !ok {
+		// If JSON parsing fails, return the raw line
+		return []StreamChunk{{Content: line, Type: "output"}}, nil
+	}
+	return parseClaudeStreamEvent(event, tools)
+}
+
+func decodeClaudeStreamLine(line string) (claudeStreamEvent, bool) {
 	var event claudeStreamEvent
 	if err := json.Unmarshal([]byte(line), &event); err != nil {
-		// If JSON parsing fails, return the raw line
-		return "output", line
+		return event, false
 	}
+	return event, true
+}
 
+// parseClaudeStreamEvent converts an event into stream chunks
+func parseClaudeStreamEvent(event claudeStreamEvent, tools *toolCalls) (chunks []StreamChunk, usage *Usage) {
 	switch event.Type {
 	case "system":
 		// Init event - show as status
-		return "status", "Claude initialized"
+		return []StreamChunk{{Content: "Claude initialized", Type: "status"}}, nil
 	case "assistant":
-		// Extract text from message content
 		for _, c := range event.Message.Content {
-			if c.Type == "text" && c.Text != "" {
-				return "output", c.Text
+			switch c.Type {
+			case "text":
+				if c.Text != "" {
+					chunks = append(chunks, StreamChunk{Content: c.Text, Type: "output"})
+				}
+			case "thinking":
+				text := c.Thinking
+				if text == "" {
+					text = c.Text
+				}
+				if text != "" {
+					chunks = append(chunks, StreamChunk{Content: text, Type: "thinking"})
+				}
+			case "tool_use":
+				tool := tools.start(c)
+				chunks = append(chunks, StreamChunk{Content: tool.String(), Type: "tool_use", Tool: &tool})
 			}
-			if c.Type == "thinking" && c.Text != "" {
-				return "thinking", c.Text
+		}
+		return chunks, nil
+	case "user":
+		// User events carry the results of the tools Claude called
+		for _, c := range event.Message.Content {
+			if c.Type == "tool_result" {
+				tool := tools.finish(c)
+				chunks = append(chunks, StreamChunk{Content: tool.String(), Type: "tool_result", Tool: &tool})
 			}
 		}
-		return "", ""
+		return chunks, nil
 	case "result":
-		// Final result - already received via streaming, so skip to avoid duplicate
-		// The result event contains the full response which we already accumulated
-		return "", ""
+		// The text was already received via streaming, so only keep the usage
+		u := event.usage()
+		return nil, &u
 	default:
-		return "", ""
-	}
-}
-
-// CheckClaudeLogin checks if Claude CLI is available (fast check - no API calls)
-// Actual login status is verified when Execute() is called
-func CheckClaudeLogin() ClaudeLoginStatus {
-	cliPath := findClaudeCLI()
-	if cliPath == "" {
-		return ClaudeLoginStatus{
-			LoggedIn: false,
-			Message:  "Claude CLI not found. Install it from https://claude.ai/cli",
-			CLIFound: false,
-		}
-	}
-
-	// Fast check: just verify CLI exists and is executable
-	// Don't check login status here - it's slow and will be checked when actually used
-	return ClaudeLoginStatus{
-		LoggedIn: true, // Assume logged in - will error on Execute() if not
-		Message:  "Claude CLI ready",
-		CLIFound: true,
+		return nil, nil
 	}
 }
 
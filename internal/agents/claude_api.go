@@ -0,0 +1,309 @@
+package agents
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ppopcode/ppopcode/internal/secrets"
+)
+
+const (
+	defaultAnthropicBaseURL = "https://api.anthropic.com"
+	anthropicVersion        = "2023-06-01"
+	defaultAPIMaxTokens     = 4096
+)
+
+// ClaudeAPIAgent talks to the Anthropic Messages API directly.
+// It keeps the conversation in memory, like --continue does for the CLI.
+type ClaudeAPIAgent struct {
+	BaseAgent
+	client    *http.Client
+	historyMu sync.Mutex // guards history; BaseAgent.mu guards the agent state
+	history   []apiMessage
+}
+
+type apiMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type apiRequest struct {
+	Model     string       `json:"model"`
+	MaxTokens int          `json:"max_tokens"`
+	System    string       `json:"system,omitempty"`
+	Messages  []apiMessage `json:"messages"`
+	Stream    bool         `json:"stream,omitempty"`
+}
+
+type apiUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type apiResponse struct {
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage apiUsage `json:"usage"`
+}
+
+type apiError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// apiStreamEvent is a server-sent event from a streaming Messages request
+type apiStreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Model string   `json:"model"`
+		Usage apiUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking"`
+	} `json:"delta"`
+	Usage apiUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func NewClaudeAPIAgent(config AgentConfig) (*ClaudeAPIAgent, error) {
+	if config.BaseURL == "" {
+		config.BaseURL = defaultAnthropicBaseURL
+	}
+	if config.APIKey == "" {
+		config.APIKey = secrets.Secret(os.Getenv("ANTHROPIC_API_KEY"))
+	}
+	if config.MaxTokens <= 0 {
+		config.MaxTokens = defaultAPIMaxTokens
+	}
+
+	agent := &ClaudeAPIAgent{
+		BaseAgent: BaseAgent{
+			config: config,
+			status: "ready",
+		},
+		client: http.DefaultClient,
+	}
+	if config.APIKey == "" {
+		agent.status = "no_api_key"
+	}
+	return agent, nil
+}
+
+func (a *ClaudeAPIAgent) Execute(ctx context.Context, prompt string) (*Response, error) {
+	if a.config.APIKey == "" {
+		return nil, fmt.Errorf("agent %s: %w", a.config.Name, ErrNoAPIKey)
+	}
+
+	defer a.begin()()
+
+	resp, err := a.post(ctx, prompt, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	var content strings.Builder
+	for _, c := range result.Content {
+		if c.Type == "text" {
+			content.WriteString(c.Text)
+		}
+	}
+
+	a.appendHistory(prompt, content.String())
+	usage := a.usage(result.Usage)
+	return &Response{
+		Content:    strings.TrimSpace(content.String()),
+		Model:      a.Model(),
+		TokensUsed: usage.Total(),
+		Usage:      usage,
+	}, nil
+}
+
+// ExecuteStream executes the prompt and streams output in real-time
+func (a *ClaudeAPIAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- StreamChunk) (*Response, error) {
+	defer close(stream)
+
+	if a.config.APIKey == "" {
+		err := fmt.Errorf("agent %s: %w", a.config.Name, ErrNoAPIKey)
+		stream <- StreamChunk{Content: err.Error(), Type: "error", Done: true}
+		return nil, err
+	}
+
+	defer a.begin()()
+
+	stream <- StreamChunk{Content: "Calling Claude API...", Type: "status"}
+
+	resp, err := a.post(ctx, prompt, true)
+	if err != nil {
+		if errors.Is(err, ErrCancelled) {
+			return &Response{Model: a.Model()}, err
+		}
+		stream <- StreamChunk{Content: err.Error(), Type: "error", Done: true}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var fullOutput strings.Builder
+	var raw apiUsage
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var event apiStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			continue
+		}
+
+		switch event.Type {
+		case "message_start":
+			raw = event.Message.Usage
+			running := a.usage(raw)
+			stream <- StreamChunk{Type: "usage", Usage: &running}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				fullOutput.WriteString(event.Delta.Text)
+				stream <- StreamChunk{Content: event.Delta.Text, Type: "output"}
+			case "thinking_delta":
+				stream <- StreamChunk{Content: event.Delta.Thinking, Type: "thinking"}
+			}
+		case "message_delta":
+			// message_delta reports the cumulative output token count
+			raw.OutputTokens = event.Usage.OutputTokens
+			running := a.usage(raw)
+			stream <- StreamChunk{Type: "usage", Usage: &running}
+		case "error":
+			err := &APIError{Type: event.Error.Type, Message: event.Error.Message}
+			stream <- StreamChunk{Content: err.Error(), Type: "error", Done: true}
+			return nil, err
+		}
+	}
+
+	usage := a.usage(raw)
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			stream <- StreamChunk{Content: "Cancelled", Type: "status", Done: true}
+			return &Response{
+				Content:    strings.TrimSpace(fullOutput.String()),
+				Model:      a.Model(),
+				TokensUsed: usage.Total(),
+				Usage:      usage,
+			}, fmt.Errorf("%w: %w", ErrCancelled, ctx.Err())
+		}
+		stream <- StreamChunk{Content: fmt.Sprintf("Read error: %v", err), Type: "error", Done: true}
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	a.appendHistory(prompt, fullOutput.String())
+	stream <- StreamChunk{Content: "Done", Type: "status", Done: true}
+
+	return &Response{
+		Content:    strings.TrimSpace(fullOutput.String()),
+		Model:      a.Model(),
+		TokensUsed: usage.Total(),
+		Usage:      usage,
+	}, nil
+}
+
+// post sends a Messages request with the conversation so far plus prompt
+func (a *ClaudeAPIAgent) post(ctx context.Context, prompt string, stream bool) (*http.Response, error) {
+	a.historyMu.Lock()
+	messages := append(append([]apiMessage{}, a.history...), apiMessage{Role: "user", Content: prompt})
+	a.historyMu.Unlock()
+
+	body, err := json.Marshal(apiRequest{
+		Model:     a.Model(),
+		MaxTokens: a.config.MaxTokens,
+		System:    systemPrompt(ctx, a.config),
+		Messages:  messages,
+		Stream:    stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimRight(a.config.BaseURL, "/") + "/v1/messages"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("x-api-key", string(a.config.APIKey))
+	req.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCancelled, ctx.Err())
+		}
+		return nil, fmt.Errorf("claude api request failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		var apiErr apiError
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, &APIError{StatusCode: resp.StatusCode, Type: apiErr.Error.Type, Message: apiErr.Error.Message}
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	return resp, nil
+}
+
+// appendHistory records a completed exchange for the next request
+func (a *ClaudeAPIAgent) appendHistory(prompt, reply string) {
+	if reply == "" {
+		return
+	}
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
+	a.history = append(a.history,
+		apiMessage{Role: "user", Content: prompt},
+		apiMessage{Role: "assistant", Content: reply},
+	)
+}
+
+// usage converts API usage and estimates its cost from list prices
+func (a *ClaudeAPIAgent) usage(raw apiUsage) Usage {
+	u := Usage{
+		InputTokens:         raw.InputTokens,
+		OutputTokens:        raw.OutputTokens,
+		CacheCreationTokens: raw.CacheCreationInputTokens,
+		CacheReadTokens:     raw.CacheReadInputTokens,
+		Requests:            1,
+	}
+	u.CostUSD = EstimateCost(a.Model(), u)
+	return u
+}
@@ -0,0 +1,119 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrCLINotFound is returned by Claude CLI agents when the claude binary is missing
+	ErrCLINotFound = errors.New("Claude CLI not found; install it and run 'claude login'")
+	// ErrNotLoggedIn is returned by Claude CLI agents when the CLI is not logged in
+	ErrNotLoggedIn = errors.New("not logged in to Claude; run 'claude login'")
+	// ErrNoAPIKey is returned by API agents without a key
+	ErrNoAPIKey = errors.New("no API key configured (set api_key or ANTHROPIC_API_KEY)")
+)
+
+// APIError is an error response from the Anthropic API
+type APIError struct {
+	StatusCode int    // 0 for errors sent in the event stream
+	Type       string // e.g. "rate_limit_error" or "overloaded_error"
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.StatusCode == 0:
+		return "claude api error: " + e.Message
+	case e.Type == "":
+		return fmt.Sprintf("claude api error (%d): %s", e.StatusCode, e.Message)
+	default:
+		return fmt.Sprintf("claude api error (%d %s): %s", e.StatusCode, e.Type, e.Message)
+	}
+}
+
+// ErrorKind classifies why a request failed
+type ErrorKind string
+
+const (
+	ErrorCancelled   ErrorKind = "cancelled"
+	ErrorRateLimit   ErrorKind = "rate limited"
+	ErrorOverloaded  ErrorKind = "overloaded"
+	ErrorAuth        ErrorKind = "authentication"
+	ErrorUnavailable ErrorKind = "unavailable"     // the agent cannot run at all, e.g. its CLI is missing
+	ErrorInvalid     ErrorKind = "invalid request" // the request itself was rejected
+	ErrorUnknown     ErrorKind = "error"
+)
+
+// Retryable reports whether another agent may succeed where this one
+// failed. Cancelled and invalid requests would fail the same way anywhere.
+func (k ErrorKind) Retryable() bool {
+	return k != ErrorCancelled && k != ErrorInvalid
+}
+
+// ClassifyError returns the kind of a request error
+func ClassifyError(err error) ErrorKind {
+	if errors.Is(err, ErrCancelled) || errors.Is(err, context.Canceled) {
+		return ErrorCancelled
+	}
+	if errors.Is(err, ErrCLINotFound) {
+		return ErrorUnavailable
+	}
+	if errors.Is(err, ErrNotLoggedIn) || errors.Is(err, ErrNoAPIKey) {
+		return ErrorAuth
+	}
+
+	var kinded interface{ ErrorKind() ErrorKind }
+	if errors.As(err, &kinded) && kinded.ErrorKind() != "" {
+		return kinded.ErrorKind()
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if kind := classifyAPIError(apiErr); kind != "" {
+			return kind
+		}
+	}
+
+	// The CLI only reports errors as text
+	msg := strings.ToLower(err.Error())
+	status := ""
+	if m := statusPattern.FindStringSubmatch(msg); m != nil {
+		status = m[1]
+	}
+	switch {
+	case strings.Contains(msg, "rate limit") || strings.Contains(msg, "rate_limit") || status == "429":
+		return ErrorRateLimit
+	case strings.Contains(msg, "overloaded") || status == "529":
+		return ErrorOverloaded
+	case strings.Contains(msg, "authentication") || strings.Contains(msg, "invalid api key") ||
+		strings.Contains(msg, "not logged in") || status == "401":
+		return ErrorAuth
+	}
+	return ErrorUnknown
+}
+
+// statusPattern finds an HTTP status in CLI error text, e.g. "API Error:
+// 429" or "status code 401". Bare numbers such as line numbers, token
+// counts or request IDs are not statuses.
+var statusPattern = regexp.MustCompile(`\b(?:status(?:[ _]?code)?|http(?:/[\d.]+)?|api error|error(?:[ _]code)?)["']?\s*[:=]?\s*(429|529|401)\b`)
+
+func classifyAPIError(e *APIError) ErrorKind {
+	switch {
+	case e.Type == "rate_limit_error" || e.StatusCode == http.StatusTooManyRequests:
+		return ErrorRateLimit
+	case e.Type == "overloaded_error" || e.StatusCode == 529 || e.StatusCode >= 500:
+		return ErrorOverloaded
+	case e.Type == "authentication_error" || e.Type == "permission_error" ||
+		e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrorAuth
+	case e.Type == "invalid_request_error" || e.StatusCode == http.StatusBadRequest ||
+		e.StatusCode == http.StatusRequestEntityTooLarge:
+		return ErrorInvalid
+	}
+	return ""
+}
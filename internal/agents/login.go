@@ -0,0 +1,243 @@
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// claudeLoginTTL is how long a login check result is reused
+const claudeLoginTTL = 10 * time.Minute
+
+// ClaudeLoginStatus represents the login status of Claude CLI
+type ClaudeLoginStatus struct {
+	LoggedIn  bool
+	Message   string
+	CLIFound  bool
+	Account   string // e.g. the account email, when known
+	CheckedAt time.Time
+}
+
+// loginCache holds the last login check. Only one check runs at a time;
+// callers that arrive while it runs wait for the same result.
+type loginCache struct {
+	mu       sync.Mutex
+	status   ClaudeLoginStatus
+	valid    bool
+	checking chan struct{} // closed when the running check finishes
+	check    func() ClaudeLoginStatus
+	failures int // counts fail calls, so a check started before one is dropped
+}
+
+var claudeLogin = &loginCache{check: func() ClaudeLoginStatus {
+	return verifyClaudeLogin(findClaudeCLI())
+}}
+
+// start runs a check in the background unless one is running, and
+// returns a channel that is closed when it finishes. Callers hold c.mu.
+func (c *loginCache) start() <-chan struct{} {
+	if c.checking != nil {
+		return c.checking
+	}
+	done := make(chan struct{})
+	c.checking = done
+	failures := c.failures
+	go func() {
+		status := c.check()
+		status.CheckedAt = time.Now()
+		c.mu.Lock()
+		if c.failures == failures {
+			c.status, c.valid = status, true
+		}
+		c.checking = nil
+		c.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// fresh returns a channel that is closed once a result no older than the
+// TTL is cached
+func (c *loginCache) fresh() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.valid && time.Since(c.status.CheckedAt) < claudeLoginTTL {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return c.start()
+}
+
+func (c *loginCache) recheck() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.valid = false
+	return c.start()
+}
+
+// fail records that the CLI rejected a request's credentials. The files a
+// check reads can still look fine, e.g. when the token was revoked, so no
+// check is run; a later request that goes through clears it via confirm.
+func (c *loginCache) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	c.status = ClaudeLoginStatus{
+		CLIFound:  true,
+		Account:   c.status.Account,
+		Message:   fmt.Sprintf("Claude rejected the login (%v). Run 'claude login'", err),
+		CheckedAt: time.Now(),
+	}
+	c.valid = true
+}
+
+// confirm records that a request went through, which the file check can
+// miss, e.g. with apiKeyHelper or ANTHROPIC_AUTH_TOKEN
+func (c *loginCache) confirm() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.valid && !c.status.LoggedIn {
+		c.status.LoggedIn = true
+		c.status.CLIFound = true
+		c.status.Message = "Claude CLI logged in"
+	}
+}
+
+func (c *loginCache) cached() (ClaudeLoginStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status, c.valid
+}
+
+// CheckClaudeLogin returns the Claude CLI login status. The result is
+// cached for a few minutes, so this is cheap to call often. It is a guess
+// from files and environment; requests are not refused on it.
+func CheckClaudeLogin() ClaudeLoginStatus {
+	<-claudeLogin.fresh()
+	status, _ := claudeLogin.cached()
+	return status
+}
+
+// StartClaudeLoginCheck checks the login in the background unless a
+// recent result is cached. The channel is closed when the result is ready.
+func StartClaudeLoginCheck() <-chan struct{} {
+	return claudeLogin.fresh()
+}
+
+// RecheckClaudeLogin discards the cached result and checks again in the
+// background, e.g. after logging in or when a request fails with an
+// authentication error
+func RecheckClaudeLogin() <-chan struct{} {
+	return claudeLogin.recheck()
+}
+
+// CachedClaudeLogin returns the last login check without waiting. ok is
+// false until the first check has finished.
+func CachedClaudeLogin() (ClaudeLoginStatus, bool) {
+	return claudeLogin.cached()
+}
+
+// failOnAuthError marks the CLI not logged in when err is an
+// authentication failure, so the Setup view and status bar catch up
+func failOnAuthError(err error) {
+	if err != nil && ClassifyError(err) == ErrorAuth {
+		claudeLogin.fail(err)
+	}
+}
+
+// confirmClaudeLogin marks the cached result logged in after a request
+// succeeded, so the status bar stops warning
+func confirmClaudeLogin() {
+	claudeLogin.confirm()
+}
+
+// claudeCredentials is the part of the CLI's .credentials.json we read
+type claudeCredentials struct {
+	ClaudeAiOauth struct {
+		AccessToken  string `json:"accessToken"`
+		RefreshToken string `json:"refreshToken"`
+		ExpiresAt    int64  `json:"expiresAt"` // Unix milliseconds
+	} `json:"claudeAiOauth"`
+}
+
+// claudeGlobalConfig is the part of the CLI's .claude.json we read
+type claudeGlobalConfig struct {
+	OAuthAccount struct {
+		EmailAddress string `json:"emailAddress"`
+	} `json:"oauthAccount"`
+}
+
+// verifyClaudeLogin inspects the credentials the CLI at cliPath would use.
+// It makes no API calls.
+func verifyClaudeLogin(cliPath string) ClaudeLoginStatus {
+	if cliPath == "" {
+		return ClaudeLoginStatus{
+			LoggedIn: false,
+			Message:  "Claude CLI not found. Install it from https://claude.ai/cli",
+			CLIFound: false,
+		}
+	}
+	status := ClaudeLoginStatus{CLIFound: true}
+
+	for _, env := range []struct{ name, account string }{
+		{"ANTHROPIC_API_KEY", "API key"},
+		{"CLAUDE_CODE_OAUTH_TOKEN", "OAuth token"},
+		{"CLAUDE_CODE_USE_BEDROCK", "Amazon Bedrock"},
+		{"CLAUDE_CODE_USE_VERTEX", "Google Vertex AI"},
+	} {
+		if os.Getenv(env.name) != "" {
+			status.LoggedIn = true
+			status.Account = env.account
+			status.Message = "Using " + env.name
+			return status
+		}
+	}
+
+	configDir, globalConfig := claudeConfigPaths()
+
+	var config claudeGlobalConfig
+	if data, err := os.ReadFile(globalConfig); err == nil && json.Unmarshal(data, &config) == nil {
+		status.Account = config.OAuthAccount.EmailAddress
+	}
+
+	var creds claudeCredentials
+	if data, err := os.ReadFile(filepath.Join(configDir, ".credentials.json")); err == nil && json.Unmarshal(data, &creds) == nil {
+		oauth := creds.ClaudeAiOauth
+		expired := oauth.ExpiresAt > 0 && time.Now().UnixMilli() > oauth.ExpiresAt
+		switch {
+		case oauth.AccessToken == "":
+		case expired && oauth.RefreshToken == "":
+			status.Message = "Claude login expired. Run 'claude login'"
+			return status
+		default:
+			status.LoggedIn = true
+			status.Message = "Claude CLI logged in"
+			return status
+		}
+	}
+
+	// On macOS the token is kept in the keychain, so the account recorded
+	// in the global config is the best evidence of a login
+	if status.Account != "" {
+		status.LoggedIn = true
+		status.Message = "Claude CLI logged in"
+		return status
+	}
+
+	status.Message = "Not logged in to Claude. Run 'claude login'"
+	return status
+}
+
+// claudeConfigPaths returns the CLI's config directory and global config
+// file, honoring CLAUDE_CONFIG_DIR
+func claudeConfigPaths() (dir, globalConfig string) {
+	if dir := os.Getenv("CLAUDE_CONFIG_DIR"); dir != "" {
+		return dir, filepath.Join(dir, ".claude.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".claude"), filepath.Join(home, ".claude.json")
+}
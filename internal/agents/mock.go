@@ -0,0 +1,211 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MockScript is the fixture a mock agent replays. Fixtures are YAML or
+// JSON files, for example:
+//
+//	responses:
+//	  - match: "(?i)hello"
+//	    chunks:
+//	      - {type: thinking, content: "Reading the request", delay: 50ms}
+//	      - {type: tool_use, tool: {name: Read, input: main.go}}
+//	      - {type: tool_result, tool: {name: Read, status: ok}}
+//	      - {type: output, content: "Hi there"}
+//	    usage: {input_tokens: 10, output_tokens: 3, cost_usd: 0.001}
+//	  - error: "rate limit exceeded"
+//
+// Each request is answered by the first unused response whose match
+// pattern matches the prompt. Responses marked repeat are never used up.
+type MockScript struct {
+	Responses []MockResponse `yaml:"responses"`
+}
+
+// MockResponse is one canned answer
+type MockResponse struct {
+	Match   string        `yaml:"match,omitempty"` // regexp; empty matches every prompt
+	Repeat  bool          `yaml:"repeat,omitempty"`
+	Chunks  []MockChunk   `yaml:"chunks,omitempty"`
+	Content string        `yaml:"content,omitempty"` // final reply; defaults to the output chunks
+	Error   string        `yaml:"error,omitempty"`   // returned after the chunks
+	Usage   Usage         `yaml:"usage,omitempty"`
+	Delay   time.Duration `yaml:"delay,omitempty"` // before the first chunk
+
+	match *regexp.Regexp
+}
+
+// MockChunk is one stream chunk of a canned answer
+type MockChunk struct {
+	Type    string        `yaml:"type"` // a StreamChunk type, e.g. "output" or "thinking"
+	Content string        `yaml:"content,omitempty"`
+	Tool    *ToolEvent    `yaml:"tool,omitempty"`
+	Delay   time.Duration `yaml:"delay,omitempty"` // before this chunk
+}
+
+// MockAgent answers from a MockScript instead of calling Claude, so chat
+// and workflow runs can be tested without the CLI or an API key
+type MockAgent struct {
+	BaseAgent
+	responsesMu sync.Mutex // guards used; BaseAgent.mu guards the agent state
+	responses   []MockResponse
+	used        []bool
+}
+
+// NewMockAgent loads the fixture named by config.Fixture
+func NewMockAgent(config AgentConfig) (*MockAgent, error) {
+	if config.Fixture == "" {
+		return nil, fmt.Errorf("mock agent %s: no fixture configured", config.Name)
+	}
+	script, err := LoadMockScript(config.Fixture)
+	if err != nil {
+		return nil, fmt.Errorf("mock agent %s: %w", config.Name, err)
+	}
+	return NewMockAgentWithScript(config, script)
+}
+
+// NewMockAgentWithScript returns a mock agent that replays script
+func NewMockAgentWithScript(config AgentConfig, script *MockScript) (*MockAgent, error) {
+	if config.Model == "" {
+		config.Model = "mock"
+	}
+	agent := &MockAgent{
+		BaseAgent: BaseAgent{config: config, status: "ready"},
+		responses: append([]MockResponse(nil), script.Responses...),
+		used:      make([]bool, len(script.Responses)),
+	}
+	for i, r := range agent.responses {
+		if r.Match == "" {
+			continue
+		}
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("mock agent %s: response %d: %w", config.Name, i+1, err)
+		}
+		agent.responses[i].match = re
+	}
+	return agent, nil
+}
+
+// LoadMockScript reads a YAML or JSON fixture
+func LoadMockScript(path string) (*MockScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script MockScript
+	// JSON is valid YAML, so one decoder reads both
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &script, nil
+}
+
+func (a *MockAgent) Execute(ctx context.Context, prompt string) (*Response, error) {
+	stream := make(chan StreamChunk)
+	go func() {
+		for range stream {
+		}
+	}()
+	return a.ExecuteStream(ctx, prompt, stream)
+}
+
+func (a *MockAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- StreamChunk) (*Response, error) {
+	defer close(stream)
+	defer a.begin()()
+
+	response, ok := a.next(prompt)
+	if !ok {
+		err := fmt.Errorf("mock agent %s: no response left for prompt %q", a.config.Name, prompt)
+		stream <- StreamChunk{Content: err.Error(), Type: "error", Done: true}
+		return nil, err
+	}
+
+	var output strings.Builder
+	cancelled := func() (*Response, error) {
+		stream <- StreamChunk{Content: "Cancelled", Type: "status", Done: true}
+		return &Response{Content: output.String(), Model: a.Model()}, fmt.Errorf("%w: %w", ErrCancelled, ctx.Err())
+	}
+
+	if !sleep(ctx, response.Delay) {
+		return cancelled()
+	}
+	for _, c := range response.Chunks {
+		if !sleep(ctx, c.Delay) {
+			return cancelled()
+		}
+		chunk := StreamChunk{Content: c.Content, Type: c.Type, Tool: c.Tool}
+		if c.Tool != nil && c.Tool.Status == "" {
+			tool := *c.Tool
+			tool.Status = ToolRunning
+			if c.Type == "tool_result" {
+				tool.Status = ToolSucceeded
+			}
+			chunk.Tool = &tool
+		}
+		if c.Type == "output" {
+			output.WriteString(c.Content)
+		}
+		stream <- chunk
+	}
+
+	usage := response.Usage
+	if !usage.IsZero() && usage.Requests == 0 {
+		usage.Requests = 1
+	}
+	if !usage.IsZero() {
+		stream <- StreamChunk{Type: "usage", Usage: &usage}
+	}
+
+	if response.Error != "" {
+		err := errors.New(response.Error)
+		stream <- StreamChunk{Content: err.Error(), Type: "error", Done: true}
+		return nil, err
+	}
+
+	content := response.Content
+	if content == "" {
+		content = output.String()
+	}
+	stream <- StreamChunk{Content: "Done", Type: "status", Done: true}
+	return &Response{Content: content, Model: a.Model(), TokensUsed: usage.Total(), Usage: usage}, nil
+}
+
+// next picks the response for prompt and marks it used
+func (a *MockAgent) next(prompt string) (MockResponse, bool) {
+	a.responsesMu.Lock()
+	defer a.responsesMu.Unlock()
+	for i, r := range a.responses {
+		if a.used[i] || (r.match != nil && !r.match.MatchString(prompt)) {
+			continue
+		}
+		a.used[i] = !r.Repeat
+		return r, true
+	}
+	return MockResponse{}, false
+}
+
+// sleep waits for d and reports false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
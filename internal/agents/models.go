@@ -0,0 +1,163 @@
+package agents
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// modelsTTL is how long a fetched model list is reused
+const modelsTTL = time.Hour
+
+// claudeModels are offered when the Anthropic model list cannot be fetched
+var claudeModels = []string{
+	"claude-opus-4-5-20251101",
+	"claude-sonnet-4-5-20250929",
+	"claude-haiku-4-5-20251001",
+	"claude-opus-4-1-20250801",
+	"claude-sonnet-4-20250514",
+}
+
+// DefaultModels returns the built-in model list for an agent type
+func DefaultModels(agentType AgentType) []string {
+	switch agentType {
+	case AgentTypeClaude, AgentTypeClaudeAPI:
+		return append([]string(nil), claudeModels...)
+	case AgentTypeMock:
+		return []string{"mock"}
+	}
+	return nil
+}
+
+// ModelList is a list of models an agent can use
+type ModelList struct {
+	Models    []string
+	Fetched   bool      // false when Models is the built-in list
+	FetchedAt time.Time // when the list was fetched, if it was
+	Err       error     // why the list could not be fetched, if it was tried
+}
+
+// modelCache holds fetched model lists by base URL and key
+type modelCache struct {
+	mu     sync.Mutex
+	lists  map[string]ModelList
+	client *http.Client
+}
+
+var models = &modelCache{lists: make(map[string]ModelList), client: http.DefaultClient}
+
+// ListModels returns the models an agent can use. Claude agents ask the
+// Anthropic API with the agent's API key or ANTHROPIC_API_KEY, and the
+// answer is cached for an hour. Without a key, or when the request fails,
+// the built-in list for the agent type is returned, with Err set if the
+// request failed.
+func ListModels(ctx context.Context, config AgentConfig) ModelList {
+	builtIn := ModelList{Models: DefaultModels(config.Type)}
+	if config.Type != AgentTypeClaude && config.Type != AgentTypeClaudeAPI {
+		return builtIn
+	}
+
+	key := string(config.APIKey)
+	if key == "" {
+		key = os.Getenv("ANTHROPIC_API_KEY")
+	}
+	if key == "" {
+		return builtIn
+	}
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = defaultAnthropicBaseURL
+	}
+
+	// The key is hashed so the cache does not hold it
+	sum := sha256.Sum256([]byte(key))
+	cacheKey := baseURL + "#" + hex.EncodeToString(sum[:8])
+
+	models.mu.Lock()
+	cached, ok := models.lists[cacheKey]
+	models.mu.Unlock()
+	if ok && time.Since(cached.FetchedAt) < modelsTTL {
+		return cached
+	}
+
+	ids, err := models.fetch(ctx, baseURL, key)
+	if err != nil {
+		builtIn.Err = err
+		return builtIn
+	}
+	list := ModelList{Models: ids, Fetched: true, FetchedAt: time.Now()}
+	models.mu.Lock()
+	models.lists[cacheKey] = list
+	models.mu.Unlock()
+	return list
+}
+
+// apiModelPage is a page of the Anthropic models list
+type apiModelPage struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+	HasMore bool   `json:"has_more"`
+	LastID  string `json:"last_id"`
+}
+
+// fetch lists the models of the Anthropic API at baseURL, newest first
+func (c *modelCache) fetch(ctx context.Context, baseURL, key string) ([]string, error) {
+	var ids []string
+	after := ""
+	for {
+		query := url.Values{"limit": {"100"}}
+		if after != "" {
+			query.Set("after_id", after)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(baseURL, "/")+"/v1/models?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("x-api-key", key)
+		req.Header.Set("anthropic-version", anthropicVersion)
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list models: %w", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list models: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			var apiErr apiError
+			if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+				return nil, &APIError{StatusCode: resp.StatusCode, Type: apiErr.Error.Type, Message: apiErr.Error.Message}
+			}
+			return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		}
+
+		var page apiModelPage
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse the model list: %w", err)
+		}
+		for _, model := range page.Data {
+			ids = append(ids, model.ID)
+		}
+		if !page.HasMore || page.LastID == "" {
+			break
+		}
+		after = page.LastID
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("the API listed no models")
+	}
+	return ids, nil
+}
@@ -0,0 +1,39 @@
+package agents
+
+import "context"
+
+// PermissionMode controls which tool calls the Claude CLI may make
+// without asking. In -p mode there is nobody to ask, so calls that would
+// need approval are refused.
+type PermissionMode string
+
+const (
+	PermissionDefault     PermissionMode = "default"
+	PermissionAcceptEdits PermissionMode = "acceptEdits"
+	PermissionPlan        PermissionMode = "plan" // read-only: Claude may look but not change anything
+	PermissionBypass      PermissionMode = "bypassPermissions"
+)
+
+// PermissionModes lists the modes accepted by the Claude CLI
+var PermissionModes = []PermissionMode{
+	PermissionDefault,
+	PermissionAcceptEdits,
+	PermissionPlan,
+	PermissionBypass,
+}
+
+type permissionModeKey struct{}
+
+// WithPermissionMode returns a context whose requests use mode instead of
+// the agent's configured permission mode
+func WithPermissionMode(ctx context.Context, mode PermissionMode) context.Context {
+	return context.WithValue(ctx, permissionModeKey{}, mode)
+}
+
+// permissionModeFrom returns the mode set by WithPermissionMode, or fallback
+func permissionModeFrom(ctx context.Context, fallback PermissionMode) PermissionMode {
+	if mode, ok := ctx.Value(permissionModeKey{}).(PermissionMode); ok && mode != "" {
+		return mode
+	}
+	return fallback
+}
@@ -0,0 +1,24 @@
+package agents
+
+import "context"
+
+type personaKey struct{}
+
+// WithPersona returns a context whose requests add prompt to the agent's
+// system prompt
+func WithPersona(ctx context.Context, prompt string) context.Context {
+	return context.WithValue(ctx, personaKey{}, prompt)
+}
+
+// systemPrompt combines the configured system prompt with the persona on ctx
+func systemPrompt(ctx context.Context, config AgentConfig) string {
+	persona, _ := ctx.Value(personaKey{}).(string)
+	switch {
+	case persona == "":
+		return config.SystemPrompt
+	case config.SystemPrompt == "":
+		return persona
+	default:
+		return config.SystemPrompt + "\n\n" + persona
+	}
+}
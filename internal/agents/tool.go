@@ -0,0 +1,143 @@
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxToolSummary is the length input and result summaries are cut to
+const maxToolSummary = 80
+
+// ToolStatus is the state of a tool call
+type ToolStatus string
+
+const (
+	ToolRunning   ToolStatus = "running"
+	ToolSucceeded ToolStatus = "ok"
+	ToolFailed    ToolStatus = "error"
+)
+
+// ToolEvent describes a tool call made by an agent, such as reading a file
+// or running a command
+type ToolEvent struct {
+	ID     string     `json:"id" yaml:"id"`
+	Name   string     `json:"name" yaml:"name"`
+	Input  string     `json:"input,omitempty" yaml:"input,omitempty"` // short summary of the input, e.g. a path or command
+	Status ToolStatus `json:"status" yaml:"status"`
+	Result string     `json:"result,omitempty" yaml:"result,omitempty"` // first line of the result
+}
+
+// String returns the call as "Name input"
+func (e ToolEvent) String() string {
+	if e.Input == "" {
+		return e.Name
+	}
+	return e.Name + " " + e.Input
+}
+
+// toolCalls remembers the tool calls of a request so results, which only
+// carry the call ID, can be reported with the tool name and input
+type toolCalls struct {
+	byID map[string]ToolEvent
+}
+
+// start records a tool_use block and returns its event
+func (t *toolCalls) start(block claudeContentBlock) ToolEvent {
+	event := ToolEvent{
+		ID:     block.ID,
+		Name:   block.Name,
+		Input:  summarizeToolInput(block.Name, block.Input),
+		Status: ToolRunning,
+	}
+	if t.byID == nil {
+		t.byID = make(map[string]ToolEvent)
+	}
+	t.byID[block.ID] = event
+	return event
+}
+
+// finish records a tool_result block and returns the completed event
+func (t *toolCalls) finish(block claudeContentBlock) ToolEvent {
+	event, ok := t.byID[block.ToolUseID]
+	if !ok {
+		event = ToolEvent{ID: block.ToolUseID, Name: "tool"}
+	}
+	event.Status = ToolSucceeded
+	if block.IsError {
+		event.Status = ToolFailed
+	}
+	event.Result = firstLine(block.resultText())
+	delete(t.byID, block.ToolUseID)
+	return event
+}
+
+// summarizeToolInput picks the most telling input field of a tool call
+func summarizeToolInput(name string, raw json.RawMessage) string {
+	var input map[string]interface{}
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return ""
+	}
+	str := func(key string) string {
+		s, _ := input[key].(string)
+		return s
+	}
+
+	switch name {
+	case "Grep", "Glob":
+		summary := str("pattern")
+		if path := str("path"); path != "" {
+			summary += " in " + displayPath(path)
+		}
+		return truncateSummary(summary)
+	case "TodoWrite":
+		if todos, ok := input["todos"].([]interface{}); ok {
+			return fmt.Sprintf("(%d items)", len(todos))
+		}
+	}
+
+	for _, key := range []string{"file_path", "notebook_path", "path"} {
+		if path := str(key); path != "" {
+			return truncateSummary(displayPath(path))
+		}
+	}
+	for _, key := range []string{"command", "url", "query", "description", "prompt", "pattern"} {
+		if s := str(key); s != "" {
+			return truncateSummary(firstLine(s))
+		}
+	}
+	return ""
+}
+
+// displayPath shortens paths inside the working directory
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil || !filepath.IsAbs(path) {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// firstLine returns the first non-empty line of s, shortened for display
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return truncateSummary(line)
+		}
+	}
+	return ""
+}
+
+func truncateSummary(s string) string {
+	runes := []rune(s)
+	if len(runes) <= maxToolSummary {
+		return s
+	}
+	return string(runes[:maxToolSummary-3]) + "..."
+}
@@ -0,0 +1,95 @@
+package agents
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Usage is the token usage and cost of one or more requests
+type Usage struct {
+	InputTokens         int     `json:"input_tokens" yaml:"input_tokens"`
+	OutputTokens        int     `json:"output_tokens" yaml:"output_tokens"`
+	CacheCreationTokens int     `json:"cache_creation_tokens,omitempty" yaml:"cache_creation_tokens,omitempty"`
+	CacheReadTokens     int     `json:"cache_read_tokens,omitempty" yaml:"cache_read_tokens,omitempty"`
+	CostUSD             float64 `json:"cost_usd" yaml:"cost_usd"`
+	Requests            int     `json:"requests" yaml:"requests"`
+}
+
+// Total returns the total number of tokens, including cached input
+func (u Usage) Total() int {
+	return u.InputTokens + u.OutputTokens + u.CacheCreationTokens + u.CacheReadTokens
+}
+
+// IsZero reports whether no usage has been recorded
+func (u Usage) IsZero() bool {
+	return u == Usage{}
+}
+
+// Add accumulates other into u
+func (u *Usage) Add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheCreationTokens += other.CacheCreationTokens
+	u.CacheReadTokens += other.CacheReadTokens
+	u.CostUSD += other.CostUSD
+	u.Requests += other.Requests
+}
+
+// String formats usage for display, e.g. "12.3k tokens · $0.0420"
+func (u Usage) String() string {
+	return fmt.Sprintf("%s tokens · $%.4f", FormatTokens(u.Total()), u.CostUSD)
+}
+
+// FormatTokens formats a token count compactly, e.g. 1234 -> "1.2k"
+func FormatTokens(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(n)/1_000)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
+// modelPrice is the price in USD per million tokens
+type modelPrice struct {
+	input  float64
+	output float64
+}
+
+// modelPrices maps model name prefixes to their list prices.
+// More specific prefixes must come before less specific ones.
+var modelPrices = []struct {
+	prefix string
+	price  modelPrice
+}{
+	{"claude-opus-4-5", modelPrice{input: 5, output: 25}},
+	{"claude-opus", modelPrice{input: 15, output: 75}},
+	{"claude-3-opus", modelPrice{input: 15, output: 75}},
+	{"claude-sonnet", modelPrice{input: 3, output: 15}},
+	{"claude-3-7-sonnet", modelPrice{input: 3, output: 15}},
+	{"claude-3-5-sonnet", modelPrice{input: 3, output: 15}},
+	{"claude-haiku-4-5", modelPrice{input: 1, output: 5}},
+	{"claude-3-5-haiku", modelPrice{input: 0.8, output: 4}},
+	{"claude-3-haiku", modelPrice{input: 0.25, output: 1.25}},
+}
+
+// EstimateCost estimates the cost of usage for a model from list prices.
+// Cache writes are billed at 1.25x and cache reads at 0.1x the input price.
+// Unknown models are estimated at Sonnet prices.
+func EstimateCost(model string, u Usage) float64 {
+	price := modelPrice{input: 3, output: 15}
+	for _, p := range modelPrices {
+		if strings.HasPrefix(model, p.prefix) {
+			price = p.price
+			break
+		}
+	}
+
+	perToken := func(perMillion float64) float64 { return perMillion / 1_000_000 }
+	return float64(u.InputTokens)*perToken(price.input) +
+		float64(u.CacheCreationTokens)*perToken(price.input*1.25) +
+		float64(u.CacheReadTokens)*perToken(price.input*0.1) +
+		float64(u.OutputTokens)*perToken(price.output)
+}
@@ -0,0 +1,271 @@
+// Package attach resolves project files that are attached to chat prompts
+package attach
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// MaxFileSize is the largest file that will be attached
+	MaxFileSize = 256 * 1024
+	// MaxFiles limits how many files a single pattern can attach
+	MaxFiles = 100
+	// MaxTotalSize limits the combined size of attached files
+	MaxTotalSize = 1024 * 1024
+)
+
+// File is a file attached as prompt context
+type File struct {
+	Path string // slash-separated path relative to the resolver root
+	Size int64
+}
+
+// Resolver resolves paths, directories and globs to attachable files
+type Resolver struct {
+	root   string
+	ignore *IgnoreMatcher
+}
+
+// NewResolver creates a resolver rooted at root, honouring its
+// .gitignore and .cursorignore files
+func NewResolver(root string) (*Resolver, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	ignore, err := LoadIgnore(absRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ignore files: %w", err)
+	}
+
+	return &Resolver{root: absRoot, ignore: ignore}, nil
+}
+
+// Root returns the absolute root directory
+func (r *Resolver) Root() string {
+	return r.root
+}
+
+// Resolve expands a path, directory or glob pattern into files.
+// Ignored, binary and oversized files are skipped and reported in skipped.
+func (r *Resolver) Resolve(pattern string) (files []File, skipped []string, err error) {
+	pattern = filepath.ToSlash(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return nil, nil, fmt.Errorf("empty path")
+	}
+
+	if isGlob(pattern) {
+		return r.resolveGlob(pattern)
+	}
+
+	abs, err := r.abs(pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", pattern, err)
+	}
+
+	if info.IsDir() {
+		return r.walk(abs, nil)
+	}
+
+	rel := r.rel(abs)
+	if reason := r.skipReason(abs, rel, info); reason != "" {
+		return nil, []string{rel + " (" + reason + ")"}, nil
+	}
+	return []File{{Path: rel, Size: info.Size()}}, nil, nil
+}
+
+func (r *Resolver) resolveGlob(pattern string) ([]File, []string, error) {
+	re := globToRegexp(strings.TrimPrefix(pattern, "./"))
+	files, skipped, err := r.walk(r.root, func(rel string) bool {
+		return re.MatchString(rel)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 && len(skipped) == 0 {
+		return nil, nil, fmt.Errorf("%s: no matching files", pattern)
+	}
+	return files, skipped, nil
+}
+
+// walk collects files under dir that satisfy match (nil matches everything)
+func (r *Resolver) walk(dir string, match func(rel string) bool) ([]File, []string, error) {
+	var files []File
+	var skipped []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel := r.rel(path)
+
+		if d.IsDir() {
+			if path != dir && (d.Name() == ".git" || r.ignore.Ignored(rel, true)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if match != nil && !match(rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if reason := r.skipReason(path, rel, info); reason != "" {
+			// Ignored files are expected in directory walks, so don't report them
+			if reason != "ignored" {
+				skipped = append(skipped, rel+" ("+reason+")")
+			}
+			return nil
+		}
+
+		if len(files) >= MaxFiles {
+			return fmt.Errorf("more than %d files match, narrow the pattern", MaxFiles)
+		}
+		files = append(files, File{Path: rel, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, skipped, nil
+}
+
+// skipReason returns why a file can't be attached, or "" if it can
+func (r *Resolver) skipReason(abs, rel string, info fs.FileInfo) string {
+	if !info.Mode().IsRegular() {
+		return "not a regular file"
+	}
+	if r.ignore.Ignored(rel, false) {
+		return "ignored"
+	}
+	if info.Size() > MaxFileSize {
+		return "too large"
+	}
+	if isBinary(abs) {
+		return "binary"
+	}
+	return ""
+}
+
+// Read returns the contents of an attached file
+func (r *Resolver) Read(f File) (string, error) {
+	abs, err := r.abs(f.Path)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// abs resolves a path relative to the root, rejecting paths outside it
+func (r *Resolver) abs(path string) (string, error) {
+	abs := filepath.FromSlash(path)
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(r.root, abs)
+	}
+	abs = filepath.Clean(abs)
+
+	rel, err := filepath.Rel(r.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the project", path)
+	}
+	return abs, nil
+}
+
+func (r *Resolver) rel(abs string) string {
+	rel, err := filepath.Rel(r.root, abs)
+	if err != nil {
+		return filepath.ToSlash(abs)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// isBinary checks the start of a file for NUL bytes
+func isBinary(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, 8000)
+	n, _ := f.Read(buf)
+	return bytes.IndexByte(buf[:n], 0) >= 0
+}
+
+// ExtractMentions returns the paths mentioned as @path in input
+func ExtractMentions(input string) []string {
+	var mentions []string
+	for _, w := range strings.Fields(input) {
+		if len(w) > 1 && strings.HasPrefix(w, "@") {
+			mentions = append(mentions, strings.TrimRight(w[1:], ",;:!?)"))
+		}
+	}
+	return mentions
+}
+
+// BuildPrompt prepends the contents of attached files to a prompt
+func (r *Resolver) BuildPrompt(files []File, prompt string) (string, error) {
+	if len(files) == 0 {
+		return prompt, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("The following project files are attached for context:\n\n")
+	for _, f := range files {
+		content, err := r.Read(f)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", f.Path, err)
+		}
+		b.WriteString(fmt.Sprintf("<file path=%q>\n", f.Path))
+		b.WriteString(content)
+		if !strings.HasSuffix(content, "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString("</file>\n\n")
+	}
+	b.WriteString(prompt)
+	return b.String(), nil
+}
+
+// TotalSize returns the combined size of files
+func TotalSize(files []File) int64 {
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	return total
+}
+
+// FormatSize formats a byte count for display
+func FormatSize(size int64) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
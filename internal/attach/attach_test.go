@@ -0,0 +1,193 @@
+package attach
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, root, rel, content string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", rel, err)
+	}
+}
+
+func paths(files []File) []string {
+	var out []string
+	for _, f := range files {
+		out = append(out, f.Path)
+	}
+	return out
+}
+
+func newTestTree(t *testing.T) *Resolver {
+	t.Helper()
+	root := t.TempDir()
+	writeFile(t, root, ".gitignore", "build/\n*.log\n!keep.log\n")
+	writeFile(t, root, ".cursorignore", "secrets.txt\n")
+	writeFile(t, root, "main.go", "package main\n")
+	writeFile(t, root, "internal/a/a.go", "package a\n")
+	writeFile(t, root, "internal/a/a_test.go", "package a\n")
+	writeFile(t, root, "internal/b/b.go", "package b\n")
+	writeFile(t, root, "build/out.go", "package out\n")
+	writeFile(t, root, "debug.log", "log\n")
+	writeFile(t, root, "keep.log", "keep\n")
+	writeFile(t, root, "secrets.txt", "token\n")
+	writeFile(t, root, "image.bin", "\x00\x01\x02")
+
+	r, err := NewResolver(root)
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+	return r
+}
+
+func TestResolveFile(t *testing.T) {
+	r := newTestTree(t)
+
+	files, skipped, err := r.Resolve("main.go")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "main.go" || files[0].Size != int64(len("package main\n")) {
+		t.Errorf("Resolve() = %+v", files)
+	}
+	if len(skipped) != 0 {
+		t.Errorf("skipped = %v, want none", skipped)
+	}
+
+	if _, _, err := r.Resolve("missing.go"); err == nil {
+		t.Error("Resolve() should fail for a missing file")
+	}
+	if _, _, err := r.Resolve("../outside.go"); err == nil {
+		t.Error("Resolve() should reject paths outside the root")
+	}
+}
+
+func TestResolveSkipsIgnoredAndBinary(t *testing.T) {
+	r := newTestTree(t)
+
+	for _, path := range []string{"debug.log", "secrets.txt", "build/out.go", "image.bin"} {
+		files, skipped, err := r.Resolve(path)
+		if err != nil {
+			t.Fatalf("Resolve(%q) error = %v", path, err)
+		}
+		if len(files) != 0 || len(skipped) != 1 {
+			t.Errorf("Resolve(%q) = %v, skipped %v; want skipped", path, paths(files), skipped)
+		}
+	}
+
+	files, _, err := r.Resolve("keep.log")
+	if err != nil || len(files) != 1 {
+		t.Errorf("Resolve(keep.log) = %v, %v; negated pattern should not ignore it", paths(files), err)
+	}
+}
+
+func TestResolveDirectoryAndGlob(t *testing.T) {
+	r := newTestTree(t)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"internal", []string{"internal/a/a.go", "internal/a/a_test.go", "internal/b/b.go"}},
+		{"internal/a/", []string{"internal/a/a.go", "internal/a/a_test.go"}},
+		{"**/*.go", []string{"internal/a/a.go", "internal/a/a_test.go", "internal/b/b.go", "main.go"}},
+		{"internal/*/*_test.go", []string{"internal/a/a_test.go"}},
+		{"*.log", []string{"keep.log"}},
+	}
+
+	for _, tt := range tests {
+		files, _, err := r.Resolve(tt.pattern)
+		if err != nil {
+			t.Fatalf("Resolve(%q) error = %v", tt.pattern, err)
+		}
+		if got := strings.Join(paths(files), ","); got != strings.Join(tt.want, ",") {
+			t.Errorf("Resolve(%q) = %s, want %s", tt.pattern, got, strings.Join(tt.want, ","))
+		}
+	}
+
+	if _, _, err := r.Resolve("*.rs"); err == nil {
+		t.Error("Resolve() should fail when a glob matches nothing")
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	m := &IgnoreMatcher{}
+	m.AddPattern("# comment")
+	m.AddPattern("node_modules/")
+	m.AddPattern("/dist")
+	m.AddPattern("docs/**/*.tmp")
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"node_modules", true, true},
+		{"web/node_modules/x.js", false, true},
+		{"node_modules", false, false},
+		{"dist/app.js", false, true},
+		{"web/dist/app.js", false, false},
+		{"docs/a/b/c.tmp", false, true},
+		{"docs/c.tmp", false, true},
+		{"src/c.tmp", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := m.Ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestExtractMentions(t *testing.T) {
+	got := ExtractMentions("explain @main.go and @internal/*.go, not me@example or @")
+	want := []string{"main.go", "internal/*.go"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("ExtractMentions() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildPrompt(t *testing.T) {
+	r := newTestTree(t)
+
+	files, _, err := r.Resolve("main.go")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	prompt, err := r.BuildPrompt(files, "What does this do?")
+	if err != nil {
+		t.Fatalf("BuildPrompt() error = %v", err)
+	}
+	if !strings.Contains(prompt, "<file path=\"main.go\">\npackage main\n</file>") {
+		t.Errorf("BuildPrompt() missing file contents:\n%s", prompt)
+	}
+	if !strings.HasSuffix(prompt, "What does this do?") {
+		t.Errorf("BuildPrompt() should end with the prompt:\n%s", prompt)
+	}
+
+	if prompt, _ := r.BuildPrompt(nil, "hi"); prompt != "hi" {
+		t.Errorf("BuildPrompt(nil) = %q, want %q", prompt, "hi")
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		512:             "512 B",
+		2048:            "2.0 KB",
+		3 * 1024 * 1024: "3.0 MB",
+	}
+	for size, want := range tests {
+		if got := FormatSize(size); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
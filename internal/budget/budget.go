@@ -0,0 +1,318 @@
+// Package budget enforces spending limits on agent requests
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// DefaultWarnAt is the fraction of a limit at which warnings start
+const DefaultWarnAt = 0.8
+
+// ErrExceeded is wrapped by every budget error
+var ErrExceeded = errors.New("budget exceeded")
+
+// ScopeKind identifies what a limit applies to
+type ScopeKind string
+
+const (
+	ScopeRequest  ScopeKind = "request"
+	ScopeSession  ScopeKind = "session"
+	ScopeWorkflow ScopeKind = "workflow"
+	ScopeDay      ScopeKind = "day"
+)
+
+// Limits are spending limits in USD. Zero means unlimited.
+type Limits struct {
+	PerRequest  float64
+	PerSession  float64
+	PerWorkflow float64
+	PerDay      float64
+	WarnAt      float64 // fraction of a limit that triggers a warning
+}
+
+// IsZero reports whether no limits are set
+func (l Limits) IsZero() bool {
+	return l.PerRequest == 0 && l.PerSession == 0 && l.PerWorkflow == 0 && l.PerDay == 0
+}
+
+// ExceededError describes which limit stopped a request
+type ExceededError struct {
+	Scope ScopeKind
+	Spent float64
+	Limit float64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s budget exceeded: $%.4f of $%.2f", e.Scope, e.Spent, e.Limit)
+}
+
+func (e *ExceededError) Unwrap() error {
+	return ErrExceeded
+}
+
+// Scope accumulates spend for a session or workflow run
+type Scope struct {
+	kind  ScopeKind
+	limit float64
+
+	mu    sync.Mutex
+	spent float64
+}
+
+// Kind returns what the scope applies to
+func (s *Scope) Kind() ScopeKind {
+	return s.kind
+}
+
+// Spent returns the amount spent in the scope
+func (s *Scope) Spent() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spent
+}
+
+// Add records spend in the scope
+func (s *Scope) Add(cost float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spent += cost
+}
+
+type scopesKey struct{}
+type overrideKey struct{}
+
+// WithScope returns a context whose requests are counted against scope,
+// in addition to any scopes already on ctx
+func WithScope(ctx context.Context, scope *Scope) context.Context {
+	if scope == nil {
+		return ctx
+	}
+	parent := scopesFrom(ctx)
+	scopes := make([]*Scope, 0, len(parent)+1)
+	scopes = append(scopes, parent...)
+	scopes = append(scopes, scope)
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+func scopesFrom(ctx context.Context) []*Scope {
+	scopes, _ := ctx.Value(scopesKey{}).([]*Scope)
+	return scopes
+}
+
+// WithOverride returns a context whose requests ignore budget limits.
+// Spend is still recorded.
+func WithOverride(ctx context.Context) context.Context {
+	return context.WithValue(ctx, overrideKey{}, true)
+}
+
+// IsOverridden reports whether ctx was created by WithOverride
+func IsOverridden(ctx context.Context) bool {
+	v, _ := ctx.Value(overrideKey{}).(bool)
+	return v
+}
+
+// dayState is the persisted daily spend
+type dayState struct {
+	Date    string  `json:"date"`
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// Tracker enforces limits and keeps the daily spend on disk
+type Tracker struct {
+	limits    Limits
+	statePath string
+	now       func() time.Time
+
+	mu  sync.Mutex
+	day dayState
+}
+
+// Equal reports whether l and other set the same limits. A warn_at a
+// tracker would replace with DefaultWarnAt counts as the default.
+func (l Limits) Equal(other Limits) bool {
+	if l.IsZero() && other.IsZero() {
+		return true
+	}
+	warnAt := func(l Limits) float64 {
+		if l.WarnAt <= 0 || l.WarnAt >= 1 {
+			return DefaultWarnAt
+		}
+		return l.WarnAt
+	}
+	return l.PerRequest == other.PerRequest && l.PerSession == other.PerSession &&
+		l.PerWorkflow == other.PerWorkflow && l.PerDay == other.PerDay && warnAt(l) == warnAt(other)
+}
+
+// NewTracker creates a tracker. statePath stores the daily spend;
+// if it is empty the daily spend is kept in memory only.
+func NewTracker(limits Limits, statePath string) *Tracker {
+	if limits.WarnAt <= 0 || limits.WarnAt >= 1 {
+		limits.WarnAt = DefaultWarnAt
+	}
+	t := &Tracker{
+		limits:    limits,
+		statePath: statePath,
+		now:       time.Now,
+	}
+	t.load()
+	return t
+}
+
+// Limits returns the configured limits
+func (t *Tracker) Limits() Limits {
+	return t.limits
+}
+
+// NewScope creates a scope for the session or workflow limit
+func (t *Tracker) NewScope(kind ScopeKind) *Scope {
+	scope := &Scope{kind: kind}
+	switch kind {
+	case ScopeSession:
+		scope.limit = t.limits.PerSession
+	case ScopeWorkflow:
+		scope.limit = t.limits.PerWorkflow
+	}
+	return scope
+}
+
+// DaySpent returns the amount spent today
+func (t *Tracker) DaySpent() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	return t.day.CostUSD
+}
+
+// Check is called before dispatching a request. It returns an error if a
+// limit has been reached and warnings for limits past the warning threshold.
+func (t *Tracker) Check(ctx context.Context) (warnings []string, err error) {
+	for _, s := range t.spends(ctx) {
+		if s.limit <= 0 {
+			continue
+		}
+		if s.spent >= s.limit {
+			if IsOverridden(ctx) {
+				warnings = append(warnings, fmt.Sprintf("%s budget override: $%.4f of $%.2f spent", s.kind, s.spent, s.limit))
+				continue
+			}
+			return warnings, &ExceededError{Scope: s.kind, Spent: s.spent, Limit: s.limit}
+		}
+		if s.spent >= s.limit*t.limits.WarnAt {
+			warnings = append(warnings, fmt.Sprintf("%s budget at %.0f%%: $%.4f of $%.2f spent", s.kind, 100*s.spent/s.limit, s.spent, s.limit))
+		}
+	}
+	return warnings, nil
+}
+
+// Remaining returns how much a single request may spend before it is
+// stopped, or +Inf when no limit applies
+func (t *Tracker) Remaining(ctx context.Context) (float64, ScopeKind) {
+	remaining := math.Inf(1)
+	var kind ScopeKind
+	if IsOverridden(ctx) {
+		return remaining, kind
+	}
+
+	if t.limits.PerRequest > 0 {
+		remaining, kind = t.limits.PerRequest, ScopeRequest
+	}
+	for _, s := range t.spends(ctx) {
+		if s.limit <= 0 {
+			continue
+		}
+		if left := s.limit - s.spent; left < remaining {
+			remaining, kind = left, s.kind
+		}
+	}
+	return math.Max(remaining, 0), kind
+}
+
+// Exceeded returns the error for a request stopped at cost by Remaining
+func (t *Tracker) Exceeded(ctx context.Context, kind ScopeKind, cost float64) error {
+	if kind == ScopeRequest {
+		return &ExceededError{Scope: kind, Spent: cost, Limit: t.limits.PerRequest}
+	}
+	for _, s := range t.spends(ctx) {
+		if s.kind == kind {
+			return &ExceededError{Scope: kind, Spent: s.spent + cost, Limit: s.limit}
+		}
+	}
+	return &ExceededError{Scope: kind, Spent: cost}
+}
+
+// Record adds the cost of a finished request to the day and to the
+// scopes on ctx
+func (t *Tracker) Record(ctx context.Context, usage agents.Usage) error {
+	for _, scope := range scopesFrom(ctx) {
+		scope.Add(usage.CostUSD)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	t.day.CostUSD += usage.CostUSD
+	return t.save()
+}
+
+type spend struct {
+	kind  ScopeKind
+	spent float64
+	limit float64
+}
+
+// spends lists the current spend against each limit that applies to ctx
+func (t *Tracker) spends(ctx context.Context) []spend {
+	var out []spend
+	for _, scope := range scopesFrom(ctx) {
+		out = append(out, spend{kind: scope.kind, spent: scope.Spent(), limit: scope.limit})
+	}
+	out = append(out, spend{kind: ScopeDay, spent: t.DaySpent(), limit: t.limits.PerDay})
+	return out
+}
+
+// rollover resets the daily spend at midnight. Callers must hold t.mu.
+func (t *Tracker) rollover() {
+	today := t.now().Format("2006-01-02")
+	if t.day.Date != today {
+		t.day = dayState{Date: today}
+	}
+}
+
+func (t *Tracker) load() {
+	if t.statePath == "" {
+		return
+	}
+	data, err := os.ReadFile(t.statePath)
+	if err != nil {
+		return
+	}
+	var state dayState
+	if json.Unmarshal(data, &state) == nil {
+		t.day = state
+	}
+}
+
+// save writes the daily spend. Callers must hold t.mu.
+func (t *Tracker) save() error {
+	if t.statePath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(t.statePath), 0755); err != nil {
+		return fmt.Errorf("failed to create budget dir: %w", err)
+	}
+	data, err := json.MarshalIndent(t.day, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.statePath, data, 0644)
+}
@@ -0,0 +1,146 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+func TestLimitsIsZero(t *testing.T) {
+	if !(Limits{WarnAt: 0.5}).IsZero() {
+		t.Error("limits with only WarnAt should be zero")
+	}
+	if (Limits{PerDay: 1}).IsZero() {
+		t.Error("limits with PerDay should not be zero")
+	}
+}
+
+func TestLimitsEqual(t *testing.T) {
+	if !(Limits{PerDay: 1}).Equal(Limits{PerDay: 1, WarnAt: DefaultWarnAt}) {
+		t.Error("an unset WarnAt should equal the default")
+	}
+	if !(Limits{}).Equal(Limits{WarnAt: 0.5}) {
+		t.Error("zero limits should be equal whatever their WarnAt")
+	}
+	if (Limits{PerDay: 1}).Equal(Limits{PerDay: 2}) {
+		t.Error("different PerDay limits should not be equal")
+	}
+	if (Limits{PerDay: 1, WarnAt: 0.5}).Equal(Limits{PerDay: 1}) {
+		t.Error("different WarnAt should not be equal")
+	}
+}
+
+func TestCheckSessionLimit(t *testing.T) {
+	tracker := NewTracker(Limits{PerSession: 1.0}, "")
+	scope := tracker.NewScope(ScopeSession)
+	ctx := WithScope(context.Background(), scope)
+
+	if warnings, err := tracker.Check(ctx); err != nil || len(warnings) != 0 {
+		t.Fatalf("Check() = %v, %v; want no warnings or error", warnings, err)
+	}
+
+	tracker.Record(ctx, agents.Usage{CostUSD: 0.85})
+	warnings, err := tracker.Check(ctx)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Check() warnings = %v, want one warning past 80%%", warnings)
+	}
+
+	tracker.Record(ctx, agents.Usage{CostUSD: 0.2})
+	_, err = tracker.Check(ctx)
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Scope != ScopeSession {
+		t.Fatalf("Check() error = %v, want session ExceededError", err)
+	}
+	if !errors.Is(err, ErrExceeded) {
+		t.Error("ExceededError should wrap ErrExceeded")
+	}
+
+	// Other sessions are unaffected
+	other := WithScope(context.Background(), tracker.NewScope(ScopeSession))
+	if _, err := tracker.Check(other); err != nil {
+		t.Errorf("Check() for a new session error = %v", err)
+	}
+
+	// Override lets the request through with a warning
+	warnings, err = tracker.Check(WithOverride(ctx))
+	if err != nil || len(warnings) != 1 {
+		t.Errorf("Check() with override = %v, %v", warnings, err)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	tracker := NewTracker(Limits{PerRequest: 0.5, PerWorkflow: 2.0}, "")
+	scope := tracker.NewScope(ScopeWorkflow)
+	ctx := WithScope(context.Background(), scope)
+
+	if remaining, kind := tracker.Remaining(ctx); remaining != 0.5 || kind != ScopeRequest {
+		t.Errorf("Remaining() = %v, %s; want 0.5, request", remaining, kind)
+	}
+
+	scope.Add(1.8)
+	remaining, kind := tracker.Remaining(ctx)
+	if math.Abs(remaining-0.2) > 1e-9 || kind != ScopeWorkflow {
+		t.Errorf("Remaining() = %v, %s; want 0.2, workflow", remaining, kind)
+	}
+
+	err := tracker.Exceeded(ctx, kind, 0.3)
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || math.Abs(exceeded.Spent-2.1) > 1e-9 || exceeded.Limit != 2.0 {
+		t.Errorf("Exceeded() = %v", err)
+	}
+
+	if remaining, _ := tracker.Remaining(WithOverride(ctx)); !math.IsInf(remaining, 1) {
+		t.Errorf("Remaining() with override = %v, want +Inf", remaining)
+	}
+
+	unlimited := NewTracker(Limits{}, "")
+	if remaining, _ := unlimited.Remaining(context.Background()); !math.IsInf(remaining, 1) {
+		t.Errorf("Remaining() without limits = %v, want +Inf", remaining)
+	}
+}
+
+func TestDailySpendPersistsAndRollsOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.json")
+	day := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tracker := NewTracker(Limits{PerDay: 1.0}, path)
+	tracker.now = func() time.Time { return day }
+	if err := tracker.Record(context.Background(), agents.Usage{CostUSD: 1.5}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	reloaded := NewTracker(Limits{PerDay: 1.0}, path)
+	reloaded.now = func() time.Time { return day }
+	if got := reloaded.DaySpent(); got != 1.5 {
+		t.Errorf("DaySpent() after reload = %v, want 1.5", got)
+	}
+	var exceeded *ExceededError
+	if _, err := reloaded.Check(context.Background()); !errors.As(err, &exceeded) || exceeded.Scope != ScopeDay {
+		t.Errorf("Check() error = %v, want day ExceededError", err)
+	}
+
+	reloaded.now = func() time.Time { return day.Add(24 * time.Hour) }
+	if got := reloaded.DaySpent(); got != 0 {
+		t.Errorf("DaySpent() on the next day = %v, want 0", got)
+	}
+}
+
+func TestNestedScopes(t *testing.T) {
+	tracker := NewTracker(Limits{PerSession: 10, PerWorkflow: 10}, "")
+	session := tracker.NewScope(ScopeSession)
+	workflow := tracker.NewScope(ScopeWorkflow)
+	ctx := WithScope(WithScope(context.Background(), session), workflow)
+
+	tracker.Record(ctx, agents.Usage{CostUSD: 0.25})
+	if session.Spent() != 0.25 || workflow.Spent() != 0.25 {
+		t.Errorf("spent = %v/%v, want both scopes charged", session.Spent(), workflow.Spent())
+	}
+}
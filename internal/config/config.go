@@ -3,8 +3,13 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/budget"
+	"github.com/ppopcode/ppopcode/internal/orchestrator"
+	"github.com/ppopcode/ppopcode/internal/secrets"
 	"gopkg.in/yaml.v3"
 )
 
@@ -13,6 +18,25 @@ type Config struct {
 	Agents  map[string]AgentConfig `yaml:"agents"`
 	Cursor  CursorConfig           `yaml:"cursor"`
 	Session SessionConfig          `yaml:"session"`
+	// Commands defines custom slash commands available in chat
+	Commands map[string]CommandConfig `yaml:"commands,omitempty"`
+	Budget   BudgetConfig             `yaml:"budget,omitempty"`
+	// Personas are extra instructions that can be selected in chat
+	Personas map[string]PersonaConfig `yaml:"personas,omitempty"`
+	Pipeline PipelineConfig           `yaml:"pipeline,omitempty"`
+	// Fallbacks lists, per agent, the agents tried in order when it fails
+	Fallbacks map[string][]string `yaml:"fallbacks,omitempty"`
+	// Routes sends each task type, e.g. "general", to an agent
+	Routes map[string]string `yaml:"routes,omitempty"`
+	// Cassette records agent requests to a file or replays them from one
+	Cassette CassetteConfig `yaml:"cassette,omitempty"`
+	// Undo snapshots the working tree before agent edits
+	Undo UndoConfig `yaml:"undo,omitempty"`
+
+	// Where the config was loaded from, set by LoadLayers
+	options    LoadOptions
+	layers     map[Layer]*yaml.Node
+	unknownEnv []string // PPOPCODE_* variables that name no setting
 }
 
 type AppConfig struct {
@@ -24,16 +48,47 @@ type AppConfig struct {
 type AgentConfig struct {
 	Type      string `yaml:"type"`
 	Model     string `yaml:"model"`
-	APIKey    string `yaml:"api_key,omitempty"`
 	BaseURL   string `yaml:"base_url,omitempty"`
 	MaxTokens int    `yaml:"max_tokens,omitempty"`
 	Role      string `yaml:"role"`
+
+	// API key, tried in this order: a secret in the encrypted store, a
+	// file holding the key, an environment variable, and the key itself.
+	// The key itself is never written back to the config.
+	APIKeySecret string         `yaml:"api_key_secret,omitempty"`
+	APIKeyFile   string         `yaml:"api_key_file,omitempty"`
+	APIKeyEnv    string         `yaml:"api_key_env,omitempty"`
+	APIKey       secrets.Secret `yaml:"api_key,omitempty"`
+
+	// System prompt, inline or from a file. The CLI appends it to its own
+	// system prompt unless replace_system_prompt is set.
+	SystemPrompt        string `yaml:"system_prompt,omitempty"`
+	SystemPromptFile    string `yaml:"system_prompt_file,omitempty"`
+	ReplaceSystemPrompt bool   `yaml:"replace_system_prompt,omitempty"`
+
+	// Claude CLI permissions: permission_mode is one of default,
+	// acceptEdits, plan or bypassPermissions
+	PermissionMode  string   `yaml:"permission_mode,omitempty"`
+	AllowedTools    []string `yaml:"allowed_tools,omitempty"`
+	DisallowedTools []string `yaml:"disallowed_tools,omitempty"`
+	AddDirs         []string `yaml:"add_dirs,omitempty"`
+	MCPConfig       string   `yaml:"mcp_config,omitempty"`
+
+	// MaxConcurrent limits how many requests run on the agent at once.
+	// Further requests are queued. 0 means 1.
+	MaxConcurrent int `yaml:"max_concurrent,omitempty"`
+
+	// Fixture is the script a mock agent replays (type: mock)
+	Fixture string `yaml:"fixture,omitempty"`
 }
 
 type CursorConfig struct {
 	Command  string `yaml:"command"`
 	Timeout  int    `yaml:"timeout"`
 	MaxRetry int    `yaml:"max_retry"`
+	// DryRun makes Cursor edit a scratch copy and shows the changes for
+	// review before any of them are applied
+	DryRun bool `yaml:"dry_run,omitempty"`
 }
 
 type SessionConfig struct {
@@ -42,6 +97,71 @@ type SessionConfig struct {
 	MaxHistory  int    `yaml:"max_history"`
 }
 
+// Path returns the history directory. Relative directories are in the
+// home directory.
+func (s SessionConfig) Path() string {
+	if rest, ok := strings.CutPrefix(s.HistoryDir, "~/"); ok {
+		s.HistoryDir = rest
+	}
+	if filepath.IsAbs(s.HistoryDir) {
+		return s.HistoryDir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, s.HistoryDir)
+}
+
+// BudgetConfig sets spending limits in USD. Zero means unlimited.
+type BudgetConfig struct {
+	PerRequest  float64 `yaml:"per_request,omitempty"`
+	PerSession  float64 `yaml:"per_session,omitempty"`
+	PerWorkflow float64 `yaml:"per_workflow,omitempty"`
+	PerDay      float64 `yaml:"per_day,omitempty"`
+	// WarnAt is the fraction of a limit at which warnings are shown (default 0.8)
+	WarnAt float64 `yaml:"warn_at,omitempty"`
+}
+
+// CassetteConfig turns on recording or replay of agent requests. The
+// --record and --replay flags override it.
+type CassetteConfig struct {
+	Record   string `yaml:"record,omitempty"`   // cassette file to write
+	Replay   string `yaml:"replay,omitempty"`   // cassette file to answer from
+	Realtime bool   `yaml:"realtime,omitempty"` // replay at the recorded pace
+}
+
+// UndoConfig controls the git snapshots taken before each chat turn,
+// Cursor edit and workflow prompt node, which /undo restores
+type UndoConfig struct {
+	Disabled bool `yaml:"disabled,omitempty"` // take no snapshots
+	Keep     int  `yaml:"keep,omitempty"`     // snapshots kept (default 20)
+}
+
+// PipelineConfig names the agents used by the plan/implement/review
+// pipeline. Empty names use the active agent; implementer may be "cursor".
+type PipelineConfig struct {
+	Planner     string `yaml:"planner,omitempty"`
+	Implementer string `yaml:"implementer,omitempty"`
+	Reviewer    string `yaml:"reviewer,omitempty"`
+	MaxTasks    int    `yaml:"max_tasks,omitempty"`
+}
+
+// CommandConfig defines a custom slash command that expands into a prompt.
+// The template may reference {{args}} for the whole argument string and
+// {{1}}, {{2}}, ... for individual arguments.
+type CommandConfig struct {
+	Description string `yaml:"description"`
+	Args        string `yaml:"args,omitempty"`
+	Template    string `yaml:"template"`
+}
+
+// Expand fills the command template with the given arguments
+func (c CommandConfig) Expand(args []string) string {
+	result := strings.ReplaceAll(c.Template, "{{args}}", strings.Join(args, " "))
+	for i, arg := range args {
+		result = strings.ReplaceAll(result, "{{"+strconv.Itoa(i+1)+"}}", arg)
+	}
+	return result
+}
+
 func DefaultConfig() *Config {
 	return &Config{
 		App: AppConfig{
@@ -70,60 +190,101 @@ func DefaultConfig() *Config {
 	}
 }
 
+// Load reads a single config file over the defaults. Use LoadLayers to
+// also read the project config and the environment.
 func Load(path string) (*Config, error) {
-	config := DefaultConfig()
-
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return config, nil
-		}
-		return nil, err
-	}
-
-	if err := yaml.Unmarshal(data, config); err != nil {
-		return nil, err
-	}
-
-	return config, nil
+	return LoadLayers(LoadOptions{GlobalPath: path})
 }
 
+// Save writes the config to path. Plaintext API keys are left out; the
+// api_key_secret, api_key_file and api_key_env settings are kept.
 func (c *Config) Save(path string) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	data, err := yaml.Marshal(c)
+	saved := *c
+	saved.Agents = make(map[string]AgentConfig, len(c.Agents))
+	for name, ac := range c.Agents {
+		ac.APIKey = ""
+		saved.Agents[name] = ac
+	}
+	data, err := yaml.Marshal(&saved)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	return writePrivate(path, data)
+}
+
+// agentTypes maps the agent types accepted in config to agent types
+var agentTypes = map[string]agents.AgentType{
+	"claude":     agents.AgentTypeClaude,
+	"claude-api": agents.AgentTypeClaudeAPI,
+	"mock":       agents.AgentTypeMock,
+}
+
+// agentTypeList returns the accepted agent types for messages
+func agentTypeList() string {
+	return strings.Join(sortedKeys(agentTypes), ", ")
 }
 
 func (c *Config) ToAgentConfigs() map[string]agents.AgentConfig {
 	configs := make(map[string]agents.AgentConfig)
 
 	for name, ac := range c.Agents {
-		var agentType agents.AgentType
-		switch ac.Type {
-		case "claude":
-			agentType = agents.AgentTypeClaude
-		default:
-			// Skip unknown agent types
+		agentType, known := agentTypes[ac.Type]
+		if !known {
+			// Skip unknown agent types; Validate reports them
 			continue
 		}
 
+		// Validate reports keys that cannot be read
+		apiKey, _ := c.APIKey(name)
+
 		configs[name] = agents.AgentConfig{
 			Name:      name,
 			Type:      agentType,
 			Model:     ac.Model,
-			APIKey:    ac.APIKey,
+			APIKey:    apiKey,
 			BaseURL:   ac.BaseURL,
 			MaxTokens: ac.MaxTokens,
+
+			SystemPrompt:        ac.inlineSystemPrompt(),
+			ReplaceSystemPrompt: ac.ReplaceSystemPrompt,
+
+			PermissionMode:  agents.PermissionMode(ac.PermissionMode),
+			AllowedTools:    ac.AllowedTools,
+			DisallowedTools: ac.DisallowedTools,
+			AddDirs:         ac.AddDirs,
+			MCPConfig:       ac.MCPConfig,
+
+			MaxConcurrent: ac.MaxConcurrent,
+			Fixture:       ac.Fixture,
 		}
 	}
 
 	return configs
 }
+
+// ToPipelineConfig converts the pipeline config for the orchestrator
+func (c *Config) ToPipelineConfig() orchestrator.PipelineConfig {
+	return orchestrator.PipelineConfig{
+		Planner:     c.Pipeline.Planner,
+		Implementer: c.Pipeline.Implementer,
+		Reviewer:    c.Pipeline.Reviewer,
+		MaxTasks:    c.Pipeline.MaxTasks,
+	}
+}
+
+// ToBudgetLimits converts the budget config into limits for the tracker
+func (c *Config) ToBudgetLimits() budget.Limits {
+	return budget.Limits{
+		PerRequest:  c.Budget.PerRequest,
+		PerSession:  c.Budget.PerSession,
+		PerWorkflow: c.Budget.PerWorkflow,
+		PerDay:      c.Budget.PerDay,
+		WarnAt:      c.Budget.WarnAt,
+	}
+}
@@ -159,3 +159,103 @@ func TestSessionConfig(t *testing.T) {
 		t.Errorf("Session.MaxHistory = %d, want %d", config.Session.MaxHistory, 100)
 	}
 }
+
+func TestCommandConfigExpand(t *testing.T) {
+	cmd := CommandConfig{
+		Description: "Review a file",
+		Template:    "Review {{1}} focusing on {{2}}. Full request: {{args}}",
+	}
+
+	got := cmd.Expand([]string{"main.go", "errors"})
+	want := "Review main.go focusing on errors. Full request: main.go errors"
+	if got != want {
+		t.Errorf("Expand() = %q, want %q", got, want)
+	}
+}
+
+func TestLoadCustomCommands(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "config-commands-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	data := []byte("commands:\n  review:\n    description: Review a file\n    args: <path>\n    template: Review {{1}}\n")
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	loaded, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+
+	review, exists := loaded.Commands["review"]
+	if !exists {
+		t.Fatal("review command should be loaded")
+	}
+	if review.Template != "Review {{1}}" {
+		t.Errorf("review.Template = %q, want %q", review.Template, "Review {{1}}")
+	}
+}
+
+func TestLoadAgentPermissions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "config-permissions-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	data := []byte(`agents:
+  sonnet:
+    type: claude
+    permission_mode: acceptEdits
+    allowed_tools: [Read, "Bash(git diff:*)"]
+    disallowed_tools: [WebFetch]
+    add_dirs: [../shared]
+    mcp_config: .mcp.json
+`)
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	loaded, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+
+	ac := loaded.ToAgentConfigs()["sonnet"]
+	if ac.PermissionMode != agents.PermissionAcceptEdits {
+		t.Errorf("PermissionMode = %q, want acceptEdits", ac.PermissionMode)
+	}
+	if len(ac.AllowedTools) != 2 || ac.AllowedTools[1] != "Bash(git diff:*)" {
+		t.Errorf("AllowedTools = %v", ac.AllowedTools)
+	}
+	if len(ac.DisallowedTools) != 1 || len(ac.AddDirs) != 1 || ac.MCPConfig != ".mcp.json" {
+		t.Errorf("agent config = %+v", ac)
+	}
+}
+
+func TestLoadMockAgent(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte(`agents:
+  sonnet:
+    type: mock
+    fixture: testdata/chat.yaml
+`)
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	loaded, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+
+	ac := loaded.ToAgentConfigs()["sonnet"]
+	if ac.Type != agents.AgentTypeMock || ac.Fixture != "testdata/chat.yaml" {
+		t.Errorf("agent config = %+v, want a mock agent with its fixture", ac)
+	}
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AgentTypes returns the agent types accepted in config, sorted
+func AgentTypes() []string {
+	return sortedKeys(agentTypes)
+}
+
+// AgentReferences returns the settings that refer to the named agent,
+// e.g. "fallbacks.sonnet", "routes.general" or "pipeline.reviewer"
+func (c *Config) AgentReferences(name string) []string {
+	var refs []string
+	for _, agent := range sortedKeys(c.Fallbacks) {
+		for _, fallback := range c.Fallbacks[agent] {
+			if agent == name || fallback == name {
+				refs = append(refs, "fallbacks."+agent)
+				break
+			}
+		}
+	}
+	for _, taskType := range sortedKeys(c.Routes) {
+		if c.Routes[taskType] == name {
+			refs = append(refs, "routes."+taskType)
+		}
+	}
+	for _, stage := range []struct{ key, agent string }{
+		{"pipeline.planner", c.Pipeline.Planner},
+		{"pipeline.implementer", c.Pipeline.Implementer},
+		{"pipeline.reviewer", c.Pipeline.Reviewer},
+	} {
+		if stage.agent == name {
+			refs = append(refs, stage.key)
+		}
+	}
+	return refs
+}
+
+// Edit is a set of changes to the global or project config file. Check
+// shows the config as it would be after the changes; Apply writes them.
+type Edit struct {
+	Layer  Layer             // LayerGlobal or LayerProject
+	Remove []string          // agents to remove
+	Rename map[string]string // agents to rename, old name to new name
+	// Set holds settings by dotted key, as with --set; lists are comma
+	// separated and "" removes the setting from the file
+	Set map[string]string
+}
+
+// IsZero reports whether the edit changes nothing
+func (e Edit) IsZero() bool {
+	return len(e.Remove) == 0 && len(e.Rename) == 0 && len(e.Set) == 0
+}
+
+// apply makes the changes to root, the file at path: removals first,
+// then renames, then settings, which use the new names
+func (e Edit) apply(path string, root *yaml.Node) error {
+	for _, name := range e.Remove {
+		if err := removeAgent(path, root, name); err != nil {
+			return err
+		}
+	}
+	for _, from := range sortedKeys(e.Rename) {
+		if err := renameAgent(path, root, from, e.Rename[from]); err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(e.Set) {
+		keys := strings.Split(key, ".")
+		t, ok := settingType(reflect.TypeOf(Config{}), keys)
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if e.Set[key] == "" {
+			unsetNode(root, keys)
+		} else {
+			mergeNodes(root, settingNode(keys, t, e.Set[key]))
+		}
+	}
+	return nil
+}
+
+// edited reads the file e changes and makes the changes in memory
+func (c *Config) edited(e Edit) (string, *yaml.Node, error) {
+	path := c.LayerPath(e.Layer)
+	if path == "" || (e.Layer != LayerGlobal && e.Layer != LayerProject) {
+		return "", nil, fmt.Errorf("no %s config file", e.Layer)
+	}
+	root, err := readNode(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode}
+	}
+	if err := e.apply(path, root); err != nil {
+		return "", nil, err
+	}
+	// The file is checked as it will be written
+	removePlaintextKeys(root)
+	return path, root, nil
+}
+
+// removePlaintextKeys removes the api_key settings from root, a config
+// file, so keys are never written back in clear text. It returns the
+// agents whose key was removed.
+func removePlaintextKeys(root *yaml.Node) []string {
+	agentsNode := lookupNode(root, []string{"agents"})
+	if agentsNode == nil || agentsNode.Kind != yaml.MappingNode {
+		return nil
+	}
+	var names []string
+	for i := 0; i+1 < len(agentsNode.Content); i += 2 {
+		if lookupNode(agentsNode.Content[i+1], []string{"api_key"}) != nil {
+			names = append(names, agentsNode.Content[i].Value)
+		}
+	}
+	for _, name := range names {
+		unsetNode(root, []string{"agents", name, "api_key"})
+	}
+	return names
+}
+
+// Check returns the config as it would be loaded after the edit, or a
+// *ValidationError listing what would be wrong with it. Nothing is
+// written.
+func (c *Config) Check(e Edit) (*Config, error) {
+	path, root, err := c.edited(e)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadLayers(c.options, map[string]*yaml.Node{path: root})
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Apply writes the edit if the config is valid with it, and returns the
+// new config. Other settings and comments in the file are kept.
+func (c *Config) Apply(e Edit) (*Config, error) {
+	cfg, err := c.Check(e)
+	if err != nil {
+		return nil, err
+	}
+	path, root, err := c.edited(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeNode(path, root); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// RemoveAgent removes an agent from the config file at path. The agent
+// may still be defined by another layer.
+func RemoveAgent(path, name string) error {
+	return editFile(path, func(root *yaml.Node) error {
+		return removeAgent(path, root, name)
+	})
+}
+
+// RenameAgent renames an agent in the config file at path, along with the
+// fallbacks, routes and pipeline settings in that file that refer to it
+func RenameAgent(path, from, to string) error {
+	return editFile(path, func(root *yaml.Node) error {
+		return renameAgent(path, root, from, to)
+	})
+}
+
+// editFile changes the config file at path with edit
+func editFile(path string, edit func(root *yaml.Node) error) error {
+	root, err := readNode(path)
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode}
+	}
+	if err := edit(root); err != nil {
+		return err
+	}
+	return writeNode(path, root)
+}
+
+func removeAgent(path string, root *yaml.Node, name string) error {
+	agentsNode, err := agentsIn(path, root, name)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(agentsNode.Content); i += 2 {
+		if agentsNode.Content[i].Value == name {
+			agentsNode.Content = append(agentsNode.Content[:i], agentsNode.Content[i+2:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func renameAgent(path string, root *yaml.Node, from, to string) error {
+	if strings.ContainsAny(to, ". ") || to == "" {
+		return fmt.Errorf("invalid agent name %q", to)
+	}
+	agentsNode, err := agentsIn(path, root, from)
+	if err != nil {
+		return err
+	}
+	if lookupNode(agentsNode, []string{to}) != nil {
+		return fmt.Errorf("agent %s already exists in %s", to, path)
+	}
+	renameKey(agentsNode, from, to)
+
+	if fallbacks := lookupNode(root, []string{"fallbacks"}); fallbacks != nil && fallbacks.Kind == yaml.MappingNode {
+		renameKey(fallbacks, from, to)
+		for i := 1; i < len(fallbacks.Content); i += 2 {
+			for _, item := range fallbacks.Content[i].Content {
+				if item.Value == from {
+					item.Value = to
+				}
+			}
+		}
+	}
+	if routes := lookupNode(root, []string{"routes"}); routes != nil && routes.Kind == yaml.MappingNode {
+		for i := 1; i < len(routes.Content); i += 2 {
+			if routes.Content[i].Value == from {
+				routes.Content[i].Value = to
+			}
+		}
+	}
+	for _, stage := range []string{"planner", "implementer", "reviewer"} {
+		if value := lookupNode(root, []string{"pipeline", stage}); value != nil && value.Value == from {
+			value.Value = to
+		}
+	}
+	return nil
+}
+
+// agentsIn returns the agents mapping of root, the file at path, which
+// must define name
+func agentsIn(path string, root *yaml.Node, name string) (*yaml.Node, error) {
+	agentsNode := lookupNode(root, []string{"agents"})
+	if agentsNode == nil || agentsNode.Kind != yaml.MappingNode || lookupNode(agentsNode, []string{name}) == nil {
+		return nil, fmt.Errorf("agent %s is not defined in %s", name, path)
+	}
+	return agentsNode, nil
+}
+
+// renameKey renames a key of a mapping node
+func renameKey(mapping *yaml.Node, from, to string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == from {
+			mapping.Content[i].Value = to
+		}
+	}
+}
+
+// unsetNode removes the value at path from a mapping, and the mappings
+// on the way that are left empty
+func unsetNode(n *yaml.Node, path []string) {
+	if n.Kind != yaml.MappingNode || len(path) == 0 {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != path[0] {
+			continue
+		}
+		if value := n.Content[i+1]; len(path) > 1 {
+			unsetNode(value, path[1:])
+			if value.Kind != yaml.MappingNode || len(value.Content) > 0 {
+				return
+			}
+		}
+		n.Content = append(n.Content[:i], n.Content[i+2:]...)
+		return
+	}
+}
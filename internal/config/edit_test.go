@@ -0,0 +1,185 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenameAgent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `agents:
+  haiku:
+    type: claude-api # cheap one
+    model: haiku-model
+  opus:
+    type: claude
+fallbacks:
+  haiku: [opus]
+  opus: [haiku]
+pipeline:
+  reviewer: haiku
+`)
+	if err := RenameAgent(path, "haiku", "fast"); err != nil {
+		t.Fatalf("RenameAgent() error: %v", err)
+	}
+	if err := RenameAgent(path, "fast", "opus"); err == nil {
+		t.Error("RenameAgent() onto an existing agent should fail")
+	}
+	if err := RenameAgent(path, "nobody", "someone"); err == nil {
+		t.Error("RenameAgent() of an undefined agent should fail")
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "# cheap one") {
+		t.Errorf("comments were lost:\n%s", data)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := cfg.Agents["haiku"]; exists || cfg.Agents["fast"].Model != "haiku-model" {
+		t.Errorf("agents = %+v", cfg.Agents)
+	}
+	if strings.Join(cfg.Fallbacks["fast"], ",") != "opus" || strings.Join(cfg.Fallbacks["opus"], ",") != "fast" || cfg.Pipeline.Reviewer != "fast" {
+		t.Errorf("references were not renamed: fallbacks %v, pipeline %+v", cfg.Fallbacks, cfg.Pipeline)
+	}
+	if got := strings.Join(cfg.AgentReferences("fast"), ","); got != "fallbacks.fast,fallbacks.opus,pipeline.reviewer" {
+		t.Errorf("AgentReferences() = %s", got)
+	}
+}
+
+func TestRemoveAgent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "agents:\n  sonnet:\n    model: pinned\n  haiku:\n    type: claude-api\n")
+	if err := RemoveAgent(path, "haiku"); err != nil {
+		t.Fatalf("RemoveAgent() error: %v", err)
+	}
+	if err := RemoveAgent(path, "haiku"); err == nil {
+		t.Error("RemoveAgent() twice should fail")
+	}
+
+	// The default sonnet agent stays, without the file's model
+	if err := RemoveAgent(path, "sonnet"); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := cfg.Agents["haiku"]; exists {
+		t.Error("haiku was not removed")
+	}
+	if cfg.Agents["sonnet"].Model == "pinned" || cfg.Origin("agents.sonnet") != LayerDefault {
+		t.Errorf("sonnet = %+v, want the default", cfg.Agents["sonnet"])
+	}
+}
+
+func TestEditCheckAndApply(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	opts := LoadOptions{GlobalPath: filepath.Join(dir, "global.yaml"), ProjectPath: filepath.Join(dir, "project", "config.yaml")}
+	writeConfig(t, opts.GlobalPath, `agents:
+  haiku:
+    type: claude-api # cheap one
+    max_tokens: 1000
+fallbacks:
+  sonnet: [haiku]
+`)
+	cfg, err := LoadLayers(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Problems are reported and nothing is written
+	bad := Edit{Layer: LayerGlobal, Remove: []string{"haiku"}, Set: map[string]string{"routes.general": "opus"}}
+	_, err = cfg.Check(bad)
+	got := strings.Join(problems(t, err), "\n")
+	for _, want := range []string{`fallbacks.sonnet: agent "haiku" is not defined`, `routes.general: agent "opus" is not defined`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("problems do not contain %q:\n%s", want, got)
+		}
+	}
+	if _, err := cfg.Apply(bad); err == nil {
+		t.Error("Apply() of an invalid edit should fail")
+	}
+	if data, _ := os.ReadFile(opts.GlobalPath); !strings.Contains(string(data), "haiku:") {
+		t.Errorf("the file changed after a failed edit:\n%s", data)
+	}
+
+	edit := Edit{
+		Layer:  LayerGlobal,
+		Rename: map[string]string{"haiku": "fast"},
+		Set: map[string]string{
+			"agents.fast.max_tokens":    "",
+			"agents.fast.allowed_tools": "Read, Grep",
+			"agents.reviewer.type":      "claude",
+			"routes.general":            "reviewer",
+		},
+	}
+	checked, err := cfg.Check(edit)
+	if err != nil {
+		t.Fatalf("Check() error: %v", err)
+	}
+	if checked.Routes["general"] != "reviewer" || checked.Fallbacks["sonnet"][0] != "fast" {
+		t.Errorf("checked config = routes %v, fallbacks %v", checked.Routes, checked.Fallbacks)
+	}
+	if _, err := os.Stat(opts.ProjectPath); !os.IsNotExist(err) {
+		t.Error("Check() should not write")
+	}
+
+	applied, err := cfg.Apply(edit)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	fast := applied.Agents["fast"]
+	if fast.MaxTokens != 0 || strings.Join(fast.AllowedTools, ",") != "Read,Grep" || applied.Agents["reviewer"].Type != "claude" {
+		t.Errorf("agents = %+v", applied.Agents)
+	}
+	data, _ := os.ReadFile(opts.GlobalPath)
+	if !strings.Contains(string(data), "# cheap one") || strings.Contains(string(data), "max_tokens") {
+		t.Errorf("file after Apply():\n%s", data)
+	}
+
+	if _, err := cfg.Check(Edit{Layer: LayerEnv}); err == nil {
+		t.Error("Check() of the environment layer should fail")
+	}
+}
+
+func TestApplyRemovesPlaintextKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `agents:
+  api:
+    type: claude-api
+    api_key: sk-ant-plaintext
+    api_key_env: WORK_ANTHROPIC_KEY
+`)
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WORK_ANTHROPIC_KEY", "sk-from-env")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := cfg.Apply(Edit{Layer: LayerGlobal, Set: map[string]string{"agents.api.model": "claude-haiku-4-5"}})
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "sk-ant") || strings.Contains(string(data), "api_key:") {
+		t.Errorf("file after Apply() holds the key:\n%s", data)
+	}
+	if !strings.Contains(string(data), "api_key_env: WORK_ANTHROPIC_KEY") || !strings.Contains(string(data), "model: claude-haiku-4-5") {
+		t.Errorf("file after Apply():\n%s", data)
+	}
+	if key, _ := applied.APIKey("api"); string(key) != "sk-from-env" {
+		t.Errorf("APIKey() = %q, want the key from the environment", string(key))
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+}
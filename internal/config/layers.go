@@ -0,0 +1,481 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Layer is a level of configuration. Each layer overrides the ones before it.
+type Layer int
+
+const (
+	LayerDefault Layer = iota // built-in defaults
+	LayerGlobal               // ~/.ppopcode/config.yaml
+	LayerProject              // .ppopcode/config.yaml in the working directory
+	LayerEnv                  // PPOPCODE_* environment variables
+	LayerFlags                // command line flags
+)
+
+var layerNames = [...]string{"default", "global", "project", "env", "flags"}
+
+func (l Layer) String() string {
+	if l < 0 || int(l) >= len(layerNames) {
+		return fmt.Sprintf("layer(%d)", int(l))
+	}
+	return layerNames[l]
+}
+
+// EnvPrefix starts the environment variables that override settings.
+// The rest of the name is the setting's path with dots and dashes written
+// as underscores, e.g. PPOPCODE_AGENTS_SONNET_MODEL sets agents.sonnet.model.
+const EnvPrefix = "PPOPCODE_"
+
+// otherEnv lists PPOPCODE_* variables that are read elsewhere and are not
+// settings, e.g. PPOPCODE_ASCII which forces ASCII borders in the TUI
+var otherEnv = map[string]bool{
+	"PPOPCODE_ASCII": true,
+}
+
+// LoadOptions says where each layer is read from. Empty paths skip a layer.
+type LoadOptions struct {
+	GlobalPath  string
+	ProjectPath string
+	Env         []string // KEY=value pairs, usually os.Environ()
+	Flags       []string // key=value settings, e.g. "agents.sonnet.model=claude-opus-4-5"
+}
+
+// GlobalPath returns the user's config file, ~/.ppopcode/config.yaml
+func GlobalPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ProjectDir, "config.yaml")
+}
+
+// ProjectPath returns the config file of the project in dir
+func ProjectPath(dir string) string {
+	return filepath.Join(dir, ProjectDir, "config.yaml")
+}
+
+// DefaultLoadOptions reads the global config, the config of the project
+// in dir and the environment
+func DefaultLoadOptions(dir string) LoadOptions {
+	opts := LoadOptions{GlobalPath: GlobalPath(), Env: os.Environ()}
+	// In the home directory the project file is the global one
+	if project := ProjectPath(dir); !sameFile(project, opts.GlobalPath) {
+		opts.ProjectPath = project
+	}
+	return opts
+}
+
+// LoadLayers merges the built-in defaults, the global and project config
+// files, the environment and the flags, in that order. Settings are merged
+// key by key, so a layer only overrides what it sets; lists are replaced
+// as a whole. Missing files are skipped.
+func LoadLayers(opts LoadOptions) (*Config, error) {
+	return loadLayers(opts, nil)
+}
+
+// loadLayers loads the layers, taking the contents of the files in
+// edited from there instead of reading them
+func loadLayers(opts LoadOptions, edited map[string]*yaml.Node) (*Config, error) {
+	layers := make(map[Layer]*yaml.Node)
+
+	var defaults yaml.Node
+	if err := defaults.Encode(DefaultConfig()); err != nil {
+		return nil, err
+	}
+	layers[LayerDefault] = &defaults
+
+	for _, file := range []struct {
+		layer Layer
+		path  string
+	}{{LayerGlobal, opts.GlobalPath}, {LayerProject, opts.ProjectPath}} {
+		if file.path == "" {
+			continue
+		}
+		node, found := edited[file.path]
+		if !found {
+			var err error
+			if node, err = readNode(file.path); err != nil {
+				return nil, fmt.Errorf("%s config: %w", file.layer, err)
+			}
+		}
+		if node != nil {
+			layers[file.layer] = node
+		}
+	}
+
+	// Environment variables are resolved against the settings defined so far
+	merged := &yaml.Node{Kind: yaml.MappingNode}
+	for layer := LayerDefault; layer <= LayerProject; layer++ {
+		if node := layers[layer]; node != nil {
+			mergeNodes(merged, node)
+		}
+	}
+
+	env, unknownEnv := envNode(opts.Env, merged)
+	if env != nil {
+		layers[LayerEnv] = env
+		mergeNodes(merged, env)
+	}
+
+	flags := &yaml.Node{Kind: yaml.MappingNode}
+	for _, setting := range opts.Flags {
+		key, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid setting %q, want key=value", setting)
+		}
+		path := strings.Split(strings.TrimSpace(key), ".")
+		t, ok := settingType(reflect.TypeOf(Config{}), path)
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		mergeNodes(flags, settingNode(path, t, value))
+	}
+	if len(flags.Content) > 0 {
+		layers[LayerFlags] = flags
+		mergeNodes(merged, flags)
+	}
+
+	// Decode each layer on its own first, so that type errors point at
+	// the file and line they come from
+	config := &Config{options: opts, layers: layers, unknownEnv: unknownEnv}
+	var problems []Problem
+	for layer := LayerGlobal; layer <= LayerFlags; layer++ {
+		if node := layers[layer]; node != nil {
+			if err := node.Decode(&Config{}); err != nil {
+				problems = append(problems, decodeProblems(config.source(layer), err)...)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	if err := merged.Decode(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Reload reads the layers again from where this config was loaded
+func (c *Config) Reload() (*Config, error) {
+	return LoadLayers(c.options)
+}
+
+// LayerPath returns the file of the global or project layer, or "" if the
+// config was not loaded with one
+func (c *Config) LayerPath(layer Layer) string {
+	switch layer {
+	case LayerGlobal:
+		return c.options.GlobalPath
+	case LayerProject:
+		return c.options.ProjectPath
+	}
+	return ""
+}
+
+// Origin returns the layer that set key, a dotted path such as
+// "agents.sonnet.model"
+func (c *Config) Origin(key string) Layer {
+	path := strings.Split(key, ".")
+	for layer := LayerFlags; layer > LayerDefault; layer-- {
+		if node := c.layers[layer]; node != nil && lookupNode(node, path) != nil {
+			return layer
+		}
+	}
+	return LayerDefault
+}
+
+// SetInFile sets key, a dotted path such as "agents.sonnet.model", in the
+// config file at path. Other settings and comments in the file are kept.
+func SetInFile(path, key, value string) error {
+	keys := strings.Split(key, ".")
+	t, ok := settingType(reflect.TypeOf(Config{}), keys)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+
+	doc, err := readNode(path)
+	if err != nil {
+		return err
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	if doc != nil {
+		root = doc
+	}
+	mergeNodes(root, settingNode(keys, t, value))
+	return writeNode(path, root)
+}
+
+// writeNode writes a config file, creating its directory. Plaintext API
+// keys are left out, and only the user can read the file.
+func writeNode(path string, root *yaml.Node) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	removePlaintextKeys(root)
+	data, err := yaml.Marshal(root)
+	if err != nil {
+		return err
+	}
+	return writePrivate(path, data)
+}
+
+// writePrivate writes data to path and makes it readable only by the user,
+// also when the file already existed with wider permissions
+func writePrivate(path string, data []byte) error {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// readNode parses a config file, returning nil if it does not exist or is empty
+func readNode(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: config must be a mapping", path)
+	}
+	return root, nil
+}
+
+// mergeNodes merges the mapping src into the mapping dst. Nested mappings
+// are merged; any other value in src replaces the one in dst.
+func mergeNodes(dst, src *yaml.Node) {
+	if dst.Kind == yaml.DocumentNode {
+		dst = dst.Content[0]
+	}
+	if src.Kind == yaml.DocumentNode {
+		src = src.Content[0]
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		existing := lookupNode(dst, []string{key.Value})
+		switch {
+		case existing != nil && existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNodes(existing, value)
+		case existing != nil:
+			// Keep the comments of the value being replaced
+			head, line, foot := existing.HeadComment, existing.LineComment, existing.FootComment
+			*existing = *copyNode(value)
+			existing.HeadComment, existing.LineComment, existing.FootComment = head, line, foot
+		default:
+			dst.Content = append(dst.Content, copyNode(key), copyNode(value))
+		}
+	}
+}
+
+func copyNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
+
+// lookupNode returns the value at path in a mapping, or nil
+func lookupNode(n *yaml.Node, path []string) *yaml.Node {
+	if n.Kind == yaml.DocumentNode {
+		n = n.Content[0]
+	}
+	for _, key := range path {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				next = n.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
+
+// settingNode builds the mapping that sets path to value. Lists are given
+// as comma separated values.
+func settingNode(path []string, t reflect.Type, value string) *yaml.Node {
+	var leaf *yaml.Node
+	switch t.Kind() {
+	case reflect.Slice:
+		leaf = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				leaf.Content = append(leaf.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: item})
+			}
+		}
+	case reflect.String:
+		leaf = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	default:
+		// Let YAML resolve numbers and booleans
+		leaf = &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		leaf = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[i]},
+			leaf,
+		}}
+	}
+	return leaf
+}
+
+// settingType returns the type of the setting at path in t
+func settingType(t reflect.Type, path []string) (reflect.Type, bool) {
+	for _, key := range path {
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := yamlField(t, key)
+			if !ok {
+				return nil, false
+			}
+			t = field.Type
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return nil, false
+		}
+	}
+	return t, isLeaf(t)
+}
+
+// isLeaf reports whether a setting of type t can be set from a string
+func isLeaf(t reflect.Type) bool {
+	return t.Kind() != reflect.Struct && t.Kind() != reflect.Map
+}
+
+// yamlField finds the field of struct t named key in YAML
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if field.IsExported() && name == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// envNode collects the PPOPCODE_* variables in env and returns the names
+// that match no setting. Map keys, such as agent names, are matched
+// against the ones in merged first.
+func envNode(env []string, merged *yaml.Node) (*yaml.Node, []string) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	var unknown []string
+	for _, kv := range env {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) || otherEnv[name] {
+			continue
+		}
+		words := strings.Split(strings.ToLower(strings.TrimPrefix(name, EnvPrefix)), "_")
+		path, t, ok := envPath(reflect.TypeOf(Config{}), merged, words)
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		mergeNodes(node, settingNode(path, t, value))
+	}
+	if len(node.Content) == 0 {
+		return nil, unknown
+	}
+	return node, unknown
+}
+
+// envPath resolves the words of an environment variable name to a setting
+// path in t. existing holds the settings at this level, if any.
+func envPath(t reflect.Type, existing *yaml.Node, words []string) ([]string, reflect.Type, bool) {
+	if len(words) == 0 {
+		return nil, t, isLeaf(t)
+	}
+	next := func(key string, rest []string, elem reflect.Type) ([]string, reflect.Type, bool) {
+		var child *yaml.Node
+		if existing != nil {
+			child = lookupNode(existing, []string{key})
+		}
+		path, leaf, ok := envPath(elem, child, rest)
+		if !ok {
+			return nil, nil, false
+		}
+		return append([]string{key}, path...), leaf, true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if !field.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			if rest, ok := cutWords(words, name); ok {
+				if path, leaf, ok := next(name, rest, field.Type); ok {
+					return path, leaf, true
+				}
+			}
+		}
+	case reflect.Map:
+		if existing != nil && existing.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(existing.Content); i += 2 {
+				key := existing.Content[i].Value
+				if rest, ok := cutWords(words, key); ok {
+					if path, leaf, ok := next(key, rest, t.Elem()); ok {
+						return path, leaf, true
+					}
+				}
+			}
+		}
+		// A new key: take as few words as leave a valid setting
+		for n := 1; n <= len(words); n++ {
+			if path, leaf, ok := next(strings.Join(words[:n], "_"), words[n:], t.Elem()); ok {
+				return path, leaf, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// cutWords removes the words of key from the front of words
+func cutWords(words []string, key string) ([]string, bool) {
+	keyWords := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	})
+	if len(keyWords) == 0 || len(keyWords) > len(words) {
+		return nil, false
+	}
+	for i, w := range keyWords {
+		if words[i] != w {
+			return nil, false
+		}
+	}
+	return words[len(keyWords):], true
+}
+
+// sameFile reports whether a and b name the same path
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
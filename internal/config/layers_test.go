@@ -0,0 +1,198 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLayersPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	opts := LoadOptions{
+		GlobalPath:  filepath.Join(dir, "global.yaml"),
+		ProjectPath: filepath.Join(dir, "project", ProjectDir, "config.yaml"),
+	}
+	writeConfig(t, opts.GlobalPath, `agents:
+  sonnet:
+    model: global-model
+    max_tokens: 8192
+  haiku:
+    type: claude-api
+    model: haiku-model
+`)
+	writeConfig(t, opts.ProjectPath, `agents:
+  sonnet:
+    model: project-model
+`)
+
+	steps := []struct {
+		env   []string
+		flags []string
+		model string
+		layer Layer
+	}{
+		{nil, nil, "project-model", LayerProject},
+		{[]string{"PPOPCODE_AGENTS_SONNET_MODEL=env-model"}, nil, "env-model", LayerEnv},
+		{[]string{"PPOPCODE_AGENTS_SONNET_MODEL=env-model"}, []string{"agents.sonnet.model=flag-model"}, "flag-model", LayerFlags},
+	}
+	for _, step := range steps {
+		opts.Env, opts.Flags = step.env, step.flags
+		cfg, err := LoadLayers(opts)
+		if err != nil {
+			t.Fatalf("LoadLayers() error: %v", err)
+		}
+		sonnet := cfg.Agents["sonnet"]
+		if sonnet.Model != step.model {
+			t.Errorf("model = %q, want %q", sonnet.Model, step.model)
+		}
+		if got := cfg.Origin("agents.sonnet.model"); got != step.layer {
+			t.Errorf("Origin(model) = %s, want %s", got, step.layer)
+		}
+
+		// Lower layers still fill in the settings the higher ones leave out
+		if sonnet.Type != "claude" || sonnet.Role != "Main coding assistant" || sonnet.MaxTokens != 8192 {
+			t.Errorf("sonnet = %+v, want defaults and global settings kept", sonnet)
+		}
+		if cfg.Agents["haiku"].Model != "haiku-model" || cfg.Origin("agents.haiku.model") != LayerGlobal {
+			t.Errorf("haiku = %+v", cfg.Agents["haiku"])
+		}
+		if cfg.Origin("cursor.command") != LayerDefault {
+			t.Errorf("Origin(cursor.command) = %s, want default", cfg.Origin("cursor.command"))
+		}
+	}
+}
+
+func TestLoadLayersEnv(t *testing.T) {
+	global := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, global, `agents:
+  sonnet-api:
+    type: claude-api
+`)
+	cfg, err := LoadLayers(LoadOptions{
+		GlobalPath: global,
+		Env: []string{
+			"PPOPCODE_SESSION_MAX_HISTORY=5",
+			"PPOPCODE_SESSION_SAVE_HISTORY=false",
+			"PPOPCODE_AGENTS_SONNET_API_MAX_TOKENS=1024",
+			"PPOPCODE_AGENTS_OPUS_MODEL=claude-opus",
+			"PPOPCODE_BUDGET_PER_DAY=2.5",
+			"PPOPCODE_FALLBACKS_SONNET=haiku, opus",
+			"PPOPCODE_NOT_A_SETTING=1",
+			"HOME=/somewhere",
+		},
+	})
+	if err != nil {
+		t.Fatalf("LoadLayers() error: %v", err)
+	}
+
+	if cfg.Session.MaxHistory != 5 || cfg.Session.SaveHistory {
+		t.Errorf("session = %+v", cfg.Session)
+	}
+	if cfg.Agents["sonnet-api"].MaxTokens != 1024 {
+		t.Errorf("sonnet-api = %+v, want max_tokens from the environment", cfg.Agents["sonnet-api"])
+	}
+	if cfg.Agents["opus"].Model != "claude-opus" {
+		t.Errorf("opus = %+v, want a new agent from the environment", cfg.Agents["opus"])
+	}
+	if cfg.Budget.PerDay != 2.5 {
+		t.Errorf("budget = %+v", cfg.Budget)
+	}
+	if got := strings.Join(cfg.Fallbacks["sonnet"], ","); got != "haiku,opus" {
+		t.Errorf("fallbacks = %q", got)
+	}
+}
+
+func TestLoadLayersInvalidFlags(t *testing.T) {
+	for _, flag := range []string{"agents.sonnet.model", "agents.sonnet.colour=red", "agents.sonnet=x", "nope=1"} {
+		if _, err := LoadLayers(LoadOptions{Flags: []string{flag}}); err == nil {
+			t.Errorf("LoadLayers() with flag %q should fail", flag)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	global := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, global, "agents:\n  sonnet:\n    model: before\n")
+	cfg, err := LoadLayers(LoadOptions{GlobalPath: global})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig(t, global, "agents:\n  sonnet:\n    model: after\n")
+	reloaded, err := cfg.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	if reloaded.Agents["sonnet"].Model != "after" || reloaded.LayerPath(LayerGlobal) != global {
+		t.Errorf("reloaded = %+v", reloaded.Agents["sonnet"])
+	}
+}
+
+func TestSetInFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ProjectDir, "config.yaml")
+	if err := SetInFile(path, "agents.sonnet.model", "new-model"); err != nil {
+		t.Fatalf("SetInFile() on a new file error: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "cursor") {
+		t.Errorf("new file should only hold the setting:\n%s", data)
+	}
+
+	writeConfig(t, path, `# team settings
+agents:
+  sonnet:
+    model: old-model # pinned for now
+    max_tokens: 2048
+`)
+	if err := SetInFile(path, "agents.sonnet.model", "new-model"); err != nil {
+		t.Fatalf("SetInFile() error: %v", err)
+	}
+	if err := SetInFile(path, "agents.sonnet.allowed_tools", "Read,Grep"); err != nil {
+		t.Fatalf("SetInFile() list error: %v", err)
+	}
+	if err := SetInFile(path, "agents.sonnet.colour", "red"); err == nil {
+		t.Error("SetInFile() should reject unknown settings")
+	}
+
+	data, _ = os.ReadFile(path)
+	for _, want := range []string{"# team settings", "# pinned for now", "max_tokens: 2048"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("file lost %q:\n%s", want, data)
+		}
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sonnet := cfg.Agents["sonnet"]
+	if sonnet.Model != "new-model" || len(sonnet.AllowedTools) != 2 || sonnet.MaxTokens != 2048 {
+		t.Errorf("sonnet = %+v", sonnet)
+	}
+}
+
+func TestDefaultLoadOptions(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	opts := DefaultLoadOptions(filepath.Join(home, "project"))
+	if opts.GlobalPath != filepath.Join(home, ProjectDir, "config.yaml") {
+		t.Errorf("GlobalPath = %q", opts.GlobalPath)
+	}
+	if opts.ProjectPath != filepath.Join(home, "project", ProjectDir, "config.yaml") {
+		t.Errorf("ProjectPath = %q", opts.ProjectPath)
+	}
+	if opts := DefaultLoadOptions(home); opts.ProjectPath != "" {
+		t.Errorf("in the home directory ProjectPath = %q, want none", opts.ProjectPath)
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// ProjectDir is the directory in a repository that holds project-level
+// overrides: prompts/<agent>.md replaces an agent's system prompt and
+// personas/<name>.md adds a persona
+const ProjectDir = ".ppopcode"
+
+// PersonaConfig defines a persona whose prompt is added to the system
+// prompt of chat requests
+type PersonaConfig struct {
+	Description string `yaml:"description,omitempty"`
+	Prompt      string `yaml:"prompt,omitempty"`
+	PromptFile  string `yaml:"prompt_file,omitempty"`
+}
+
+// Persona is a resolved persona
+type Persona struct {
+	Name        string
+	Description string
+	Prompt      string
+}
+
+// inlineSystemPrompt returns the configured prompt, falling back to the role
+func (ac AgentConfig) inlineSystemPrompt() string {
+	if ac.SystemPrompt != "" {
+		return ac.SystemPrompt
+	}
+	if ac.Role != "" {
+		return "Your role: " + ac.Role + "."
+	}
+	return ""
+}
+
+// LoadSystemPrompts fills in system prompts that come from files: the
+// agent's system_prompt_file, replaced by <projectDir>/.ppopcode/prompts/<agent>.md
+// when the project has one
+func (c *Config) LoadSystemPrompts(configs map[string]agents.AgentConfig, projectDir string) error {
+	for name, ac := range configs {
+		prompt, err := readPromptFile(filepath.Join(projectDir, ProjectDir, "prompts", name+".md"))
+		if err != nil {
+			return err
+		}
+		if prompt == "" && c.Agents[name].SystemPromptFile != "" {
+			path := resolvePath(c.Agents[name].SystemPromptFile, projectDir)
+			if prompt, err = readPromptFile(path); err != nil {
+				return err
+			}
+			if prompt == "" {
+				return fmt.Errorf("agent %s: system prompt file %s not found", name, path)
+			}
+		}
+		if prompt != "" {
+			ac.SystemPrompt = prompt
+			configs[name] = ac
+		}
+	}
+	return nil
+}
+
+// LoadPersonas returns the personas from config and from
+// <projectDir>/.ppopcode/personas/*.md, sorted by name. Project personas
+// override configured ones with the same name.
+func (c *Config) LoadPersonas(projectDir string) ([]Persona, error) {
+	byName := make(map[string]Persona)
+	for name, pc := range c.Personas {
+		prompt := pc.Prompt
+		if pc.PromptFile != "" {
+			path := resolvePath(pc.PromptFile, projectDir)
+			text, err := readPromptFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if text == "" {
+				return nil, fmt.Errorf("persona %s: prompt file %s not found", name, path)
+			}
+			prompt = text
+		}
+		byName[name] = Persona{Name: name, Description: pc.Description, Prompt: prompt}
+	}
+
+	dir := filepath.Join(projectDir, ProjectDir, "personas")
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read personas: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".md" {
+			continue
+		}
+		prompt, err := readPromptFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(entry.Name(), ".md")
+		byName[name] = Persona{Name: name, Description: describePrompt(prompt), Prompt: prompt}
+	}
+
+	personas := make([]Persona, 0, len(byName))
+	for _, p := range byName {
+		if p.Prompt != "" {
+			personas = append(personas, p)
+		}
+	}
+	sort.Slice(personas, func(i, j int) bool { return personas[i].Name < personas[j].Name })
+	return personas, nil
+}
+
+// readPromptFile returns the trimmed contents of path, or "" if it does not exist
+func readPromptFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read prompt: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// resolvePath expands ~ and makes relative paths relative to dir
+func resolvePath(path, dir string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// describePrompt uses the first line of a persona file as its description
+func describePrompt(prompt string) string {
+	line, _, _ := strings.Cut(prompt, "\n")
+	return strings.TrimSpace(strings.TrimLeft(line, "# "))
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestLoadSystemPrompts(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "prompts", "haiku.md"), "Answer briefly.\n")
+	writeFile(t, filepath.Join(dir, ProjectDir, "prompts", "opus.md"), "Follow this repo's style guide.")
+
+	cfg := &Config{Agents: map[string]AgentConfig{
+		"sonnet": {Type: "claude", Role: "Main coding assistant"},
+		"haiku":  {Type: "claude", SystemPromptFile: "prompts/haiku.md"},
+		"opus":   {Type: "claude", SystemPrompt: "Be thorough."},
+	}}
+	configs := cfg.ToAgentConfigs()
+	if err := cfg.LoadSystemPrompts(configs, dir); err != nil {
+		t.Fatalf("LoadSystemPrompts() error: %v", err)
+	}
+
+	tests := map[string]string{
+		"sonnet": "Your role: Main coding assistant.",
+		"haiku":  "Answer briefly.",
+		"opus":   "Follow this repo's style guide.", // project file overrides config
+	}
+	for name, want := range tests {
+		if got := configs[name].SystemPrompt; got != want {
+			t.Errorf("%s SystemPrompt = %q, want %q", name, got, want)
+		}
+	}
+
+	cfg.Agents["haiku"] = AgentConfig{Type: "claude", SystemPromptFile: "missing.md"}
+	if err := cfg.LoadSystemPrompts(cfg.ToAgentConfigs(), dir); err == nil {
+		t.Error("LoadSystemPrompts() should fail for a missing prompt file")
+	}
+}
+
+func TestLoadPersonas(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ProjectDir, "personas", "reviewer.md"), "# Strict code reviewer\nPoint out every bug.")
+
+	cfg := &Config{Personas: map[string]PersonaConfig{
+		"teacher":  {Description: "Explains concepts", Prompt: "Explain step by step."},
+		"reviewer": {Description: "Overridden", Prompt: "Be nice."},
+	}}
+	personas, err := cfg.LoadPersonas(dir)
+	if err != nil {
+		t.Fatalf("LoadPersonas() error: %v", err)
+	}
+	if len(personas) != 2 || personas[0].Name != "reviewer" || personas[1].Name != "teacher" {
+		t.Fatalf("personas = %+v, want reviewer and teacher", personas)
+	}
+	if personas[0].Description != "Strict code reviewer" || personas[0].Prompt != "# Strict code reviewer\nPoint out every bug." {
+		t.Errorf("project persona = %+v", personas[0])
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ppopcode/ppopcode/internal/secrets"
+)
+
+// apiKeySetting returns the setting the agent's API key comes from, or ""
+func (ac AgentConfig) apiKeySetting() string {
+	switch {
+	case ac.APIKeySecret != "":
+		return "api_key_secret"
+	case ac.APIKeyFile != "":
+		return "api_key_file"
+	case ac.APIKeyEnv != "":
+		return "api_key_env"
+	case ac.APIKey != "":
+		return "api_key"
+	}
+	return ""
+}
+
+// APIKey resolves the API key of the named agent from its api_key_secret,
+// api_key_file, api_key_env or api_key setting, in that order. It returns
+// "" if none is set, leaving the agent to use ANTHROPIC_API_KEY.
+func (c *Config) APIKey(name string) (secrets.Secret, error) {
+	ac := c.Agents[name]
+	switch ac.apiKeySetting() {
+	case "api_key_secret":
+		key, err := secrets.DefaultStore().Get(ac.APIKeySecret)
+		if errors.Is(err, secrets.ErrNotFound) {
+			return "", fmt.Errorf("%w (add it with 'ppopcode config secret set %s')", err, ac.APIKeySecret)
+		}
+		return key, err
+	case "api_key_file":
+		path := resolvePath(ac.APIKeyFile, c.configDir("agents."+name+".api_key_file"))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read the API key: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("%s is empty", path)
+		}
+		return secrets.Secret(key), nil
+	case "api_key_env":
+		key := os.Getenv(ac.APIKeyEnv)
+		if key == "" {
+			return "", fmt.Errorf("environment variable %s is not set", ac.APIKeyEnv)
+		}
+		return secrets.Secret(key), nil
+	}
+	return ac.APIKey, nil
+}
+
+// configDir returns the directory relative paths in key are resolved
+// against: that of the config file that set it, or the working directory
+func (c *Config) configDir(key string) string {
+	switch layer := c.Origin(key); layer {
+	case LayerGlobal, LayerProject:
+		return filepath.Dir(c.LayerPath(layer))
+	}
+	return ""
+}
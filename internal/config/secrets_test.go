@@ -0,0 +1,101 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ppopcode/ppopcode/internal/secrets"
+)
+
+func TestAPIKeySources(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("WORK_ANTHROPIC_KEY", "sk-from-env")
+	if err := secrets.DefaultStore().Set("anthropic", "sk-from-store"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "keys", "anthropic"), "sk-from-file\n")
+	path := filepath.Join(dir, "config.yaml")
+	writeConfig(t, path, `agents:
+  store:
+    type: claude-api
+    api_key_secret: anthropic
+    api_key: ignored
+  file:
+    type: claude-api
+    api_key_file: keys/anthropic
+  env:
+    type: claude-api
+    api_key_env: WORK_ANTHROPIC_KEY
+  inline:
+    type: claude-api
+    api_key: sk-inline
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+
+	configs := cfg.ToAgentConfigs()
+	for name, want := range map[string]string{
+		"store":  "sk-from-store",
+		"file":   "sk-from-file",
+		"env":    "sk-from-env",
+		"inline": "sk-inline",
+		"sonnet": "",
+	} {
+		if got := string(configs[name].APIKey); got != want {
+			t.Errorf("%s: APIKey = %q, want %q", name, got, want)
+		}
+	}
+
+	// Saving leaves every key out
+	saved := filepath.Join(t.TempDir(), "saved.yaml")
+	if err := cfg.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(saved)
+	if strings.Contains(string(data), "sk-") || strings.Contains(string(data), "api_key:") {
+		t.Errorf("saved config holds a key:\n%s", data)
+	}
+	if !strings.Contains(string(data), "api_key_secret: anthropic") {
+		t.Errorf("saved config lost the key references:\n%s", data)
+	}
+}
+
+func TestValidateAPIKeys(t *testing.T) {
+	_, err := validate(t, `agents:
+  store:
+    type: claude-api
+    api_key_secret: missing
+  file:
+    type: claude-api
+    api_key_file: /nonexistent/key
+  env:
+    type: claude-api
+    api_key_env: UNSET_ANTHROPIC_KEY
+  cli:
+    type: claude
+    api_key_env: UNSET_ANTHROPIC_KEY
+`)
+	got := strings.Join(problems(t, err), "\n")
+	for _, want := range []string{
+		`config.yaml:4: agents.store.api_key_secret: secret not found: missing (add it with 'ppopcode config secret set missing')`,
+		`config.yaml:7: agents.file.api_key_file: cannot read the API key`,
+		`config.yaml:10: agents.env.api_key_env: environment variable UNSET_ANTHROPIC_KEY is not set`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("problems do not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "agents.cli") {
+		t.Errorf("CLI agents do not use API keys, got:\n%s", got)
+	}
+}
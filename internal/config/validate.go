@@ -0,0 +1,380 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/orchestrator"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is one thing wrong with the configuration
+type Problem struct {
+	Source  string // config file, "environment" or "command line"
+	Line    int    // 0 when unknown
+	Key     string // dotted path of the setting, e.g. "agents.sonnet.type"
+	Message string
+}
+
+// String formats the problem as "file:line: key: message"
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.Source != "" {
+		b.WriteString(p.Source)
+		if p.Line > 0 {
+			b.WriteString(":" + strconv.Itoa(p.Line))
+		}
+		b.WriteString(": ")
+	}
+	if p.Key != "" {
+		b.WriteString(p.Key + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// ValidationError lists everything wrong with a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	if len(lines) == 1 {
+		return "invalid config: " + lines[0]
+	}
+	return fmt.Sprintf("invalid config (%d problems):\n  %s", len(lines), strings.Join(lines, "\n  "))
+}
+
+// yamlLine finds "line N: " in YAML decoding errors
+var yamlLine = regexp.MustCompile(`^line (\d+): `)
+
+// decodeProblems turns a YAML decoding error into problems
+func decodeProblems(source string, err error) []Problem {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return []Problem{{Source: source, Message: err.Error()}}
+	}
+	problems := make([]Problem, 0, len(typeErr.Errors))
+	for _, msg := range typeErr.Errors {
+		p := Problem{Source: source, Message: msg}
+		if m := yamlLine.FindStringSubmatch(msg); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Message = msg[len(m[0]):]
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+// source describes where a layer comes from
+func (c *Config) source(layer Layer) string {
+	switch layer {
+	case LayerGlobal, LayerProject:
+		return c.LayerPath(layer)
+	case LayerEnv:
+		return "environment"
+	case LayerFlags:
+		return "command line"
+	}
+	return ""
+}
+
+// problem reports a problem with key, located in the layer that set it
+func (c *Config) problem(key, format string, args ...any) Problem {
+	layer := c.Origin(key)
+	p := Problem{Source: c.source(layer), Key: key, Message: fmt.Sprintf(format, args...)}
+	if node := c.layers[layer]; node != nil {
+		if value := lookupNode(node, strings.Split(key, ".")); value != nil {
+			p.Line = value.Line
+		}
+	}
+	return p
+}
+
+// Validate checks the configuration: unknown keys in the config files and
+// settings that cannot work, such as unknown agent types or agents that are
+// referenced but not defined. It returns a *ValidationError listing every
+// problem, or nil.
+func (c *Config) Validate() error {
+	var problems []Problem
+
+	for _, layer := range []Layer{LayerGlobal, LayerProject} {
+		if node := c.layers[layer]; node != nil {
+			problems = append(problems, unknownKeys(c.source(layer), node, reflect.TypeOf(Config{}), nil)...)
+		}
+	}
+
+	problems = append(problems, c.validateAgents()...)
+	problems = append(problems, c.validateSettings()...)
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// Warnings lists things that look wrong but do not stop the config from
+// working: PPOPCODE_* environment variables that name no setting, which
+// may be typos or may belong to another tool
+func (c *Config) Warnings() []Problem {
+	var warnings []Problem
+	for _, name := range c.unknownEnv {
+		warnings = append(warnings, Problem{Source: "environment", Key: name, Message: "does not name a setting"})
+	}
+	return warnings
+}
+
+func (c *Config) validateAgents() []Problem {
+	var problems []Problem
+	for _, name := range sortedKeys(c.Agents) {
+		ac := c.Agents[name]
+		key := "agents." + name
+		if _, known := agentTypes[ac.Type]; !known {
+			if ac.Type == "" {
+				problems = append(problems, c.problem(key, "type is not set (want %s)", agentTypeList()))
+			} else {
+				problems = append(problems, c.problem(key+".type", "unknown agent type %q (want %s)%s", ac.Type, agentTypeList(), suggest(ac.Type, sortedKeys(agentTypes))))
+			}
+		}
+		if ac.Type == "mock" && ac.Fixture == "" {
+			problems = append(problems, c.problem(key, "mock agents need a fixture"))
+		}
+		if ac.PermissionMode != "" {
+			valid := false
+			modes := make([]string, len(agents.PermissionModes))
+			for i, mode := range agents.PermissionModes {
+				modes[i] = string(mode)
+				valid = valid || string(mode) == ac.PermissionMode
+			}
+			if !valid {
+				problems = append(problems, c.problem(key+".permission_mode", "unknown permission mode %q (want %s)%s", ac.PermissionMode, strings.Join(modes, ", "), suggest(ac.PermissionMode, modes)))
+			}
+		}
+		if ac.MaxTokens < 0 {
+			problems = append(problems, c.problem(key+".max_tokens", "must not be negative"))
+		}
+		if ac.MaxConcurrent < 0 {
+			problems = append(problems, c.problem(key+".max_concurrent", "must not be negative"))
+		}
+		if setting := ac.apiKeySetting(); setting != "" && agentTypes[ac.Type] == agents.AgentTypeClaudeAPI {
+			if _, err := c.APIKey(name); err != nil {
+				problems = append(problems, c.problem(key+"."+setting, "%v", err))
+			}
+		}
+		if ac.SystemPrompt != "" && ac.SystemPromptFile != "" {
+			problems = append(problems, c.problem(key+".system_prompt_file", "set system_prompt or system_prompt_file, not both"))
+		}
+	}
+
+	// Agents referenced by other settings must exist
+	names := sortedKeys(c.Agents)
+	reference := func(key, name string) {
+		if _, exists := c.Agents[name]; name != "" && !exists {
+			problems = append(problems, c.problem(key, "agent %q is not defined%s", name, suggest(name, names)))
+		}
+	}
+	for _, name := range sortedKeys(c.Fallbacks) {
+		reference("fallbacks."+name, name)
+		for _, fallback := range c.Fallbacks[name] {
+			reference("fallbacks."+name, fallback)
+		}
+	}
+	taskTypes := make([]string, len(orchestrator.TaskTypes))
+	for i, taskType := range orchestrator.TaskTypes {
+		taskTypes[i] = string(taskType)
+	}
+	for _, taskType := range sortedKeys(c.Routes) {
+		if !slices.Contains(taskTypes, taskType) {
+			problems = append(problems, c.problem("routes."+taskType, "unknown task type %q (want %s)%s", taskType, strings.Join(taskTypes, ", "), suggest(taskType, taskTypes)))
+		}
+		reference("routes."+taskType, c.Routes[taskType])
+	}
+	reference("pipeline.planner", c.Pipeline.Planner)
+	if c.Pipeline.Implementer != orchestrator.CursorImplementer {
+		reference("pipeline.implementer", c.Pipeline.Implementer)
+	}
+	reference("pipeline.reviewer", c.Pipeline.Reviewer)
+	return problems
+}
+
+func (c *Config) validateSettings() []Problem {
+	var problems []Problem
+
+	if c.Cursor.Command == "" {
+		problems = append(problems, c.problem("cursor.command", "must not be empty"))
+	}
+	if c.Cursor.Timeout <= 0 {
+		problems = append(problems, c.problem("cursor.timeout", "must be a positive number of seconds, got %d", c.Cursor.Timeout))
+	}
+	if c.Cursor.MaxRetry < 0 {
+		problems = append(problems, c.problem("cursor.max_retry", "must not be negative"))
+	}
+
+	if c.Undo.Keep < 0 {
+		problems = append(problems, c.problem("undo.keep", "must not be negative"))
+	}
+
+	if c.Session.MaxHistory < 0 {
+		problems = append(problems, c.problem("session.max_history", "must not be negative"))
+	}
+	if c.Session.SaveHistory {
+		if err := checkWritable(c.Session.Path()); err != nil {
+			problems = append(problems, c.problem("session.history_dir", "%v", err))
+		}
+	}
+
+	for _, limit := range []struct {
+		key   string
+		value float64
+	}{
+		{"budget.per_request", c.Budget.PerRequest},
+		{"budget.per_session", c.Budget.PerSession},
+		{"budget.per_workflow", c.Budget.PerWorkflow},
+		{"budget.per_day", c.Budget.PerDay},
+	} {
+		if limit.value < 0 {
+			problems = append(problems, c.problem(limit.key, "must not be negative"))
+		}
+	}
+	if c.Budget.WarnAt < 0 || c.Budget.WarnAt > 1 {
+		problems = append(problems, c.problem("budget.warn_at", "must be between 0 and 1, got %g", c.Budget.WarnAt))
+	}
+	if c.Pipeline.MaxTasks < 0 {
+		problems = append(problems, c.problem("pipeline.max_tasks", "must not be negative"))
+	}
+
+	for _, name := range sortedKeys(c.Commands) {
+		if strings.TrimSpace(c.Commands[name].Template) == "" {
+			problems = append(problems, c.problem("commands."+name, "template is empty"))
+		}
+	}
+	for _, name := range sortedKeys(c.Personas) {
+		if p := c.Personas[name]; p.Prompt == "" && p.PromptFile == "" {
+			problems = append(problems, c.problem("personas."+name, "set prompt or prompt_file"))
+		}
+	}
+
+	if c.Cassette.Record != "" && c.Cassette.Replay != "" {
+		problems = append(problems, c.problem("cassette", "record and replay cannot both be set"))
+	}
+	return problems
+}
+
+// unknownKeys reports keys in node that are not fields of t
+func unknownKeys(source string, node *yaml.Node, t reflect.Type, path []string) []Problem {
+	var problems []Problem
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		var fields []string
+		for i := 0; i < t.NumField(); i++ {
+			if name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); t.Field(i).IsExported() && name != "" && name != "-" {
+				fields = append(fields, name)
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := append(append([]string(nil), path...), key.Value)
+			field, ok := yamlField(t, key.Value)
+			if !ok {
+				problems = append(problems, Problem{
+					Source:  source,
+					Line:    key.Line,
+					Key:     strings.Join(keyPath, "."),
+					Message: "unknown setting" + suggest(key.Value, fields),
+				})
+				continue
+			}
+			problems = append(problems, unknownKeys(source, value, field.Type, keyPath)...)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyPath := append(append([]string(nil), path...), node.Content[i].Value)
+			problems = append(problems, unknownKeys(source, node.Content[i+1], t.Elem(), keyPath)...)
+		}
+	}
+	return problems
+}
+
+// checkWritable checks that dir, or its nearest existing parent when dir
+// does not exist yet, is a directory with write permission. It looks at
+// the permission bits only and does not touch the filesystem.
+func checkWritable(dir string) error {
+	path := filepath.Clean(dir)
+	for {
+		info, err := os.Stat(path)
+		switch {
+		case err == nil && !info.IsDir():
+			return fmt.Errorf("cannot create %s: %s is not a directory", dir, path)
+		case err == nil && info.Mode().Perm()&0200 == 0:
+			return fmt.Errorf("%s is not writable", path)
+		case err == nil:
+			return nil
+		case !errors.Is(err, fs.ErrNotExist):
+			return fmt.Errorf("cannot create %s: %w", dir, err)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return fmt.Errorf("cannot create %s: no parent directory exists", dir)
+		}
+		path = parent
+	}
+}
+
+// suggest returns ` (did you mean "x"?)` for the closest of options to s,
+// or "" if none is close
+func suggest(s string, options []string) string {
+	best, bestDistance := "", len(s)/2+1
+	for _, option := range options {
+		if d := editDistance(strings.ToLower(s), strings.ToLower(option)); d < bestDistance {
+			best, bestDistance = option, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
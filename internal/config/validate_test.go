@@ -0,0 +1,174 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// validate loads content as the global config and validates it
+func validate(t *testing.T, content string, env ...string) (*Config, error) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, content)
+	cfg, err := LoadLayers(LoadOptions{GlobalPath: path, Env: env})
+	if err != nil {
+		return nil, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// problems returns the problems of a validation error as strings
+func problems(t *testing.T, err error) []string {
+	t.Helper()
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error = %v, want a *ValidationError", err)
+	}
+	var lines []string
+	for _, p := range invalid.Problems {
+		lines = append(lines, p.String())
+	}
+	return lines
+}
+
+func TestValidateDefaults(t *testing.T) {
+	if _, err := validate(t, ""); err != nil {
+		t.Errorf("Validate() of the defaults = %v", err)
+	}
+	if _, err := validate(t, "agents:\n  haiku:\n    type: claude-api\nfallbacks:\n  sonnet: [haiku]\npipeline:\n  implementer: cursor\n"); err != nil {
+		t.Errorf("Validate() of a valid config = %v", err)
+	}
+}
+
+func TestValidateReportsProblems(t *testing.T) {
+	_, err := validate(t, `agents:
+  sonnet:
+    type: claud
+    max_tokns: 100
+  test:
+    type: mock
+  reader:
+    type: claude
+    permission_mode: acceptEdit
+cursor:
+  timeout: 0
+fallbacks:
+  sonnet: [haiku]
+pipeline:
+  reviewer: opus
+budget:
+  warn_at: 80
+cassette:
+  record: a.json
+  replay: b.json
+routes:
+  genral: sonnet
+  code: opsu
+undo:
+  keep: -1
+`, "PPOPCODE_SESION_MAX_HISTORY=3")
+
+	got := strings.Join(problems(t, err), "\n")
+	for _, want := range []string{
+		`config.yaml:4: agents.sonnet.max_tokns: unknown setting (did you mean "max_tokens"?)`,
+		`config.yaml:3: agents.sonnet.type: unknown agent type "claud"`,
+		`(did you mean "claude"?)`,
+		`config.yaml:6: agents.test: mock agents need a fixture`,
+		`config.yaml:9: agents.reader.permission_mode: unknown permission mode "acceptEdit"`,
+		`config.yaml:11: cursor.timeout: must be a positive number of seconds, got 0`,
+		`config.yaml:13: fallbacks.sonnet: agent "haiku" is not defined`,
+		`config.yaml:15: pipeline.reviewer: agent "opus" is not defined`,
+		`config.yaml:17: budget.warn_at: must be between 0 and 1, got 80`,
+		`cassette: record and replay cannot both be set`,
+		`config.yaml:22: routes.genral: unknown task type "genral" (want general, ui, design, debug, code) (did you mean "general"?)`,
+		`config.yaml:23: routes.code: agent "opsu" is not defined`,
+		`config.yaml:25: undo.keep: must not be negative`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("problems do not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "PPOPCODE_SESION_MAX_HISTORY") {
+		t.Errorf("unknown environment variables should be warnings, not problems:\n%s", got)
+	}
+}
+
+func TestValidateUnknownEnv(t *testing.T) {
+	cfg, err := validate(t, "", "PPOPCODE_ASCII=1", "PPOPCODE_SESION_MAX_HISTORY=3")
+	if err != nil {
+		t.Fatalf("Validate() = %v, want unknown environment variables to pass", err)
+	}
+	var got []string
+	for _, w := range cfg.Warnings() {
+		got = append(got, w.String())
+	}
+	if want := []string{"environment: PPOPCODE_SESION_MAX_HISTORY: does not name a setting"}; !slices.Equal(got, want) {
+		t.Errorf("Warnings() = %q, want %q", got, want)
+	}
+}
+
+func TestValidateHistoryDir(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	writeConfig(t, file, "not a directory")
+
+	_, err := validate(t, "session:\n  history_dir: "+filepath.Join(file, "history")+"\n")
+	if got := problems(t, err); len(got) != 1 || !strings.Contains(got[0], "session.history_dir: cannot create") {
+		t.Errorf("problems = %v", got)
+	}
+
+	// Validating does not create the directory
+	dir := filepath.Join(t.TempDir(), "missing", "history")
+	if _, err := validate(t, "session:\n  history_dir: "+dir+"\n"); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(dir)); !os.IsNotExist(err) {
+		t.Errorf("Validate() created %s", filepath.Dir(dir))
+	}
+
+	// Not saving history needs no directory
+	if _, err := validate(t, "session:\n  save_history: false\n  history_dir: "+filepath.Join(file, "history")+"\n"); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
+
+func TestLoadLayersTypeErrors(t *testing.T) {
+	_, err := validate(t, "session:\n  max_history: lots\n")
+	got := problems(t, err)
+	if len(got) != 1 || !strings.Contains(got[0], "config.yaml:2: cannot unmarshal") {
+		t.Errorf("problems = %v, want the file and line of the bad value", got)
+	}
+
+	_, err = validate(t, "", "PPOPCODE_SESSION_MAX_HISTORY=lots")
+	if got := problems(t, err); len(got) != 1 || !strings.HasPrefix(got[0], "environment: ") {
+		t.Errorf("problems = %v, want one from the environment", got)
+	}
+}
+
+func TestSessionPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for dir, want := range map[string]string{
+		".ppopcode/history": filepath.Join(home, ".ppopcode/history"),
+		"~/history":         filepath.Join(home, "history"),
+		"/var/lib/ppopcode": "/var/lib/ppopcode",
+	} {
+		if got := (SessionConfig{HistoryDir: dir}).Path(); got != want {
+			t.Errorf("Path() of %q = %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	options := []string{"model", "max_tokens", "role"}
+	if got := suggest("modle", options); got != ` (did you mean "model"?)` {
+		t.Errorf("suggest(modle) = %q", got)
+	}
+	if got := suggest("something_else", options); got != "" {
+		t.Errorf("suggest(something_else) = %q, want no suggestion", got)
+	}
+}
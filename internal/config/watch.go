@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	"github.com/ppopcode/ppopcode/internal/orchestrator"
+)
+
+// Reloaded is sent by Watch when a config file changes. Err is set, and
+// Config is nil, if the changed config does not load or validate.
+type Reloaded struct {
+	Config *Config
+	Err    error
+}
+
+// Watch polls the global and project config files every interval and
+// sends the reloaded, validated config when either changes. A file that
+// is created or deleted counts as a change. The channel is closed when
+// ctx is done.
+func (c *Config) Watch(ctx context.Context, interval time.Duration) <-chan Reloaded {
+	reloads := make(chan Reloaded)
+	paths := []string{c.options.GlobalPath, c.options.ProjectPath}
+	contents := readFiles(paths)
+
+	go func() {
+		defer close(reloads)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		current := c
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			latest := readFiles(paths)
+			if equalContents(contents, latest) {
+				continue
+			}
+			contents = latest
+
+			reloaded := Reloaded{}
+			if cfg, err := current.Reload(); err != nil {
+				reloaded.Err = err
+			} else if err := cfg.Validate(); err != nil {
+				reloaded.Err = err
+			} else {
+				reloaded.Config, current = cfg, cfg
+			}
+
+			select {
+			case reloads <- reloaded:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return reloads
+}
+
+// readFiles reads each path, with nil for missing or unset files
+func readFiles(paths []string) [][]byte {
+	contents := make([][]byte, len(paths))
+	for i, path := range paths {
+		if path != "" {
+			contents[i], _ = os.ReadFile(path)
+		}
+	}
+	return contents
+}
+
+func equalContents(a, b [][]byte) bool {
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) || !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ToSettings converts the settings the orchestrator can swap while it
+// runs. System prompt files are read relative to workDir; when one
+// cannot be read the error is returned with the other settings filled in.
+func (c *Config) ToSettings(workDir string) (orchestrator.Settings, error) {
+	settings := orchestrator.Settings{
+		Agents:    c.ToAgentConfigs(),
+		Fallbacks: c.Fallbacks,
+		Routes:    make(map[orchestrator.TaskType]string, len(c.Routes)),
+		Pipeline:  c.ToPipelineConfig(),
+	}
+	for taskType, name := range c.Routes {
+		settings.Routes[orchestrator.TaskType(taskType)] = name
+	}
+	err := c.LoadSystemPrompts(settings.Agents, workDir)
+	return settings, err
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ppopcode/ppopcode/internal/orchestrator"
+)
+
+func TestWatch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	opts := LoadOptions{
+		GlobalPath:  filepath.Join(dir, "global.yaml"),
+		ProjectPath: filepath.Join(dir, "project", "config.yaml"),
+	}
+	writeConfig(t, opts.GlobalPath, "agents:\n  sonnet:\n    model: first\n")
+	cfg, err := LoadLayers(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := cfg.Watch(ctx, 10*time.Millisecond)
+
+	next := func() Reloaded {
+		t.Helper()
+		select {
+		case reloaded := <-reloads:
+			return reloaded
+		case <-time.After(2 * time.Second):
+			t.Fatal("no reload after the config changed")
+			return Reloaded{}
+		}
+	}
+
+	writeConfig(t, opts.GlobalPath, "agents:\n  sonnet:\n    model: second\n")
+	if reloaded := next(); reloaded.Err != nil || reloaded.Config.Agents["sonnet"].Model != "second" {
+		t.Errorf("reload = %+v", reloaded)
+	}
+
+	// A new project file counts, and invalid configs are reported
+	writeConfig(t, opts.ProjectPath, "agents:\n  sonnet:\n    type: claud\n")
+	if reloaded := next(); reloaded.Err == nil || reloaded.Config != nil {
+		t.Errorf("reload of an invalid config = %+v, want an error", reloaded)
+	}
+
+	os.Remove(opts.ProjectPath)
+	if reloaded := next(); reloaded.Err != nil || reloaded.Config.Agents["sonnet"].Type != "claude" {
+		t.Errorf("reload after removing the project file = %+v", reloaded)
+	}
+
+	cancel()
+	for range reloads {
+	}
+}
+
+func TestToSettings(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `agents:
+  sonnet:
+    system_prompt_file: missing.md
+fallbacks:
+  sonnet: [haiku]
+routes:
+  general: sonnet
+pipeline:
+  reviewer: sonnet
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err := cfg.ToSettings(t.TempDir())
+	if err == nil {
+		t.Error("ToSettings() should report the missing prompt file")
+	}
+	if settings.Agents["sonnet"].Model == "" || settings.Fallbacks["sonnet"][0] != "haiku" || settings.Pipeline.Reviewer != "sonnet" ||
+		settings.Routes[orchestrator.TaskTypeGeneral] != "sonnet" {
+		t.Errorf("settings = %+v", settings)
+	}
+}
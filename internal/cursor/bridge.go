@@ -65,11 +65,16 @@ func (b *Bridge) CheckAvailability() error {
 }
 
 func (b *Bridge) Execute(ctx context.Context, req EditRequest) *EditResult {
+	return b.execute(ctx, req, b.workDir)
+}
+
+// execute runs cursor-agent in dir, retrying when it fails
+func (b *Bridge) execute(ctx context.Context, req EditRequest, dir string) *EditResult {
 	start := time.Now()
 
 	var lastErr error
 	for attempt := 0; attempt <= b.maxRetry; attempt++ {
-		result := b.executeOnce(ctx, req)
+		result := b.executeOnce(ctx, req, dir)
 		if result.Success {
 			result.Duration = time.Since(start)
 			return result
@@ -88,7 +93,13 @@ func (b *Bridge) Execute(ctx context.Context, req EditRequest) *EditResult {
 	}
 }
 
-func (b *Bridge) executeOnce(ctx context.Context, req EditRequest) *EditResult {
+// Edit runs cursor-agent with prompt and returns its output
+func (b *Bridge) Edit(ctx context.Context, prompt string) (string, error) {
+	result := b.Execute(ctx, EditRequest{Prompt: prompt})
+	return result.Output, result.Error
+}
+
+func (b *Bridge) executeOnce(ctx context.Context, req EditRequest, dir string) *EditResult {
 	cmd := b.getCursorCommand()
 	if cmd == "" {
 		return &EditResult{
@@ -115,7 +126,7 @@ func (b *Bridge) executeOnce(ctx context.Context, req EditRequest) *EditResult {
 	defer cancel()
 
 	c := exec.CommandContext(execCtx, cmd, args...)
-	c.Dir = b.workDir
+	c.Dir = dir
 
 	var stdout, stderr bytes.Buffer
 	c.Stdout = &stdout
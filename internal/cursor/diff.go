@@ -0,0 +1,213 @@
+package cursor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// maxDiffCells bounds the table used to diff the changed middle of a
+// file. Larger changes are shown as one replacement.
+const maxDiffCells = 4_000_000
+
+// Hunk is a run of changed lines with the unchanged lines around them
+type Hunk struct {
+	OldStart, OldLines int // 1-based range of the hunk in the old file
+	NewStart, NewLines int
+	Lines              []string // each starts with ' ', '-' or '+' and keeps its newline
+	Approved           bool
+}
+
+// Header returns the "@@ -1,4 +1,5 @@" line of the hunk
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Counts returns the number of added and removed lines
+func (h Hunk) Counts() (added, removed int) {
+	for _, line := range h.Lines {
+		switch line[0] {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// splitLines splits text into lines that keep their newline
+func splitLines(text string) []string {
+	var lines []string
+	for text != "" {
+		end := strings.IndexByte(text, '\n') + 1
+		if end == 0 {
+			end = len(text)
+		}
+		lines = append(lines, text[:end])
+		text = text[end:]
+	}
+	return lines
+}
+
+// diffOp is one line of an edit script
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// editScript returns the lines that turn a into b, found from the
+// longest common subsequence of the lines in between their common
+// prefix and suffix
+func editScript(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, middleScript(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+func middleScript(a, b []string) []diffOp {
+	var ops []diffOp
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// diffHunks groups the changes between old and new into hunks, merging
+// changes that are close enough for their context to overlap
+func diffHunks(old, new string) []Hunk {
+	ops := editScript(splitLines(old), splitLines(new))
+
+	var hunks []Hunk
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		// Extend the hunk while the next change is within reach
+		end := start
+		for next := start; next < len(ops); next++ {
+			if ops[next].kind != ' ' {
+				if next-end > 2*diffContext {
+					break
+				}
+				end = next + 1
+			}
+		}
+		from := max(start-diffContext, 0)
+		to := min(end+diffContext, len(ops))
+
+		// Line numbers of the first line of the hunk
+		h := Hunk{OldStart: 1, NewStart: 1}
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				h.OldStart++
+			}
+			if op.kind != '-' {
+				h.NewStart++
+			}
+		}
+		for _, op := range ops[from:to] {
+			h.Lines = append(h.Lines, string(op.kind)+op.line)
+			if op.kind != '+' {
+				h.OldLines++
+			}
+			if op.kind != '-' {
+				h.NewLines++
+			}
+		}
+		// An empty range starts at the line before it, as in diff -u
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+		hunks = append(hunks, h)
+		start = to
+	}
+	return hunks
+}
+
+// applyHunks returns old with the approved hunks applied
+func applyHunks(old string, hunks []Hunk) string {
+	lines := splitLines(old)
+	var b strings.Builder
+	next := 0 // index of the next old line to copy
+	for _, h := range hunks {
+		start := h.OldStart - 1
+		if h.OldLines == 0 {
+			start = h.OldStart
+		}
+		for _, line := range lines[next:start] {
+			b.WriteString(line)
+		}
+		for _, line := range h.Lines {
+			switch {
+			case line[0] == ' ',
+				line[0] == '+' && h.Approved,
+				line[0] == '-' && !h.Approved:
+				b.WriteString(line[1:])
+			}
+		}
+		next = start + h.OldLines
+	}
+	for _, line := range lines[next:] {
+		b.WriteString(line)
+	}
+	return b.String()
+}
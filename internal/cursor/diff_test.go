@@ -0,0 +1,88 @@
+package cursor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numbered returns the lines "line 1\n" to "line n\n", with line i
+// replaced by replace[i] if set
+func numbered(n int, replace map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if line, ok := replace[i]; ok {
+			b.WriteString(line)
+			continue
+		}
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestDiffAndApplyHunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		hunks    int
+		approve  []bool // per hunk
+		want     string
+	}{
+		{"all approved", "a\nb\nc\n", "a\nB\nc\nd\n", 1, []bool{true}, "a\nB\nc\nd\n"},
+		{"none approved", "a\nb\nc\n", "a\nB\nc\nd\n", 1, []bool{false}, "a\nb\nc\n"},
+		{"created", "", "a\nb\n", 1, []bool{true}, "a\nb\n"},
+		{"emptied", "a\nb\n", "", 1, []bool{true}, ""},
+		{"newline added at end", "a\nb", "a\nb\n", 1, []bool{true}, "a\nb\n"},
+		{"newline removed at end", "a\nb\n", "a\nb", 1, []bool{true}, "a\nb"},
+		{"newline kept missing", "a\nb", "a\nc", 1, []bool{true}, "a\nc"},
+		{"newline rejected", "a\nb", "a\nb\n", 1, []bool{false}, "a\nb"},
+		{
+			"first of two approved",
+			numbered(20, nil),
+			numbered(20, map[int]string{2: "two\n", 19: "nineteen\n"}),
+			2, []bool{true, false},
+			numbered(20, map[int]string{2: "two\n"}),
+		},
+		{
+			"second of two approved",
+			numbered(20, nil),
+			numbered(20, map[int]string{2: "two\n", 19: "nineteen\n"}),
+			2, []bool{false, true},
+			numbered(20, map[int]string{19: "nineteen\n"}),
+		},
+		{
+			"insertion and deletion, last approved",
+			numbered(20, map[int]string{20: "end"}),
+			numbered(20, map[int]string{1: "", 20: "end\nafter"}),
+			2, []bool{false, true},
+			numbered(20, map[int]string{20: "end\nafter"}),
+		},
+	}
+
+	for _, tt := range tests {
+		hunks := diffHunks(tt.old, tt.new)
+		if len(hunks) != tt.hunks {
+			t.Errorf("%s: diffHunks() = %d hunks, want %d", tt.name, len(hunks), tt.hunks)
+			continue
+		}
+		for i := range hunks {
+			hunks[i].Approved = tt.approve[i]
+		}
+		if got := applyHunks(tt.old, hunks); got != tt.want {
+			t.Errorf("%s: applyHunks() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffHunksHeader(t *testing.T) {
+	hunks := diffHunks(numbered(10, nil), numbered(10, map[int]string{5: "five\n", 6: ""}))
+	if len(hunks) != 1 {
+		t.Fatalf("diffHunks() = %d hunks, want 1", len(hunks))
+	}
+	if got := hunks[0].Header(); got != "@@ -2,8 +2,7 @@" {
+		t.Errorf("Header() = %q, want %q", got, "@@ -2,8 +2,7 @@")
+	}
+	if added, removed := hunks[0].Counts(); added != 1 || removed != 2 {
+		t.Errorf("Counts() = +%d -%d, want +1 -2", added, removed)
+	}
+}
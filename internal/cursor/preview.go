@@ -0,0 +1,315 @@
+package cursor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Change statuses
+const (
+	ChangeAdded    = "added"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// Change is a file that an edit changed in the scratch copy
+type Change struct {
+	Path     string // relative to the working directory
+	Status   string // ChangeAdded, ChangeModified or ChangeDeleted
+	Old      string
+	New      string
+	Binary   bool   // binary files are approved as a whole, with Approved
+	Hunks    []Hunk // changed lines of text files
+	Approved bool
+}
+
+// WholeFile reports whether the change is approved as a whole, with
+// Approved: a binary file, or one with no lines to review, such as an
+// empty file that was created or deleted
+func (c *Change) WholeFile() bool {
+	return c.Binary || len(c.Hunks) == 0
+}
+
+// Counts returns the number of added and removed lines
+func (c *Change) Counts() (added, removed int) {
+	for _, h := range c.Hunks {
+		a, r := h.Counts()
+		added, removed = added+a, removed+r
+	}
+	return added, removed
+}
+
+// ApprovedHunks returns how many hunks are approved, counting a file
+// approved as a whole as one hunk
+func (c *Change) ApprovedHunks() (approved, total int) {
+	if c.WholeFile() {
+		if c.Approved {
+			return 1, 1
+		}
+		return 0, 1
+	}
+	for _, h := range c.Hunks {
+		if h.Approved {
+			approved++
+		}
+	}
+	return approved, len(c.Hunks)
+}
+
+// SetApproved approves or rejects the whole file
+func (c *Change) SetApproved(approved bool) {
+	c.Approved = approved
+	for i := range c.Hunks {
+		c.Hunks[i].Approved = approved
+	}
+}
+
+// result returns the file with the approved hunks applied, and whether
+// it should exist
+func (c *Change) result() (string, bool) {
+	if c.WholeFile() {
+		if c.Approved {
+			return c.New, c.Status != ChangeDeleted
+		}
+		return c.Old, c.Status != ChangeAdded
+	}
+	approved, total := c.ApprovedHunks()
+	switch {
+	case c.Status == ChangeAdded && approved == 0:
+		return "", false
+	case c.Status == ChangeDeleted && approved == total:
+		return "", false
+	}
+	return applyHunks(c.Old, c.Hunks), true
+}
+
+// Preview is an edit made in a scratch copy of the working tree, to be
+// reviewed before any of it reaches the working tree
+type Preview struct {
+	Changes  []*Change
+	Output   string
+	Duration time.Duration
+	workDir  string
+}
+
+// Preview runs cursor-agent in a scratch copy of the working tree and
+// returns the changes it made there. In a git repository the copy holds
+// the tracked files and the untracked ones that are not ignored; elsewhere
+// it holds every file outside .git directories. The working tree is not
+// touched until Apply.
+func (b *Bridge) Preview(ctx context.Context, req EditRequest) (*Preview, error) {
+	start := time.Now()
+	scratch, err := os.MkdirTemp("", "ppopcode-cursor-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a scratch copy: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	files, dirs, err := listFiles(b.workDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(scratch, dir), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create a scratch copy: %w", err)
+		}
+	}
+	for _, file := range files {
+		if err := copyFile(filepath.Join(b.workDir, file), filepath.Join(scratch, file)); err != nil {
+			return nil, fmt.Errorf("failed to create a scratch copy: %w", err)
+		}
+	}
+
+	// A target inside the working tree is edited in the copy instead
+	if filepath.IsAbs(req.TargetPath) {
+		if rel, err := filepath.Rel(b.workDir, req.TargetPath); err == nil && !strings.HasPrefix(rel, "..") {
+			req.TargetPath = rel
+		}
+	}
+	result := b.execute(ctx, req, scratch)
+	if !result.Success {
+		return nil, result.Error
+	}
+
+	changes, err := compareTrees(b.workDir, scratch, files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare the scratch copy: %w", err)
+	}
+	return &Preview{Changes: changes, Output: result.Output, Duration: time.Since(start), workDir: b.workDir}, nil
+}
+
+// Apply writes the approved hunks of each change to the working tree.
+// Files that changed since the preview are left alone and reported in
+// the error. It returns the files it wrote or removed.
+func (p *Preview) Apply() ([]string, error) {
+	var applied []string
+	var errs []error
+	for _, c := range p.Changes {
+		if approved, _ := c.ApprovedHunks(); approved == 0 {
+			continue
+		}
+		path := filepath.Join(p.workDir, filepath.FromSlash(c.Path))
+		current, err := os.ReadFile(path)
+		exists := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		if exists != (c.Status != ChangeAdded) || exists && string(current) != c.Old {
+			errs = append(errs, fmt.Errorf("%s changed since the preview", c.Path))
+			continue
+		}
+
+		content, keep := c.result()
+		if !keep {
+			err = os.Remove(path)
+		} else {
+			err = writeFile(path, []byte(content))
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		applied = append(applied, c.Path)
+	}
+	return applied, errors.Join(errs...)
+}
+
+// listFiles returns the files of the working tree, relative to workDir.
+// Outside git it also returns the directories, so empty ones are copied.
+func listFiles(workDir string) (files, dirs []string, err error) {
+	c := exec.Command("git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	c.Dir = workDir
+	if out, err := c.Output(); err == nil {
+		for _, file := range strings.Split(string(out), "\x00") {
+			// Deleted files are still listed until the deletion is staged
+			if info, err := os.Lstat(filepath.Join(workDir, file)); file != "" && err == nil && info.Mode().IsRegular() {
+				files = append(files, filepath.FromSlash(file))
+			}
+		}
+		return files, nil, nil
+	}
+
+	err = filepath.WalkDir(workDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(workDir, path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			dirs = append(dirs, rel)
+		case d.Type().IsRegular():
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, dirs, err
+}
+
+// compareTrees returns the changes between the files of workDir and the
+// scratch copy, sorted by path
+func compareTrees(workDir, scratch string, files []string) ([]*Change, error) {
+	copied := make(map[string]bool, len(files))
+	for _, file := range files {
+		copied[file] = true
+	}
+
+	var changes []*Change
+	err := filepath.WalkDir(scratch, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(scratch, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !copied[rel] {
+			changes = append(changes, newChange(rel, ChangeAdded, nil, data))
+			return nil
+		}
+		delete(copied, rel)
+		old, err := os.ReadFile(filepath.Join(workDir, rel))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(old, data) {
+			changes = append(changes, newChange(rel, ChangeModified, old, data))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for file := range copied {
+		old, err := os.ReadFile(filepath.Join(workDir, file))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, newChange(file, ChangeDeleted, old, nil))
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func newChange(path, status string, old, new []byte) *Change {
+	c := &Change{Path: filepath.ToSlash(path), Status: status, Old: string(old), New: string(new)}
+	c.Binary = bytes.IndexByte(old, 0) >= 0 || bytes.IndexByte(new, 0) >= 0
+	if !c.Binary {
+		c.Hunks = diffHunks(c.Old, c.New)
+	}
+	return c
+}
+
+// copyFile copies a regular file, keeping its mode
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, info.Mode().Perm())
+}
+
+// writeFile writes data to path, keeping the mode of an existing file
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, mode)
+}
@@ -0,0 +1,151 @@
+package cursor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree writes files under dir, creating their directories
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEmptyFileApprovedAsWhole(t *testing.T) {
+	workDir, scratch := t.TempDir(), t.TempDir()
+	writeTree(t, workDir, map[string]string{"gone.txt": ""})
+	writeTree(t, scratch, map[string]string{"new.txt": ""})
+
+	changes, err := compareTrees(workDir, scratch, []string{"gone.txt"})
+	if err != nil {
+		t.Fatalf("compareTrees() error = %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("compareTrees() = %d changes, want 2", len(changes))
+	}
+	for _, c := range changes {
+		if !c.WholeFile() {
+			t.Errorf("%s: WholeFile() = false, want an empty file approved as a whole", c.Path)
+		}
+		if approved, total := c.ApprovedHunks(); approved != 0 || total != 1 {
+			t.Errorf("%s: ApprovedHunks() = %d, %d before approval, want 0, 1", c.Path, approved, total)
+		}
+		c.SetApproved(true)
+		if approved, total := c.ApprovedHunks(); approved != 1 || total != 1 {
+			t.Errorf("%s: ApprovedHunks() = %d, %d after approval, want 1, 1", c.Path, approved, total)
+		}
+	}
+
+	p := &Preview{Changes: changes, workDir: workDir}
+	applied, err := p.Apply()
+	if err != nil || len(applied) != 2 {
+		t.Fatalf("Apply() = %v, %v; want both files applied", applied, err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "gone.txt")); !os.IsNotExist(err) {
+		t.Errorf("gone.txt should be removed, stat error = %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(workDir, "new.txt")); err != nil || len(data) != 0 {
+		t.Errorf("new.txt = %q, %v; want an empty file", data, err)
+	}
+}
+
+func TestCompareTrees(t *testing.T) {
+	workDir, scratch := t.TempDir(), t.TempDir()
+	writeTree(t, workDir, map[string]string{
+		"same.go":     "package main\n",
+		"edit.go":     "package main\n",
+		"gone.go":     "package main\n",
+		"image.png":   "PNG\x00old",
+		"docs/a.md":   "# A\n",
+		".git/config": "[core]\n",
+	})
+	writeTree(t, scratch, map[string]string{
+		"same.go":     "package main\n",
+		"edit.go":     "package main\n\nfunc main() {}\n",
+		"image.png":   "PNG\x00new",
+		"docs/a.md":   "# A\n",
+		"docs/b.md":   "# B\n",
+		".git/config": "[core]\n\tbare = true\n",
+	})
+
+	changes, err := compareTrees(workDir, scratch, []string{"same.go", "edit.go", "gone.go", "image.png", filepath.Join("docs", "a.md")})
+	if err != nil {
+		t.Fatalf("compareTrees() error = %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		status  string
+		binary  bool
+		added   int
+		removed int
+	}{
+		{"docs/b.md", ChangeAdded, false, 1, 0},
+		{"edit.go", ChangeModified, false, 2, 0},
+		{"gone.go", ChangeDeleted, false, 0, 1},
+		{"image.png", ChangeModified, true, 0, 0},
+	}
+	if len(changes) != len(tests) {
+		var paths []string
+		for _, c := range changes {
+			paths = append(paths, c.Path)
+		}
+		t.Fatalf("compareTrees() = %v, want %d changes", paths, len(tests))
+	}
+	for i, tt := range tests {
+		c := changes[i]
+		added, removed := c.Counts()
+		if c.Path != tt.path || c.Status != tt.status || c.Binary != tt.binary || added != tt.added || removed != tt.removed {
+			t.Errorf("change %d = %s %s binary=%v +%d -%d, want %s %s binary=%v +%d -%d", i,
+				c.Path, c.Status, c.Binary, added, removed, tt.path, tt.status, tt.binary, tt.added, tt.removed)
+		}
+	}
+}
+
+func TestApplyRefusesChangedFiles(t *testing.T) {
+	workDir := t.TempDir()
+	writeTree(t, workDir, map[string]string{"a.go": "a\n", "b.go": "b\n", "c.go": "c\n"})
+	p := &Preview{
+		Changes: []*Change{
+			newChange("a.go", ChangeModified, []byte("a\n"), []byte("A\n")),
+			newChange("b.go", ChangeModified, []byte("b\n"), []byte("B\n")),
+			newChange("c.go", ChangeDeleted, []byte("c\n"), nil),
+			newChange("d.go", ChangeAdded, nil, []byte("d\n")),
+		},
+		workDir: workDir,
+	}
+	for _, c := range p.Changes {
+		c.SetApproved(true)
+	}
+
+	// Changed in the working tree after the preview was taken
+	writeTree(t, workDir, map[string]string{"b.go": "mine\n", "d.go": "mine\n"})
+	if err := os.Remove(filepath.Join(workDir, "c.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := p.Apply()
+	if len(applied) != 1 || applied[0] != "a.go" {
+		t.Errorf("Apply() applied %v, want only a.go", applied)
+	}
+	for _, file := range []string{"b.go", "c.go", "d.go"} {
+		if err == nil || !strings.Contains(err.Error(), file+" changed since the preview") {
+			t.Errorf("Apply() error = %v, want %s reported", err, file)
+		}
+	}
+	for name, want := range map[string]string{"a.go": "A\n", "b.go": "mine\n", "d.go": "mine\n"} {
+		if data, _ := os.ReadFile(filepath.Join(workDir, name)); string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+}
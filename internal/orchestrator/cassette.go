@@ -0,0 +1,49 @@
+package orchestrator
+
+import (
+	"fmt"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// Record wraps every agent so that its requests are written to a new
+// cassette at path. Call it before any request is sent. Agents added by
+// Reconfigure are recorded too.
+func (o *Orchestrator) Record(path string) error {
+	recorder, err := agents.NewCassetteRecorder(path)
+	if err != nil {
+		return fmt.Errorf("failed to start recording: %w", err)
+	}
+	o.configMu.Lock()
+	defer o.configMu.Unlock()
+	o.recorder = recorder
+	for name, agent := range o.agents {
+		o.agents[name] = agents.NewRecordingAgent(agent, recorder)
+	}
+	return nil
+}
+
+// Replay replaces every agent, and every agent recorded in the cassette at
+// path, with one that answers from the cassette. With realtime set,
+// responses stream at their recorded pace. Call it before any request is
+// sent. Reconfigure keeps the replaying agents.
+func (o *Orchestrator) Replay(path string, realtime bool) error {
+	cassette, err := agents.LoadCassette(path)
+	if err != nil {
+		return err
+	}
+	o.configMu.Lock()
+	defer o.configMu.Unlock()
+	o.replaying = true
+	names := make(map[string]bool)
+	for name := range o.agents {
+		names[name] = true
+	}
+	for _, interaction := range cassette.Interactions {
+		names[interaction.Agent] = true
+	}
+	for name := range names {
+		o.agents[name] = agents.NewReplayAgent(agents.AgentConfig{Name: name}, cassette, realtime)
+	}
+	return nil
+}
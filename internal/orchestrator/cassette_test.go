@@ -0,0 +1,82 @@
+package orchestrator
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// run sends input through o and returns the update types and final update
+func run(o *Orchestrator, input string) ([]string, ProgressUpdate) {
+	var types []string
+	var last ProgressUpdate
+	for update := range o.ProcessStreamAsync(context.Background(), input) {
+		types = append(types, update.Type+":"+update.Message)
+		last = update
+	}
+	return types, last
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	script := func(responses ...agents.MockResponse) *agents.MockScript {
+		return &agents.MockScript{Responses: responses}
+	}
+	sonnet, _ := agents.NewMockAgentWithScript(agents.AgentConfig{Name: "sonnet"}, script(
+		agents.MockResponse{Error: "overloaded"},
+	))
+	haiku, _ := agents.NewMockAgentWithScript(agents.AgentConfig{Name: "haiku", Model: "haiku-test"}, script(
+		agents.MockResponse{Chunks: []agents.MockChunk{
+			{Type: "thinking", Content: "hmm"},
+			{Type: "output", Content: "answer"},
+		}, Usage: agents.Usage{InputTokens: 4, OutputTokens: 1}},
+	))
+
+	path := filepath.Join(t.TempDir(), "run.json")
+	recording := New(map[string]agents.AgentConfig{})
+	recording.agents["sonnet"] = sonnet
+	recording.agents["haiku"] = haiku
+	recording.SetFallbacks(map[string][]string{"sonnet": {"haiku"}})
+	if err := recording.Record(path); err != nil {
+		t.Fatalf("Record() error: %v", err)
+	}
+	recorded, recordedLast := run(recording, "question")
+	if recordedLast.Agent != "haiku" || !recordedLast.Done || recordedLast.Stage != "completed" {
+		t.Fatalf("recorded run ended with %+v", recordedLast)
+	}
+
+	// Replay needs neither the agents nor their config
+	replaying := New(map[string]agents.AgentConfig{})
+	replaying.SetFallbacks(map[string][]string{"sonnet": {"haiku"}})
+	if err := replaying.Replay(path, false); err != nil {
+		t.Fatalf("Replay() error: %v", err)
+	}
+	replayed, replayedLast := run(replaying, "question")
+
+	if len(replayed) != len(recorded) {
+		t.Fatalf("replayed updates = %v, want %v", replayed, recorded)
+	}
+	for i := range recorded {
+		if replayed[i] != recorded[i] {
+			t.Errorf("update %d = %q, want %q", i, replayed[i], recorded[i])
+		}
+	}
+	if replayedLast.Usage != recordedLast.Usage {
+		t.Errorf("replayed usage = %+v, want %+v", replayedLast.Usage, recordedLast.Usage)
+	}
+	if task := replaying.GetCurrentTask(); task.Model != "haiku-test" || len(task.Failed) != 1 {
+		t.Errorf("replayed task = %+v", task)
+	}
+
+	if _, last := run(replaying, "something new"); last.Type != "error" {
+		t.Errorf("unrecorded prompt ended with %+v, want an error", last)
+	}
+}
+
+func TestReplayMissingCassette(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	if err := o.Replay(filepath.Join(t.TempDir(), "missing.json"), false); err == nil {
+		t.Error("Replay() should fail without a cassette")
+	}
+}
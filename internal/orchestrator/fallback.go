@@ -0,0 +1,88 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/budget"
+)
+
+// SetFallbacks replaces the agents tried in order when an agent fails,
+// keyed by agent name, e.g. "sonnet": {"haiku", "sonnet-api"}
+func (o *Orchestrator) SetFallbacks(fallbacks map[string][]string) {
+	o.router.ReplaceFallbacks(fallbacks)
+}
+
+// execute sends input to agentName. When the agent fails with a retryable
+// error, such as a rate limit or a missing CLI, each of its fallbacks is
+// tried in turn and a "fallback" update copied from tag announces the
+// switch. The budget is checked again before each fallback, since the
+// failed attempts count against it. The task records the agent that
+// answered and its usage, which includes failed attempts. With a nil
+// progress channel nothing is forwarded.
+func (o *Orchestrator) execute(ctx context.Context, task *Task, agentName, input string, tag ProgressUpdate, progress chan<- ProgressUpdate) (*agents.Response, error) {
+	chain := o.router.Chain(agentName)
+
+	var response *agents.Response
+	var err error
+	for i, name := range chain {
+		if i > 0 && o.budget != nil {
+			if _, budgetErr := o.budget.Check(ctx); budgetErr != nil {
+				return response, budgetErr
+			}
+		}
+		response, err = o.attempt(ctx, task, name, input, tag, progress)
+		if err == nil || i == len(chain)-1 || errors.Is(err, budget.ErrExceeded) || isCancelled(ctx, err) {
+			return response, err
+		}
+		kind := agents.ClassifyError(err)
+		if !kind.Retryable() {
+			return response, err
+		}
+
+		o.updateTask(task, func(t *Task) { t.Failed = append(t.Failed, name) })
+		if progress != nil {
+			update := tag
+			update.Agent = name
+			update.Type = "fallback"
+			update.Message = fmt.Sprintf("%s failed (%s), switching to %s: %v", name, kind, chain[i+1], err)
+			progress <- update
+		}
+	}
+	return response, err
+}
+
+// attempt runs input on one agent once a slot is free
+func (o *Orchestrator) attempt(ctx context.Context, task *Task, agentName, input string, tag ProgressUpdate, progress chan<- ProgressUpdate) (*agents.Response, error) {
+	agent, exists := o.GetAgent(agentName)
+	if !exists {
+		return nil, fmt.Errorf("agent %s not found", agentName)
+	}
+	o.updateTask(task, func(t *Task) {
+		t.AssignedTo = agentName
+		t.Model = agent.Model()
+	})
+
+	tag.Agent = agentName
+	release, err := o.acquire(ctx, agentName, tag, progress)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Without a budget and a progress channel there is nothing to watch
+	// the stream for
+	var response *agents.Response
+	if progress == nil && o.budget == nil {
+		response, err = agent.Execute(ctx, input)
+	} else {
+		response, err = o.streamAgent(ctx, agent, input, tag, progress)
+	}
+	if response != nil {
+		o.recordUsage(ctx, response.Usage)
+		o.updateTask(task, func(t *Task) { t.Usage.Add(response.Usage) })
+	}
+	return response, err
+}
@@ -0,0 +1,133 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/budget"
+)
+
+// failingAgent fails every request with err
+type failingAgent struct {
+	name  string
+	err   error
+	cost  float64 // of each streamed attempt
+	calls int
+}
+
+func (a *failingAgent) Execute(ctx context.Context, prompt string) (*agents.Response, error) {
+	a.calls++
+	return nil, a.err
+}
+
+func (a *failingAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- agents.StreamChunk) (*agents.Response, error) {
+	defer close(stream)
+	a.calls++
+	stream <- agents.StreamChunk{Content: "partial", Type: "output"}
+	return &agents.Response{Usage: agents.Usage{InputTokens: 5, CostUSD: a.cost, Requests: 1}}, a.err
+}
+
+func (a *failingAgent) Status() string { return "ready" }
+func (a *failingAgent) Name() string   { return a.name }
+func (a *failingAgent) Model() string  { return a.name + "-model" }
+
+func TestProcessStreamFallsBack(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &failingAgent{name: "sonnet", err: &agents.APIError{StatusCode: 429, Message: "rate limited"}}
+	o.agents["haiku"] = &failingAgent{name: "haiku", err: errors.New("agent haiku: " + agents.ErrCLINotFound.Error())}
+	o.agents["api"] = &scriptedAgent{name: "api", reply: func(string) string { return "answer" }}
+	o.SetFallbacks(map[string][]string{"sonnet": {"haiku", "api"}})
+
+	var switches []string
+	var last ProgressUpdate
+	for update := range o.ProcessStreamAsync(context.Background(), "hello") {
+		if update.Type == "fallback" {
+			switches = append(switches, update.Agent)
+		}
+		last = update
+	}
+
+	if strings.Join(switches, ",") != "sonnet,haiku" {
+		t.Errorf("fallback updates from %v, want sonnet then haiku", switches)
+	}
+	if last.Stage != "completed" || last.Agent != "api" {
+		t.Errorf("final update = %+v, want completed by api", last)
+	}
+
+	task := o.GetCurrentTask()
+	if task.AssignedTo != "api" || task.Model != "test-model" || task.Result != "answer" {
+		t.Errorf("task = %+v, want the answer from api", task)
+	}
+	if strings.Join(task.Failed, ",") != "sonnet,haiku" {
+		t.Errorf("task.Failed = %v", task.Failed)
+	}
+	if task.Usage.Requests != 3 {
+		t.Errorf("task.Usage = %+v, want the failed attempts counted", task.Usage)
+	}
+}
+
+func TestProcessDoesNotFallBackOnFatalErrors(t *testing.T) {
+	primary := &failingAgent{name: "sonnet", err: &agents.APIError{StatusCode: 400, Type: "invalid_request_error", Message: "prompt is too long"}}
+	fallback := &failingAgent{name: "haiku"}
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = primary
+	o.agents["haiku"] = fallback
+	o.SetFallbacks(map[string][]string{"sonnet": {"haiku"}})
+
+	task, err := o.Process(context.Background(), "hello")
+	if err == nil || task.Status != TaskError || task.AssignedTo != "sonnet" {
+		t.Errorf("Process() = %+v, %v, want the invalid request error from sonnet", task, err)
+	}
+	if fallback.calls != 0 {
+		t.Error("an invalid request should not be sent to the fallback")
+	}
+}
+
+func TestProcessFallsBackToMissingAgent(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &failingAgent{name: "sonnet", err: errors.New("claude error: exit status 1: Overloaded")}
+	o.SetFallbacks(map[string][]string{"sonnet": {"nobody"}})
+
+	task, err := o.Process(context.Background(), "hello")
+	if err == nil || !strings.Contains(err.Error(), "agent nobody not found") {
+		t.Errorf("Process() error = %v, want the error of the last agent", err)
+	}
+	if task.Status != TaskError || len(task.Failed) != 1 {
+		t.Errorf("task = %+v", task)
+	}
+}
+
+func TestFallbackChecksBudget(t *testing.T) {
+	fallback := &failingAgent{name: "haiku"}
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &failingAgent{name: "sonnet", err: &agents.APIError{StatusCode: 429, Message: "rate limited"}, cost: 0.5}
+	o.agents["haiku"] = fallback
+	o.SetFallbacks(map[string][]string{"sonnet": {"haiku"}})
+	tracker := budget.NewTracker(budget.Limits{PerSession: 1}, "")
+	o.SetBudget(tracker)
+
+	// The failed attempt fits the budget but uses up the rest of it
+	scope := tracker.NewScope(budget.ScopeSession)
+	scope.Add(0.5)
+	task, err := o.Process(budget.WithScope(context.Background(), scope), "hello")
+	if !errors.Is(err, budget.ErrExceeded) || task.Status != TaskBudgetExceeded {
+		t.Errorf("Process() = %q, %v, want the budget exceeded", task.Status, err)
+	}
+	if fallback.calls != 0 {
+		t.Error("the fallback should not run once the budget is used up")
+	}
+}
+
+func TestRouterChain(t *testing.T) {
+	r := NewRouter()
+	r.SetFallbacks("sonnet", []string{"haiku", "sonnet", "haiku", "api"})
+	if got := strings.Join(r.Chain("sonnet"), ","); got != "sonnet,haiku,api" {
+		t.Errorf("Chain(sonnet) = %s", got)
+	}
+	if got := strings.Join(r.Chain("opus"), ","); got != "opus" {
+		t.Errorf("Chain(opus) = %s, want no fallbacks", got)
+	}
+}
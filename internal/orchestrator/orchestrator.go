@@ -2,49 +2,56 @@ package orchestrator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"sync"
 
 	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/budget"
 )
 
 // ProgressUpdate represents a progress update during processing
 type ProgressUpdate struct {
-	Stage   string // "routing", "processing", "streaming"
+	// Stage is "routing", "queued", "processing", "streaming", "completed",
+	// "cancelled" or "budget", or the pipeline stage "plan", "implement" or "review"
+	Stage   string
+	TaskID  string // subtask the update belongs to, for pipeline stages
 	Message string
 	Agent   string
-	Type    string // "status", "thinking", "output", "error"
+	Type    string // "status", "thinking", "output", "error", "cancelled", "warning", "budget", "fallback", "tool_use", "tool_result"
 	Done    bool
-}
-
-type TaskType string
-
-const (
-	TaskTypeGeneral TaskType = "general"
-	TaskTypeUI      TaskType = "ui"
-	TaskTypeDesign  TaskType = "design"
-	TaskTypeDebug   TaskType = "debug"
-	TaskTypeCode    TaskType = "code"
-)
-
-type Task struct {
-	ID         string
-	Content    string
-	Type       TaskType
-	AssignedTo string
-	Status     string
-	Result     string
+	Usage   agents.Usage      // set on the final update when the agent reports usage
+	Tool    *agents.ToolEvent // set on "tool_use" and "tool_result" updates
 }
 
 type Orchestrator struct {
-	router      *Router
-	agents      map[string]agents.Agent
+	router *Router
+	budget *budget.Tracker
+	editor Editor
+
+	// configMu guards the settings Reconfigure swaps while requests run.
+	// Take it before mu, never while holding mu.
+	configMu  sync.RWMutex
+	agents    map[string]agents.Agent
+	configs   map[string]agents.AgentConfig // the agents were created from
+	pipeline  PipelineConfig
+	recorder  *agents.CassetteRecorder // set while recording
+	replaying bool
+
+	mu          sync.Mutex // guards tasks, every task in them, and queues
+	tasks       []*Task    // history of top-level tasks, oldest first
 	currentTask *Task
+	queues      map[string]*agentQueue // per-agent concurrency limits
 }
 
 func New(agentConfigs map[string]agents.AgentConfig) *Orchestrator {
 	o := &Orchestrator{
-		router: NewRouter(),
-		agents: make(map[string]agents.Agent),
+		router:  NewRouter(),
+		agents:  make(map[string]agents.Agent),
+		configs: make(map[string]agents.AgentConfig),
+		queues:  make(map[string]*agentQueue),
 	}
 
 	for name, config := range agentConfigs {
@@ -54,42 +61,65 @@ func New(agentConfigs map[string]agents.AgentConfig) *Orchestrator {
 			continue
 		}
 		o.agents[name] = agent
+		o.configs[name] = config
+		o.queues[name] = newAgentQueue(config.MaxConcurrent)
 	}
 
 	return o
 }
 
 func (o *Orchestrator) Process(ctx context.Context, input string) (*Task, error) {
-	taskType := o.analyzeTask(input)
+	agentName := o.router.Route(o.analyzeTask(input))
+	task := o.startTask(nil, input, agentName)
 
-	agentName := o.router.Route(taskType)
+	if o.budget != nil {
+		if _, err := o.budget.Check(ctx); err != nil {
+			o.finishTask(task, TaskBudgetExceeded, err.Error())
+			return o.snapshot(task), err
+		}
+	}
 
-	task := &Task{
-		ID:         fmt.Sprintf("task-%d", len(input)),
-		Content:    input,
-		Type:       taskType,
-		AssignedTo: agentName,
-		Status:     "processing",
+	response, err := o.execute(ctx, task, agentName, input, ProgressUpdate{}, nil)
+	if response != nil {
+		o.updateTask(task, func(t *Task) { t.Result = response.Content })
 	}
-	o.currentTask = task
+	o.finishTask(task, taskStatus(ctx, err), errorResult(ctx, err))
+	return o.snapshot(task), err
+}
 
-	agent, exists := o.agents[agentName]
-	if !exists {
-		task.Status = "error"
-		task.Result = fmt.Sprintf("Agent %s not found", agentName)
-		return task, fmt.Errorf("agent %s not found", agentName)
+// taskStatus returns the status of a task that ended with err
+func taskStatus(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(err, budget.ErrExceeded):
+		return TaskBudgetExceeded
+	case isCancelled(ctx, err):
+		return TaskCancelled
+	case err != nil:
+		return TaskError
+	default:
+		return TaskCompleted
 	}
+}
 
-	response, err := agent.Execute(ctx, input)
-	if err != nil {
-		task.Status = "error"
-		task.Result = err.Error()
-		return task, err
+// errorResult returns the result to record for a failed task. Cancelled and
+// budget-stopped tasks keep their partial output.
+func errorResult(ctx context.Context, err error) string {
+	if taskStatus(ctx, err) == TaskError {
+		return err.Error()
 	}
+	return ""
+}
+
+// snapshot returns a copy of task that is safe to read while it changes
+func (o *Orchestrator) snapshot(task *Task) *Task {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return task.clone()
+}
 
-	task.Status = "completed"
-	task.Result = response.Content
-	return task, nil
+// isCancelled reports whether a request ended because its context was cancelled
+func isCancelled(ctx context.Context, err error) bool {
+	return errors.Is(err, agents.ErrCancelled) || ctx.Err() != nil
 }
 
 func (o *Orchestrator) analyzeTask(_ string) TaskType {
@@ -98,173 +128,236 @@ func (o *Orchestrator) analyzeTask(_ string) TaskType {
 	return TaskTypeGeneral
 }
 
-func (o *Orchestrator) GetCurrentTask() *Task {
-	return o.currentTask
-}
-
-// ProcessStream processes the input with real-time progress updates
+// ProcessStream processes the input with real-time progress updates.
+// progress is closed when processing completes.
 func (o *Orchestrator) ProcessStream(ctx context.Context, input string, progress chan<- ProgressUpdate) (*Task, error) {
 	defer close(progress)
 
-	// Send routing status
+	// Send initial status immediately
 	progress <- ProgressUpdate{Stage: "routing", Message: "Analyzing task...", Type: "status"}
 
-	taskType := o.analyzeTask(input)
-	agentName := o.router.Route(taskType)
+	agentName := o.router.Route(o.analyzeTask(input))
+	task := o.startTask(nil, input, agentName)
 
 	progress <- ProgressUpdate{
 		Stage:   "routing",
-		Message: fmt.Sprintf("Routing to %s agent", agentName),
+		Message: fmt.Sprintf("Routing to %s", agentName),
 		Agent:   agentName,
+		TaskID:  task.ID,
 		Type:    "status",
 	}
 
-	task := &Task{
-		ID:         fmt.Sprintf("task-%d", len(input)),
-		Content:    input,
-		Type:       taskType,
-		AssignedTo: agentName,
-		Status:     "processing",
-	}
-	o.currentTask = task
-
-	agent, exists := o.agents[agentName]
-	if !exists {
-		task.Status = "error"
-		task.Result = fmt.Sprintf("Agent %s not found", agentName)
-		progress <- ProgressUpdate{Stage: "error", Message: task.Result, Type: "error", Done: true}
-		return task, fmt.Errorf("agent %s not found", agentName)
+	if err := o.checkBudget(ctx, agentName, progress); err != nil {
+		o.finishTask(task, TaskBudgetExceeded, err.Error())
+		progress <- ProgressUpdate{Stage: "budget", Message: err.Error(), Agent: agentName, TaskID: task.ID, Type: "budget", Done: true}
+		return o.snapshot(task), err
 	}
 
 	progress <- ProgressUpdate{
 		Stage:   "processing",
 		Message: fmt.Sprintf("Starting %s...", agentName),
 		Agent:   agentName,
+		TaskID:  task.ID,
 		Type:    "status",
 	}
 
-	// Create stream channel for agent
-	agentStream := make(chan agents.StreamChunk, 100)
+	response, execErr := o.execute(ctx, task, agentName, input, ProgressUpdate{Stage: "streaming", TaskID: task.ID}, progress)
+	if response != nil {
+		o.updateTask(task, func(t *Task) { t.Result = response.Content })
+	}
 
-	// Start streaming execution in goroutine
+	status := taskStatus(ctx, execErr)
+	o.finishTask(task, status, errorResult(ctx, execErr))
+
+	// The answering agent may be a fallback
+	snapshot := o.snapshot(task)
+	final := ProgressUpdate{Agent: snapshot.AssignedTo, TaskID: task.ID, Done: true, Usage: snapshot.Usage}
+	switch status {
+	case TaskBudgetExceeded:
+		final.Stage, final.Type, final.Message = "budget", "budget", execErr.Error()
+	case TaskCancelled:
+		final.Stage, final.Type, final.Message = "cancelled", "cancelled", "Request cancelled"
+	case TaskError:
+		final.Stage, final.Type, final.Message = "error", "error", execErr.Error()
+	default:
+		final.Stage, final.Type, final.Message = "completed", "status", "Done"
+	}
+	progress <- final
+
+	return o.snapshot(task), execErr
+}
+
+// streamAgent runs the agent and forwards its output as progress updates
+// copied from tag, unless progress is nil. Usage chunks are not forwarded;
+// they are checked against the remaining budget, and the agent is cancelled
+// with a budget error once it is used up. The usage of the response is
+// checked too, for agents that only report it at the end.
+func (o *Orchestrator) streamAgent(ctx context.Context, agent agents.Agent, input string, tag ProgressUpdate, progress chan<- ProgressUpdate) (*agents.Response, error) {
+	agentCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	remaining, limitKind := math.Inf(1), budget.ScopeKind("")
+	if o.budget != nil {
+		remaining, limitKind = o.budget.Remaining(ctx)
+	}
+
+	agentStream := make(chan agents.StreamChunk, 100)
 	var response *agents.Response
-	var execErr error
+	var execErr, budgetErr error
 
+	done := make(chan struct{})
 	go func() {
-		response, execErr = agent.ExecuteStream(ctx, input, agentStream)
+		defer close(done)
+		response, execErr = agent.ExecuteStream(agentCtx, input, agentStream)
 	}()
 
-	// Forward agent stream chunks to progress channel
+	// The caller sends the final update, so chunk Done flags are dropped
 	for chunk := range agentStream {
-		progress <- ProgressUpdate{
-			Stage:   "streaming",
-			Message: chunk.Content,
-			Agent:   agentName,
-			Type:    chunk.Type,
-			Done:    chunk.Done,
+		if chunk.Type == "usage" {
+			if chunk.Usage != nil && budgetErr == nil && chunk.Usage.CostUSD > remaining {
+				budgetErr = o.budget.Exceeded(ctx, limitKind, chunk.Usage.CostUSD)
+				stop()
+			}
+			continue
 		}
+		if progress == nil {
+			continue
+		}
+		update := tag
+		update.Message = chunk.Content
+		update.Type = chunk.Type
+		update.Tool = chunk.Tool
+		progress <- update
 	}
+	<-done
 
-	if execErr != nil {
-		task.Status = "error"
-		task.Result = execErr.Error()
-		progress <- ProgressUpdate{Stage: "error", Message: execErr.Error(), Type: "error", Done: true}
-		return task, execErr
+	if budgetErr == nil && response != nil && response.Usage.CostUSD > remaining {
+		budgetErr = o.budget.Exceeded(ctx, limitKind, response.Usage.CostUSD)
 	}
-
-	task.Status = "completed"
-	task.Result = response.Content
-	progress <- ProgressUpdate{Stage: "completed", Message: "Done", Agent: agentName, Type: "status", Done: true}
-
-	return task, nil
+	if budgetErr != nil {
+		return response, budgetErr
+	}
+	return response, execErr
 }
 
-func (o *Orchestrator) GetAgentStatus() map[string]string {
-	status := make(map[string]string)
-	for name, agent := range o.agents {
-		status[name] = agent.Status()
+// checkBudget reports budget warnings and returns an error if a limit has
+// already been reached
+func (o *Orchestrator) checkBudget(ctx context.Context, agentName string, progress chan<- ProgressUpdate) error {
+	if o.budget == nil {
+		return nil
 	}
-	return status
+	warnings, err := o.budget.Check(ctx)
+	for _, w := range warnings {
+		progress <- ProgressUpdate{Stage: "budget", Message: w, Agent: agentName, Type: "warning"}
+	}
+	return err
 }
 
-// ProcessStreamAsync starts async processing and returns the progress channel
-// The channel is owned by Orchestrator and will be closed when processing completes
-func (o *Orchestrator) ProcessStreamAsync(ctx context.Context, input string) <-chan ProgressUpdate {
-	progress := make(chan ProgressUpdate, 100)
+// recordUsage counts a finished request against the budget
+func (o *Orchestrator) recordUsage(ctx context.Context, usage agents.Usage) {
+	if o.budget == nil || usage.IsZero() {
+		return
+	}
+	// A failed save only loses the on-disk daily total; it is still counted in memory
+	_ = o.budget.Record(ctx, usage)
+}
 
-	go func() {
-		defer close(progress)
+// SetBudget enables budget enforcement. A nil tracker disables it.
+func (o *Orchestrator) SetBudget(tracker *budget.Tracker) {
+	o.budget = tracker
+}
 
-		// Send initial status immediately
-		progress <- ProgressUpdate{Stage: "routing", Message: "Analyzing task...", Type: "status"}
+// Budget returns the budget tracker, or nil if budgets are disabled
+func (o *Orchestrator) Budget() *budget.Tracker {
+	return o.budget
+}
 
-		taskType := o.analyzeTask(input)
-		agentName := o.router.Route(taskType)
+// AgentNames returns the names of all configured agents in sorted order
+func (o *Orchestrator) AgentNames() []string {
+	o.configMu.RLock()
+	defer o.configMu.RUnlock()
+	names := make([]string, 0, len(o.agents))
+	for name := range o.agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-		task := &Task{
-			ID:         fmt.Sprintf("task-%d", len(input)),
-			Content:    input,
-			Type:       taskType,
-			AssignedTo: agentName,
-			Status:     "processing",
-		}
-		o.currentTask = task
+// GetAgent returns the agent registered under name
+func (o *Orchestrator) GetAgent(name string) (agents.Agent, bool) {
+	o.configMu.RLock()
+	defer o.configMu.RUnlock()
+	agent, exists := o.agents[name]
+	return agent, exists
+}
 
-		progress <- ProgressUpdate{
-			Stage:   "routing",
-			Message: fmt.Sprintf("Routing to %s", agentName),
-			Agent:   agentName,
-			Type:    "status",
-		}
+// ActiveAgent returns the agent that general requests are routed to
+func (o *Orchestrator) ActiveAgent() string {
+	return o.router.Route(TaskTypeGeneral)
+}
 
-		agent, exists := o.agents[agentName]
-		if !exists {
-			task.Status = "error"
-			task.Result = fmt.Sprintf("Agent %s not found", agentName)
-			progress <- ProgressUpdate{Message: task.Result, Agent: agentName, Type: "error", Done: true}
-			return
-		}
+// SetActiveAgent routes every task type to the named agent
+func (o *Orchestrator) SetActiveAgent(name string) error {
+	if _, exists := o.GetAgent(name); !exists {
+		return fmt.Errorf("agent %s not found", name)
+	}
+	for taskType := range o.router.GetRoutes() {
+		o.router.SetRoute(taskType, name)
+	}
+	return nil
+}
 
-		progress <- ProgressUpdate{
-			Stage:   "processing",
-			Message: fmt.Sprintf("Starting %s...", agentName),
-			Agent:   agentName,
-			Type:    "status",
+// SetRoutes routes each task type in routes to its agent. Other task
+// types keep their route.
+func (o *Orchestrator) SetRoutes(routes map[TaskType]string) error {
+	for _, name := range routes {
+		if _, exists := o.GetAgent(name); !exists {
+			return fmt.Errorf("agent %s not found", name)
 		}
+	}
+	for taskType, name := range routes {
+		o.router.SetRoute(taskType, name)
+	}
+	return nil
+}
 
-		// Create stream channel for agent
-		agentStream := make(chan agents.StreamChunk, 100)
-		var response *agents.Response
-		var execErr error
-
-		go func() {
-			response, execErr = agent.ExecuteStream(ctx, input, agentStream)
-		}()
-
-		// Forward agent stream chunks to progress channel
-		for chunk := range agentStream {
-			progress <- ProgressUpdate{
-				Stage:   "streaming",
-				Message: chunk.Content,
-				Agent:   agentName,
-				Type:    chunk.Type,
-				Done:    chunk.Done,
-			}
-		}
+// SetAgentModel changes the model used by the named agent
+func (o *Orchestrator) SetAgentModel(name, model string) error {
+	agent, exists := o.GetAgent(name)
+	if !exists {
+		return fmt.Errorf("agent %s not found", name)
+	}
+	setter, ok := agent.(interface{ SetModel(string) })
+	if !ok {
+		return fmt.Errorf("agent %s does not support changing models", name)
+	}
+	setter.SetModel(model)
+	return nil
+}
 
-		if execErr != nil {
-			task.Status = "error"
-			task.Result = execErr.Error()
-			progress <- ProgressUpdate{Message: execErr.Error(), Agent: agentName, Type: "error", Done: true}
-			return
-		}
+// GetAgentStatus returns the status of every agent with the number of
+// running and queued requests
+func (o *Orchestrator) GetAgentStatus() map[string]AgentStatus {
+	o.configMu.RLock()
+	current := make(map[string]agents.Agent, len(o.agents))
+	for name, agent := range o.agents {
+		current[name] = agent
+	}
+	o.configMu.RUnlock()
 
-		task.Status = "completed"
-		if response != nil {
-			task.Result = response.Content
-		}
-		progress <- ProgressUpdate{Stage: "completed", Message: "Done", Agent: agentName, Type: "status", Done: true}
-	}()
+	status := make(map[string]AgentStatus)
+	for name, agent := range current {
+		running, queued, limit := o.queue(name).counts()
+		status[name] = AgentStatus{State: agent.Status(), Running: running, Queued: queued, Limit: limit}
+	}
+	return status
+}
 
+// ProcessStreamAsync starts async processing and returns the progress channel
+// The channel is owned by Orchestrator and will be closed when processing completes
+func (o *Orchestrator) ProcessStreamAsync(ctx context.Context, input string) <-chan ProgressUpdate {
+	progress := make(chan ProgressUpdate, 100)
+	go o.ProcessStream(ctx, input, progress)
 	return progress
 }
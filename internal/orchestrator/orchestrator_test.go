@@ -1,7 +1,15 @@
 package orchestrator
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/budget"
 )
 
 func TestAnalyzeTask(t *testing.T) {
@@ -92,3 +100,312 @@ func TestGetAgentStatus(t *testing.T) {
 		t.Error("GetAgentStatus() should not return nil")
 	}
 }
+
+func TestSetActiveAgent(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{
+		"sonnet": {Name: "sonnet", Type: agents.AgentTypeClaude, Model: "claude-sonnet"},
+		"haiku":  {Name: "haiku", Type: agents.AgentTypeClaude, Model: "claude-haiku"},
+	})
+
+	if names := o.AgentNames(); len(names) != 2 || names[0] != "haiku" {
+		t.Errorf("AgentNames() = %v, want sorted [haiku sonnet]", names)
+	}
+
+	if err := o.SetActiveAgent("missing"); err == nil {
+		t.Error("SetActiveAgent() should error for unknown agent")
+	}
+
+	if err := o.SetActiveAgent("haiku"); err != nil {
+		t.Fatalf("SetActiveAgent() error: %v", err)
+	}
+	if o.ActiveAgent() != "haiku" {
+		t.Errorf("ActiveAgent() = %q, want %q", o.ActiveAgent(), "haiku")
+	}
+}
+
+func TestSetAgentModel(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{
+		"sonnet": {Name: "sonnet", Type: agents.AgentTypeClaude, Model: "claude-sonnet"},
+	})
+
+	if err := o.SetAgentModel("missing", "x"); err == nil {
+		t.Error("SetAgentModel() should error for unknown agent")
+	}
+
+	if err := o.SetAgentModel("sonnet", "claude-opus"); err != nil {
+		t.Fatalf("SetAgentModel() error: %v", err)
+	}
+	agent, _ := o.GetAgent("sonnet")
+	if agent.Model() != "claude-opus" {
+		t.Errorf("agent.Model() = %q, want %q", agent.Model(), "claude-opus")
+	}
+}
+
+// blockingAgent streams one chunk and then waits for cancellation
+type blockingAgent struct{}
+
+func (a *blockingAgent) Execute(ctx context.Context, prompt string) (*agents.Response, error) {
+	<-ctx.Done()
+	return &agents.Response{Content: "partial"}, agents.ErrCancelled
+}
+
+func (a *blockingAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- agents.StreamChunk) (*agents.Response, error) {
+	defer close(stream)
+	stream <- agents.StreamChunk{Content: "partial", Type: "output"}
+	<-ctx.Done()
+	return &agents.Response{Content: "partial"}, agents.ErrCancelled
+}
+
+func (a *blockingAgent) Status() string { return "ready" }
+func (a *blockingAgent) Name() string   { return "sonnet" }
+func (a *blockingAgent) Model() string  { return "test-model" }
+
+func TestProcessStreamAsyncCancelled(t *testing.T) {
+	o := New(nil)
+	o.agents["sonnet"] = &blockingAgent{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	progress := o.ProcessStreamAsync(ctx, "hello")
+
+	var last ProgressUpdate
+	for update := range progress {
+		if update.Type == "output" {
+			cancel()
+		}
+		last = update
+	}
+
+	if last.Type != "cancelled" || !last.Done {
+		t.Errorf("final update = %+v, want cancelled and done", last)
+	}
+	if task := o.GetCurrentTask(); task == nil || task.Status != "cancelled" {
+		t.Errorf("task status should be cancelled, got %+v", task)
+	}
+}
+
+// usageAgent replies immediately and reports usage
+type usageAgent struct{}
+
+func (a *usageAgent) Execute(ctx context.Context, prompt string) (*agents.Response, error) {
+	return &agents.Response{Content: "ok", Usage: agents.Usage{InputTokens: 12, OutputTokens: 3, CostUSD: 0.001, Requests: 1}}, nil
+}
+
+func (a *usageAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- agents.StreamChunk) (*agents.Response, error) {
+	defer close(stream)
+	return a.Execute(ctx, prompt)
+}
+
+func (a *usageAgent) Status() string { return "ready" }
+func (a *usageAgent) Name() string   { return "sonnet" }
+func (a *usageAgent) Model() string  { return "test-model" }
+
+func TestProcessRecordsUsage(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &usageAgent{}
+
+	task, err := o.Process(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if task.Usage.InputTokens != 12 || task.Usage.OutputTokens != 3 || task.Usage.Requests != 1 {
+		t.Errorf("task.Usage = %+v, want the agent's usage", task.Usage)
+	}
+}
+
+// spendingAgent reports growing usage until it is cancelled
+type spendingAgent struct{}
+
+func (a *spendingAgent) Execute(ctx context.Context, prompt string) (*agents.Response, error) {
+	return &agents.Response{Content: "ok"}, nil
+}
+
+func (a *spendingAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- agents.StreamChunk) (*agents.Response, error) {
+	defer close(stream)
+	stream <- agents.StreamChunk{Content: "working", Type: "output"}
+	for cost := 0.1; ; cost += 0.1 {
+		select {
+		case <-ctx.Done():
+			return &agents.Response{Content: "working", Usage: agents.Usage{CostUSD: cost, Requests: 1}}, agents.ErrCancelled
+		case stream <- agents.StreamChunk{Type: "usage", Usage: &agents.Usage{CostUSD: cost}}:
+		}
+	}
+}
+
+func (a *spendingAgent) Status() string { return "ready" }
+func (a *spendingAgent) Name() string   { return "sonnet" }
+func (a *spendingAgent) Model() string  { return "test-model" }
+
+func TestBudgetStopsInFlightRequest(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &spendingAgent{}
+	tracker := budget.NewTracker(budget.Limits{PerRequest: 0.35}, "")
+	o.SetBudget(tracker)
+
+	var last ProgressUpdate
+	for update := range o.ProcessStreamAsync(context.Background(), "spend") {
+		if update.Type == "usage" {
+			t.Error("usage chunks should not be forwarded")
+		}
+		last = update
+	}
+
+	if last.Type != "budget" || !last.Done {
+		t.Fatalf("final update = %+v, want budget stop", last)
+	}
+	if o.GetCurrentTask().Status != "budget_exceeded" {
+		t.Errorf("task status = %q, want budget_exceeded", o.GetCurrentTask().Status)
+	}
+	if tracker.DaySpent() <= 0 {
+		t.Error("spend of the stopped request should be recorded")
+	}
+}
+
+func TestBudgetStopsInFlightProcess(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &spendingAgent{}
+	tracker := budget.NewTracker(budget.Limits{PerRequest: 0.35}, "")
+	o.SetBudget(tracker)
+
+	task, err := o.Process(context.Background(), "spend")
+	if !errors.Is(err, budget.ErrExceeded) {
+		t.Fatalf("Process() error = %v, want ErrExceeded", err)
+	}
+	if task.Status != TaskBudgetExceeded {
+		t.Errorf("task status = %q, want budget_exceeded", task.Status)
+	}
+	if task.Usage.CostUSD <= 0.35 || tracker.DaySpent() <= 0.35 {
+		t.Errorf("task cost = %g, want the spend of the stopped request recorded", task.Usage.CostUSD)
+	}
+
+	// A reply that only reports its cost at the end is checked too
+	o.agents["sonnet"] = &usageAgent{}
+	o.SetBudget(budget.NewTracker(budget.Limits{PerRequest: 0.0005}, ""))
+	if _, err := o.Process(context.Background(), "hello"); !errors.Is(err, budget.ErrExceeded) {
+		t.Errorf("Process() error = %v, want ErrExceeded", err)
+	}
+}
+
+func TestBudgetBlocksBeforeDispatch(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &usageAgent{}
+	tracker := budget.NewTracker(budget.Limits{PerSession: 0.5}, "")
+	o.SetBudget(tracker)
+
+	scope := tracker.NewScope(budget.ScopeSession)
+	scope.Add(0.5)
+	ctx := budget.WithScope(context.Background(), scope)
+
+	if _, err := o.Process(ctx, "hello"); !errors.Is(err, budget.ErrExceeded) {
+		t.Errorf("Process() error = %v, want ErrExceeded", err)
+	}
+
+	var last ProgressUpdate
+	for update := range o.ProcessStreamAsync(ctx, "hello") {
+		last = update
+	}
+	if last.Type != "budget" {
+		t.Errorf("final update = %+v, want budget stop", last)
+	}
+
+	// Overriding lets the request through
+	task, err := o.Process(budget.WithOverride(ctx), "hello")
+	if err != nil || task.Status != "completed" {
+		t.Errorf("Process() with override = %v, %v", task.Status, err)
+	}
+}
+
+// toolAgent calls a tool before replying
+type toolAgent struct{}
+
+func (a *toolAgent) Execute(ctx context.Context, prompt string) (*agents.Response, error) {
+	return &agents.Response{Content: "ok"}, nil
+}
+
+func (a *toolAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- agents.StreamChunk) (*agents.Response, error) {
+	defer close(stream)
+	tool := agents.ToolEvent{ID: "toolu_1", Name: "Read", Input: "main.go", Status: agents.ToolRunning}
+	stream <- agents.StreamChunk{Content: tool.String(), Type: "tool_use", Tool: &tool}
+	done := tool
+	done.Status = agents.ToolSucceeded
+	stream <- agents.StreamChunk{Content: done.String(), Type: "tool_result", Tool: &done}
+	stream <- agents.StreamChunk{Content: "ok", Type: "output"}
+	return &agents.Response{Content: "ok"}, nil
+}
+
+func (a *toolAgent) Status() string { return "ready" }
+func (a *toolAgent) Name() string   { return "sonnet" }
+func (a *toolAgent) Model() string  { return "test-model" }
+
+func TestProcessStreamAsyncForwardsToolEvents(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &toolAgent{}
+
+	var tools []ProgressUpdate
+	for update := range o.ProcessStreamAsync(context.Background(), "read main.go") {
+		if update.Type == "tool_use" || update.Type == "tool_result" {
+			tools = append(tools, update)
+		}
+	}
+
+	if len(tools) != 2 {
+		t.Fatalf("got %d tool updates, want 2", len(tools))
+	}
+	if tools[0].Tool == nil || tools[0].Tool.Status != agents.ToolRunning {
+		t.Errorf("tool_use update = %+v", tools[0])
+	}
+	if tools[1].Tool == nil || tools[1].Tool.Status != agents.ToolSucceeded || tools[1].Message != "Read main.go" {
+		t.Errorf("tool_result update = %+v", tools[1])
+	}
+	if o.GetCurrentTask().Result != "ok" {
+		t.Errorf("result = %q, tool events should not be part of the reply", o.GetCurrentTask().Result)
+	}
+}
+
+func TestProcessStreamWithMockAgents(t *testing.T) {
+	dir := t.TempDir()
+	fixtures := map[string]string{
+		"sonnet": "responses:\n  - error: rate limit exceeded\n",
+		"haiku": `responses:
+  - match: "(?i)button"
+    chunks:
+      - {type: thinking, content: "Picking a style"}
+      - {type: output, content: "Button added"}
+    usage: {input_tokens: 20, output_tokens: 5, cost_usd: 0.002}
+`,
+	}
+	configs := make(map[string]agents.AgentConfig)
+	for name, fixture := range fixtures {
+		path := filepath.Join(dir, name+".yaml")
+		if err := os.WriteFile(path, []byte(fixture), 0644); err != nil {
+			t.Fatal(err)
+		}
+		configs[name] = agents.AgentConfig{Name: name, Type: agents.AgentTypeMock, Fixture: path}
+	}
+	o := New(configs)
+	o.SetFallbacks(map[string][]string{"sonnet": {"haiku"}})
+
+	var types []string
+	var last ProgressUpdate
+	for update := range o.ProcessStreamAsync(context.Background(), "Add a button") {
+		switch update.Type {
+		case "thinking", "output", "fallback":
+			types = append(types, update.Type)
+		}
+		last = update
+	}
+
+	if got := strings.Join(types, " "); got != "fallback thinking output" {
+		t.Errorf("update types = %q", got)
+	}
+	if !last.Done || last.Agent != "haiku" || last.Usage.OutputTokens != 5 {
+		t.Errorf("final update = %+v", last)
+	}
+	task := o.GetCurrentTask()
+	if task.Status != TaskCompleted || task.Result != "Button added" || task.AssignedTo != "haiku" || task.Model != "mock" {
+		t.Errorf("task = %+v", task)
+	}
+	if len(task.Failed) != 1 || task.Failed[0] != "sonnet" {
+		t.Errorf("failed agents = %v, want [sonnet]", task.Failed)
+	}
+}
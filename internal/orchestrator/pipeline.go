@@ -0,0 +1,239 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CursorImplementer is the implementer name that sends tasks to the
+// Cursor bridge instead of an agent
+const CursorImplementer = "cursor"
+
+// defaultMaxPlanTasks limits how many planned tasks are implemented
+const defaultMaxPlanTasks = 5
+
+// Pipeline stages
+const (
+	StagePlan      = "plan"
+	StageImplement = "implement"
+	StageReview    = "review"
+)
+
+// Editor applies code changes described by a prompt, e.g. the Cursor bridge
+type Editor interface {
+	Edit(ctx context.Context, prompt string) (string, error)
+}
+
+// PipelineConfig names the agents used for each stage of the pipeline.
+// Empty names use the active agent.
+type PipelineConfig struct {
+	Planner     string
+	Implementer string // an agent name, or CursorImplementer
+	Reviewer    string
+	MaxTasks    int
+}
+
+const planPrompt = `You are the planner. Break the request below into at most %d concrete implementation tasks that can be done one after another.
+Reply with a short numbered list, one task per line ("1. ..."), and nothing else.
+
+Request:
+%s`
+
+const implementPrompt = `You are the implementer. Carry out task %d of the plan for the request below. Only do this task.
+
+Request:
+%s
+
+Plan:
+%s
+
+Task %d: %s`
+
+const reviewPrompt = `You are the reviewer. Review the work done for the request below. Point out bugs, missing pieces and deviations from the plan, and say whether the request is complete.
+
+Request:
+%s
+
+Plan:
+%s
+
+Results:
+%s`
+
+// planLine matches a numbered plan item such as "1. Add a flag" or "2) Test it"
+var planLine = regexp.MustCompile(`^\s*\d+[.)]\s+(.+)$`)
+
+// SetPipeline configures the agents used by ProcessPipelineAsync
+func (o *Orchestrator) SetPipeline(config PipelineConfig) {
+	o.configMu.Lock()
+	defer o.configMu.Unlock()
+	o.pipeline = config
+}
+
+// SetEditor sets the editor used when the implementer is CursorImplementer
+func (o *Orchestrator) SetEditor(editor Editor) {
+	o.editor = editor
+}
+
+// ProcessPipelineAsync runs the input through the planner, one implementer
+// step per planned task and the reviewer. Every update is tagged with its
+// stage and subtask. The channel is closed when the pipeline finishes.
+func (o *Orchestrator) ProcessPipelineAsync(ctx context.Context, input string) <-chan ProgressUpdate {
+	progress := make(chan ProgressUpdate, 100)
+
+	go func() {
+		defer close(progress)
+
+		task := o.startTask(nil, input, "pipeline")
+		err := o.runPipeline(ctx, task, progress)
+		o.finishPipeline(ctx, task, err, progress)
+	}()
+
+	return progress
+}
+
+func (o *Orchestrator) runPipeline(ctx context.Context, task *Task, progress chan<- ProgressUpdate) error {
+	o.configMu.RLock()
+	config := o.pipeline
+	o.configMu.RUnlock()
+
+	active := o.ActiveAgent()
+	planner := orDefault(config.Planner, active)
+	implementer := orDefault(config.Implementer, active)
+	reviewer := orDefault(config.Reviewer, active)
+	maxTasks := config.MaxTasks
+	if maxTasks <= 0 {
+		maxTasks = defaultMaxPlanTasks
+	}
+
+	plan, err := o.runStage(ctx, task, StagePlan, "Plan", planner, fmt.Sprintf(planPrompt, maxTasks, task.Content), progress)
+	if err != nil {
+		return err
+	}
+	steps := parsePlan(plan.Result, maxTasks)
+	if len(steps) == 0 {
+		// The planner did not produce a list, so implement the request as a whole
+		steps = []string{task.Content}
+	}
+
+	var results strings.Builder
+	for i, step := range steps {
+		prompt := fmt.Sprintf(implementPrompt, i+1, task.Content, plan.Result, i+1, step)
+		sub, err := o.runStage(ctx, task, StageImplement, step, implementer, prompt, progress)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&results, "Task %d: %s\n%s\n\n", i+1, step, sub.Result)
+	}
+
+	review, err := o.runStage(ctx, task, StageReview, "Review", reviewer, fmt.Sprintf(reviewPrompt, task.Content, plan.Result, results.String()), progress)
+	if err != nil {
+		return err
+	}
+	o.updateTask(task, func(t *Task) { t.Result = review.Result })
+	return nil
+}
+
+// runStage runs one pipeline step as a subtask of task
+func (o *Orchestrator) runStage(ctx context.Context, task *Task, stage, content, agentName, prompt string, progress chan<- ProgressUpdate) (*Task, error) {
+	sub := o.startTask(task, content, agentName)
+	o.updateTask(sub, func(t *Task) { t.Stage = stage })
+
+	tag := ProgressUpdate{Stage: stage, TaskID: sub.ID, Agent: agentName}
+	status := tag
+	status.Type = "status"
+	status.Message = fmt.Sprintf("%s: %s", stage, content)
+	progress <- status
+
+	if agentName == CursorImplementer {
+		err := o.runEditor(ctx, sub, prompt, tag, progress)
+		return o.snapshot(sub), err
+	}
+
+	if err := o.checkBudget(ctx, agentName, progress); err != nil {
+		o.finishTask(sub, TaskBudgetExceeded, err.Error())
+		return o.snapshot(sub), err
+	}
+
+	response, err := o.execute(ctx, sub, agentName, prompt, tag, progress)
+	o.updateTask(sub, func(t *Task) {
+		if response != nil {
+			t.Result = response.Content
+		}
+		task.Usage.Add(t.Usage)
+	})
+	o.finishTask(sub, taskStatus(ctx, err), errorResult(ctx, err))
+	return o.snapshot(sub), err
+}
+
+// runEditor sends an implementer step to the editor
+func (o *Orchestrator) runEditor(ctx context.Context, sub *Task, prompt string, tag ProgressUpdate, progress chan<- ProgressUpdate) error {
+	if o.editor == nil {
+		o.finishTask(sub, TaskError, "Cursor bridge not configured")
+		return errors.New("cursor bridge not configured")
+	}
+
+	output, err := o.editor.Edit(ctx, prompt)
+	output = strings.TrimSpace(output)
+	if output != "" {
+		o.updateTask(sub, func(t *Task) { t.Result = output })
+		update := tag
+		update.Type = "output"
+		update.Message = output
+		progress <- update
+	}
+
+	o.finishTask(sub, taskStatus(ctx, err), errorResult(ctx, err))
+	return err
+}
+
+// finishPipeline sets the final status of task and sends the final update
+func (o *Orchestrator) finishPipeline(ctx context.Context, task *Task, err error, progress chan<- ProgressUpdate) {
+	status := taskStatus(ctx, err)
+	o.finishTask(task, status, errorResult(ctx, err))
+
+	snapshot := o.snapshot(task)
+	final := ProgressUpdate{Agent: "pipeline", TaskID: task.ID, Done: true, Usage: snapshot.Usage}
+	if n := len(snapshot.Subtasks); n > 0 {
+		final.Agent = snapshot.Subtasks[n-1].AssignedTo
+		final.TaskID = snapshot.Subtasks[n-1].ID
+	}
+
+	switch status {
+	case TaskBudgetExceeded:
+		final.Stage, final.Type, final.Message = "budget", "budget", err.Error()
+	case TaskCancelled:
+		final.Stage, final.Type, final.Message = "cancelled", "cancelled", "Request cancelled"
+	case TaskError:
+		final.Stage, final.Type, final.Message = "error", "error", err.Error()
+	default:
+		final.Stage, final.Type, final.Message = "completed", "status", "Done"
+	}
+	progress <- final
+}
+
+// parsePlan extracts the numbered tasks from a plan
+func parsePlan(plan string, maxTasks int) []string {
+	var steps []string
+	for _, line := range strings.Split(plan, "\n") {
+		m := planLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		steps = append(steps, strings.TrimSpace(m[1]))
+		if len(steps) == maxTasks {
+			break
+		}
+	}
+	return steps
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,137 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// scriptedAgent replies with reply(prompt) and records its prompts
+type scriptedAgent struct {
+	name    string
+	reply   func(prompt string) string
+	prompts []string
+}
+
+func (a *scriptedAgent) Execute(ctx context.Context, prompt string) (*agents.Response, error) {
+	a.prompts = append(a.prompts, prompt)
+	return &agents.Response{Content: a.reply(prompt)}, nil
+}
+
+func (a *scriptedAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- agents.StreamChunk) (*agents.Response, error) {
+	defer close(stream)
+	a.prompts = append(a.prompts, prompt)
+	content := a.reply(prompt)
+	stream <- agents.StreamChunk{Content: content, Type: "output"}
+	return &agents.Response{Content: content, Usage: agents.Usage{CostUSD: 0.01, Requests: 1}}, nil
+}
+
+func (a *scriptedAgent) Status() string { return "ready" }
+func (a *scriptedAgent) Name() string   { return a.name }
+func (a *scriptedAgent) Model() string  { return "test-model" }
+
+type fakeEditor struct {
+	prompts []string
+	err     error
+}
+
+func (e *fakeEditor) Edit(ctx context.Context, prompt string) (string, error) {
+	e.prompts = append(e.prompts, prompt)
+	return "edited", e.err
+}
+
+func TestParsePlan(t *testing.T) {
+	plan := "Here is the plan:\n1. Add the flag\n2) Wire it up\n  3. Write tests\n- not a task\n4. Update docs"
+	steps := parsePlan(plan, 3)
+	want := []string{"Add the flag", "Wire it up", "Write tests"}
+	if strings.Join(steps, "|") != strings.Join(want, "|") {
+		t.Errorf("parsePlan() = %q, want %q", steps, want)
+	}
+}
+
+func TestProcessPipelineAsync(t *testing.T) {
+	planner := &scriptedAgent{name: "planner", reply: func(string) string { return "1. Add a flag\n2. Test it" }}
+	coder := &scriptedAgent{name: "coder", reply: func(string) string { return "done" }}
+	reviewer := &scriptedAgent{name: "reviewer", reply: func(string) string { return "Looks complete." }}
+
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["planner"] = planner
+	o.agents["coder"] = coder
+	o.agents["reviewer"] = reviewer
+	o.SetPipeline(PipelineConfig{Planner: "planner", Implementer: "coder", Reviewer: "reviewer"})
+
+	var stages []string
+	var last ProgressUpdate
+	for update := range o.ProcessPipelineAsync(context.Background(), "add a verbose flag") {
+		if update.Type == "output" {
+			stages = append(stages, update.Stage+":"+update.TaskID)
+		}
+		last = update
+	}
+
+	task := o.GetCurrentTask()
+	if task.Status != "completed" || task.Result != "Looks complete." || len(task.Subtasks) != 4 {
+		t.Fatalf("task = %+v", task)
+	}
+	if task.Usage.Requests != 4 || task.CompletedAt.IsZero() {
+		t.Errorf("task usage = %+v, completed at %v", task.Usage, task.CompletedAt)
+	}
+	sub := task.Subtasks[2]
+	if sub.Content != "Test it" || sub.Stage != StageImplement || sub.AssignedTo != "coder" || sub.ParentID != task.ID {
+		t.Errorf("subtask = %+v", sub)
+	}
+
+	wantStages := []string{"plan:" + task.ID + ".1", "implement:" + task.ID + ".2", "implement:" + task.ID + ".3", "review:" + task.ID + ".4"}
+	if strings.Join(stages, " ") != strings.Join(wantStages, " ") {
+		t.Errorf("output stages = %v, want %v", stages, wantStages)
+	}
+	if !last.Done || last.Stage != "completed" {
+		t.Errorf("final update = %+v", last)
+	}
+	if last.Usage.Requests != 4 {
+		t.Errorf("total usage = %+v, want 4 requests", last.Usage)
+	}
+	if found, ok := o.Task(sub.ID); !ok || found.Content != "Test it" {
+		t.Errorf("Task(%q) = %+v, %v", sub.ID, found, ok)
+	}
+	if len(coder.prompts) != 2 || !strings.Contains(coder.prompts[1], "Task 2: Test it") {
+		t.Errorf("implementer prompts = %q", coder.prompts)
+	}
+	if !strings.Contains(reviewer.prompts[0], "Task 1: Add a flag\ndone") {
+		t.Errorf("reviewer prompt should include results, got %q", reviewer.prompts[0])
+	}
+}
+
+func TestProcessPipelineAsyncCursorImplementer(t *testing.T) {
+	agent := &scriptedAgent{name: "sonnet", reply: func(prompt string) string {
+		if strings.Contains(prompt, "You are the planner") {
+			return "No list here"
+		}
+		return "ok"
+	}}
+	editor := &fakeEditor{err: errors.New("cursor-agent not found")}
+
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = agent
+	o.SetPipeline(PipelineConfig{Implementer: CursorImplementer})
+	o.SetEditor(editor)
+
+	var last ProgressUpdate
+	for update := range o.ProcessPipelineAsync(context.Background(), "rename a function") {
+		last = update
+	}
+
+	// Without a list the request is implemented as a single task
+	if len(editor.prompts) != 1 || !strings.Contains(editor.prompts[0], "Task 1: rename a function") {
+		t.Errorf("editor prompts = %q", editor.prompts)
+	}
+	if last.Type != "error" || o.GetCurrentTask().Status != "error" {
+		t.Errorf("final update = %+v, want error from the editor", last)
+	}
+	if n := len(o.GetCurrentTask().Subtasks); n != 2 {
+		t.Errorf("got %d subtasks, want the plan and the failed step", n)
+	}
+}
@@ -0,0 +1,136 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// defaultMaxConcurrent is how many requests an agent runs at once when its
+// config does not say. The Claude CLI continues one conversation per
+// directory, so requests to the same agent are serialized by default.
+const defaultMaxConcurrent = 1
+
+// AgentStatus describes an agent and the requests the orchestrator has
+// sent to it
+type AgentStatus struct {
+	State   string // the agent's own status, e.g. "ready" or "cli_not_found"
+	Running int
+	Queued  int
+	Limit   int // requests that may run at once
+}
+
+// String returns the state with the running and queued counts, if any
+func (s AgentStatus) String() string {
+	switch {
+	case s.Queued > 0:
+		return fmt.Sprintf("%s (%d running, %d queued)", s.State, s.Running, s.Queued)
+	case s.Running > 0:
+		return fmt.Sprintf("%s (%d running)", s.State, s.Running)
+	default:
+		return s.State
+	}
+}
+
+// agentQueue limits the requests running on one agent. Requests over the
+// limit wait in order of arrival.
+type agentQueue struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	waiting []chan struct{}
+}
+
+func newAgentQueue(limit int) *agentQueue {
+	if limit <= 0 {
+		limit = defaultMaxConcurrent
+	}
+	return &agentQueue{limit: limit}
+}
+
+// enter takes a slot if one is free. Otherwise it returns a channel that
+// is closed once a slot is handed over, and the position in the queue.
+func (q *agentQueue) enter() (chan struct{}, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running < q.limit {
+		q.running++
+		return nil, 0
+	}
+	ready := make(chan struct{})
+	q.waiting = append(q.waiting, ready)
+	return ready, len(q.waiting)
+}
+
+// leave removes a waiting request. It returns false if the request was
+// handed a slot in the meantime, which the caller must then release.
+func (q *agentQueue) leave(ready chan struct{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, ch := range q.waiting {
+		if ch == ready {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// release frees a slot, handing it to the next waiting request
+func (q *agentQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) > 0 {
+		close(q.waiting[0])
+		q.waiting = q.waiting[1:]
+		return
+	}
+	q.running--
+}
+
+func (q *agentQueue) counts() (running, queued, limit int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running, len(q.waiting), q.limit
+}
+
+// queue returns the request queue of the named agent
+func (o *Orchestrator) queue(agentName string) *agentQueue {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q, exists := o.queues[agentName]
+	if !exists {
+		q = newAgentQueue(defaultMaxConcurrent)
+		o.queues[agentName] = q
+	}
+	return q
+}
+
+// acquire waits until the agent may take another request and returns the
+// func that frees the slot again. While waiting, a "queued" update copied
+// from tag is sent to progress, if progress is not nil.
+func (o *Orchestrator) acquire(ctx context.Context, agentName string, tag ProgressUpdate, progress chan<- ProgressUpdate) (func(), error) {
+	q := o.queue(agentName)
+	ready, position := q.enter()
+	if ready == nil {
+		return q.release, nil
+	}
+
+	if progress != nil {
+		update := tag
+		update.Stage = "queued"
+		update.Type = "status"
+		update.Message = fmt.Sprintf("Waiting for %s (position %d in queue)", agentName, position)
+		progress <- update
+	}
+
+	select {
+	case <-ready:
+		return q.release, nil
+	case <-ctx.Done():
+		if !q.leave(ready) {
+			q.release()
+		}
+		return nil, ctx.Err()
+	}
+}
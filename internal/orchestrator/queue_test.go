@@ -0,0 +1,161 @@
+package orchestrator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// gatedAgent blocks every request until gate is closed
+type gatedAgent struct {
+	gate    chan struct{}
+	mu      sync.Mutex
+	started int
+}
+
+func (a *gatedAgent) Execute(ctx context.Context, prompt string) (*agents.Response, error) {
+	return a.ExecuteStream(ctx, prompt, make(chan agents.StreamChunk, 1))
+}
+
+func (a *gatedAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- agents.StreamChunk) (*agents.Response, error) {
+	defer close(stream)
+	a.mu.Lock()
+	a.started++
+	a.mu.Unlock()
+	<-a.gate
+	return &agents.Response{Content: prompt}, nil
+}
+
+func (a *gatedAgent) Status() string { return "ready" }
+func (a *gatedAgent) Name() string   { return "sonnet" }
+func (a *gatedAgent) Model() string  { return "test-model" }
+
+func (a *gatedAgent) startedCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.started
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrentRequestsAreQueued(t *testing.T) {
+	agent := &gatedAgent{gate: make(chan struct{})}
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = agent
+
+	first := o.ProcessStreamAsync(context.Background(), "first")
+	waitFor(t, "the first request to start", func() bool { return agent.startedCount() == 1 })
+
+	second := o.ProcessStreamAsync(context.Background(), "second")
+	var queued bool
+	for update := range second {
+		if update.Stage == "queued" {
+			queued = true
+			break
+		}
+	}
+	if !queued {
+		t.Fatal("second request should be queued while the first runs")
+	}
+
+	status := o.GetAgentStatus()["sonnet"]
+	if status.Running != 1 || status.Queued != 1 || status.Limit != 1 {
+		t.Errorf("status = %+v, want 1 running and 1 queued", status)
+	}
+	if got := status.String(); got != "ready (1 running, 1 queued)" {
+		t.Errorf("status.String() = %q", got)
+	}
+
+	close(agent.gate)
+	for range first {
+	}
+	for range second {
+	}
+	if agent.startedCount() != 2 {
+		t.Errorf("agent started %d requests, want 2", agent.startedCount())
+	}
+	if status := o.GetAgentStatus()["sonnet"]; status.Running != 0 || status.Queued != 0 {
+		t.Errorf("status after both requests = %+v", status)
+	}
+	if tasks := o.Tasks(TaskFilter{Status: TaskCompleted}); len(tasks) != 2 {
+		t.Errorf("got %d completed tasks, want 2", len(tasks))
+	}
+}
+
+func TestConcurrencyLimitFromConfig(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{
+		"api": {Name: "api", Type: agents.AgentTypeClaudeAPI, APIKey: "test", MaxConcurrent: 3},
+	})
+	if limit := o.GetAgentStatus()["api"].Limit; limit != 3 {
+		t.Errorf("limit = %d, want 3", limit)
+	}
+}
+
+func TestQueuedRequestCancelled(t *testing.T) {
+	agent := &gatedAgent{gate: make(chan struct{})}
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = agent
+
+	first := o.ProcessStreamAsync(context.Background(), "first")
+	waitFor(t, "the first request to start", func() bool { return agent.startedCount() == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last ProgressUpdate
+	for update := range o.ProcessStreamAsync(ctx, "second") {
+		if update.Stage == "queued" {
+			cancel()
+		}
+		last = update
+	}
+	if last.Type != "cancelled" {
+		t.Errorf("final update = %+v, want cancelled", last)
+	}
+	if status := o.GetAgentStatus()["sonnet"]; status.Queued != 0 {
+		t.Errorf("cancelled request is still queued: %+v", status)
+	}
+
+	close(agent.gate)
+	for range first {
+	}
+	if agent.startedCount() != 1 {
+		t.Errorf("cancelled request reached the agent")
+	}
+}
+
+func TestAgentQueueHandsOverSlots(t *testing.T) {
+	q := newAgentQueue(1)
+	if ready, _ := q.enter(); ready != nil {
+		t.Fatal("first enter should get a slot")
+	}
+	ready, position := q.enter()
+	if ready == nil || position != 1 {
+		t.Fatalf("second enter = %v, %d, want to wait at position 1", ready, position)
+	}
+
+	q.release()
+	select {
+	case <-ready:
+	default:
+		t.Fatal("release should hand the slot to the waiting request")
+	}
+	if q.leave(ready) {
+		t.Error("leave() should report that the slot was already handed over")
+	}
+	if running, queued, _ := q.counts(); running != 1 || queued != 0 {
+		t.Errorf("counts = %d running, %d queued", running, queued)
+	}
+}
@@ -0,0 +1,145 @@
+package orchestrator
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// Settings are the parts of the configuration Reconfigure can change
+// while the orchestrator runs
+type Settings struct {
+	Agents    map[string]agents.AgentConfig
+	Fallbacks map[string][]string
+	Routes    map[TaskType]string // task types not listed keep their route
+	Pipeline  PipelineConfig
+}
+
+// ReloadResult lists the agents a Reconfigure call changed
+type ReloadResult struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// String summarizes the changes, e.g. "added haiku, changed sonnet"
+func (r ReloadResult) String() string {
+	var parts []string
+	for _, group := range []struct {
+		verb  string
+		names []string
+	}{{"added", r.Added}, {"removed", r.Removed}, {"changed", r.Changed}} {
+		if len(group.names) > 0 {
+			parts = append(parts, group.verb+" "+strings.Join(group.names, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return "no agent changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Reconfigure swaps in new agents, fallbacks, routes and pipeline settings.
+// Requests that are running or queued finish on the agent they started
+// with. Agents whose config did not change are kept, so a model chosen
+// with SetAgentModel survives. Routes to removed agents move to "sonnet",
+// or the first agent. If any agent cannot be created nothing is changed.
+// While replaying a cassette the agents are kept as they are.
+func (o *Orchestrator) Reconfigure(settings Settings) (ReloadResult, error) {
+	o.configMu.Lock()
+	var result ReloadResult
+	var limits map[string]int
+	if !o.replaying {
+		next, changes, queueLimits, err := o.buildAgents(settings.Agents)
+		if err != nil {
+			o.configMu.Unlock()
+			return ReloadResult{}, err
+		}
+		o.agents, o.configs = next, settings.Agents
+		result, limits = changes, queueLimits
+	}
+	o.pipeline = settings.Pipeline
+	o.router.ReplaceFallbacks(settings.Fallbacks)
+	for taskType, name := range settings.Routes {
+		o.router.SetRoute(taskType, name)
+	}
+	o.reroute()
+	o.configMu.Unlock()
+
+	// Requests holding a slot release it on the queue they took it from
+	o.mu.Lock()
+	for name, limit := range limits {
+		o.queues[name] = newAgentQueue(limit)
+	}
+	for _, name := range result.Removed {
+		delete(o.queues, name)
+	}
+	o.mu.Unlock()
+	return result, nil
+}
+
+// buildAgents creates the agents for configs, keeping the current agents
+// whose config did not change. It returns the new queue limits of added
+// and changed agents. The caller holds configMu.
+func (o *Orchestrator) buildAgents(configs map[string]agents.AgentConfig) (map[string]agents.Agent, ReloadResult, map[string]int, error) {
+	var result ReloadResult
+	next := make(map[string]agents.Agent, len(configs))
+	limits := make(map[string]int)
+	for name, config := range configs {
+		old, exists := o.configs[name]
+		if exists && reflect.DeepEqual(old, config) {
+			next[name] = o.agents[name]
+			continue
+		}
+		agent, err := agents.NewAgent(config)
+		if err != nil {
+			return nil, ReloadResult{}, nil, fmt.Errorf("failed to create agent %s: %w", name, err)
+		}
+		if o.recorder != nil {
+			agent = agents.NewRecordingAgent(agent, o.recorder)
+		}
+		next[name] = agent
+		if exists {
+			result.Changed = append(result.Changed, name)
+		} else {
+			result.Added = append(result.Added, name)
+		}
+		if !exists || old.MaxConcurrent != config.MaxConcurrent {
+			limits[name] = config.MaxConcurrent
+		}
+	}
+	for name := range o.agents {
+		if _, kept := next[name]; !kept {
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	for _, names := range [][]string{result.Added, result.Removed, result.Changed} {
+		sort.Strings(names)
+	}
+	return next, result, limits, nil
+}
+
+// reroute moves routes to removed agents to "sonnet", or the first agent.
+// The caller holds configMu.
+func (o *Orchestrator) reroute() {
+	fallback := "sonnet"
+	if _, exists := o.agents[fallback]; !exists {
+		names := make([]string, 0, len(o.agents))
+		for name := range o.agents {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return
+		}
+		fallback = names[0]
+	}
+	for taskType, name := range o.router.GetRoutes() {
+		if _, exists := o.agents[name]; !exists {
+			o.router.SetRoute(taskType, fallback)
+		}
+	}
+}
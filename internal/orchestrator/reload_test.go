@@ -0,0 +1,165 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// gateAgent streams "started" and answers once release is closed
+type gateAgent struct {
+	release chan struct{}
+}
+
+func (a *gateAgent) Execute(ctx context.Context, prompt string) (*agents.Response, error) {
+	<-a.release
+	return &agents.Response{Content: "answer"}, nil
+}
+
+func (a *gateAgent) ExecuteStream(ctx context.Context, prompt string, stream chan<- agents.StreamChunk) (*agents.Response, error) {
+	defer close(stream)
+	stream <- agents.StreamChunk{Content: "started", Type: "output"}
+	return a.Execute(ctx, prompt)
+}
+
+func (a *gateAgent) Status() string { return "ready" }
+func (a *gateAgent) Name() string   { return "sonnet" }
+func (a *gateAgent) Model() string  { return "old-model" }
+
+func apiAgent(name, model string, maxConcurrent int) agents.AgentConfig {
+	return agents.AgentConfig{Name: name, Type: agents.AgentTypeClaudeAPI, Model: model, APIKey: "test", MaxConcurrent: maxConcurrent}
+}
+
+func TestReconfigure(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{
+		"sonnet": apiAgent("sonnet", "sonnet-model", 0),
+		"haiku":  apiAgent("haiku", "haiku-model", 0),
+	})
+	haiku, _ := o.GetAgent("haiku")
+	if err := o.SetAgentModel("haiku", "picked-model"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.SetActiveAgent("sonnet"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := o.Reconfigure(Settings{
+		Agents: map[string]agents.AgentConfig{
+			"haiku": apiAgent("haiku", "haiku-model", 0),
+			"opus":  apiAgent("opus", "opus-model", 4),
+		},
+		Fallbacks: map[string][]string{"opus": {"haiku"}},
+		Routes:    map[TaskType]string{TaskTypeCode: "opus"},
+		Pipeline:  PipelineConfig{Reviewer: "opus"},
+	})
+	if err != nil {
+		t.Fatalf("Reconfigure() error: %v", err)
+	}
+	if got := result.String(); got != "added opus; removed sonnet" {
+		t.Errorf("result = %q", got)
+	}
+
+	if got := strings.Join(o.AgentNames(), ","); got != "haiku,opus" {
+		t.Errorf("AgentNames() = %s", got)
+	}
+	// The unchanged agent is kept with the model picked at runtime
+	if kept, _ := o.GetAgent("haiku"); kept != haiku || kept.Model() != "picked-model" {
+		t.Errorf("haiku was replaced or lost its model: %v", kept.Model())
+	}
+	if got := o.ActiveAgent(); got != "haiku" {
+		t.Errorf("ActiveAgent() = %s, want the first agent once sonnet is gone", got)
+	}
+	if got := o.router.Route(TaskTypeCode); got != "opus" {
+		t.Errorf("Route(code) = %s, want opus", got)
+	}
+	if got := strings.Join(o.router.Chain("opus"), ","); got != "opus,haiku" {
+		t.Errorf("Chain(opus) = %s", got)
+	}
+	if status := o.GetAgentStatus()["opus"]; status.Limit != 4 {
+		t.Errorf("opus limit = %d, want 4", status.Limit)
+	}
+
+	// A changed config replaces the agent
+	result, err = o.Reconfigure(Settings{Agents: map[string]agents.AgentConfig{
+		"haiku": apiAgent("haiku", "haiku-4-5", 0),
+		"opus":  apiAgent("opus", "opus-model", 4),
+	}})
+	if err != nil || result.String() != "changed haiku" {
+		t.Fatalf("Reconfigure() = %q, %v", result, err)
+	}
+	if replaced, _ := o.GetAgent("haiku"); replaced.Model() != "haiku-4-5" {
+		t.Errorf("haiku model = %s", replaced.Model())
+	}
+	if got := strings.Join(o.router.Chain("opus"), ","); got != "opus" {
+		t.Errorf("fallbacks were not replaced: %s", got)
+	}
+}
+
+func TestReconfigureKeepsRunningRequests(t *testing.T) {
+	o := New(nil)
+	agent := &gateAgent{release: make(chan struct{})}
+	o.agents["sonnet"] = agent
+
+	progress := o.ProcessStreamAsync(context.Background(), "hello")
+	for update := range progress {
+		if update.Type == "output" {
+			break
+		}
+	}
+
+	if _, err := o.Reconfigure(Settings{Agents: map[string]agents.AgentConfig{
+		"haiku": apiAgent("haiku", "haiku-model", 0),
+	}}); err != nil {
+		t.Fatalf("Reconfigure() error: %v", err)
+	}
+	if _, exists := o.GetAgent("sonnet"); exists {
+		t.Error("sonnet should be removed")
+	}
+
+	close(agent.release)
+	var last ProgressUpdate
+	for update := range progress {
+		last = update
+	}
+	if last.Stage != "completed" || last.Agent != "sonnet" {
+		t.Errorf("final update = %+v, want the request to finish on sonnet", last)
+	}
+	if task := o.GetCurrentTask(); task.Result != "answer" || task.Model != "old-model" {
+		t.Errorf("task = %+v", task)
+	}
+}
+
+func TestReconfigureFailureChangesNothing(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{"sonnet": apiAgent("sonnet", "sonnet-model", 0)})
+	_, err := o.Reconfigure(Settings{Agents: map[string]agents.AgentConfig{
+		"haiku": apiAgent("haiku", "haiku-model", 0),
+		"bad":   {Name: "bad", Type: agents.AgentTypeMock},
+	}})
+	if err == nil {
+		t.Fatal("Reconfigure() with a broken agent should fail")
+	}
+	if got := strings.Join(o.AgentNames(), ","); got != "sonnet" {
+		t.Errorf("AgentNames() = %s, want the old agents", got)
+	}
+}
+
+func TestSetRoutes(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{
+		"sonnet": apiAgent("sonnet", "sonnet-model", 0),
+		"haiku":  apiAgent("haiku", "haiku-model", 0),
+	})
+	if err := o.SetRoutes(map[TaskType]string{TaskTypeGeneral: "haiku"}); err != nil {
+		t.Fatalf("SetRoutes() error: %v", err)
+	}
+	if o.ActiveAgent() != "haiku" || o.router.Route(TaskTypeDebug) != "sonnet" {
+		t.Errorf("routes = %v", o.router.GetRoutes())
+	}
+	if err := o.SetRoutes(map[TaskType]string{TaskTypeDebug: "opus", TaskTypeCode: "haiku"}); err == nil {
+		t.Error("SetRoutes() to an unknown agent should fail")
+	}
+	if got := o.router.Route(TaskTypeCode); got != "sonnet" {
+		t.Errorf("a failed SetRoutes() changed code to %s", got)
+	}
+}
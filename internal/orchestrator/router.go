@@ -1,12 +1,16 @@
 package orchestrator
 
+import "sync"
+
 type Router struct {
-	routes map[TaskType]string
+	mu        sync.RWMutex
+	routes    map[TaskType]string
+	fallbacks map[string][]string // agent name -> agents to try when it fails
 }
 
 func NewRouter() *Router {
-	// All requests go to Claude (sonnet) as the orchestrator
-	// Claude maintains conversation context and decides when to delegate
+	// All requests go to Claude (sonnet), which maintains conversation context.
+	// Delegation to several agents is done by ProcessPipelineAsync.
 	return &Router{
 		routes: map[TaskType]string{
 			TaskTypeUI:      "sonnet",
@@ -15,10 +19,13 @@ func NewRouter() *Router {
 			TaskTypeCode:    "sonnet",
 			TaskTypeGeneral: "sonnet",
 		},
+		fallbacks: make(map[string][]string),
 	}
 }
 
 func (r *Router) Route(taskType TaskType) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	if agent, exists := r.routes[taskType]; exists {
 		return agent
 	}
@@ -26,13 +33,56 @@ func (r *Router) Route(taskType TaskType) string {
 }
 
 func (r *Router) SetRoute(taskType TaskType, agentName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.routes[taskType] = agentName
 }
 
 func (r *Router) GetRoutes() map[TaskType]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	result := make(map[TaskType]string)
 	for k, v := range r.routes {
 		result[k] = v
 	}
 	return result
 }
+
+// SetFallbacks sets the agents tried in order when agentName fails
+func (r *Router) SetFallbacks(agentName string, fallbacks []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(fallbacks) == 0 {
+		delete(r.fallbacks, agentName)
+		return
+	}
+	r.fallbacks[agentName] = append([]string(nil), fallbacks...)
+}
+
+// ReplaceFallbacks replaces the fallbacks of every agent
+func (r *Router) ReplaceFallbacks(fallbacks map[string][]string) {
+	replaced := make(map[string][]string, len(fallbacks))
+	for name, chain := range fallbacks {
+		if len(chain) > 0 {
+			replaced[name] = append([]string(nil), chain...)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallbacks = replaced
+}
+
+// Chain returns agentName followed by its fallbacks, each agent once
+func (r *Router) Chain(agentName string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	chain := []string{agentName}
+	seen := map[string]bool{agentName: true}
+	for _, name := range r.fallbacks[agentName] {
+		if !seen[name] {
+			seen[name] = true
+			chain = append(chain, name)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,201 @@
+package orchestrator
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// maxTaskHistory is how many top-level tasks the orchestrator remembers
+const maxTaskHistory = 200
+
+// Task statuses
+const (
+	TaskProcessing     = "processing"
+	TaskCompleted      = "completed"
+	TaskError          = "error"
+	TaskCancelled      = "cancelled"
+	TaskBudgetExceeded = "budget_exceeded"
+)
+
+type TaskType string
+
+const (
+	TaskTypeGeneral TaskType = "general"
+	TaskTypeUI      TaskType = "ui"
+	TaskTypeDesign  TaskType = "design"
+	TaskTypeDebug   TaskType = "debug"
+	TaskTypeCode    TaskType = "code"
+)
+
+// TaskTypes lists every task type
+var TaskTypes = []TaskType{TaskTypeGeneral, TaskTypeUI, TaskTypeDesign, TaskTypeDebug, TaskTypeCode}
+
+// Task is a request handled by the orchestrator. Pipeline runs split a task
+// into subtasks, one per step.
+type Task struct {
+	ID          string
+	ParentID    string
+	Content     string
+	Type        TaskType
+	AssignedTo  string   // agent that answered, or is answering
+	Model       string   // model of AssignedTo
+	Failed      []string // agents that failed before AssignedTo took over
+	Status      string
+	Result      string
+	Usage       agents.Usage
+	Stage       string  // pipeline stage of a subtask
+	Subtasks    []*Task // pipeline steps, in order
+	CreatedAt   time.Time
+	CompletedAt time.Time // zero while the task is processing
+}
+
+// Duration returns how long the task ran, or has been running
+func (t *Task) Duration() time.Duration {
+	if t.CompletedAt.IsZero() {
+		return time.Since(t.CreatedAt)
+	}
+	return t.CompletedAt.Sub(t.CreatedAt)
+}
+
+// clone returns a deep copy of the task and its subtasks
+func (t *Task) clone() *Task {
+	c := *t
+	c.Failed = append([]string(nil), t.Failed...)
+	c.Subtasks = make([]*Task, len(t.Subtasks))
+	for i, sub := range t.Subtasks {
+		c.Subtasks[i] = sub.clone()
+	}
+	return &c
+}
+
+// find returns the task or subtask with the given ID
+func (t *Task) find(id string) *Task {
+	if t.ID == id {
+		return t
+	}
+	for _, sub := range t.Subtasks {
+		if found := sub.find(id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// TaskFilter selects tasks from the history. Zero fields match all tasks.
+type TaskFilter struct {
+	Status string
+	Agent  string
+	Since  time.Time
+	Limit  int // keep only the most recent tasks
+}
+
+func (f TaskFilter) matches(t *Task) bool {
+	return (f.Status == "" || t.Status == f.Status) &&
+		(f.Agent == "" || t.AssignedTo == f.Agent) &&
+		(f.Since.IsZero() || !t.CreatedAt.Before(f.Since))
+}
+
+var taskSeq atomic.Uint64
+
+// newTaskID returns an ID that is unique within the process
+func newTaskID() string {
+	return fmt.Sprintf("task-%d-%d", time.Now().Unix(), taskSeq.Add(1))
+}
+
+// startTask creates a task and records it. Top-level tasks go into the
+// history and become the current task; subtasks are added to parent.
+func (o *Orchestrator) startTask(parent *Task, input, agentName string) *Task {
+	agent, exists := o.GetAgent(agentName)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	task := &Task{
+		ID:         newTaskID(),
+		Content:    input,
+		Type:       o.analyzeTask(input),
+		AssignedTo: agentName,
+		Status:     TaskProcessing,
+		CreatedAt:  time.Now(),
+	}
+	if exists {
+		task.Model = agent.Model()
+	}
+
+	if parent != nil {
+		task.ID = fmt.Sprintf("%s.%d", parent.ID, len(parent.Subtasks)+1)
+		task.ParentID = parent.ID
+		task.Type = parent.Type
+		parent.Subtasks = append(parent.Subtasks, task)
+		return task
+	}
+
+	o.tasks = append(o.tasks, task)
+	if len(o.tasks) > maxTaskHistory {
+		o.tasks = o.tasks[len(o.tasks)-maxTaskHistory:]
+	}
+	o.currentTask = task
+	return task
+}
+
+// updateTask changes a task while holding the lock, so readers get a
+// consistent snapshot
+func (o *Orchestrator) updateTask(task *Task, update func(t *Task)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	update(task)
+}
+
+// finishTask records the outcome of a task. An empty result keeps the
+// result collected so far.
+func (o *Orchestrator) finishTask(task *Task, status, result string) {
+	o.updateTask(task, func(t *Task) {
+		t.Status = status
+		if result != "" {
+			t.Result = result
+		}
+		t.CompletedAt = time.Now()
+	})
+}
+
+// GetCurrentTask returns a snapshot of the most recent top-level task
+func (o *Orchestrator) GetCurrentTask() *Task {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.currentTask == nil {
+		return nil
+	}
+	return o.currentTask.clone()
+}
+
+// Tasks returns snapshots of the top-level tasks matching filter, oldest first
+func (o *Orchestrator) Tasks(filter TaskFilter) []*Task {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var tasks []*Task
+	for _, t := range o.tasks {
+		if filter.matches(t) {
+			tasks = append(tasks, t.clone())
+		}
+	}
+	if filter.Limit > 0 && len(tasks) > filter.Limit {
+		tasks = tasks[len(tasks)-filter.Limit:]
+	}
+	return tasks
+}
+
+// Task returns a snapshot of the task or subtask with the given ID
+func (o *Orchestrator) Task(id string) (*Task, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, t := range o.tasks {
+		if found := t.find(id); found != nil {
+			return found.clone(), true
+		}
+	}
+	return nil, false
+}
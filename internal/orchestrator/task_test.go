@@ -0,0 +1,92 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+func TestTaskIDsAreUnique(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &usageAgent{}
+
+	first, _ := o.Process(context.Background(), "fix a")
+	second, _ := o.Process(context.Background(), "fix b")
+	if first.ID == second.ID {
+		t.Errorf("inputs of the same length got the same ID %q", first.ID)
+	}
+}
+
+func TestProcessRecordsTaskDetails(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &usageAgent{}
+
+	task, err := o.Process(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if task.AssignedTo != "sonnet" || task.Model != "test-model" {
+		t.Errorf("task agent = %q, model = %q", task.AssignedTo, task.Model)
+	}
+	if task.CreatedAt.IsZero() || task.CompletedAt.Before(task.CreatedAt) {
+		t.Errorf("task times = %v - %v", task.CreatedAt, task.CompletedAt)
+	}
+	if task.Status != TaskCompleted {
+		t.Errorf("task.Status = %q", task.Status)
+	}
+}
+
+func TestTasksFilter(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &usageAgent{}
+
+	before := time.Now()
+	o.Process(context.Background(), "one")
+	o.Process(context.Background(), "two")
+	o.Process(context.Background(), "three")
+	o.finishTask(o.startTask(nil, "four", "sonnet"), TaskError, "failed")
+
+	if got := o.Tasks(TaskFilter{}); len(got) != 4 || got[0].Content != "one" {
+		t.Fatalf("Tasks() = %d tasks, want 4 oldest first", len(got))
+	}
+	if got := o.Tasks(TaskFilter{Status: TaskCompleted, Limit: 2}); len(got) != 2 || got[0].Content != "two" || got[1].Content != "three" {
+		t.Errorf("Tasks(completed, limit 2) = %+v", got)
+	}
+	if got := o.Tasks(TaskFilter{Agent: "opus"}); len(got) != 0 {
+		t.Errorf("Tasks(agent opus) = %d tasks, want none", len(got))
+	}
+	if got := o.Tasks(TaskFilter{Since: before.Add(time.Hour)}); len(got) != 0 {
+		t.Errorf("Tasks(since future) = %d tasks, want none", len(got))
+	}
+}
+
+func TestTaskSnapshots(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	o.agents["sonnet"] = &usageAgent{}
+
+	task, _ := o.Process(context.Background(), "hello")
+	task.Result = "changed"
+
+	stored, ok := o.Task(task.ID)
+	if !ok {
+		t.Fatalf("Task(%q) not found", task.ID)
+	}
+	if stored.Result == "changed" {
+		t.Error("changing a returned task should not change the history")
+	}
+	if _, ok := o.Task("task-missing"); ok {
+		t.Error("Task() found a missing ID")
+	}
+}
+
+func TestTaskHistoryIsCapped(t *testing.T) {
+	o := New(map[string]agents.AgentConfig{})
+	for i := 0; i < maxTaskHistory+5; i++ {
+		o.startTask(nil, "x", "sonnet")
+	}
+	if got := len(o.Tasks(TaskFilter{})); got != maxTaskHistory {
+		t.Errorf("history has %d tasks, want %d", got, maxTaskHistory)
+	}
+}
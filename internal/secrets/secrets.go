@@ -0,0 +1,238 @@
+// Package secrets keeps API keys out of config files and log output
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Secret is a string that is not shown when printed and refuses to be
+// marshalled to YAML, so configs holding one can be logged without leaking
+// it and are not saved with it by mistake. Convert it with string() where
+// the value is needed.
+type Secret string
+
+// Redacted is printed instead of a secret's value
+const Redacted = "[redacted]"
+
+// String hides the value from %v, %s and %q
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// GoString hides the value from %#v
+func (s Secret) GoString() string {
+	return fmt.Sprintf("secrets.Secret(%q)", s.String())
+}
+
+// IsZero reports whether the secret is empty, for yaml omitempty fields
+func (s Secret) IsZero() bool { return s == "" }
+
+// ErrMarshal is returned when a non-empty secret would be written to YAML
+var ErrMarshal = errors.New("refusing to write a secret to YAML")
+
+// MarshalYAML writes empty secrets and returns ErrMarshal for others;
+// clear them before marshalling
+func (s Secret) MarshalYAML() (any, error) {
+	if s != "" {
+		return nil, ErrMarshal
+	}
+	return "", nil
+}
+
+// MarshalJSON writes the redacted form
+func (s Secret) MarshalJSON() ([]byte, error) { return json.Marshal(s.String()) }
+
+// ErrNotFound is returned for names that are not in the store
+var ErrNotFound = errors.New("secret not found")
+
+// Store keeps named secrets in a JSON file, each encrypted with AES-256-GCM.
+// The key is kept in a separate file only the user can read, so the
+// secrets file can be backed up or shared by mistake without exposing
+// them; anyone who can read both files can decrypt them.
+type Store struct {
+	path    string
+	keyPath string
+}
+
+// storeFile is the JSON layout of the secrets file
+type storeFile struct {
+	Version int               `json:"version"`
+	Secrets map[string]string `json:"secrets"` // base64 of nonce + ciphertext
+}
+
+// NewStore returns the store kept in dir as secrets.json and secrets.key
+func NewStore(dir string) *Store {
+	return &Store{
+		path:    filepath.Join(dir, "secrets.json"),
+		keyPath: filepath.Join(dir, "secrets.key"),
+	}
+}
+
+// DefaultStore returns the store in ~/.ppopcode
+func DefaultStore() *Store {
+	home, _ := os.UserHomeDir()
+	return NewStore(filepath.Join(home, ".ppopcode"))
+}
+
+// Path returns the secrets file
+func (s *Store) Path() string { return s.path }
+
+// Get decrypts the secret called name
+func (s *Store) Get(name string) (Secret, error) {
+	file, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	sealed, ok := file.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	key, err := s.key(false)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("secret %s is corrupt: %w", name, err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("secret %s is corrupt", name)
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt secret %s (was %s replaced?): %w", name, s.keyPath, err)
+	}
+	return Secret(plain), nil
+}
+
+// Set encrypts value and stores it as name, creating the key on first use
+func (s *Store) Set(name string, value Secret) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("secret name is empty")
+	}
+	file, err := s.load()
+	if err != nil {
+		return err
+	}
+	key, err := s.key(true)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// The name is authenticated too, so entries cannot be swapped
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(name))
+	file.Secrets[name] = base64.StdEncoding.EncodeToString(sealed)
+	return s.save(file)
+}
+
+// Delete removes the secret called name
+func (s *Store) Delete(name string) error {
+	file, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := file.Secrets[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	delete(file.Secrets, name)
+	return s.save(file)
+}
+
+// Names lists the stored secrets in order
+func (s *Store) Names() ([]string, error) {
+	file, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(file.Secrets))
+	for name := range file.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Store) load() (*storeFile, error) {
+	file := &storeFile{Version: 1, Secrets: map[string]string{}}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	if file.Secrets == nil {
+		file.Secrets = map[string]string{}
+	}
+	return file, nil
+}
+
+func (s *Store) save(file *storeFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+// key reads the encryption key, creating it if create is set
+func (s *Store) key(create bool) ([]byte, error) {
+	data, err := os.ReadFile(s.keyPath)
+	if err == nil {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s is not a valid key", s.keyPath)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, fmt.Errorf("cannot read the secrets key: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.keyPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.keyPath, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,124 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSecretIsRedacted(t *testing.T) {
+	config := struct {
+		Name   string `yaml:"name"`
+		APIKey Secret `yaml:"api_key,omitempty"`
+		Token  Secret `yaml:"token"`
+	}{"sonnet", "sk-ant-123", "tok-456"}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q"} {
+		if out := fmt.Sprintf(format, config); strings.Contains(out, "sk-ant") || strings.Contains(out, "tok-") {
+			t.Errorf("Sprintf(%s) = %s, want the secrets redacted", format, out)
+		}
+	}
+	if out := fmt.Sprint(config.APIKey); out != Redacted {
+		t.Errorf("Sprint() = %q, want %q", out, Redacted)
+	}
+	if out := fmt.Sprint(Secret("")); out != "" {
+		t.Errorf("Sprint() of an empty secret = %q", out)
+	}
+
+	if _, err := yaml.Marshal(config); !errors.Is(err, ErrMarshal) {
+		t.Errorf("yaml.Marshal() error = %v, want ErrMarshal", err)
+	}
+	config.Token = ""
+	config.APIKey = ""
+	data, err := yaml.Marshal(config)
+	if err != nil || strings.Contains(string(data), "api_key") || !strings.Contains(string(data), "token: \"\"") {
+		t.Errorf("yaml.Marshal() of empty secrets = %s, %v", data, err)
+	}
+	config.APIKey = "sk-ant-123"
+	data, _ = json.Marshal(config)
+	if strings.Contains(string(data), "sk-ant") {
+		t.Errorf("json.Marshal() = %s, want no secrets", data)
+	}
+
+	// Decoding still reads the value
+	if err := yaml.Unmarshal([]byte("api_key: sk-ant-789\n"), &config); err != nil || string(config.APIKey) != "sk-ant-789" {
+		t.Errorf("yaml.Unmarshal() = %q, %v", string(config.APIKey), err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir)
+
+	if _, err := store.Get("anthropic"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() on an empty store = %v, want ErrNotFound", err)
+	}
+	if err := store.Set("anthropic", "sk-ant-123"); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	if err := store.Set("work", "sk-ant-456"); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+
+	// A new store on the same files reads the secrets back
+	store = NewStore(dir)
+	if got, err := store.Get("anthropic"); err != nil || string(got) != "sk-ant-123" {
+		t.Errorf("Get() = %q, %v", string(got), err)
+	}
+	if names, _ := store.Names(); strings.Join(names, ",") != "anthropic,work" {
+		t.Errorf("Names() = %v", names)
+	}
+
+	data, _ := os.ReadFile(store.Path())
+	if strings.Contains(string(data), "sk-ant") {
+		t.Errorf("secrets file holds a secret in clear text:\n%s", data)
+	}
+	for _, name := range []string{"secrets.json", "secrets.key"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("%s: mode = %v, %v, want 0600", name, info.Mode().Perm(), err)
+		}
+	}
+
+	if err := store.Delete("work"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := store.Get("work"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewStore(dir).Set("anthropic", "sk-ant-123"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries are bound to their names
+	var file storeFile
+	data, _ := os.ReadFile(filepath.Join(dir, "secrets.json"))
+	json.Unmarshal(data, &file)
+	file.Secrets["other"] = file.Secrets["anthropic"]
+	data, _ = json.Marshal(file)
+	os.WriteFile(filepath.Join(dir, "secrets.json"), data, 0600)
+	if _, err := NewStore(dir).Get("other"); err == nil {
+		t.Error("Get() of a copied entry should fail")
+	}
+
+	// A new key cannot decrypt the old secrets
+	os.Remove(filepath.Join(dir, "secrets.key"))
+	if _, err := NewStore(dir).Get("anthropic"); err == nil {
+		t.Error("Get() without the key should fail")
+	}
+	if err := NewStore(dir).Set("new", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore(dir).Get("anthropic"); err == nil {
+		t.Error("Get() with a different key should fail")
+	}
+}
@@ -6,21 +6,27 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
 )
 
 type Message struct {
-	Role      string    `json:"role"`
-	Content   string    `json:"content"`
-	Model     string    `json:"model,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
+	Role        string             `json:"role"`
+	Content     string             `json:"content"`
+	Model       string             `json:"model,omitempty"`
+	Interrupted bool               `json:"interrupted,omitempty"`
+	Usage       *agents.Usage      `json:"usage,omitempty"`
+	Tools       []agents.ToolEvent `json:"tools,omitempty"` // tool calls made while answering
+	Timestamp   time.Time          `json:"timestamp"`
 }
 
 type Session struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Messages  []Message `json:"messages"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Messages  []Message    `json:"messages"`
+	Usage     agents.Usage `json:"usage"` // total usage of all messages
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
 }
 
 type Manager struct {
@@ -56,13 +62,24 @@ func (m *Manager) Current() *Session {
 }
 
 func (m *Manager) AddMessage(role, content, model string) {
-	session := m.Current()
-	session.Messages = append(session.Messages, Message{
-		Role:      role,
-		Content:   content,
-		Model:     model,
-		Timestamp: time.Now(),
+	m.AppendMessage(Message{
+		Role:    role,
+		Content: content,
+		Model:   model,
 	})
+}
+
+// AppendMessage adds a fully populated message to the current session
+// and adds its usage to the session total
+func (m *Manager) AppendMessage(msg Message) {
+	session := m.Current()
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	if msg.Usage != nil {
+		session.Usage.Add(*msg.Usage)
+	}
+	session.Messages = append(session.Messages, msg)
 	session.UpdatedAt = time.Now()
 }
 
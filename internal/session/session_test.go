@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
 )
 
 func TestNewManager(t *testing.T) {
@@ -224,3 +226,50 @@ func TestClear(t *testing.T) {
 		t.Error("Clear() should set current to nil")
 	}
 }
+
+func TestAppendMessage(t *testing.T) {
+	m := NewManager("/tmp/test", 100)
+
+	m.AppendMessage(Message{Role: "assistant", Content: "partial", Interrupted: true})
+
+	session := m.Current()
+	if len(session.Messages) != 1 {
+		t.Fatalf("session should have 1 message, got %d", len(session.Messages))
+	}
+	if !session.Messages[0].Interrupted {
+		t.Error("message should be marked interrupted")
+	}
+	if session.Messages[0].Timestamp.IsZero() {
+		t.Error("message timestamp should be set")
+	}
+}
+
+func TestSessionUsage(t *testing.T) {
+	tmpDir := t.TempDir()
+	m := NewManager(tmpDir, 100)
+	session := m.NewSession("usage")
+
+	m.AddMessage("user", "hi", "")
+	m.AppendMessage(Message{Role: "assistant", Content: "a", Usage: &agents.Usage{InputTokens: 10, OutputTokens: 5, CostUSD: 0.01, Requests: 1}})
+	m.AppendMessage(Message{Role: "assistant", Content: "b", Usage: &agents.Usage{InputTokens: 20, OutputTokens: 5, CostUSD: 0.02, Requests: 1}})
+
+	want := agents.Usage{InputTokens: 30, OutputTokens: 10, CostUSD: 0.03, Requests: 2}
+	got := m.Current().Usage
+	if got.InputTokens != want.InputTokens || got.OutputTokens != want.OutputTokens || got.Requests != want.Requests {
+		t.Errorf("session usage = %+v, want %+v", got, want)
+	}
+
+	if err := m.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	loaded, err := NewManager(tmpDir, 100).Load(session.ID)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if loaded.Usage.Total() != 40 {
+		t.Errorf("loaded usage total = %d, want 40", loaded.Usage.Total())
+	}
+	if loaded.Messages[1].Usage == nil || loaded.Messages[1].Usage.InputTokens != 10 {
+		t.Errorf("message usage not persisted: %+v", loaded.Messages[1].Usage)
+	}
+}
@@ -0,0 +1,381 @@
+// Package snapshot records the working tree before agent edits so they
+// can be undone
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RefPrefix is where snapshots are kept, one ref per edit
+const RefPrefix = "refs/ppopcode/snapshots/"
+
+// DefaultKeep is how many snapshots are kept when no limit is set
+const DefaultKeep = 20
+
+// ErrNotRepository is returned by Open outside a git repository
+var ErrNotRepository = errors.New("not a git repository")
+
+// ChangedError lists the files an undo would overwrite because they
+// changed since the edit
+type ChangedError struct {
+	Files []string
+}
+
+func (e *ChangedError) Error() string {
+	return strings.Join(e.Files, ", ") + " changed since the edit"
+}
+
+// Snapshot is the working tree before an edit and, once finished, after
+// it. The edit's changes are kept under RefPrefix+ID as a commit of the
+// tree after the edit whose parent is the tree before it.
+type Snapshot struct {
+	ID    string
+	Label string // what made the edit, e.g. the prompt
+	Time  time.Time
+	Files []string // paths changed by the edit, relative to the repository root
+
+	before string // commits
+	after  string
+}
+
+// Changed reports whether the edit changed any files
+func (s *Snapshot) Changed() bool {
+	return len(s.Files) > 0
+}
+
+// Summary lists the changed files, shortened to a few
+func (s *Snapshot) Summary() string {
+	const shown = 5
+	if len(s.Files) <= shown {
+		return strings.Join(s.Files, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(s.Files[:shown], ", "), len(s.Files)-shown)
+}
+
+// History holds the snapshots of a repository, oldest first. It is safe
+// for concurrent use, but edits that overlap in time share their changes.
+type History struct {
+	root string // top level of the working tree
+	keep int
+
+	mu        sync.Mutex
+	snapshots []*Snapshot
+}
+
+// Open returns the history of the repository containing workDir with the
+// snapshots left by earlier runs. keep limits how many are kept; 0 means
+// DefaultKeep.
+func Open(workDir string, keep int) (*History, error) {
+	out, err := git(workDir, nil, "", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, ErrNotRepository
+	}
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	h := &History{root: strings.TrimSpace(out), keep: keep}
+
+	out, err = h.git("", "", "for-each-ref", "--format=%(refname)%00%(objectname)%00%(committerdate:unix)%00%(contents:subject)", RefPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.SplitN(line, "\x00", 4)
+		if len(fields) < 4 {
+			continue
+		}
+		unix, _ := strconv.ParseInt(fields[2], 10, 64)
+		s := &Snapshot{
+			ID:     strings.TrimPrefix(fields[0], RefPrefix),
+			Label:  fields[3],
+			Time:   time.Unix(unix, 0),
+			before: fields[1] + "^",
+			after:  fields[1],
+		}
+		if s.Files, err = h.changedFiles(s.before, s.after); err != nil {
+			return nil, err
+		}
+		h.snapshots = append(h.snapshots, s)
+	}
+	sort.Slice(h.snapshots, func(i, j int) bool { return h.snapshots[i].ID < h.snapshots[j].ID })
+	return h, nil
+}
+
+// Begin records the working tree before an edit. Files ignored by git
+// are not recorded, and only the first line of label is kept.
+func (h *History) Begin(label string) (*Snapshot, error) {
+	label, _, _ = strings.Cut(label, "\n")
+	now := time.Now()
+	s := &Snapshot{ID: fmt.Sprintf("%019d", now.UnixNano()), Label: label, Time: now}
+
+	tree, err := h.writeTree()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"commit-tree", tree, "-m", "before: " + label}
+	if head, err := h.git("", "", "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		args = append(args, "-p", strings.TrimSpace(head))
+	}
+	if s.before, err = h.commit(args...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Finish records the working tree after the edit begun with s. If any
+// files changed it adds s to the history, dropping the oldest snapshots
+// past the limit.
+func (h *History) Finish(s *Snapshot) error {
+	tree, err := h.writeTree()
+	if err != nil {
+		return err
+	}
+	after, err := h.commit("commit-tree", tree, "-p", s.before, "-m", s.Label)
+	if err != nil {
+		return err
+	}
+	if s.Files, err = h.changedFiles(s.before, after); err != nil || !s.Changed() {
+		return err
+	}
+	if _, err := h.git("", "", "update-ref", RefPrefix+s.ID, after); err != nil {
+		return err
+	}
+	s.after = after
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.snapshots = append(h.snapshots, s)
+	for len(h.snapshots) > h.keep {
+		if _, err := h.git("", "", "update-ref", "-d", RefPrefix+h.snapshots[0].ID); err != nil {
+			return err
+		}
+		h.snapshots = h.snapshots[1:]
+	}
+	return nil
+}
+
+// List returns the snapshots, newest first
+func (h *History) List() []*Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := make([]*Snapshot, len(h.snapshots))
+	for i, s := range h.snapshots {
+		list[len(list)-1-i] = s
+	}
+	return list
+}
+
+// Get returns the snapshot with the given ID, or nil
+func (h *History) Get(id string) *Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.snapshots {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// Undo puts the files changed by the edit of s back as they were before
+// it and drops s from the history. Unless force is set, nothing is
+// restored if any of those files changed since the edit; the error is
+// then a *ChangedError. Commits made by the edit are not undone.
+func (h *History) Undo(s *Snapshot, force bool) error {
+	if h.Get(s.ID) == nil {
+		return fmt.Errorf("%s was already undone", s.Label)
+	}
+	if !force {
+		current, err := h.writeTree()
+		if err != nil {
+			return err
+		}
+		changed, err := h.changedFiles(s.after, current, s.Files...)
+		if err != nil {
+			return err
+		}
+		if len(changed) > 0 {
+			return &ChangedError{Files: changed}
+		}
+	}
+
+	// Check out the files that existed before the edit through a scratch
+	// index, leaving the real one alone, and remove the others
+	out, err := h.git("", "", append([]string{"ls-tree", "-r", "-z", "--name-only", s.before, "--"}, s.Files...)...)
+	if err != nil {
+		return err
+	}
+	existed := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	index, cleanup, err := h.scratchIndex(false)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if _, err := h.git(index, "", "read-tree", s.before); err != nil {
+		return err
+	}
+	if out != "" {
+		if _, err := h.git(index, out, "checkout-index", "-f", "-z", "--stdin"); err != nil {
+			return err
+		}
+	}
+	for _, file := range s.Files {
+		if !slices.Contains(existed, file) {
+			if err := h.remove(file); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := h.git("", "", "update-ref", "-d", RefPrefix+s.ID); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, other := range h.snapshots {
+		if other.ID == s.ID {
+			h.snapshots = append(h.snapshots[:i], h.snapshots[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// writeTree writes the working tree to the object store and returns its
+// tree. The real index is copied so unchanged files need not be hashed.
+func (h *History) writeTree() (string, error) {
+	index, cleanup, err := h.scratchIndex(true)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	if _, err := h.git(index, "", "add", "--all", "--", "."); err != nil {
+		return "", err
+	}
+	out, err := h.git(index, "", "write-tree")
+	return strings.TrimSpace(out), err
+}
+
+// scratchIndex returns the path of a temporary index file, a copy of the
+// real one if fromReal is set
+func (h *History) scratchIndex(fromReal bool) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "ppopcode-snapshot-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	index := filepath.Join(dir, "index")
+	if !fromReal {
+		return index, cleanup, nil
+	}
+
+	out, err := h.git("", "", "rev-parse", "--git-path", "index")
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	real := strings.TrimSpace(out)
+	if !filepath.IsAbs(real) {
+		real = filepath.Join(h.root, real)
+	}
+	if err := copyFile(real, index); err != nil && !errors.Is(err, os.ErrNotExist) {
+		cleanup()
+		return "", nil, err
+	}
+	return index, cleanup, nil
+}
+
+// commit runs git commit-tree and returns the new commit
+func (h *History) commit(args ...string) (string, error) {
+	out, err := h.git("", "", args...)
+	return strings.TrimSpace(out), err
+}
+
+// changedFiles returns the paths that differ between two commits or
+// trees, limited to paths if any are given
+func (h *History) changedFiles(from, to string, paths ...string) ([]string, error) {
+	args := append([]string{"diff-tree", "-r", "-z", "--name-only", "--no-renames", from, to, "--"}, paths...)
+	out, err := h.git("", "", args...)
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(out, "\x00"), "\x00"), nil
+}
+
+// remove deletes a file created by an edit and the directories it leaves
+// empty
+func (h *History) remove(file string) error {
+	path := filepath.Join(h.root, filepath.FromSlash(file))
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != h.root && strings.HasPrefix(dir, h.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// git runs a git command at the top of the working tree, with index as
+// the index file if set and stdin as its input. Snapshots are committed
+// as ppopcode, so they work without a configured identity. Paths are
+// taken literally, so a file named *.go matches only itself.
+func (h *History) git(index, stdin string, args ...string) (string, error) {
+	var env []string
+	if index != "" {
+		env = append(env, "GIT_INDEX_FILE="+index)
+	}
+	return git(h.root, env, stdin, args...)
+}
+
+func git(dir string, env []string, stdin string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, "GIT_LITERAL_PATHSPECS=1",
+		"GIT_AUTHOR_NAME=ppopcode", "GIT_AUTHOR_EMAIL=ppopcode@localhost",
+		"GIT_COMMITTER_NAME=ppopcode", "GIT_COMMITTER_EMAIL=ppopcode@localhost")
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return string(out), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,257 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newRepo returns a repository with a committed file, an untracked file
+// and an ignored one
+func newRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":    "package main\n",
+		"keep.go":    "package main\n",
+		".gitignore": "*.log\n",
+	})
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	writeFiles(t, dir, map[string]string{"notes.txt": "draft\n", "run.log": "old\n"})
+	return dir
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// edit begins a snapshot, changes the tree and finishes it
+func edit(t *testing.T, h *History, dir, label string) *Snapshot {
+	t.Helper()
+	s, err := h.Begin(label)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	writeFiles(t, dir, map[string]string{
+		"main.go":      "package main\n\nfunc main() {}\n",
+		"notes.txt":    "rewritten\n",
+		"pkg/new/a.go": "package new\n",
+		"run.log":      "new\n",
+	})
+	if err := os.Remove(filepath.Join(dir, "keep.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Finish(s); err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+	return s
+}
+
+func TestOpenOutsideRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	if _, err := Open(t.TempDir(), 0); !errors.Is(err, ErrNotRepository) {
+		t.Errorf("Open() error = %v, want ErrNotRepository", err)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	dir := newRepo(t)
+	h, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	s := edit(t, h, dir, "add a package")
+	want := []string{"keep.go", "main.go", "notes.txt", "pkg/new/a.go"}
+	if !slices.Equal(s.Files, want) {
+		t.Errorf("Files = %v, want %v (ignored files left out)", s.Files, want)
+	}
+	if got := h.List(); len(got) != 1 || got[0] != s {
+		t.Fatalf("List() = %v, want the snapshot", got)
+	}
+	status := exec.Command("git", "status", "--porcelain")
+	status.Dir = dir
+	if out, err := status.Output(); err != nil || string(out) != " D keep.go\n M main.go\n?? notes.txt\n?? pkg/\n" {
+		t.Errorf("git status = %q, %v; want the index left alone", out, err)
+	}
+
+	if err := h.Undo(s, false); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	for name, content := range map[string]string{
+		"main.go":   "package main\n",
+		"keep.go":   "package main\n",
+		"notes.txt": "draft\n",
+		"run.log":   "new\n",
+	} {
+		if got := readFile(t, filepath.Join(dir, name)); got != content {
+			t.Errorf("%s = %q after Undo, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pkg")); !os.IsNotExist(err) {
+		t.Errorf("pkg should be removed with the file the edit created, stat error = %v", err)
+	}
+	if len(h.List()) != 0 {
+		t.Error("an undone snapshot should leave the history")
+	}
+	if err := h.Undo(s, false); err == nil {
+		t.Error("a second Undo should fail")
+	}
+}
+
+func TestUndoChangedSinceEdit(t *testing.T) {
+	dir := newRepo(t)
+	h, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	s := edit(t, h, dir, "edit")
+	writeFiles(t, dir, map[string]string{"main.go": "package main // mine\n"})
+
+	err = h.Undo(s, false)
+	var changed *ChangedError
+	if !errors.As(err, &changed) || !slices.Equal(changed.Files, []string{"main.go"}) {
+		t.Fatalf("Undo() error = %v, want main.go changed", err)
+	}
+	if got := readFile(t, filepath.Join(dir, "notes.txt")); got != "rewritten\n" {
+		t.Errorf("notes.txt = %q, want nothing restored", got)
+	}
+
+	if err := h.Undo(s, true); err != nil {
+		t.Fatalf("Undo(force) error = %v", err)
+	}
+	if got := readFile(t, filepath.Join(dir, "main.go")); got != "package main\n" {
+		t.Errorf("main.go = %q, want it restored", got)
+	}
+}
+
+func TestFinishWithoutChanges(t *testing.T) {
+	dir := newRepo(t)
+	h, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	s, err := h.Begin("read only")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	writeFiles(t, dir, map[string]string{"debug.log": "ignored\n"})
+	if err := h.Finish(s); err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+	if s.Changed() || len(h.List()) != 0 {
+		t.Errorf("an edit that changed nothing should not be kept, Files = %v", s.Files)
+	}
+}
+
+func TestOpenKeepsEarlierSnapshots(t *testing.T) {
+	dir := newRepo(t)
+	h, err := Open(dir, 2)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for _, content := range []string{"one", "two", "three"} {
+		s, err := h.Begin("write " + content)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		writeFiles(t, dir, map[string]string{"notes.txt": content + "\n"})
+		if err := h.Finish(s); err != nil {
+			t.Fatalf("Finish() error = %v", err)
+		}
+	}
+
+	// Reopened from the refs, with only the two newest kept
+	h, err = Open(dir, 2)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	list := h.List()
+	if len(list) != 2 || list[0].Label != "write three" || list[1].Label != "write two" {
+		var labels []string
+		for _, s := range list {
+			labels = append(labels, s.Label)
+		}
+		t.Fatalf("List() labels = %q, want the two newest", labels)
+	}
+	if !slices.Equal(list[0].Files, []string{"notes.txt"}) {
+		t.Errorf("Files = %v, want notes.txt", list[0].Files)
+	}
+	if err := h.Undo(list[0], false); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if got := readFile(t, filepath.Join(dir, "notes.txt")); got != "two\n" {
+		t.Errorf("notes.txt = %q, want %q", got, "two\n")
+	}
+}
+
+func TestUndoGlobCharacters(t *testing.T) {
+	dir := newRepo(t)
+	writeFiles(t, dir, map[string]string{"a[1].go": "package main\n", "a1.go": "package main\n"})
+	h, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	s, err := h.Begin("glob names")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	writeFiles(t, dir, map[string]string{"a[1].go": "package main // edited\n", "*.txt": "star\n"})
+	if err := h.Finish(s); err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+
+	// Files the edit did not touch, whose names match its paths as globs
+	writeFiles(t, dir, map[string]string{"a1.go": "package main // mine\n", "notes.txt": "mine\n"})
+	if err := h.Undo(s, false); err != nil {
+		t.Fatalf("Undo() error = %v, want only the edit's own files checked", err)
+	}
+	for name, content := range map[string]string{
+		"a[1].go":   "package main\n",
+		"a1.go":     "package main // mine\n",
+		"notes.txt": "mine\n",
+	} {
+		if got := readFile(t, filepath.Join(dir, name)); got != content {
+			t.Errorf("%s = %q after Undo, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "*.txt")); !os.IsNotExist(err) {
+		t.Errorf("*.txt should be removed, stat error = %v", err)
+	}
+}
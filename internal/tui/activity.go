@@ -0,0 +1,84 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ppopcode/ppopcode/internal/agents"
+)
+
+// maxActivitySummary is the width of the collapsed activity line
+const maxActivitySummary = 100
+
+var toolFailedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444"))
+
+// toolActivity is the list of tool calls made while answering a request
+type toolActivity []agents.ToolEvent
+
+// update adds a tool call, or replaces an earlier state of the same call
+func (a toolActivity) update(event *agents.ToolEvent) toolActivity {
+	if event == nil {
+		return a
+	}
+	for i := range a {
+		if a[i].ID == event.ID {
+			a[i] = *event
+			return a
+		}
+	}
+	return append(a, *event)
+}
+
+// render returns the activity as one summary line, or as one line per
+// call when expanded
+func (a toolActivity) render(expanded bool) string {
+	if len(a) == 0 {
+		return ""
+	}
+
+	failed := 0
+	for _, e := range a {
+		if e.Status == agents.ToolFailed {
+			failed++
+		}
+	}
+	count := fmt.Sprintf("%d tool call", len(a))
+	if len(a) > 1 {
+		count += "s"
+	}
+	if failed > 0 {
+		count += fmt.Sprintf(", %d failed", failed)
+	}
+
+	if !expanded {
+		var calls []string
+		for _, e := range a {
+			calls = append(calls, e.String())
+		}
+		line := truncateRunes(fmt.Sprintf("[+] %s: %s", count, strings.Join(calls, ", ")), maxActivitySummary)
+		return mutedStyle.Render(line)
+	}
+
+	lines := []string{mutedStyle.Render("[-] " + count)}
+	for _, e := range a {
+		lines = append(lines, renderToolEvent(e))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderToolEvent renders a single call with its status and result
+func renderToolEvent(e agents.ToolEvent) string {
+	switch e.Status {
+	case agents.ToolFailed:
+		line := "  [!] " + e.String()
+		if e.Result != "" {
+			line += " - " + e.Result
+		}
+		return toolFailedStyle.Render(line)
+	case agents.ToolRunning:
+		return mutedStyle.Render("  [>] " + e.String())
+	default:
+		return mutedStyle.Render("  [*] " + e.String())
+	}
+}
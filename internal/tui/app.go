@@ -3,9 +3,11 @@ package tui
 import (
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ppopcode/ppopcode/internal/agents"
 	"github.com/ppopcode/ppopcode/internal/config"
 	"github.com/ppopcode/ppopcode/internal/orchestrator"
 	"github.com/ppopcode/ppopcode/internal/session"
+	"github.com/ppopcode/ppopcode/internal/snapshot"
 )
 
 type ViewState int
@@ -74,6 +76,13 @@ type App struct {
 	session      *session.Manager
 	config       *config.Config
 	ready        bool // true after first WindowSizeMsg is received
+
+	// Config changes are applied as they are reported, see WatchConfig
+	reloads <-chan config.Reloaded
+	workDir string
+	toast   toast
+
+	snapshots *snapshot.History // working tree snapshots for undo, or nil
 }
 
 func NewApp() *App {
@@ -92,6 +101,7 @@ func NewApp() *App {
 
 func NewAppWithDeps(orch *orchestrator.Orchestrator, sess *session.Manager, cfg *config.Config) *App {
 	chat := NewChatModelWithOrchestrator(orch, sess)
+	chat.SetConfig(cfg)
 	return &App{
 		currentView:  ViewMenu,
 		menu:         NewMenuModel(),
@@ -108,10 +118,25 @@ func NewAppWithDeps(orch *orchestrator.Orchestrator, sess *session.Manager, cfg
 	}
 }
 
+// SetSnapshots snapshots the working tree before chat requests, Cursor
+// edits and workflow prompt nodes so their edits can be undone
+func (a *App) SetSnapshots(h *snapshot.History) {
+	a.snapshots = h
+	a.chat.SetSnapshots(h)
+}
+
+// LoginCheckedMsg is sent when the background Claude login check finishes
+type LoginCheckedMsg struct{}
+
 func (a *App) Init() tea.Cmd {
-	// Return nil - tea.WithAltScreen in main.go handles screen setup
-	// The window size message will be sent automatically
-	return nil
+	// tea.WithAltScreen in main.go handles screen setup and the window size
+	// message is sent automatically. Check the Claude login in the
+	// background so the status bar can show it.
+	checkLogin := func() tea.Msg {
+		<-agents.StartClaudeLoginCheck()
+		return LoginCheckedMsg{}
+	}
+	return tea.Batch(checkLogin, waitForReload(a.reloads))
 }
 
 func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -131,6 +156,21 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return a, nil
 
 	case tea.KeyMsg:
+		// Esc/Ctrl+C cancel an in-flight chat request instead of leaving the view,
+		// and the chat's sub-views handle all keys themselves
+		if a.currentView == ViewChat && (a.chat.HasSubView() || a.chat.IsProcessing() &&
+			(key.Matches(msg, a.keys.Back) || msg.Type == tea.KeyCtrlC)) {
+			newChat, chatCmd := a.chat.Update(msg)
+			a.chat = newChat.(*ChatModel)
+			return a, chatCmd
+		}
+		// Settings pickers and the name input close themselves on Esc
+		if a.currentView == ViewSettings && a.settings.HasSubView() {
+			newSettings, settingsCmd := a.settings.Update(msg)
+			a.settings = newSettings.(*SettingsModel)
+			return a, settingsCmd
+		}
+
 		switch {
 		case key.Matches(msg, a.keys.Quit):
 			if a.currentView == ViewMenu {
@@ -192,6 +232,7 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return a, nil
 		}
 		a.workflowRun = NewWorkflowRunModel(msg.Workflow, a.orchestrator)
+		a.workflowRun.SetSnapshots(a.snapshots)
 		a.workflowRun.SetWorkflowPath(msg.Path)
 		a.workflowRun.SetSize(a.width, a.height-4)
 		a.currentView = ViewWorkflowRun
@@ -205,6 +246,31 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return a, wfRunCmd
 		}
 
+	case ConfigReloadedMsg:
+		return a, tea.Batch(a.applyConfig(msg), waitForReload(a.reloads))
+
+	case toastExpiredMsg:
+		if msg.id == a.toast.id {
+			a.toast.message = ""
+		}
+		return a, nil
+
+	case LoginCheckedMsg:
+		// Nothing to update; the status bar reads the cached result
+		return a, nil
+
+	case CodeBlockResultMsg, CursorPreviewMsg, turnSnapshotMsg, turnChangesMsg:
+		// Cursor edits and snapshots may finish after the user has left the chat
+		newChat, chatCmd := a.chat.Update(msg)
+		a.chat = newChat.(*ChatModel)
+		return a, chatCmd
+
+	case SettingsSavedMsg:
+		// A save may finish after the user has left Settings
+		newSettings, settingsCmd := a.settings.Update(msg)
+		a.settings = newSettings.(*SettingsModel)
+		return a, settingsCmd
+
 	case StreamUpdateMsg:
 		// Forward streaming updates directly to chat
 		if a.currentView == ViewChat {
@@ -289,6 +355,13 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (a *App) View() string {
+	if toast := a.renderToast(); toast != "" {
+		return a.view() + "\n" + toast
+	}
+	return a.view()
+}
+
+func (a *App) view() string {
 	switch a.currentView {
 	case ViewMenu:
 		return a.menu.View()
@@ -0,0 +1,122 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ppopcode/ppopcode/internal/attach"
+)
+
+// fileResolver returns the resolver for the working directory,
+// creating it on first use
+func (m *ChatModel) fileResolver() (*attach.Resolver, error) {
+	if m.resolver != nil {
+		return m.resolver, nil
+	}
+	r, err := attach.NewResolver(".")
+	if err != nil {
+		return nil, err
+	}
+	m.resolver = r
+	return r, nil
+}
+
+// attachFiles resolves patterns and adds the files to the context tray.
+// It returns a summary of what was added and skipped.
+func (m *ChatModel) attachFiles(patterns []string) (string, error) {
+	r, err := m.fileResolver()
+	if err != nil {
+		return "", err
+	}
+
+	var added []string
+	var skipped []string
+	for _, pattern := range patterns {
+		files, skip, err := r.Resolve(pattern)
+		if err != nil {
+			return "", err
+		}
+		skipped = append(skipped, skip...)
+
+		for _, f := range files {
+			if m.hasAttachment(f.Path) {
+				continue
+			}
+			if attach.TotalSize(m.attachments)+f.Size > attach.MaxTotalSize {
+				return "", fmt.Errorf("attachments would exceed %s", attach.FormatSize(attach.MaxTotalSize))
+			}
+			m.attachments = append(m.attachments, f)
+			added = append(added, f.Path)
+		}
+	}
+
+	var b strings.Builder
+	switch len(added) {
+	case 0:
+		b.WriteString("No new files attached.")
+	case 1:
+		b.WriteString(fmt.Sprintf("Attached %s.", added[0]))
+	default:
+		b.WriteString(fmt.Sprintf("Attached %d files.", len(added)))
+	}
+	if len(skipped) > 0 {
+		b.WriteString("\nSkipped: " + strings.Join(skipped, ", "))
+	}
+	return b.String(), nil
+}
+
+func (m *ChatModel) hasAttachment(path string) bool {
+	for _, f := range m.attachments {
+		if f.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// attachMentions attaches the files mentioned as @path in content and
+// returns content with the @ dropped from each resolved mention.
+// Mentions that don't resolve to files are left untouched.
+func (m *ChatModel) attachMentions(content string) string {
+	for _, mention := range attach.ExtractMentions(content) {
+		summary, err := m.attachFiles([]string{mention})
+		if err != nil {
+			m.addSystemMessage(fmt.Sprintf("Could not attach @%s: %v", mention, err))
+			continue
+		}
+		if strings.Contains(summary, "Skipped:") {
+			m.addSystemMessage(summary)
+		}
+		content = strings.ReplaceAll(content, "@"+mention, mention)
+	}
+	return content
+}
+
+// viewContextTray renders the attached files with their sizes
+func (m *ChatModel) viewContextTray() string {
+	if len(m.attachments) == 0 {
+		return ""
+	}
+
+	var items []string
+	for _, f := range m.attachments {
+		items = append(items, fmt.Sprintf("%s (%s)", f.Path, attach.FormatSize(f.Size)))
+	}
+
+	label := fmt.Sprintf("Context [%d, %s]: ", len(m.attachments), attach.FormatSize(attach.TotalSize(m.attachments)))
+	line := label + strings.Join(items, " · ")
+
+	if maxWidth := m.width - 4; maxWidth > 3 && lipgloss.Width(line) > maxWidth {
+		line = truncateRunes(line, maxWidth-3) + "..."
+	}
+	return accentStyle.Render(line)
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
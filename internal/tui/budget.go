@@ -0,0 +1,69 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ppopcode/ppopcode/internal/budget"
+)
+
+// budgetOverride is a request that was stopped by a budget limit and can
+// be sent again while ignoring the limit
+type budgetOverride struct {
+	reason   string
+	prompt   string // full prompt, including attached files
+	pipeline bool   // the request ran through the pipeline
+}
+
+// budgetContext scopes a request to the session budget, ignoring limits
+// if the user chose to override them
+func (m *ChatModel) budgetContext(ctx context.Context) context.Context {
+	ctx = budget.WithScope(ctx, m.budgetScope)
+	if m.overrideBudget {
+		ctx = budget.WithOverride(ctx)
+		m.overrideBudget = false
+	}
+	return ctx
+}
+
+// resetBudgetScope starts counting session spend from spent
+func (m *ChatModel) resetBudgetScope(spent float64) {
+	if m.orchestrator == nil || m.orchestrator.Budget() == nil {
+		return
+	}
+	m.budgetScope = m.orchestrator.Budget().NewScope(budget.ScopeSession)
+	m.budgetScope.Add(spent)
+}
+
+// promptBudgetOverride asks whether to send a stopped request anyway
+func (m *ChatModel) promptBudgetOverride(reason string) {
+	m.budgetPrompt = &budgetOverride{
+		reason:   reason,
+		prompt:   m.pendingPrompt,
+		pipeline: m.pipelineRun,
+	}
+	m.addSystemMessage(fmt.Sprintf("Stopped: %s.\nSend anyway, ignoring the budget? [y/N]", reason))
+	m.input.Blur()
+}
+
+func (m *ChatModel) updateBudgetPrompt(msg tea.KeyMsg) tea.Cmd {
+	p := m.budgetPrompt
+
+	switch msg.String() {
+	case "y", "Y":
+		m.budgetPrompt = nil
+		m.input.Focus()
+		m.addSystemMessage("Budget override: sending once without limits.")
+		m.overrideBudget = true
+		m.pipelineNext = p.pipeline
+		return m.dispatch(p.prompt)
+	case "n", "N", "esc", "enter":
+		m.budgetPrompt = nil
+		m.input.Focus()
+		m.addSystemMessage("Request not sent.")
+		m.viewport.SetContent(m.renderMessages())
+		m.viewport.GotoBottom()
+	}
+	return nil
+}
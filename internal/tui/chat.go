@@ -3,6 +3,7 @@ package tui
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -12,8 +13,13 @@ import (
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/attach"
+	"github.com/ppopcode/ppopcode/internal/budget"
+	"github.com/ppopcode/ppopcode/internal/config"
 	"github.com/ppopcode/ppopcode/internal/orchestrator"
 	"github.com/ppopcode/ppopcode/internal/session"
+	"github.com/ppopcode/ppopcode/internal/snapshot"
 )
 
 type MessageRole string
@@ -25,27 +31,64 @@ const (
 )
 
 type Message struct {
-	Role    MessageRole
-	Content string
-	Model   string
+	Role        MessageRole
+	Content     string
+	Model       string
+	Interrupted bool     // true when the response was cancelled before completion
+	Attachments []string // paths of files sent with a user message
+	Usage       agents.Usage
+	Tools       toolActivity // tool calls made while answering
+
+	rendered      string // cached Markdown rendering of Content
+	renderedWidth int    // width the cached rendering was wrapped to
 }
 
 type ChatModel struct {
-	messages      []Message
-	input         textarea.Model
-	viewport      viewport.Model
-	width         int
-	height        int
-	ready         bool
-	processing    bool
-	streamingText string
-	thinkingText  string
-	currentAgent  string
-	orchestrator  *orchestrator.Orchestrator
-	session       *session.Manager
-	progressChan  <-chan orchestrator.ProgressUpdate // Active progress channel
-	spinner       spinner.Model
-	startTime     time.Time
+	messages       []Message
+	input          textarea.Model
+	viewport       viewport.Model
+	width          int
+	height         int
+	ready          bool
+	processing     bool
+	streamingText  string
+	thinkingText   string
+	currentAgent   string
+	orchestrator   *orchestrator.Orchestrator
+	session        *session.Manager
+	progressChan   <-chan orchestrator.ProgressUpdate // Active progress channel
+	cancel         context.CancelFunc                 // Cancels the in-flight request
+	cancelled      bool                               // true once the in-flight request was cancelled
+	spinner        spinner.Model
+	startTime      time.Time
+	commands       *CommandRegistry
+	suggestions    []string // slash command completions for the current input
+	lastPrompt     string   // last prompt sent, used by /retry
+	markdown       *markdownRenderer
+	codePicker     *codePicker        // non-nil while picking a code block
+	diffReview     *diffReview        // non-nil while reviewing a Cursor edit
+	cursorDryRun   bool               // Cursor edits from the picker are previewed first
+	snapshots      *snapshot.History  // nil takes no snapshots
+	snapshot       *snapshot.Snapshot // working tree before the in-flight request
+	resolver       *attach.Resolver
+	attachments    []attach.File // context tray, sent with the next message
+	lastFiles      []attach.File // files sent with lastPrompt, used by /retry
+	usage          agents.Usage  // total usage of this conversation
+	lastUsage      agents.Usage  // usage of the most recent request
+	pendingPrompt  string        // full prompt of the last dispatched request
+	budgetScope    *budget.Scope // session spend counted against the budget
+	budgetStopped  string        // reason the in-flight request was stopped by the budget
+	budgetPrompt   *budgetOverride
+	overrideBudget bool         // ignore budget limits for the next request
+	tools          toolActivity // tool calls of the in-flight request
+	showTools      bool         // expand tool activity to one line per call
+	planMode       bool         // requests run in read-only plan mode
+	pipelineNext   bool         // send the next request through the pipeline
+	pipelineRun    bool         // the in-flight request runs through the pipeline
+	stage          string       // pipeline stage of the streamed reply
+	personas       []config.Persona
+	persona        *config.Persona // selected persona, added to the system prompt
+	sessionStart   time.Time       // tasks since then are listed by /tasks
 }
 
 func NewChatModel() *ChatModel {
@@ -62,10 +105,12 @@ func NewChatModel() *ChatModel {
 	s.Style = lipgloss.NewStyle().Foreground(accentColor)
 
 	return &ChatModel{
-		messages:   []Message{},
-		input:      ti,
-		processing: false,
-		spinner:    s,
+		messages:     []Message{},
+		input:        ti,
+		processing:   false,
+		spinner:      s,
+		commands:     newDefaultCommandRegistry(),
+		sessionStart: time.Now(),
 	}
 }
 
@@ -82,14 +127,38 @@ func NewChatModelWithOrchestrator(orch *orchestrator.Orchestrator, sess *session
 	s.Spinner = spinner.Dot
 	s.Style = lipgloss.NewStyle().Foreground(accentColor)
 
-	return &ChatModel{
+	m := &ChatModel{
 		messages:     []Message{},
 		input:        ti,
 		processing:   false,
 		orchestrator: orch,
 		session:      sess,
 		spinner:      s,
+		commands:     newDefaultCommandRegistry(),
+		sessionStart: time.Now(),
+	}
+	m.resetBudgetScope(0)
+	return m
+}
+
+// SetConfig registers the custom slash commands defined in config
+func (m *ChatModel) SetConfig(cfg *config.Config) {
+	if cfg == nil {
+		return
 	}
+	m.commands.registerCustomCommands(cfg.Commands)
+	m.cursorDryRun = cfg.Cursor.DryRun
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	personas, err := cfg.LoadPersonas(workDir)
+	if err != nil {
+		m.addSystemMessage(fmt.Sprintf("Failed to load personas: %v", err))
+		return
+	}
+	m.setPersonas(personas)
 }
 
 func (m *ChatModel) SetSize(width, height int) {
@@ -105,6 +174,8 @@ func (m *ChatModel) SetSize(width, height int) {
 	}
 
 	m.viewport = viewport.New(width-4, viewportHeight)
+	// Bubble border and padding take 4 columns inside the viewport
+	m.markdown = newMarkdownRenderer(width - 8)
 	m.viewport.SetContent(m.renderMessages())
 	m.input.SetWidth(width - 4)
 	m.ready = true
@@ -122,8 +193,11 @@ func (m *ChatModel) Init() tea.Cmd {
 type StreamUpdateMsg struct {
 	Content string
 	Agent   string
-	Type    string // "status", "thinking", "output", "error"
+	Stage   string
+	Type    string // "status", "thinking", "output", "error", "tool_use", "tool_result"
 	Done    bool
+	Usage   agents.Usage
+	Tool    *agents.ToolEvent
 }
 
 // tickMsg is sent periodically to update spinner and elapsed time
@@ -150,9 +224,71 @@ func (m *ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 
+	case CodeBlockResultMsg:
+		m.addSystemMessage(msg.Message)
+		m.viewport.SetContent(m.renderMessages())
+		m.viewport.GotoBottom()
+		return m, nil
+
+	case CursorPreviewMsg:
+		m.openDiffReview(msg)
+		return m, nil
+
+	case turnSnapshotMsg:
+		m.snapshot = msg.snap
+		if msg.notice != "" {
+			m.addSystemMessage(msg.notice)
+			m.viewport.SetContent(m.renderMessages())
+			m.viewport.GotoBottom()
+		}
+		m.progressChan = m.startStreaming(msg.prompt)
+		return m, m.waitForUpdate()
+
+	case turnChangesMsg:
+		if msg.notice != "" {
+			m.addSystemMessage(msg.notice)
+			m.viewport.SetContent(m.renderMessages())
+			m.viewport.GotoBottom()
+		}
+		return m, nil
+
 	case tea.KeyMsg:
+		if m.diffReview != nil {
+			return m, m.updateDiffReview(msg)
+		}
+		if m.codePicker != nil {
+			return m, m.updateCodePicker(msg)
+		}
+		if m.budgetPrompt != nil {
+			return m, m.updateBudgetPrompt(msg)
+		}
+
 		switch {
-		case key.Matches(msg, DefaultKeyMap.Back):
+		case msg.Type == tea.KeyCtrlY:
+			if !m.processing {
+				m.openCodePicker()
+			}
+			return m, nil
+
+		case msg.Type == tea.KeyCtrlO:
+			m.showTools = !m.showTools
+			m.viewport.SetContent(m.renderMessages())
+			return m, nil
+
+		case key.Matches(msg, DefaultKeyMap.Back), msg.Type == tea.KeyCtrlC:
+			if m.processing {
+				m.cancelRequest()
+			}
+			return m, nil
+
+		case msg.Type == tea.KeyTab:
+			m.completeCommand()
+			return m, nil
+
+		case msg.Type == tea.KeyShiftTab:
+			m.setPlanMode(!m.planMode)
+			m.viewport.SetContent(m.renderMessages())
+			m.viewport.GotoBottom()
 			return m, nil
 
 		case msg.Type == tea.KeyEnter && !msg.Alt:
@@ -165,40 +301,25 @@ func (m *ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 
-			// Handle /clean command
-			if content == "/clean" || content == "/clear" {
-				m.messages = []Message{}
-				m.streamingText = ""
-				m.thinkingText = ""
-				m.currentAgent = ""
-				m.input.Reset()
-				m.viewport.SetContent(m.renderMessages())
-				return m, nil
-			}
-
-			m.messages = append(m.messages, Message{
-				Role:    RoleUser,
-				Content: content,
-			})
-
 			m.input.Reset()
-			m.streamingText = ""
-			m.thinkingText = ""
-			m.viewport.SetContent(m.renderMessages())
-			m.viewport.GotoBottom()
-
-			m.processing = true
-			m.startTime = time.Now()
+			m.suggestions = nil
 
-			// Start streaming and get the channel
-			progressChan := m.startStreaming(content)
-			m.progressChan = progressChan
+			if strings.HasPrefix(content, "/") {
+				cmd := m.runCommand(content)
+				m.viewport.SetContent(m.renderMessages())
+				m.viewport.GotoBottom()
+				return m, cmd
+			}
 
-			// Return command to listen for first update and start spinner tick
-			return m, tea.Batch(m.waitForUpdate(), tickCmd())
+			return m, m.send(content)
 		}
 
 	case StreamUpdateMsg:
+		// Each pipeline stage gets its own reply
+		if m.pipelineRun && isPipelineStage(msg.Stage) && msg.Stage != m.stage {
+			m.appendReply(agents.Usage{}, false)
+			m.stage = msg.Stage
+		}
 		if msg.Agent != "" {
 			m.currentAgent = msg.Agent
 		}
@@ -213,27 +334,56 @@ func (m *ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.thinkingText = "" // Clear thinking when output starts
 		case "error":
 			m.thinkingText = "Error: " + msg.Content
+		case "tool_use":
+			m.tools = m.tools.update(msg.Tool)
+			m.thinkingText = msg.Content
+		case "tool_result":
+			m.tools = m.tools.update(msg.Tool)
+		case "cancelled":
+			m.cancelled = true
+		case "warning":
+			m.addSystemMessage("Budget warning: " + msg.Content)
+		case "fallback":
+			// Keep what the failed agent streamed, marked as interrupted
+			m.appendReply(agents.Usage{}, true)
+			m.addSystemMessage(msg.Content)
+		case "budget":
+			m.budgetStopped = msg.Content
 		}
 
 		m.viewport.SetContent(m.renderMessages())
 		m.viewport.GotoBottom()
 
 		if msg.Done {
-			if m.streamingText != "" {
-				m.messages = append(m.messages, Message{
-					Role:    RoleAssistant,
-					Content: m.streamingText,
-					Model:   m.currentAgent,
-				})
+			if !msg.Usage.IsZero() {
+				m.usage.Add(msg.Usage)
+				m.lastUsage = msg.Usage
+			}
+			if m.streamingText != "" || len(m.tools) > 0 {
+				m.appendReply(msg.Usage, m.cancelled || m.budgetStopped != "")
+			} else if m.cancelled {
+				m.addSystemMessage("Request cancelled.")
+			}
+			changes := m.finishTurnSnapshot()
+			if m.budgetStopped != "" {
+				m.promptBudgetOverride(m.budgetStopped)
+				m.budgetStopped = ""
 			}
+			if m.cancel != nil {
+				m.cancel()
+				m.cancel = nil
+			}
+			m.cancelled = false
 			m.processing = false
 			m.streamingText = ""
 			m.thinkingText = ""
+			m.tools = nil
+			m.stage = ""
 			m.currentAgent = ""
 			m.progressChan = nil
 			m.viewport.SetContent(m.renderMessages())
 			m.viewport.GotoBottom()
-			return m, nil
+			return m, changes
 		}
 
 		// Continue listening for more updates
@@ -243,6 +393,7 @@ func (m *ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var inputCmd tea.Cmd
 	m.input, inputCmd = m.input.Update(msg)
 	cmds = append(cmds, inputCmd)
+	m.updateSuggestions()
 
 	var vpCmd tea.Cmd
 	m.viewport, vpCmd = m.viewport.Update(msg)
@@ -251,6 +402,151 @@ func (m *ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// send adds a user message and starts streaming the response
+func (m *ChatModel) send(content string) tea.Cmd {
+	if m.processing {
+		return nil
+	}
+
+	content = m.attachMentions(content)
+
+	prompt := content
+	if len(m.attachments) > 0 {
+		r, err := m.fileResolver()
+		if err == nil {
+			prompt, err = r.BuildPrompt(m.attachments, content)
+		}
+		if err != nil {
+			m.addSystemMessage(fmt.Sprintf("Failed to attach files: %v", err))
+			m.viewport.SetContent(m.renderMessages())
+			m.viewport.GotoBottom()
+			return nil
+		}
+	}
+
+	var attached []string
+	for _, f := range m.attachments {
+		attached = append(attached, f.Path)
+	}
+
+	m.messages = append(m.messages, Message{
+		Role:        RoleUser,
+		Content:     content,
+		Attachments: attached,
+	})
+	if m.session != nil {
+		m.session.AddMessage(string(RoleUser), content, "")
+	}
+	m.lastPrompt = content
+	m.lastFiles = m.attachments
+	m.attachments = nil
+
+	return m.dispatch(prompt)
+}
+
+// appendReply saves the streamed reply as an assistant message
+func (m *ChatModel) appendReply(usage agents.Usage, interrupted bool) {
+	if m.streamingText == "" && len(m.tools) == 0 {
+		return
+	}
+	model := m.currentAgent
+	if m.stage != "" {
+		model += " · " + m.stage
+	}
+
+	m.messages = append(m.messages, Message{
+		Role:        RoleAssistant,
+		Content:     m.streamingText,
+		Model:       model,
+		Interrupted: interrupted,
+		Usage:       usage,
+		Tools:       m.tools,
+	})
+	if m.session != nil {
+		var u *agents.Usage
+		if !usage.IsZero() {
+			u = &usage
+		}
+		m.session.AppendMessage(session.Message{
+			Role:        string(RoleAssistant),
+			Content:     m.streamingText,
+			Model:       model,
+			Interrupted: interrupted,
+			Usage:       u,
+			Tools:       m.tools,
+		})
+	}
+	m.streamingText = ""
+	m.tools = nil
+}
+
+// isPipelineStage reports whether stage is a plan/implement/review stage
+func isPipelineStage(stage string) bool {
+	switch stage {
+	case orchestrator.StagePlan, orchestrator.StageImplement, orchestrator.StageReview:
+		return true
+	}
+	return false
+}
+
+// dispatch sends a prompt to the orchestrator and starts streaming the response
+func (m *ChatModel) dispatch(prompt string) tea.Cmd {
+	m.pendingPrompt = prompt
+	m.pipelineRun = m.pipelineNext
+	m.pipelineNext = false
+	m.stage = ""
+	m.streamingText = ""
+	m.thinkingText = ""
+	m.tools = nil
+	m.viewport.SetContent(m.renderMessages())
+	m.viewport.GotoBottom()
+
+	m.processing = true
+	m.startTime = time.Now()
+
+	// Streaming starts once the working tree is snapshotted
+	return tea.Batch(m.beginTurnSnapshot(m.lastPrompt, prompt), tickCmd())
+}
+
+// runCommand executes a slash command line
+func (m *ChatModel) runCommand(content string) tea.Cmd {
+	name, args, _ := parseCommand(content)
+	cmd, exists := m.commands.Lookup(name)
+	if !exists {
+		m.addSystemMessage(fmt.Sprintf("Unknown command: /%s (type /help for a list)", name))
+		return nil
+	}
+	return cmd.Run(m, args)
+}
+
+// addSystemMessage shows a local notice in the conversation
+func (m *ChatModel) addSystemMessage(content string) {
+	m.messages = append(m.messages, Message{
+		Role:    RoleSystem,
+		Content: content,
+	})
+}
+
+// completeCommand completes the slash command in the input field
+func (m *ChatModel) completeCommand() {
+	matches := m.commands.Complete(m.input.Value())
+	if len(matches) == 0 {
+		return
+	}
+	if len(matches) == 1 {
+		m.input.SetValue(matches[0] + " ")
+	} else {
+		m.input.SetValue(commonPrefix(matches))
+	}
+	m.input.CursorEnd()
+	m.updateSuggestions()
+}
+
+// updateSuggestions refreshes the completions shown below the input
+func (m *ChatModel) updateSuggestions() {
+	m.suggestions = m.commands.Complete(m.input.Value())
+}
+
 // startStreaming starts the streaming process and returns the progress channel
 // Channel is now owned and managed by Orchestrator
 func (m *ChatModel) startStreaming(content string) <-chan orchestrator.ProgressUpdate {
@@ -265,10 +561,70 @@ func (m *ChatModel) startStreaming(content string) <-chan orchestrator.ProgressU
 		return ch
 	}
 
-	ctx := context.Background()
+	ctx := m.budgetContext(context.Background())
+	if m.planMode {
+		ctx = agents.WithPermissionMode(ctx, agents.PermissionPlan)
+	}
+	if m.persona != nil {
+		ctx = agents.WithPersona(ctx, m.persona.Prompt)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	m.cancel = cancel
+	m.cancelled = false
+	if m.pipelineRun {
+		return m.orchestrator.ProcessPipelineAsync(ctx, content)
+	}
 	return m.orchestrator.ProcessStreamAsync(ctx, content)
 }
 
+// setPlanMode switches read-only plan mode, in which Claude may read
+// files and plan changes but not edit files or run commands
+func (m *ChatModel) setPlanMode(on bool) {
+	m.planMode = on
+	if on {
+		m.addSystemMessage("Plan mode on: Claude can read and plan but not change files.")
+	} else {
+		m.addSystemMessage("Plan mode off.")
+	}
+}
+
+// cancelRequest stops the in-flight request. The orchestrator reports the
+// cancellation on the progress channel, which finishes the response.
+func (m *ChatModel) cancelRequest() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	m.thinkingText = "Cancelling..."
+	m.viewport.SetContent(m.renderMessages())
+}
+
+// loginWarning describes a Claude CLI login problem found by the last
+// login check, if the active agent uses the CLI
+func (m *ChatModel) loginWarning() string {
+	if m.orchestrator == nil {
+		return ""
+	}
+	agent, exists := m.orchestrator.GetAgent(m.orchestrator.ActiveAgent())
+	if _, cli := agents.Unwrap(agent).(*agents.ClaudeAgent); !exists || !cli {
+		return ""
+	}
+	status, checked := agents.CachedClaudeLogin()
+	switch {
+	case !checked || status.LoggedIn:
+		return ""
+	case !status.CLIFound:
+		return "Claude CLI not found"
+	default:
+		return "not logged in"
+	}
+}
+
+// IsProcessing returns true while a request is in flight
+func (m *ChatModel) IsProcessing() bool {
+	return m.processing
+}
+
 // waitForUpdate returns a command that waits for the next progress update
 func (m *ChatModel) waitForUpdate() tea.Cmd {
 	ch := m.progressChan
@@ -284,7 +640,10 @@ func (m *ChatModel) waitForUpdate() tea.Cmd {
 		return StreamUpdateMsg{
 			Content: update.Message,
 			Agent:   update.Agent,
+			Stage:   update.Stage,
 			Type:    update.Type,
+			Usage:   update.Usage,
+			Tool:    update.Tool,
 			Done:    update.Done,
 		}
 	}
@@ -304,7 +663,8 @@ I'm your AI coding assistant powered by Claude.
 Code modifications will be executed by Cursor.
 
 Commands:
-  /clean - Clear chat history
+  /help  - Show all commands
+  /clear - Clear chat history
 
 How can I help you today?
 `
@@ -312,18 +672,37 @@ How can I help you today?
 	}
 
 	var b strings.Builder
-	for _, msg := range m.messages {
+	for i := range m.messages {
+		msg := &m.messages[i]
 		switch msg.Role {
 		case RoleUser:
-			bubble := chatBubbleUser.Render(msg.Content)
+			content := msg.Content
+			if len(msg.Attachments) > 0 {
+				content += "\n" + mutedStyle.Render("+ "+strings.Join(msg.Attachments, ", "))
+			}
+			bubble := chatBubbleUser.Render(content)
 			b.WriteString(lipgloss.PlaceHorizontal(m.width-4, lipgloss.Right, bubble))
 		case RoleAssistant:
 			modelTag := ""
 			if msg.Model != "" {
-				modelTag = mutedStyle.Render(fmt.Sprintf("[%s]", msg.Model)) + "\n"
+				tag := msg.Model
+				if !msg.Usage.IsZero() {
+					tag += " · " + msg.Usage.String()
+				}
+				modelTag = mutedStyle.Render(fmt.Sprintf("[%s]", tag)) + "\n"
+			}
+			content := m.renderMarkdown(msg)
+			if len(msg.Tools) > 0 {
+				content = strings.TrimSuffix(msg.Tools.render(m.showTools)+"\n"+content, "\n")
+			}
+			if msg.Interrupted {
+				content += "\n" + mutedStyle.Render("[interrupted]")
 			}
-			bubble := chatBubbleAssistant.Render(modelTag + msg.Content)
+			bubble := chatBubbleAssistant.Render(modelTag + content)
 			b.WriteString(bubble)
+		case RoleSystem:
+			b.WriteString(systemMessageStyle.Render(msg.Content))
+			b.WriteString("\n")
 		}
 		b.WriteString("\n")
 	}
@@ -342,11 +721,14 @@ How can I help you today?
 		}
 
 		// Show streaming output
-		if m.streamingText != "" {
+		if m.streamingText != "" || len(m.tools) > 0 {
 			modelTag := ""
 			if m.currentAgent != "" {
 				modelTag = mutedStyle.Render(fmt.Sprintf("[%s]", m.currentAgent)) + "\n"
 			}
+			if len(m.tools) > 0 {
+				modelTag += m.tools.render(m.showTools) + "\n"
+			}
 			streamingContent := modelTag + m.streamingText + "_"
 			bubble := chatBubbleAssistant.Render(streamingContent)
 			b.WriteString(bubble)
@@ -356,6 +738,19 @@ How can I help you today?
 	return b.String()
 }
 
+// renderMarkdown returns the Markdown rendering of an assistant message,
+// re-rendering only when the wrap width has changed
+func (m *ChatModel) renderMarkdown(msg *Message) string {
+	if m.markdown == nil {
+		return msg.Content
+	}
+	if msg.rendered == "" || msg.renderedWidth != m.markdown.width {
+		msg.rendered = m.markdown.Render(msg.Content)
+		msg.renderedWidth = m.markdown.width
+	}
+	return msg.rendered
+}
+
 func (m *ChatModel) View() string {
 	if !m.ready {
 		return "Loading..."
@@ -378,16 +773,88 @@ func (m *ChatModel) View() string {
 		}
 	}
 
+	if login := m.loginWarning(); login != "" {
+		statusText = toolFailedStyle.Render(" ["+login+"]") + statusText
+	}
+	if m.persona != nil {
+		statusText = accentStyle.Render(" ["+m.persona.Name+"]") + statusText
+	}
+	if m.planMode {
+		statusText = accentStyle.Render(" [plan]") + statusText
+	}
+	if !m.usage.IsZero() {
+		statusText += mutedStyle.Render(" [" + m.usage.String() + "]")
+	}
+
 	headerLine := lipgloss.JoinHorizontal(lipgloss.Left, header, statusText)
 
-	help := helpStyle.Render("Enter: send | Esc: back | /clean: clear | Shift+Enter: newline")
+	if m.diffReview != nil {
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			headerLine,
+			"",
+			m.viewDiffReview(),
+			helpStyle.Render("tab/←/→: file | ↑/↓: hunk | space: toggle hunk | a/r: approve/reject file | A: approve all | enter: apply approved | esc: discard"),
+		)
+	}
+
+	if m.codePicker != nil {
+		help := helpStyle.Render("↑/↓ or 1-9: select | c: copy | w: write to file | e: apply via Cursor | p: preview Cursor edit | esc: close")
+		if m.codePicker.action != codeActionNone {
+			help = helpStyle.Render("Enter: confirm | Esc: cancel")
+		}
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			headerLine,
+			"",
+			m.viewCodePicker(),
+			help,
+		)
+	}
+
+	help := helpStyle.Render("Enter: send | Esc: back | /help: commands | @path: attach file | Tab: complete | Ctrl+Y: code blocks | Ctrl+O: tool activity | Shift+Tab: plan mode")
+	if m.processing {
+		help = helpStyle.Render("Esc/Ctrl+C: cancel request | Ctrl+O: tool activity")
+	}
+	if m.budgetPrompt != nil {
+		help = accentStyle.Render("y: send anyway | n/Esc: don't send")
+	}
+	if len(m.suggestions) > 0 {
+		var hints []string
+		for _, s := range m.suggestions {
+			hint := s
+			if name, _, ok := parseCommand(s); ok && !strings.Contains(s, " ") {
+				if cmd, exists := m.commands.Lookup(name); exists {
+					hint = fmt.Sprintf("%s - %s", cmd.Usage(), cmd.Help)
+				}
+			}
+			hints = append(hints, hint)
+		}
+		if len(hints) > 5 {
+			hints = append(hints[:5], fmt.Sprintf("... %d more", len(hints)-5))
+		}
+		help = helpStyle.Render(strings.Join(hints, "\n"))
+	}
+
+	tray := m.viewContextTray()
+	if tray == "" {
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			headerLine,
+			"",
+			m.viewport.View(),
+			"",
+			inputStyle.Render(m.input.View()),
+			help,
+		)
+	}
 
 	return lipgloss.JoinVertical(
 		lipgloss.Left,
 		headerLine,
 		"",
 		m.viewport.View(),
-		"",
+		tray,
 		inputStyle.Render(m.input.View()),
 		help,
 	)
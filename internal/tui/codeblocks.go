@@ -0,0 +1,379 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ppopcode/ppopcode/internal/cursor"
+	"github.com/ppopcode/ppopcode/internal/snapshot"
+)
+
+// CodeBlock is a fenced code block extracted from an assistant reply
+type CodeBlock struct {
+	Lang string
+	Path string // from an info string such as "go:main.go" or "go main.go"
+	Code string
+}
+
+// extractCodeBlocks returns the fenced code blocks in Markdown content.
+// A block is closed by a fence of the same character that is at least as
+// long as the opening one, so shorter fences can appear inside it, and an
+// unterminated block runs to the end of the content.
+func extractCodeBlocks(content string) []CodeBlock {
+	var blocks []CodeBlock
+	var current *CodeBlock
+	var fence, indent string
+	var lines []string
+
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if current == nil {
+			marker, info, ok := openingFence(trimmed)
+			if ok {
+				fence = marker
+				indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+				current = codeBlockInfo(info)
+				lines = nil
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			current.Code = strings.Join(lines, "\n")
+			blocks = append(blocks, *current)
+			current = nil
+			continue
+		}
+		// Lines of a block nested in a list are indented like its fence
+		lines = append(lines, strings.TrimPrefix(line, indent))
+	}
+
+	if current != nil {
+		current.Code = strings.Join(lines, "\n")
+		blocks = append(blocks, *current)
+	}
+	return blocks
+}
+
+// openingFence returns the fence that line opens and its info string.
+// A backtick fence's info string cannot contain backticks, which rules out
+// inline code such as ```x```.
+func openingFence(line string) (fence, info string, ok bool) {
+	if !strings.HasPrefix(line, "```") && !strings.HasPrefix(line, "~~~") {
+		return "", "", false
+	}
+	info = strings.TrimLeft(line, line[:1])
+	fence = line[:len(line)-len(info)]
+	if fence[0] == '`' && strings.Contains(info, "`") {
+		return "", "", false
+	}
+	return fence, strings.TrimSpace(info), true
+}
+
+// codeBlockInfo reads the language and file path from an info string
+func codeBlockInfo(info string) *CodeBlock {
+	block := &CodeBlock{}
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return block
+	}
+	block.Lang, block.Path, _ = strings.Cut(fields[0], ":")
+	if block.Path == "" && len(fields) > 1 && !strings.Contains(fields[1], "=") {
+		block.Path = fields[1]
+	}
+	return block
+}
+
+// codePickerAction is what happens to a block once a path is entered
+type codePickerAction int
+
+const (
+	codeActionNone codePickerAction = iota
+	codeActionWrite
+	codeActionCursor
+	codeActionPreview
+)
+
+// codePicker lets the user pick a code block from the last reply
+type codePicker struct {
+	blocks    []CodeBlock
+	cursor    int
+	action    codePickerAction
+	pathInput textinput.Model
+	message   string
+}
+
+// CodeBlockResultMsg reports the outcome of a code block action
+type CodeBlockResultMsg struct {
+	Message string
+}
+
+// openCodePicker shows the fenced blocks of the last assistant reply
+func (m *ChatModel) openCodePicker() {
+	var blocks []CodeBlock
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].Role == RoleAssistant {
+			blocks = extractCodeBlocks(m.messages[i].Content)
+			break
+		}
+	}
+
+	if len(blocks) == 0 {
+		m.addSystemMessage("No code blocks in the last reply.")
+		m.viewport.SetContent(m.renderMessages())
+		m.viewport.GotoBottom()
+		return
+	}
+
+	ti := textinput.New()
+	ti.Placeholder = "path/to/file"
+	ti.CharLimit = 512
+
+	m.codePicker = &codePicker{
+		blocks:    blocks,
+		pathInput: ti,
+	}
+	m.input.Blur()
+}
+
+// closeCodePicker returns to the chat input
+func (m *ChatModel) closeCodePicker() {
+	m.codePicker = nil
+	m.input.Focus()
+}
+
+// HasSubView returns true while the code block picker, a budget
+// override prompt or a Cursor diff review is open
+func (m *ChatModel) HasSubView() bool {
+	return m.codePicker != nil || m.budgetPrompt != nil || m.diffReview != nil
+}
+
+func (m *ChatModel) updateCodePicker(msg tea.KeyMsg) tea.Cmd {
+	p := m.codePicker
+
+	if p.action != codeActionNone {
+		switch msg.Type {
+		case tea.KeyEsc:
+			p.action = codeActionNone
+			p.pathInput.Blur()
+			return nil
+		case tea.KeyEnter:
+			path := strings.TrimSpace(p.pathInput.Value())
+			if path == "" {
+				return nil
+			}
+			block := p.blocks[p.cursor]
+			action := p.action
+			p.action = codeActionNone
+			p.pathInput.Blur()
+
+			if action == codeActionWrite {
+				if err := writeCodeBlock(path, block.Code); err != nil {
+					p.message = fmt.Sprintf("Failed to write: %v", err)
+					return nil
+				}
+				m.closeCodePicker()
+				m.addSystemMessage(fmt.Sprintf("Wrote block %d to %s.", p.cursor+1, path))
+				m.viewport.SetContent(m.renderMessages())
+				m.viewport.GotoBottom()
+				return nil
+			}
+
+			m.closeCodePicker()
+			if action == codeActionPreview {
+				m.addSystemMessage(fmt.Sprintf("Sending block %d to Cursor for %s in a scratch copy...", p.cursor+1, path))
+				m.viewport.SetContent(m.renderMessages())
+				m.viewport.GotoBottom()
+				return previewCodeBlockWithCursor(path, block)
+			}
+			m.addSystemMessage(fmt.Sprintf("Sending block %d to Cursor for %s...", p.cursor+1, path))
+			m.viewport.SetContent(m.renderMessages())
+			m.viewport.GotoBottom()
+			return sendCodeBlockToCursor(path, block, m.snapshots)
+		}
+
+		var cmd tea.Cmd
+		p.pathInput, cmd = p.pathInput.Update(msg)
+		return cmd
+	}
+
+	switch msg.String() {
+	case "esc", "q":
+		m.closeCodePicker()
+	case "up", "k":
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case "down", "j":
+		if p.cursor < len(p.blocks)-1 {
+			p.cursor++
+		}
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		if n := int(msg.Runes[0] - '1'); n < len(p.blocks) {
+			p.cursor = n
+		}
+	case "c", "enter":
+		if err := copyToClipboard(p.blocks[p.cursor].Code); err != nil {
+			p.message = fmt.Sprintf("Failed to copy: %v", err)
+			return nil
+		}
+		p.message = fmt.Sprintf("Copied block %d to clipboard.", p.cursor+1)
+	case "w":
+		p.action = codeActionWrite
+		p.pathInput.SetValue(p.blocks[p.cursor].Path)
+		p.pathInput.CursorEnd()
+		p.pathInput.Focus()
+	case "e":
+		p.action = codeActionCursor
+		if m.cursorDryRun {
+			p.action = codeActionPreview
+		}
+		p.pathInput.SetValue(p.blocks[p.cursor].Path)
+		p.pathInput.CursorEnd()
+		p.pathInput.Focus()
+	case "p":
+		p.action = codeActionPreview
+		p.pathInput.SetValue(p.blocks[p.cursor].Path)
+		p.pathInput.CursorEnd()
+		p.pathInput.Focus()
+	}
+	return nil
+}
+
+func (m *ChatModel) viewCodePicker() string {
+	p := m.codePicker
+	var b strings.Builder
+
+	b.WriteString(selectedStyle.Render(fmt.Sprintf("Code blocks in last reply (%d)", len(p.blocks))))
+	b.WriteString("\n\n")
+
+	previewWidth := m.width - 20
+	if previewWidth < 20 {
+		previewWidth = 20
+	}
+
+	for i, block := range p.blocks {
+		cursor := "  "
+		style := normalStyle
+		if i == p.cursor {
+			cursor = "▸ "
+			style = selectedStyle
+		}
+
+		lang := block.Lang
+		if lang == "" {
+			lang = "text"
+		}
+		firstLine, _, _ := strings.Cut(strings.TrimSpace(block.Code), "\n")
+		if len(firstLine) > previewWidth {
+			firstLine = firstLine[:previewWidth-3] + "..."
+		}
+		lineCount := strings.Count(block.Code, "\n") + 1
+
+		b.WriteString(style.Render(fmt.Sprintf("%s[%d] %s (%d lines)", cursor, i+1, lang, lineCount)))
+		b.WriteString("\n")
+		b.WriteString(mutedStyle.Render("      " + firstLine))
+		b.WriteString("\n")
+	}
+
+	// Preview of the selected block
+	preview := p.blocks[p.cursor].Code
+	if lines := strings.Split(preview, "\n"); len(lines) > 10 {
+		preview = strings.Join(lines[:10], "\n") + "\n..."
+	}
+	b.WriteString("\n")
+	b.WriteString(lipgloss.NewStyle().
+		Border(getBorder()).
+		BorderForeground(mutedColor).
+		Padding(0, 1).
+		Render(preview))
+	b.WriteString("\n")
+
+	switch p.action {
+	case codeActionWrite:
+		b.WriteString("\n" + accentStyle.Render("Write to file: ") + p.pathInput.View() + "\n")
+	case codeActionCursor:
+		b.WriteString("\n" + accentStyle.Render("Apply via Cursor to: ") + p.pathInput.View() + "\n")
+	case codeActionPreview:
+		b.WriteString("\n" + accentStyle.Render("Preview Cursor edit of: ") + p.pathInput.View() + "\n")
+	}
+
+	if p.message != "" {
+		b.WriteString("\n" + accentStyle.Render(p.message) + "\n")
+	}
+
+	return b.String()
+}
+
+// copyToClipboard copies text using the OSC52 terminal escape sequence,
+// which also works over SSH
+func copyToClipboard(text string) error {
+	seq := osc52.New(text)
+	if os.Getenv("TMUX") != "" {
+		seq = seq.Tmux()
+	} else if os.Getenv("STY") != "" {
+		seq = seq.Screen()
+	}
+	// Write to stderr so the sequence doesn't interleave with the TUI renderer
+	_, err := seq.WriteTo(os.Stderr)
+	return err
+}
+
+// writeCodeBlock writes code to path, creating parent directories
+func writeCodeBlock(path, code string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+	return os.WriteFile(path, []byte(code), 0644)
+}
+
+// sendCodeBlockToCursor asks the Cursor bridge to apply a block to path,
+// snapshotting the working tree first when snapshots is set
+func sendCodeBlockToCursor(path string, block CodeBlock, snapshots *snapshot.History) tea.Cmd {
+	return func() tea.Msg {
+		workDir, err := os.Getwd()
+		if err != nil {
+			return CodeBlockResultMsg{Message: fmt.Sprintf("Cursor edit failed: %v", err)}
+		}
+
+		snap, notice := beginSnapshot(snapshots, snapshotLabel("cursor", "apply a code block to "+path))
+		bridge := cursor.NewBridge(workDir)
+		result := bridge.Execute(context.Background(), cursorRequest(path, block))
+		if changed := finishSnapshot(snapshots, snap); changed != "" {
+			notice = strings.TrimSpace(notice + "\n" + changed)
+		}
+
+		message := fmt.Sprintf("Cursor applied the edit to %s (%s).", path, result.Duration.Round(100*time.Millisecond))
+		if !result.Success {
+			message = fmt.Sprintf("Cursor edit failed: %v", result.Error)
+		}
+		if notice != "" {
+			message += "\n" + notice
+		}
+		return CodeBlockResultMsg{Message: message}
+	}
+}
+
+// cursorRequest asks Cursor to apply a block to path
+func cursorRequest(path string, block CodeBlock) cursor.EditRequest {
+	return cursor.EditRequest{
+		Prompt:     fmt.Sprintf("Apply the following code to %s. Integrate it with the existing file contents where appropriate.", path),
+		TargetPath: path,
+		Context:    fmt.Sprintf("```%s\n%s\n```", block.Lang, block.Code),
+	}
+}
@@ -0,0 +1,104 @@
+package tui
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []CodeBlock
+	}{
+		{"no blocks", "just text\n`inline` code", nil},
+		{
+			"backtick fence",
+			"Here:\n```go\nfunc main() {}\n```\ndone",
+			[]CodeBlock{{Lang: "go", Code: "func main() {}"}},
+		},
+		{
+			"tilde fence",
+			"~~~python\nprint(1)\n~~~",
+			[]CodeBlock{{Lang: "python", Code: "print(1)"}},
+		},
+		{
+			"no language",
+			"```\na\n\nb\n```",
+			[]CodeBlock{{Code: "a\n\nb"}},
+		},
+		{
+			"empty block",
+			"```sh\n```",
+			[]CodeBlock{{Lang: "sh"}},
+		},
+		{
+			"two blocks",
+			"```go\na\n```\ntext\n```js\nb\n```",
+			[]CodeBlock{{Lang: "go", Code: "a"}, {Lang: "js", Code: "b"}},
+		},
+		{
+			"longer fence holds a shorter one",
+			"````markdown\n```go\nx\n```\n````",
+			[]CodeBlock{{Lang: "markdown", Code: "```go\nx\n```"}},
+		},
+		{
+			"closed by a longer fence",
+			"```\na\n`````",
+			[]CodeBlock{{Code: "a"}},
+		},
+		{
+			"other fence character does not close",
+			"~~~\n```\n~~~",
+			[]CodeBlock{{Code: "```"}},
+		},
+		{
+			"fence with text after it does not close",
+			"```\n``` not a fence\n```",
+			[]CodeBlock{{Code: "``` not a fence"}},
+		},
+		{
+			"unterminated",
+			"```go\nfunc main() {\n",
+			[]CodeBlock{{Lang: "go", Code: "func main() {\n"}},
+		},
+		{
+			"inline code is not a fence",
+			"```x``` and\n```\ny\n```",
+			[]CodeBlock{{Code: "y"}},
+		},
+		{
+			"CRLF line endings",
+			"text\r\n```go\r\na\r\nb\r\n```\r\n",
+			[]CodeBlock{{Lang: "go", Code: "a\nb"}},
+		},
+		{
+			"path after a colon",
+			"```go:cmd/main.go\nx\n```",
+			[]CodeBlock{{Lang: "go", Path: "cmd/main.go", Code: "x"}},
+		},
+		{
+			"path as second word",
+			"``` go main.go\nx\n```",
+			[]CodeBlock{{Lang: "go", Path: "main.go", Code: "x"}},
+		},
+		{
+			"attributes are not paths",
+			"```go title=main.go\nx\n```",
+			[]CodeBlock{{Lang: "go", Code: "x"}},
+		},
+		{
+			"indented in a list",
+			"1. Run:\n   ```sh\n   make\n     -j4\n   ```",
+			[]CodeBlock{{Lang: "sh", Code: "make\n  -j4"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractCodeBlocks(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractCodeBlocks(%q) = %#v, want %#v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,700 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/attach"
+	"github.com/ppopcode/ppopcode/internal/budget"
+	"github.com/ppopcode/ppopcode/internal/config"
+	"github.com/ppopcode/ppopcode/internal/workflow"
+)
+
+// SlashCommand describes a chat command invoked with a leading slash
+type SlashCommand struct {
+	Name        string
+	Aliases     []string
+	Args        string // usage hint shown in /help, e.g. "<name>"
+	Help        string
+	Subcommands []string // completion candidates for the first argument
+	Run         func(m *ChatModel, args []string) tea.Cmd
+}
+
+// Usage returns the command with its argument hint
+func (c *SlashCommand) Usage() string {
+	if c.Args == "" {
+		return "/" + c.Name
+	}
+	return "/" + c.Name + " " + c.Args
+}
+
+// CommandRegistry holds the slash commands available in chat
+type CommandRegistry struct {
+	commands map[string]*SlashCommand
+	aliases  map[string]string
+	order    []string
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{
+		commands: make(map[string]*SlashCommand),
+		aliases:  make(map[string]string),
+	}
+}
+
+// Register adds a command, replacing any existing command with the same name
+func (r *CommandRegistry) Register(cmd *SlashCommand) {
+	if _, exists := r.commands[cmd.Name]; !exists {
+		r.order = append(r.order, cmd.Name)
+	}
+	r.commands[cmd.Name] = cmd
+	for _, alias := range cmd.Aliases {
+		r.aliases[alias] = cmd.Name
+	}
+}
+
+// Lookup finds a command by name or alias
+func (r *CommandRegistry) Lookup(name string) (*SlashCommand, bool) {
+	if target, ok := r.aliases[name]; ok {
+		name = target
+	}
+	cmd, exists := r.commands[name]
+	return cmd, exists
+}
+
+// Commands returns all registered commands in registration order
+func (r *CommandRegistry) Commands() []*SlashCommand {
+	cmds := make([]*SlashCommand, 0, len(r.order))
+	for _, name := range r.order {
+		cmds = append(cmds, r.commands[name])
+	}
+	return cmds
+}
+
+// Complete returns the possible completions for a partially typed command line
+func (r *CommandRegistry) Complete(input string) []string {
+	if !strings.HasPrefix(input, "/") {
+		return nil
+	}
+
+	var matches []string
+	name, rest, hasArgs := strings.Cut(strings.TrimPrefix(input, "/"), " ")
+	if !hasArgs {
+		for _, cmdName := range r.order {
+			if strings.HasPrefix(cmdName, name) {
+				matches = append(matches, "/"+cmdName)
+			}
+		}
+		return matches
+	}
+
+	cmd, exists := r.Lookup(name)
+	if !exists || strings.Contains(rest, " ") {
+		return nil
+	}
+	for _, sub := range cmd.Subcommands {
+		if strings.HasPrefix(sub, rest) {
+			matches = append(matches, "/"+name+" "+sub)
+		}
+	}
+	return matches
+}
+
+// parseCommand splits a slash command line into its name and arguments
+func parseCommand(input string) (name string, args []string, ok bool) {
+	if !strings.HasPrefix(input, "/") {
+		return "", nil, false
+	}
+	fields := strings.Fields(strings.TrimPrefix(input, "/"))
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	return fields[0], fields[1:], true
+}
+
+// commonPrefix returns the longest prefix shared by all values
+func commonPrefix(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	prefix := values[0]
+	for _, v := range values[1:] {
+		for !strings.HasPrefix(v, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// newDefaultCommandRegistry returns a registry with the built-in commands
+func newDefaultCommandRegistry() *CommandRegistry {
+	r := NewCommandRegistry()
+
+	r.Register(&SlashCommand{
+		Name: "help",
+		Help: "Show available commands",
+		Run:  cmdHelp,
+	})
+	r.Register(&SlashCommand{
+		Name:    "clear",
+		Aliases: []string{"clean"},
+		Help:    "Clear chat history",
+		Run:     cmdClear,
+	})
+	r.Register(&SlashCommand{
+		Name: "model",
+		Args: "[model]",
+		Help: "Show or change the active agent's model",
+		Run:  cmdModel,
+	})
+	r.Register(&SlashCommand{
+		Name: "agent",
+		Args: "[name]",
+		Help: "Show agents or switch the active agent",
+		Run:  cmdAgent,
+	})
+	r.Register(&SlashCommand{
+		Name:        "session",
+		Args:        "new [name]|load <id>|save|list",
+		Help:        "Manage saved chat sessions",
+		Subcommands: []string{"new", "load", "save", "list"},
+		Run:         cmdSession,
+	})
+	r.Register(&SlashCommand{
+		Name: "export",
+		Args: "[path]",
+		Help: "Export the conversation as Markdown",
+		Run:  cmdExport,
+	})
+	r.Register(&SlashCommand{
+		Name:        "workflow",
+		Args:        "run <name>|list",
+		Help:        "Run a workflow from " + workflowDir,
+		Subcommands: []string{"run", "list"},
+		Run:         cmdWorkflow,
+	})
+	r.Register(&SlashCommand{
+		Name: "add",
+		Args: "<path|dir|glob>...",
+		Help: "Attach files to the next message",
+		Run:  cmdAdd,
+	})
+	r.Register(&SlashCommand{
+		Name: "drop",
+		Args: "[path|glob]...",
+		Help: "Remove attached files (all if no argument)",
+		Run:  cmdDrop,
+	})
+	r.Register(&SlashCommand{
+		Name: "code",
+		Help: "Copy, save or apply code blocks from the last reply",
+		Run: func(m *ChatModel, _ []string) tea.Cmd {
+			m.openCodePicker()
+			return nil
+		},
+	})
+	r.Register(&SlashCommand{
+		Name: "cost",
+		Help: "Show usage for this session",
+		Run:  cmdCost,
+	})
+	r.Register(&SlashCommand{
+		Name:        "plan",
+		Args:        "[on|off]",
+		Help:        "Toggle read-only plan mode (also Shift+Tab)",
+		Subcommands: []string{"on", "off"},
+		Run:         cmdPlan,
+	})
+	r.Register(&SlashCommand{
+		Name: "pipeline",
+		Args: "<request>",
+		Help: "Plan, implement and review a request with several agents",
+		Run:  cmdPipeline,
+	})
+	r.Register(&SlashCommand{
+		Name: "tasks",
+		Help: "Show the tasks and pipeline steps of this session",
+		Run:  cmdTasks,
+	})
+	r.Register(&SlashCommand{
+		Name:        "persona",
+		Args:        "[name|off]",
+		Help:        "List personas or select one for this chat",
+		Subcommands: []string{"off"},
+		Run:         cmdPersona,
+	})
+	r.Register(&SlashCommand{
+		Name:        "undo",
+		Args:        "[list|force]",
+		Help:        "Restore the files changed by the last request or Cursor edit",
+		Subcommands: []string{"list", "force"},
+		Run:         cmdUndo,
+	})
+	r.Register(&SlashCommand{
+		Name: "retry",
+		Help: "Send the last message again",
+		Run:  cmdRetry,
+	})
+
+	return r
+}
+
+// registerCustomCommands adds prompt-template commands from config.
+// Built-in commands take precedence over custom commands with the same name.
+func (r *CommandRegistry) registerCustomCommands(commands map[string]config.CommandConfig) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, exists := r.Lookup(name); exists {
+			continue
+		}
+		cc := commands[name]
+		help := cc.Description
+		if help == "" {
+			help = "Custom prompt"
+		}
+		r.Register(&SlashCommand{
+			Name: name,
+			Args: cc.Args,
+			Help: help,
+			Run: func(m *ChatModel, args []string) tea.Cmd {
+				return m.send(cc.Expand(args))
+			},
+		})
+	}
+}
+
+func cmdHelp(m *ChatModel, _ []string) tea.Cmd {
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, cmd := range m.commands.Commands() {
+		b.WriteString(fmt.Sprintf("\n  %-32s %s", cmd.Usage(), cmd.Help))
+	}
+	b.WriteString("\n\nTab completes command names.")
+	m.addSystemMessage(b.String())
+	return nil
+}
+
+func cmdClear(m *ChatModel, _ []string) tea.Cmd {
+	m.messages = []Message{}
+	m.streamingText = ""
+	m.thinkingText = ""
+	m.currentAgent = ""
+	return nil
+}
+
+func cmdModel(m *ChatModel, args []string) tea.Cmd {
+	if m.orchestrator == nil {
+		m.addSystemMessage("No orchestrator configured.")
+		return nil
+	}
+
+	name := m.orchestrator.ActiveAgent()
+	agent, exists := m.orchestrator.GetAgent(name)
+	if !exists {
+		m.addSystemMessage(fmt.Sprintf("Agent %s not found.", name))
+		return nil
+	}
+
+	if len(args) == 0 {
+		m.addSystemMessage(fmt.Sprintf("Agent %s is using model %s.", name, agent.Model()))
+		return nil
+	}
+
+	if err := m.orchestrator.SetAgentModel(name, args[0]); err != nil {
+		m.addSystemMessage(fmt.Sprintf("Failed to change model: %v", err))
+		return nil
+	}
+	m.addSystemMessage(fmt.Sprintf("Agent %s now uses model %s.", name, args[0]))
+	return nil
+}
+
+func cmdPlan(m *ChatModel, args []string) tea.Cmd {
+	switch {
+	case len(args) == 0:
+		m.setPlanMode(!m.planMode)
+	case args[0] == "on":
+		m.setPlanMode(true)
+	case args[0] == "off":
+		m.setPlanMode(false)
+	default:
+		m.addSystemMessage("Usage: /plan [on|off]")
+	}
+	return nil
+}
+
+func cmdPipeline(m *ChatModel, args []string) tea.Cmd {
+	if len(args) == 0 {
+		m.addSystemMessage("Usage: /pipeline <request>")
+		return nil
+	}
+	m.pipelineNext = true
+	cmd := m.send(strings.Join(args, " "))
+	if !m.processing {
+		m.pipelineNext = false
+	}
+	return cmd
+}
+
+func cmdPersona(m *ChatModel, args []string) tea.Cmd {
+	if len(args) == 0 {
+		if len(m.personas) == 0 {
+			m.addSystemMessage("No personas configured. Add them under personas: in config.yaml or as " + config.ProjectDir + "/personas/<name>.md.")
+			return nil
+		}
+		var b strings.Builder
+		b.WriteString("Personas:")
+		for _, p := range m.personas {
+			marker := " "
+			if m.persona != nil && m.persona.Name == p.Name {
+				marker = "*"
+			}
+			b.WriteString(fmt.Sprintf("\n %s %-16s %s", marker, p.Name, p.Description))
+		}
+		m.addSystemMessage(b.String())
+		return nil
+	}
+
+	if args[0] == "off" {
+		m.persona = nil
+		m.addSystemMessage("Persona cleared.")
+		return nil
+	}
+	for i := range m.personas {
+		if m.personas[i].Name == args[0] {
+			m.persona = &m.personas[i]
+			m.addSystemMessage(fmt.Sprintf("Persona %s selected.", args[0]))
+			return nil
+		}
+	}
+	m.addSystemMessage(fmt.Sprintf("Persona %s not found (type /persona for a list).", args[0]))
+	return nil
+}
+
+func cmdAgent(m *ChatModel, args []string) tea.Cmd {
+	if m.orchestrator == nil {
+		m.addSystemMessage("No orchestrator configured.")
+		return nil
+	}
+
+	if len(args) == 0 {
+		active := m.orchestrator.ActiveAgent()
+		status := m.orchestrator.GetAgentStatus()
+		var b strings.Builder
+		b.WriteString("Agents:")
+		for _, name := range m.orchestrator.AgentNames() {
+			marker := "  "
+			if name == active {
+				marker = "▸ "
+			}
+			agent, _ := m.orchestrator.GetAgent(name)
+			b.WriteString(fmt.Sprintf("\n%s%s (%s) - %s", marker, name, agent.Model(), status[name]))
+		}
+		m.addSystemMessage(b.String())
+		return nil
+	}
+
+	if err := m.orchestrator.SetActiveAgent(args[0]); err != nil {
+		m.addSystemMessage(fmt.Sprintf("Failed to switch agent: %v", err))
+		return nil
+	}
+	m.addSystemMessage(fmt.Sprintf("Switched to agent %s.", args[0]))
+	return nil
+}
+
+func cmdSession(m *ChatModel, args []string) tea.Cmd {
+	if m.session == nil {
+		m.addSystemMessage("Session history is not configured.")
+		return nil
+	}
+	if len(args) == 0 {
+		current := m.session.Current()
+		m.addSystemMessage(fmt.Sprintf("Current session: %s (%s), %d messages.", current.Name, current.ID, len(current.Messages)))
+		return nil
+	}
+
+	switch args[0] {
+	case "new":
+		name := "chat"
+		if len(args) > 1 {
+			name = strings.Join(args[1:], " ")
+		}
+		s := m.session.NewSession(name)
+		cmdClear(m, nil)
+		m.usage = agents.Usage{}
+		m.lastUsage = agents.Usage{}
+		m.resetBudgetScope(0)
+		m.sessionStart = time.Now()
+		m.addSystemMessage(fmt.Sprintf("Started session %s (%s).", s.Name, s.ID))
+
+	case "load":
+		if len(args) < 2 {
+			m.addSystemMessage("Usage: /session load <id>")
+			return nil
+		}
+		s, err := m.session.Load(args[1])
+		if err != nil {
+			m.addSystemMessage(fmt.Sprintf("Failed to load session: %v", err))
+			return nil
+		}
+		m.messages = []Message{}
+		for _, msg := range s.Messages {
+			m.messages = append(m.messages, Message{
+				Role:        MessageRole(msg.Role),
+				Content:     msg.Content,
+				Model:       msg.Model,
+				Interrupted: msg.Interrupted,
+				Usage:       usageOf(msg.Usage),
+				Tools:       msg.Tools,
+			})
+		}
+		m.usage = s.Usage
+		m.lastUsage = agents.Usage{}
+		m.resetBudgetScope(s.Usage.CostUSD)
+		m.sessionStart = time.Now()
+		m.addSystemMessage(fmt.Sprintf("Loaded session %s (%d messages).", s.Name, len(s.Messages)))
+
+	case "save":
+		if err := m.session.Save(); err != nil {
+			m.addSystemMessage(fmt.Sprintf("Failed to save session: %v", err))
+			return nil
+		}
+		m.addSystemMessage(fmt.Sprintf("Saved session %s.", m.session.Current().ID))
+
+	case "list":
+		sessions, err := m.session.List()
+		if err != nil {
+			m.addSystemMessage(fmt.Sprintf("Failed to list sessions: %v", err))
+			return nil
+		}
+		if len(sessions) == 0 {
+			m.addSystemMessage("No saved sessions.")
+			return nil
+		}
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+		})
+		var b strings.Builder
+		b.WriteString("Saved sessions:")
+		for _, s := range sessions {
+			b.WriteString(fmt.Sprintf("\n  %s  %s (%d messages, %s)", s.ID, s.Name, len(s.Messages), s.UpdatedAt.Format("2006-01-02 15:04")))
+		}
+		m.addSystemMessage(b.String())
+
+	default:
+		m.addSystemMessage(fmt.Sprintf("Unknown session command: %s", args[0]))
+	}
+	return nil
+}
+
+func cmdExport(m *ChatModel, args []string) tea.Cmd {
+	path := fmt.Sprintf("ppopcode-chat-%s.md", time.Now().Format("20060102-150405"))
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	var b strings.Builder
+	b.WriteString("# ppopcode chat\n\n")
+	for _, msg := range m.messages {
+		switch msg.Role {
+		case RoleUser:
+			b.WriteString("## You\n\n")
+		case RoleAssistant:
+			if msg.Model != "" {
+				b.WriteString(fmt.Sprintf("## Assistant (%s)\n\n", msg.Model))
+			} else {
+				b.WriteString("## Assistant\n\n")
+			}
+		default:
+			continue
+		}
+		b.WriteString(msg.Content)
+		if msg.Interrupted {
+			b.WriteString("\n\n_(interrupted)_")
+		}
+		b.WriteString("\n\n")
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			m.addSystemMessage(fmt.Sprintf("Failed to export: %v", err))
+			return nil
+		}
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		m.addSystemMessage(fmt.Sprintf("Failed to export: %v", err))
+		return nil
+	}
+	m.addSystemMessage(fmt.Sprintf("Exported conversation to %s.", path))
+	return nil
+}
+
+func cmdWorkflow(m *ChatModel, args []string) tea.Cmd {
+	loader := workflow.NewLoader(workflowDir)
+
+	if len(args) == 0 || args[0] == "list" {
+		names, err := loader.ListWorkflows()
+		if err != nil || len(names) == 0 {
+			m.addSystemMessage(fmt.Sprintf("No workflows found in %s.", workflowDir))
+			return nil
+		}
+		m.addSystemMessage("Workflows:\n  " + strings.Join(names, "\n  "))
+		return nil
+	}
+
+	if args[0] != "run" || len(args) < 2 {
+		m.addSystemMessage("Usage: /workflow run <name>")
+		return nil
+	}
+
+	name := strings.Join(args[1:], " ")
+	wf, err := loader.Load(name)
+	if err != nil {
+		m.addSystemMessage(fmt.Sprintf("Failed to load workflow: %v", err))
+		return nil
+	}
+
+	path := filepath.Join(workflowDir, strings.TrimSuffix(name, ".json")+".json")
+	return func() tea.Msg {
+		return WorkflowLoadedMsg{Name: wf.Name, Path: path, Workflow: wf}
+	}
+}
+
+func cmdCost(m *ChatModel, _ []string) tea.Cmd {
+	budgetInfo := ""
+	if m.orchestrator != nil && m.orchestrator.Budget() != nil {
+		budgetInfo = formatBudget(m.orchestrator.Budget(), m.budgetScope)
+	}
+
+	if m.usage.IsZero() {
+		m.addSystemMessage("No usage recorded in this session yet." + budgetInfo)
+		return nil
+	}
+
+	u := m.usage
+	var b strings.Builder
+	b.WriteString("Session usage:")
+	b.WriteString(fmt.Sprintf("\n  Requests:      %d", u.Requests))
+	b.WriteString(fmt.Sprintf("\n  Input tokens:  %d", u.InputTokens))
+	b.WriteString(fmt.Sprintf("\n  Output tokens: %d", u.OutputTokens))
+	if u.CacheCreationTokens > 0 || u.CacheReadTokens > 0 {
+		b.WriteString(fmt.Sprintf("\n  Cache tokens:  %d written, %d read", u.CacheCreationTokens, u.CacheReadTokens))
+	}
+	b.WriteString(fmt.Sprintf("\n  Cost:          $%.4f", u.CostUSD))
+	if !m.lastUsage.IsZero() {
+		b.WriteString("\n\nLast request: " + m.lastUsage.String())
+	}
+	b.WriteString(budgetInfo)
+	m.addSystemMessage(b.String())
+	return nil
+}
+
+// formatBudget describes the configured limits and current spend
+func formatBudget(t *budget.Tracker, session *budget.Scope) string {
+	limits := t.Limits()
+	limit := func(v float64) string {
+		if v <= 0 {
+			return "unlimited"
+		}
+		return fmt.Sprintf("$%.2f", v)
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\nBudget:")
+	b.WriteString(fmt.Sprintf("\n  Per request:   %s", limit(limits.PerRequest)))
+	if session != nil {
+		b.WriteString(fmt.Sprintf("\n  Session:       $%.4f of %s", session.Spent(), limit(limits.PerSession)))
+	}
+	b.WriteString(fmt.Sprintf("\n  Per workflow:  %s", limit(limits.PerWorkflow)))
+	b.WriteString(fmt.Sprintf("\n  Today:         $%.4f of %s", t.DaySpent(), limit(limits.PerDay)))
+	return b.String()
+}
+
+// usageOf dereferences optional persisted usage
+func usageOf(u *agents.Usage) agents.Usage {
+	if u == nil {
+		return agents.Usage{}
+	}
+	return *u
+}
+
+func cmdRetry(m *ChatModel, _ []string) tea.Cmd {
+	if m.lastPrompt == "" {
+		m.addSystemMessage("Nothing to retry.")
+		return nil
+	}
+	m.attachments = m.lastFiles
+	return m.send(m.lastPrompt)
+}
+
+func cmdAdd(m *ChatModel, args []string) tea.Cmd {
+	if len(args) == 0 {
+		m.addSystemMessage("Usage: /add <path|dir|glob>...")
+		return nil
+	}
+	summary, err := m.attachFiles(args)
+	if err != nil {
+		m.addSystemMessage(fmt.Sprintf("Failed to attach: %v", err))
+		return nil
+	}
+	m.addSystemMessage(summary)
+	return nil
+}
+
+func cmdDrop(m *ChatModel, args []string) tea.Cmd {
+	if len(args) == 0 {
+		m.attachments = nil
+		m.addSystemMessage("Removed all attached files.")
+		return nil
+	}
+
+	var kept []attach.File
+	removed := 0
+	for _, f := range m.attachments {
+		if matchesAny(f.Path, args) {
+			removed++
+			continue
+		}
+		kept = append(kept, f)
+	}
+	m.attachments = kept
+	m.addSystemMessage(fmt.Sprintf("Removed %d attached file(s).", removed))
+	return nil
+}
+
+// matchesAny reports whether path equals, is under, or matches one of patterns
+func matchesAny(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "./"), "/")
+		if path == pattern || strings.HasPrefix(path, pattern+"/") {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setPersonas makes personas selectable and completable with /persona
+func (m *ChatModel) setPersonas(personas []config.Persona) {
+	m.personas = personas
+	m.persona = nil
+	if cmd, ok := m.commands.Lookup("persona"); ok {
+		subs := []string{"off"}
+		for _, p := range personas {
+			subs = append(subs, p.Name)
+		}
+		cmd.Subcommands = subs
+	}
+}
@@ -0,0 +1,207 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ppopcode/ppopcode/internal/cursor"
+)
+
+var (
+	diffAddedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#10B981"))
+	diffRemovedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444"))
+)
+
+// CursorPreviewMsg carries a Cursor edit made in a scratch copy, to be
+// reviewed before it is applied
+type CursorPreviewMsg struct {
+	Path    string
+	Preview *cursor.Preview
+	Err     error
+}
+
+// diffReview lets the user approve or reject the hunks of a Cursor edit
+type diffReview struct {
+	preview *cursor.Preview
+	file    int // selected change
+	hunk    int // selected hunk of that change
+}
+
+// previewCodeBlockWithCursor asks the Cursor bridge to apply a block to
+// path in a scratch copy of the working tree
+func previewCodeBlockWithCursor(path string, block CodeBlock) tea.Cmd {
+	return func() tea.Msg {
+		workDir, err := os.Getwd()
+		if err != nil {
+			return CursorPreviewMsg{Path: path, Err: err}
+		}
+		preview, err := cursor.NewBridge(workDir).Preview(context.Background(), cursorRequest(path, block))
+		return CursorPreviewMsg{Path: path, Preview: preview, Err: err}
+	}
+}
+
+// openDiffReview shows the changes of a Cursor preview, or says why there
+// are none to review
+func (m *ChatModel) openDiffReview(msg CursorPreviewMsg) {
+	switch {
+	case msg.Err != nil:
+		m.addSystemMessage(fmt.Sprintf("Cursor edit failed: %v", msg.Err))
+	case len(msg.Preview.Changes) == 0:
+		m.addSystemMessage(fmt.Sprintf("Cursor made no changes for %s.", msg.Path))
+	default:
+		m.diffReview = &diffReview{preview: msg.Preview}
+		m.input.Blur()
+		return
+	}
+	m.viewport.SetContent(m.renderMessages())
+	m.viewport.GotoBottom()
+}
+
+// closeDiffReview returns to the chat input with a note of the outcome
+func (m *ChatModel) closeDiffReview(note string) {
+	m.diffReview = nil
+	m.input.Focus()
+	m.addSystemMessage(note)
+	m.viewport.SetContent(m.renderMessages())
+	m.viewport.GotoBottom()
+}
+
+func (m *ChatModel) updateDiffReview(msg tea.KeyMsg) tea.Cmd {
+	r := m.diffReview
+	changes := r.preview.Changes
+	change := changes[r.file]
+	hunks := max(len(change.Hunks), 1) // a whole file is one hunk
+
+	switch msg.String() {
+	case "esc", "q":
+		m.closeDiffReview("Discarded the Cursor edit; nothing was applied.")
+	case "tab", "right", "l":
+		r.file = (r.file + 1) % len(changes)
+		r.hunk = 0
+	case "shift+tab", "left", "h":
+		r.file = (r.file - 1 + len(changes)) % len(changes)
+		r.hunk = 0
+	case "down", "j":
+		r.hunk = min(r.hunk+1, hunks-1)
+	case "up", "k":
+		r.hunk = max(r.hunk-1, 0)
+	case " ":
+		if change.WholeFile() {
+			change.Approved = !change.Approved
+		} else {
+			change.Hunks[r.hunk].Approved = !change.Hunks[r.hunk].Approved
+		}
+	case "a":
+		change.SetApproved(true)
+	case "r":
+		change.SetApproved(false)
+	case "A":
+		for _, c := range changes {
+			c.SetApproved(true)
+		}
+	case "enter":
+		snap, notice := beginSnapshot(m.snapshots, snapshotLabel("cursor", "apply the reviewed edit"))
+		applied, err := r.preview.Apply()
+		note := "No hunks were approved; nothing was applied."
+		if len(applied) > 0 {
+			note = fmt.Sprintf("Applied the approved changes to %s.", strings.Join(applied, ", "))
+		}
+		if err != nil {
+			note += fmt.Sprintf("\nNot applied: %v", err)
+		}
+		for _, n := range []string{notice, finishSnapshot(m.snapshots, snap)} {
+			if n != "" {
+				note += "\n" + n
+			}
+		}
+		m.closeDiffReview(note)
+	}
+	return nil
+}
+
+func (m *ChatModel) viewDiffReview() string {
+	r := m.diffReview
+	var b strings.Builder
+
+	b.WriteString(selectedStyle.Render(fmt.Sprintf("Review Cursor edit (%s)", r.preview.Duration.Round(100*time.Millisecond))))
+	b.WriteString("\n\n")
+
+	// Files, with how much of each is approved
+	for i, c := range r.preview.Changes {
+		approved, total := c.ApprovedHunks()
+		mark := "[ ]"
+		switch {
+		case approved == total:
+			mark = "[✓]"
+		case approved > 0:
+			mark = "[~]"
+		}
+		added, removed := c.Counts()
+		line := fmt.Sprintf("%s %s (%s, +%d -%d)", mark, c.Path, c.Status, added, removed)
+		if c.Binary {
+			line = fmt.Sprintf("%s %s (%s, binary)", mark, c.Path, c.Status)
+		}
+		if i == r.file {
+			b.WriteString(selectedStyle.Render("▸ " + line))
+		} else {
+			b.WriteString(normalStyle.Render("  " + line))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	// Hunks of the selected file, scrolled to keep the selected one in view
+	change := r.preview.Changes[r.file]
+	var lines []string
+	selectedLine := 0
+	switch {
+	case change.Binary:
+		lines = append(lines, mutedStyle.Render("Binary file; approve or reject it as a whole."))
+	case change.WholeFile():
+		lines = append(lines, mutedStyle.Render("Empty file; approve or reject it as a whole."))
+	}
+	for i, h := range change.Hunks {
+		mark := "[ ]"
+		if h.Approved {
+			mark = "[✓]"
+		}
+		header := fmt.Sprintf("%s %s", mark, h.Header())
+		if i == r.hunk {
+			selectedLine = len(lines)
+			lines = append(lines, selectedStyle.Render("▸ "+header))
+		} else {
+			lines = append(lines, accentStyle.Render("  "+header))
+		}
+		for _, line := range h.Lines {
+			text := "  " + truncateLine(strings.ReplaceAll(strings.TrimSuffix(line, "\n"), "\t", "    "), m.width-6)
+			switch line[0] {
+			case '+':
+				lines = append(lines, diffAddedStyle.Render(text))
+			case '-':
+				lines = append(lines, diffRemovedStyle.Render(text))
+			default:
+				lines = append(lines, mutedStyle.Render(text))
+			}
+		}
+	}
+	height := max(m.height-len(r.preview.Changes)-10, 5)
+	start := max(min(selectedLine-2, len(lines)-height), 0)
+	end := min(start+height, len(lines))
+	b.WriteString(strings.Join(lines[start:end], "\n"))
+	b.WriteString("\n")
+	return b.String()
+}
+
+// truncateLine shortens a line to width runes
+func truncateLine(line string, width int) string {
+	runes := []rune(line)
+	if width < 4 || len(runes) <= width {
+		return line
+	}
+	return string(runes[:width-3]) + "..."
+}
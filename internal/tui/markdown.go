@@ -0,0 +1,60 @@
+package tui
+
+import (
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+)
+
+// markdownRenderer renders assistant replies as Markdown for the terminal.
+// Code blocks are syntax highlighted based on their fence language.
+type markdownRenderer struct {
+	renderer *glamour.TermRenderer
+	width    int
+}
+
+// markdownStyle returns the glamour style to use.
+// Set GLAMOUR_STYLE to override (e.g. "light", "dracula", "notty").
+func markdownStyle() string {
+	if style := os.Getenv("GLAMOUR_STYLE"); style != "" {
+		return style
+	}
+	if useASCIIBorder() {
+		return "ascii"
+	}
+	// Don't auto-detect: querying the terminal background conflicts with the alt screen
+	return "dark"
+}
+
+func newMarkdownRenderer(width int) *markdownRenderer {
+	if width < 20 {
+		width = 20
+	}
+
+	r, err := glamour.NewTermRenderer(
+		glamour.WithStylePath(markdownStyle()),
+		glamour.WithWordWrap(width),
+		glamour.WithEmoji(),
+	)
+	if err != nil {
+		// Fall back to plain text rendering
+		return &markdownRenderer{width: width}
+	}
+
+	return &markdownRenderer{renderer: r, width: width}
+}
+
+// Render converts Markdown to styled terminal output.
+// The original text is returned if rendering fails.
+func (r *markdownRenderer) Render(content string) string {
+	if r == nil || r.renderer == nil {
+		return content
+	}
+
+	out, err := r.renderer.Render(content)
+	if err != nil {
+		return content
+	}
+	return strings.Trim(out, "\n")
+}
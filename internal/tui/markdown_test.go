@@ -0,0 +1,82 @@
+package tui
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+const markdownReply = "Steps:\n\n" +
+	"- install the **dependencies**\n" +
+	"- run the tests\n\n" +
+	"```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```\n\n" +
+	"This paragraph is long enough that it has to be wrapped once the chat gets narrower than it is."
+
+// ansiCodes matches the SGR escapes glamour styles text with
+var ansiCodes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestMarkdownRendererRenders(t *testing.T) {
+	t.Setenv("GLAMOUR_STYLE", "dark")
+	out := ansiCodes.ReplaceAllString(newMarkdownRenderer(80).Render(markdownReply), "")
+
+	for _, raw := range []string{"```", "**", "- install"} {
+		if strings.Contains(out, raw) {
+			t.Errorf("Render() left %q in place:\n%s", raw, out)
+		}
+	}
+	for _, want := range []string{"install the dependencies", "func main() {", `fmt.Println("hi")`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Render() lost %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMarkdownRendererFallback(t *testing.T) {
+	for _, r := range []*markdownRenderer{nil, {width: 40}} {
+		if out := r.Render(markdownReply); out != markdownReply {
+			t.Errorf("Render() without a renderer = %q, want the plain text", out)
+		}
+	}
+}
+
+func TestChatMarkdownFollowsWidth(t *testing.T) {
+	t.Setenv("GLAMOUR_STYLE", "notty")
+	m := NewChatModel()
+	m.messages = []Message{{Role: RoleAssistant, Content: markdownReply}}
+
+	for _, width := range []int{100, 50} {
+		m.SetSize(width, 40)
+		for _, line := range strings.Split(m.renderMessages(), "\n") {
+			if w := lipgloss.Width(line); w > width {
+				t.Errorf("width %d: line is %d wide: %q", width, w, line)
+			}
+		}
+		if got := m.messages[0].renderedWidth; got != width-8 {
+			t.Errorf("width %d: rendered at %d, want %d", width, got, width-8)
+		}
+	}
+}
+
+func TestChatMarkdownOnlyForAssistant(t *testing.T) {
+	t.Setenv("GLAMOUR_STYLE", "notty")
+	m := NewChatModel()
+	m.SetSize(100, 40)
+	m.messages = []Message{
+		{Role: RoleUser, Content: "use **bold** here"},
+		{Role: RoleSystem, Content: "see `/help` and # not a heading"},
+	}
+
+	out := m.renderMessages()
+	for _, raw := range []string{"**bold**", "`/help`", "# not a heading"} {
+		if !strings.Contains(out, raw) {
+			t.Errorf("renderMessages() changed %q:\n%s", raw, out)
+		}
+	}
+	for _, msg := range m.messages {
+		if msg.rendered != "" {
+			t.Errorf("%s message was rendered as Markdown", msg.Role)
+		}
+	}
+}
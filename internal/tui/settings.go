@@ -1,74 +1,148 @@
 package tui
 
 import (
+	"context"
 	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/ppopcode/ppopcode/internal/agents"
 	"github.com/ppopcode/ppopcode/internal/config"
+	"github.com/ppopcode/ppopcode/internal/orchestrator"
 )
 
-// Available models for each agent type
-var claudeModels = []string{
-	"claude-opus-4-5-20251101",
-	"claude-sonnet-4-5-20250929",
-	"claude-haiku-4-5-20251001",
-	"claude-opus-4-1-20250801",
-	"claude-sonnet-4-20250514",
-}
-
-var openaiModels = []string{
-	"gpt-5.2",
-	"gpt-5.2-mini",
-	"gpt-5",
-	"gpt-5-mini",
-	"o3",
-	"o4-mini",
-}
-
-var geminiModels = []string{
-	"gemini-3-pro",
-	"gemini-3-flash",
-	"gemini-2.5-pro",
-	"gemini-2.5-flash",
-}
-
+// AgentSetting is an agent as edited in Settings. Changes are kept here
+// until they are saved.
 type AgentSetting struct {
 	Name         string
+	Original     string // name in the config, "" for a new agent
 	Type         string
 	CurrentModel string
 	Models       []string
 	ModelIndex   int
+	ModelsNote   string            // where the model list came from
+	Values       map[string]string // other edited settings, e.g. "max_tokens"
+	Removed      bool              // removed from the config file when saved
+}
+
+// setModels offers the models in list, keeping the current model
+func (a *AgentSetting) setModels(list agents.ModelList) {
+	models := list.Models
+	if a.CurrentModel != "" && !slices.Contains(models, a.CurrentModel) {
+		models = append([]string{a.CurrentModel}, models...)
+	}
+	a.Models = models
+	a.ModelIndex = max(slices.Index(models, a.CurrentModel), 0)
+
+	switch {
+	case list.Fetched:
+		a.ModelsNote = "Models from the Anthropic API"
+	case list.Err != nil:
+		a.ModelsNote = fmt.Sprintf("Built-in list (could not fetch models: %v)", list.Err)
+	default:
+		a.ModelsNote = "Built-in list"
+	}
+}
+
+// agentFormFields are the settings the agent form edits besides the
+// name, type, model and permission mode. Fields marked API or CLI only
+// apply to that kind of agent.
+var agentFormFields = []struct {
+	label, key, placeholder string
+	number                  bool
+}{
+	{"Max tokens", "max_tokens", "default", true},
+	{"Role", "role", "what the agent is for", false},
+	{"System prompt", "system_prompt", "extra instructions", false},
+	{"Base URL (API)", "base_url", "https://api.anthropic.com", false},
+	{"Max concurrent", "max_concurrent", "1", true},
+	{"Allowed tools (CLI)", "allowed_tools", "e.g. Read, Grep", false},
+	{"Disallowed tools (CLI)", "disallowed_tools", "e.g. Bash", false},
+	{"Extra dirs (CLI)", "add_dirs", "comma separated", false},
+	{"MCP config (CLI)", "mcp_config", "path to a JSON file", false},
 }
 
+// agentValues returns the settings of ac the agent form edits, as typed
+func agentValues(ac config.AgentConfig) map[string]string {
+	number := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	return map[string]string{
+		"max_tokens":       number(ac.MaxTokens),
+		"role":             ac.Role,
+		"system_prompt":    ac.SystemPrompt,
+		"base_url":         ac.BaseURL,
+		"max_concurrent":   number(ac.MaxConcurrent),
+		"permission_mode":  ac.PermissionMode,
+		"allowed_tools":    strings.Join(ac.AllowedTools, ", "),
+		"disallowed_tools": strings.Join(ac.DisallowedTools, ", "),
+		"add_dirs":         strings.Join(ac.AddDirs, ", "),
+		"mcp_config":       ac.MCPConfig,
+	}
+}
+
+// settingsMode is what the Settings keys act on
+type settingsMode int
+
+const (
+	settingsList       settingsMode = iota
+	settingsPickModel               // choosing the model of the selected agent
+	settingsAgentForm               // adding or editing an agent
+	settingsRoutesForm              // editing routes and fallbacks
+)
+
 type SettingsModel struct {
 	agents      []AgentSetting
 	cursor      int
 	width       int
 	height      int
 	config      *config.Config
-	configPath  string
+	target      config.Layer // config file changes are saved to
 	message     string
 	showMessage bool
-	editMode    bool // true when selecting model for current agent
-	modelCursor int  // cursor for model selection
+	mode        settingsMode
+	pickCursor  int  // cursor in the model list
+	fetching    bool // the model list is being fetched
+	saving      bool // keys are ignored until the save is done
+
+	// Forms check their values against the config before they are kept
+	form      *settingsForm
+	formIndex int               // agent the form edits, -1 for a new one
+	routing   map[string]string // edited routes and fallbacks by key
 }
 
 // SettingsSavedMsg is sent when settings are saved
 type SettingsSavedMsg struct {
 	Success bool
 	Message string
+	Config  *config.Config // the saved config, nil if nothing was written
+}
+
+// ModelsLoadedMsg carries the models fetched for an agent of a type
+type ModelsLoadedMsg struct {
+	Agent string
+	Type  string
+	List  agents.ModelList
 }
 
 func NewSettingsModel() *SettingsModel {
 	return &SettingsModel{
-		agents:     []AgentSetting{},
-		cursor:     0,
-		width:      80,
-		height:     24,
-		configPath: "config/ppopcode.yaml",
+		agents: []AgentSetting{},
+		cursor: 0,
+		width:  80,
+		height: 24,
+		target: config.LayerGlobal,
 	}
 }
 
@@ -76,54 +150,68 @@ func NewSettingsModelWithConfig(cfg *config.Config) *SettingsModel {
 	m := NewSettingsModel()
 	m.config = cfg
 	m.loadAgentsFromConfig()
+	m.target = defaultTarget(cfg)
 	return m
 }
 
+// defaultTarget saves to the project config if the project has one
+func defaultTarget(cfg *config.Config) config.Layer {
+	if path := cfg.LayerPath(config.LayerProject); path != "" {
+		if _, err := os.Stat(path); err == nil {
+			return config.LayerProject
+		}
+	}
+	return config.LayerGlobal
+}
+
+// loadAgentsFromConfig lists every agent in the config by name, offering
+// the built-in models until the list is fetched
 func (m *SettingsModel) loadAgentsFromConfig() {
 	if m.config == nil {
 		return
 	}
 
 	m.agents = []AgentSetting{}
+	names := make([]string, 0, len(m.config.Agents))
+	for name := range m.config.Agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	// Define order of agents
-	agentOrder := []string{"orchestrator", "sonnet", "gemini", "gpt"}
-
-	for _, name := range agentOrder {
-		ac, exists := m.config.Agents[name]
-		if !exists {
-			continue
-		}
-
-		var models []string
-		switch ac.Type {
-		case "claude":
-			models = claudeModels
-		case "openai":
-			models = openaiModels
-		case "gemini":
-			models = geminiModels
-		default:
-			continue
-		}
-
-		// Find current model index
-		modelIndex := 0
-		for i, model := range models {
-			if model == ac.Model {
-				modelIndex = i
-				break
-			}
-		}
-
-		m.agents = append(m.agents, AgentSetting{
+	for _, name := range names {
+		ac := m.config.Agents[name]
+		agent := AgentSetting{
 			Name:         name,
+			Original:     name,
 			Type:         ac.Type,
 			CurrentModel: ac.Model,
-			Models:       models,
-			ModelIndex:   modelIndex,
-		})
+		}
+		agent.setModels(agents.ModelList{Models: agents.DefaultModels(agents.AgentType(ac.Type))})
+		m.agents = append(m.agents, agent)
 	}
+	m.routing = nil
+	m.cursor = min(m.cursor, max(len(m.agents)-1, 0))
+	m.mode = settingsList
+}
+
+// fetchModels asks the agent's provider for its models
+func (m *SettingsModel) fetchModels(agent AgentSetting) tea.Cmd {
+	ac := agents.AgentConfig{Name: agent.Name}
+	if m.config != nil && agent.Original != "" {
+		ac = m.config.ToAgentConfigs()[agent.Original]
+	}
+	ac.Type = agents.AgentType(agent.Type)
+	m.fetching = true
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return ModelsLoadedMsg{Agent: agent.Name, Type: agent.Type, List: agents.ListModels(ctx, ac)}
+	}
+}
+
+// HasSubView reports whether the model picker or a form has the keys
+func (m *SettingsModel) HasSubView() bool {
+	return m.mode != settingsList
 }
 
 func (m *SettingsModel) Init() tea.Cmd {
@@ -131,7 +219,14 @@ func (m *SettingsModel) Init() tea.Cmd {
 }
 
 func (m *SettingsModel) loadConfig() tea.Msg {
-	cfg, err := config.Load(m.configPath)
+	var cfg *config.Config
+	var err error
+	if m.config != nil {
+		cfg, err = m.config.Reload()
+	} else {
+		workDir, _ := os.Getwd()
+		cfg, err = config.LoadLayers(config.DefaultLoadOptions(workDir))
+	}
 	if err != nil {
 		return SettingsSavedMsg{Success: false, Message: fmt.Sprintf("Failed to load config: %v", err)}
 	}
@@ -148,13 +243,43 @@ func (m *SettingsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case SettingsSavedMsg:
+		m.saving = false
+		if msg.Config != nil {
+			m.config = msg.Config
+			m.loadAgentsFromConfig()
+		}
 		m.message = msg.Message
 		m.showMessage = true
 		return m, nil
 
+	case ModelsLoadedMsg:
+		for i := range m.agents {
+			if m.agents[i].Name == msg.Agent && m.agents[i].Type == msg.Type {
+				m.agents[i].setModels(msg.List)
+				if m.mode == settingsPickModel && i == m.cursor {
+					m.fetching = false
+					m.pickCursor = m.agents[i].ModelIndex
+				}
+			}
+		}
+		if m.mode == settingsAgentForm && m.form.field("type").value() == msg.Type {
+			model := m.form.field("model")
+			offered := AgentSetting{CurrentModel: model.value()}
+			offered.setModels(msg.List)
+			model.setChoices(offered.Models)
+			m.fetching = false
+		}
+		return m, nil
+
 	case tea.KeyMsg:
-		if m.editMode {
-			return m.handleEditModeKeys(msg)
+		if m.saving {
+			return m, nil
+		}
+		switch m.mode {
+		case settingsPickModel:
+			return m.handlePickModelKeys(msg)
+		case settingsAgentForm, settingsRoutesForm:
+			return m.handleFormKeys(msg)
 		}
 		return m.handleNormalModeKeys(msg)
 	}
@@ -163,6 +288,7 @@ func (m *SettingsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m *SettingsModel) handleNormalModeKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.showMessage = false
 	switch {
 	case key.Matches(msg, DefaultKeyMap.Up):
 		if m.cursor > 0 {
@@ -173,58 +299,473 @@ func (m *SettingsModel) handleNormalModeKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd
 			m.cursor++
 		}
 	case key.Matches(msg, DefaultKeyMap.Enter):
+		if m.cursor < len(m.agents) && !m.agents[m.cursor].Removed {
+			m.mode = settingsPickModel
+			m.pickCursor = m.agents[m.cursor].ModelIndex
+			return m, m.fetchModels(m.agents[m.cursor])
+		}
+	case msg.String() == "a":
+		if m.config != nil {
+			return m, m.openAgentForm(-1)
+		}
+	case msg.String() == "e":
+		if m.config != nil && m.cursor < len(m.agents) && !m.agents[m.cursor].Removed {
+			return m, m.openAgentForm(m.cursor)
+		}
+	case msg.String() == "o":
+		if m.config != nil {
+			return m, m.openRoutesForm()
+		}
+	case msg.String() == "d":
+		// New agents are dropped; others are marked and removed on save
 		if m.cursor < len(m.agents) {
-			m.editMode = true
-			m.modelCursor = m.agents[m.cursor].ModelIndex
+			agent := &m.agents[m.cursor]
+			switch {
+			case agent.Original == "":
+				m.agents = slices.Delete(m.agents, m.cursor, m.cursor+1)
+				m.cursor = min(m.cursor, max(len(m.agents)-1, 0))
+			case agent.Removed:
+				agent.Removed = false
+			default:
+				agent.Removed = true
+				if refs := m.config.AgentReferences(agent.Original); len(refs) > 0 {
+					m.message = fmt.Sprintf("%s is used by %s; change them with o before saving", agent.Original, strings.Join(refs, ", "))
+					m.showMessage = true
+				}
+			}
 		}
 	case msg.String() == "s":
-		return m, m.saveConfig
+		return m, m.saveConfig()
+	case msg.String() == "t":
+		// Switch between the global and the project config file
+		if m.target == config.LayerGlobal && m.config != nil && m.config.LayerPath(config.LayerProject) != "" {
+			m.target = config.LayerProject
+		} else {
+			m.target = config.LayerGlobal
+		}
 	}
 	return m, nil
 }
 
-func (m *SettingsModel) handleEditModeKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+func (m *SettingsModel) handlePickModelKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	agent := &m.agents[m.cursor]
 
 	switch {
 	case key.Matches(msg, DefaultKeyMap.Up):
-		if m.modelCursor > 0 {
-			m.modelCursor--
+		if m.pickCursor > 0 {
+			m.pickCursor--
 		}
 	case key.Matches(msg, DefaultKeyMap.Down):
-		if m.modelCursor < len(agent.Models)-1 {
-			m.modelCursor++
+		if m.pickCursor < len(agent.Models)-1 {
+			m.pickCursor++
 		}
 	case key.Matches(msg, DefaultKeyMap.Enter):
-		// Select model
-		agent.ModelIndex = m.modelCursor
-		agent.CurrentModel = agent.Models[m.modelCursor]
-		m.editMode = false
+		if m.pickCursor < len(agent.Models) {
+			agent.ModelIndex = m.pickCursor
+			agent.CurrentModel = agent.Models[m.pickCursor]
+		}
+		m.mode = settingsList
 	case key.Matches(msg, DefaultKeyMap.Back):
-		m.editMode = false
+		m.mode = settingsList
 	}
 	return m, nil
 }
 
-func (m *SettingsModel) saveConfig() tea.Msg {
-	if m.config == nil {
-		return SettingsSavedMsg{Success: false, Message: "No config loaded"}
+// newAgentTypes are the types offered for new agents. Mock agents need a
+// fixture file, so they are left to the config file.
+func newAgentTypes() []string {
+	var types []string
+	for _, t := range config.AgentTypes() {
+		if t != string(agents.AgentTypeMock) {
+			types = append(types, t)
+		}
 	}
+	return types
+}
 
-	// Update config with new models
+// openAgentForm edits the agent at index, or a new agent if index is -1
+func (m *SettingsModel) openAgentForm(index int) tea.Cmd {
+	agent := AgentSetting{Type: string(agents.AgentTypeClaude)}
+	title := "New agent"
+	if index >= 0 {
+		agent = m.agents[index]
+		title = "Edit " + agent.Name
+	}
+	if agent.CurrentModel == "" {
+		if models := agents.DefaultModels(agents.AgentType(agent.Type)); len(models) > 0 {
+			agent.CurrentModel = models[0]
+		}
+	}
+	models := agent.Models
+	if len(models) == 0 {
+		models = agents.DefaultModels(agents.AgentType(agent.Type))
+	}
+
+	values := agentValues(m.config.Agents[agent.Original])
+	maps.Copy(values, agent.Values)
+	modes := []string{""}
+	for _, mode := range agents.PermissionModes {
+		modes = append(modes, string(mode))
+	}
+	fields := []*formField{
+		newTextField("Name", "name", agent.Name, "e.g. reviewer"),
+		newChoiceField("Type", "type", newAgentTypes(), agent.Type),
+		newChoiceField("Model", "model", models, agent.CurrentModel),
+	}
+	for _, field := range agentFormFields {
+		if field.number {
+			fields = append(fields, newNumberField(field.label, field.key, values[field.key], field.placeholder))
+		} else {
+			fields = append(fields, newTextField(field.label, field.key, values[field.key], field.placeholder))
+		}
+	}
+	fields = append(fields, newChoiceField("Permission mode (CLI)", "permission_mode", modes, values["permission_mode"]))
+
+	m.form = newSettingsForm(title, fields...)
+	m.formIndex = index
+	m.mode = settingsAgentForm
+	return tea.Batch(m.form.focus(0), m.fetchModels(agent))
+}
+
+// openRoutesForm edits the agent each task type goes to and the agents
+// each agent falls back to
+func (m *SettingsModel) openRoutesForm() tea.Cmd {
+	names := []string{}
 	for _, agent := range m.agents {
-		if ac, exists := m.config.Agents[agent.Name]; exists {
-			ac.Model = agent.CurrentModel
-			m.config.Agents[agent.Name] = ac
+		if !agent.Removed {
+			names = append(names, agent.Name)
 		}
 	}
 
-	// Save to file
-	if err := m.config.Save(m.configPath); err != nil {
-		return SettingsSavedMsg{Success: false, Message: fmt.Sprintf("Failed to save: %v", err)}
+	var fields []*formField
+	for _, taskType := range orchestrator.TaskTypes {
+		key := "routes." + string(taskType)
+		fields = append(fields, newChoiceField(string(taskType)+" requests go to", key, append([]string{""}, names...), m.routingValue(key)))
+	}
+	for _, name := range names {
+		key := "fallbacks." + name
+		fields = append(fields, newTextField(name+" falls back to", key, m.routingValue(key), "agents to try in order, comma separated"))
 	}
 
-	return SettingsSavedMsg{Success: true, Message: "Settings saved!"}
+	m.form = newSettingsForm("Routes and fallbacks", fields...)
+	m.mode = settingsRoutesForm
+	return m.form.focus(0)
+}
+
+// routingValue returns a route or fallback setting with unsaved edits
+func (m *SettingsModel) routingValue(key string) string {
+	if value, edited := m.routing[key]; edited {
+		return value
+	}
+	return m.configRouting(key)
+}
+
+// configRouting returns a route or fallback setting as it is in the
+// config, with the agents named as they are being edited
+func (m *SettingsModel) configRouting(key string) string {
+	names := make(map[string]string)
+	for _, agent := range m.agents {
+		if agent.Original != "" {
+			names[agent.Original] = agent.Name
+		}
+	}
+	rename := func(name string) string {
+		if renamed, ok := names[name]; ok {
+			return renamed
+		}
+		return name
+	}
+
+	section, name, _ := strings.Cut(key, ".")
+	if section == "routes" {
+		return rename(m.config.Routes[name])
+	}
+	for _, agent := range m.agents {
+		if agent.Name == name && agent.Original != "" {
+			var chain []string
+			for _, fallback := range m.config.Fallbacks[agent.Original] {
+				chain = append(chain, rename(fallback))
+			}
+			return strings.Join(chain, ", ")
+		}
+	}
+	return ""
+}
+
+func (m *SettingsModel) handleFormKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.form = nil
+		m.mode = settingsList
+		return m, nil
+	case tea.KeyEnter:
+		if m.mode == settingsAgentForm {
+			m.submitAgentForm()
+		} else {
+			m.submitRoutesForm()
+		}
+		return m, nil
+	}
+
+	// A new type offers that type's models
+	typeBefore := ""
+	if m.mode == settingsAgentForm {
+		typeBefore = m.form.field("type").value()
+	}
+	cmd := m.form.update(msg)
+	if agentType := m.form.field("type"); m.mode == settingsAgentForm && agentType.value() != typeBefore {
+		m.form.field("model").setChoices(agents.DefaultModels(agents.AgentType(agentType.value())))
+		agent := AgentSetting{Name: m.form.field("name").value(), Type: agentType.value()}
+		if m.formIndex >= 0 {
+			agent.Original = m.agents[m.formIndex].Original
+		}
+		return m, tea.Batch(cmd, m.fetchModels(agent))
+	}
+	return m, cmd
+}
+
+// submitAgentForm keeps the agent form's values if the config would be
+// valid with them, and lists the problems otherwise
+func (m *SettingsModel) submitAgentForm() {
+	form := m.form
+	form.problems = form.check()
+	name := form.field("name").value()
+	if problem := m.nameProblem(name, m.formIndex); problem != "" {
+		form.problems = append([]string{problem}, form.problems...)
+	}
+	if len(form.problems) > 0 {
+		return
+	}
+
+	agent := AgentSetting{}
+	if m.formIndex >= 0 {
+		agent = m.agents[m.formIndex]
+	}
+	previous := agent.Name
+	agent.Name = name
+	agent.Type = form.field("type").value()
+	agent.CurrentModel = form.field("model").value()
+	agent.Models = form.field("model").choices
+	agent.ModelIndex = form.field("model").choice
+
+	// Only settings that differ from the config are written
+	configured := agentValues(m.config.Agents[agent.Original])
+	agent.Values = make(map[string]string)
+	for _, field := range form.fields {
+		if value, editable := configured[field.key]; editable && field.value() != value {
+			agent.Values[field.key] = field.value()
+		}
+	}
+
+	list := slices.Clone(m.agents)
+	index := m.formIndex
+	if index < 0 {
+		list = append(list, agent)
+		index = len(list) - 1
+	} else {
+		list[index] = agent
+	}
+	routing := m.routing
+	if previous != "" && previous != name {
+		routing = renamedRouting(routing, previous, name)
+	}
+	if _, err := m.config.Check(m.edit(list, routing)); err != nil {
+		form.problems = configProblems(err)
+		return
+	}
+
+	m.agents, m.routing, m.cursor = list, routing, index
+	m.form = nil
+	m.mode = settingsList
+	m.message, m.showMessage = fmt.Sprintf("Kept the changes to %s; press s to save them", name), true
+}
+
+// submitRoutesForm keeps the routes form's values if the config would be
+// valid with them, and lists the problems otherwise
+func (m *SettingsModel) submitRoutesForm() {
+	form := m.form
+	routing := maps.Clone(m.routing)
+	if routing == nil {
+		routing = make(map[string]string)
+	}
+	for _, field := range form.fields {
+		value := field.value()
+		if strings.HasPrefix(field.key, "fallbacks.") {
+			var chain []string
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					chain = append(chain, name)
+				}
+			}
+			value = strings.Join(chain, ", ")
+		}
+		if value == m.configRouting(field.key) {
+			delete(routing, field.key)
+		} else {
+			routing[field.key] = value
+		}
+	}
+
+	if _, err := m.config.Check(m.edit(m.agents, routing)); err != nil {
+		form.problems = configProblems(err)
+		return
+	}
+	m.routing = routing
+	m.form = nil
+	m.mode = settingsList
+	m.message, m.showMessage = "Kept the routing changes; press s to save them", true
+}
+
+// renamedRouting returns routing with the agent from renamed to to
+func renamedRouting(routing map[string]string, from, to string) map[string]string {
+	renamed := make(map[string]string, len(routing))
+	for key, value := range routing {
+		if key == "fallbacks."+from {
+			key = "fallbacks." + to
+		}
+		if strings.HasPrefix(key, "routes.") && value == from {
+			value = to
+		}
+		if strings.HasPrefix(key, "fallbacks.") {
+			chain := strings.Split(value, ", ")
+			for i, name := range chain {
+				if name == from {
+					chain[i] = to
+				}
+			}
+			value = strings.Join(chain, ", ")
+		}
+		renamed[key] = value
+	}
+	return renamed
+}
+
+// nameProblem describes what is wrong with the name of the agent at
+// index, -1 for a new agent, or returns ""
+func (m *SettingsModel) nameProblem(name string, index int) string {
+	if name == "" || strings.ContainsAny(name, ". \t") {
+		return "Agent names cannot be empty or contain spaces or dots"
+	}
+	for i, agent := range m.agents {
+		if agent.Name == name && i != index && !agent.Removed {
+			return "There is already an agent called " + name
+		}
+	}
+	return ""
+}
+
+// edit collects the changes in list and routing that differ from the
+// config into an edit of the target file
+func (m *SettingsModel) edit(list []AgentSetting, routing map[string]string) config.Edit {
+	edit := config.Edit{Layer: m.target, Rename: make(map[string]string), Set: make(map[string]string)}
+	for _, agent := range list {
+		if agent.Removed {
+			if agent.Original != "" {
+				edit.Remove = append(edit.Remove, agent.Original)
+			}
+			// Its own fallbacks go with it
+			if _, exists := m.config.Fallbacks[agent.Original]; exists {
+				edit.Set["fallbacks."+agent.Original] = ""
+			}
+			continue
+		}
+		if agent.Original != "" && agent.Name != agent.Original {
+			edit.Rename[agent.Original] = agent.Name
+		}
+		prefix := "agents." + agent.Name + "."
+		ac, exists := m.config.Agents[agent.Original]
+		if !exists || ac.Type != agent.Type {
+			edit.Set[prefix+"type"] = agent.Type
+		}
+		if agent.CurrentModel != "" && (!exists || ac.Model != agent.CurrentModel) {
+			edit.Set[prefix+"model"] = agent.CurrentModel
+		}
+		for key, value := range agent.Values {
+			edit.Set[prefix+key] = value
+		}
+	}
+	maps.Copy(edit.Set, routing)
+	return edit
+}
+
+// saveConfig collects the edits and returns a command that writes them.
+// The command only reads what is collected here; Update shows the saved
+// config when it is done.
+func (m *SettingsModel) saveConfig() tea.Cmd {
+	if m.config == nil {
+		return func() tea.Msg { return SettingsSavedMsg{Success: false, Message: "No config loaded"} }
+	}
+	edit := m.edit(m.agents, m.routing)
+	if edit.IsZero() {
+		return func() tea.Msg { return SettingsSavedMsg{Success: true, Message: "No changes to save"} }
+	}
+	current, target := m.config, m.target
+	m.saving = true
+	return func() tea.Msg {
+		// The edit is checked before anything is written
+		cfg, err := current.Apply(edit)
+		if err != nil {
+			return SettingsSavedMsg{Success: false, Message: fmt.Sprintf("Not saved: %s", summarizeConfigError(err))}
+		}
+		return SettingsSavedMsg{Success: true, Message: savedMessage(current, cfg, edit, target), Config: cfg}
+	}
+}
+
+// savedMessage describes a saved edit that turned before into cfg. Saving
+// drops plaintext API keys from the file, a lower layer may still define a
+// removed agent, and a higher layer still wins over a saved value.
+func savedMessage(before, cfg *config.Config, edit config.Edit, target config.Layer) string {
+	for _, name := range slices.Sorted(maps.Keys(before.Agents)) {
+		if before.Agents[name].APIKey != "" && before.Origin("agents."+name+".api_key") == target {
+			return fmt.Sprintf("Saved to %s config and removed the plaintext api_key of %s; set api_key_env, api_key_file or api_key_secret instead", target, name)
+		}
+	}
+	for _, name := range edit.Remove {
+		if _, exists := cfg.Agents[name]; exists {
+			source := cfg.Origin("agents."+name).String() + " config"
+			if cfg.Origin("agents."+name) == config.LayerDefault {
+				source = "the built-in defaults"
+			}
+			return fmt.Sprintf("Removed %s from the %s config, but it is still defined by %s", name, target, source)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(edit.Set)) {
+		origin := cfg.Origin(key)
+		switch {
+		case edit.Set[key] != "" && origin > target:
+			return fmt.Sprintf("Saved to %s config, but %s is overridden by %s", target, key, origin)
+		case edit.Set[key] == "" && origin != config.LayerDefault:
+			return fmt.Sprintf("Cleared %s in the %s config, but it is still set by the %s config", key, target, origin)
+		}
+	}
+	return fmt.Sprintf("Settings saved to %s config!", target)
+}
+
+// writeMessage shows the result of the last action
+func (m *SettingsModel) writeMessage(b *strings.Builder) {
+	if m.showMessage && m.message != "" {
+		msgStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#10B981")).Bold(true)
+		b.WriteString(msgStyle.Render(m.message))
+		b.WriteString("\n\n")
+	}
+}
+
+// writePicker lists choices with the cursor and the current choice marked
+func writePicker(b *strings.Builder, choices []string, cursor int, current string) {
+	for i, choice := range choices {
+		prefix := "  "
+		style := normalStyle
+		if i == cursor {
+			prefix = "▸ "
+			style = selectedStyle
+		}
+		suffix := ""
+		if choice == current {
+			suffix = " (current)"
+		}
+		b.WriteString(style.Render(prefix + choice + suffix))
+		b.WriteString("\n")
+	}
 }
 
 func (m *SettingsModel) SetSize(width, height int) {
@@ -232,9 +773,18 @@ func (m *SettingsModel) SetSize(width, height int) {
 	m.height = height
 }
 
+// SetConfig shows a reloaded config. Unsaved edits are kept; they are
+// compared with the new config when saved.
 func (m *SettingsModel) SetConfig(cfg *config.Config) {
 	m.config = cfg
-	m.loadAgentsFromConfig()
+	if m.mode == settingsList && !m.hasChanges() {
+		m.loadAgentsFromConfig()
+	}
+}
+
+// hasChanges reports whether there are unsaved edits
+func (m *SettingsModel) hasChanges() bool {
+	return !m.edit(m.agents, m.routing).IsZero()
 }
 
 func (m *SettingsModel) View() string {
@@ -246,42 +796,44 @@ func (m *SettingsModel) View() string {
 	b.WriteString("\n\n")
 
 	// Subtitle
-	subtitle := mutedStyle.Render("Configure AI models for each agent")
+	subtitle := mutedStyle.Render("Configure agents, their models and how requests are routed")
 	b.WriteString(subtitle)
-	b.WriteString("\n\n")
-
-	if len(m.agents) == 0 {
-		b.WriteString(mutedStyle.Render("Loading configuration..."))
+	b.WriteString("\n")
+	if m.config != nil {
+		b.WriteString(mutedStyle.Render(fmt.Sprintf("Saving to: %s (%s)", m.target, m.config.LayerPath(m.target))))
 		b.WriteString("\n")
-	} else if m.editMode {
-		// Model selection mode
-		agent := m.agents[m.cursor]
-		b.WriteString(selectedStyle.Render(fmt.Sprintf("Select model for %s:", agent.Name)))
-		b.WriteString("\n\n")
-
-		for i, model := range agent.Models {
-			cursor := "  "
-			style := normalStyle
-
-			if i == m.modelCursor {
-				cursor = "▸ "
-				style = selectedStyle
-			}
-
-			// Mark current model
-			current := ""
-			if model == agent.CurrentModel {
-				current = " (current)"
-			}
+	}
+	b.WriteString("\n")
 
-			b.WriteString(style.Render(fmt.Sprintf("%s%s%s", cursor, model, current)))
+	switch {
+	case m.mode == settingsAgentForm || m.mode == settingsRoutesForm:
+		m.form.view(&b)
+		if m.mode == settingsAgentForm && m.fetching {
 			b.WriteString("\n")
+			b.WriteString(mutedStyle.Render("Fetching models..."))
 		}
 
+	case m.mode == settingsPickModel:
+		agent := m.agents[m.cursor]
+		b.WriteString(selectedStyle.Render(fmt.Sprintf("Select model for %s:", agent.Name)))
 		b.WriteString("\n")
-		help := helpStyle.Render("↑/↓: navigate • enter: select • esc: cancel")
-		b.WriteString(help)
-	} else {
+		note := agent.ModelsNote
+		if m.fetching {
+			note = "Fetching models..."
+		}
+		b.WriteString(mutedStyle.Render(note))
+		b.WriteString("\n\n")
+		writePicker(&b, agent.Models, m.pickCursor, agent.CurrentModel)
+		b.WriteString("\n")
+		b.WriteString(helpStyle.Render("↑/↓: navigate • enter: select • esc: cancel"))
+
+	case len(m.agents) == 0:
+		b.WriteString(mutedStyle.Render("No agents configured. Press a to add one."))
+		b.WriteString("\n\n")
+		m.writeMessage(&b)
+		b.WriteString(helpStyle.Render("a: add agent • esc: back"))
+
+	default:
 		// Agent list mode
 		for i, agent := range m.agents {
 			cursor := "  "
@@ -295,21 +847,34 @@ func (m *SettingsModel) View() string {
 			// Agent type icon
 			icon := "🤖"
 			switch agent.Type {
-			case "claude":
+			case "claude", "claude-api":
 				icon = "🟣"
-			case "openai":
-				icon = "🟢"
-			case "gemini":
-				icon = "🔵"
 			}
 
-			// Title line
+			// Title line with pending changes
 			titleLine := fmt.Sprintf("%s%s %s", cursor, icon, agent.Name)
+			switch {
+			case agent.Removed:
+				titleLine += " [remove]"
+			case agent.Original == "":
+				titleLine += " [new " + agent.Type + "]"
+			case agent.Name != agent.Original:
+				titleLine += " [renamed from " + agent.Original + "]"
+			case len(agent.Values) > 0 || agent.Type != m.config.Agents[agent.Original].Type:
+				titleLine += " [edited]"
+			}
 			b.WriteString(style.Render(titleLine))
 			b.WriteString("\n")
 
 			// Model line
 			modelLine := fmt.Sprintf("    Model: %s", agent.CurrentModel)
+			if m.config != nil {
+				if ac, exists := m.config.Agents[agent.Name]; exists && ac.Model == agent.CurrentModel && agent.Name == agent.Original {
+					modelLine += fmt.Sprintf(" [%s]", m.config.Origin("agents."+agent.Name+".model"))
+				} else {
+					modelLine += " [unsaved]"
+				}
+			}
 			if i == m.cursor {
 				b.WriteString(accentStyle.Render(modelLine))
 			} else {
@@ -318,15 +883,14 @@ func (m *SettingsModel) View() string {
 			b.WriteString("\n\n")
 		}
 
-		// Message
-		if m.showMessage && m.message != "" {
-			msgStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#10B981")).Bold(true)
-			b.WriteString(msgStyle.Render(m.message))
+		if len(m.routing) > 0 {
+			b.WriteString(mutedStyle.Render(fmt.Sprintf("%d unsaved routing change(s)", len(m.routing))))
 			b.WriteString("\n\n")
 		}
+		m.writeMessage(&b)
 
 		// Help
-		help := helpStyle.Render("↑/↓: navigate • enter: change model • s: save • esc: back")
+		help := helpStyle.Render("↑/↓: navigate • enter: change model • e: edit • a: add • d: remove\no: routes and fallbacks • t: save target • s: save • esc: back")
 		b.WriteString(help)
 	}
 
@@ -0,0 +1,208 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ppopcode/ppopcode/internal/config"
+)
+
+var formProblemStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444"))
+
+// notSet shows an empty choice
+const notSet = "(not set)"
+
+// formField is one setting in a settings form
+type formField struct {
+	label   string
+	key     string   // setting the field edits, e.g. "max_tokens"
+	choices []string // values ←/→ cycle through; nil for typed values
+	choice  int
+	input   textinput.Model
+	number  bool   // must be a whole number
+	initial string // value when the form was opened
+}
+
+// newTextField makes a typed field. Lists are typed comma separated.
+func newTextField(label, key, value, placeholder string) *formField {
+	ti := textinput.New()
+	ti.Placeholder = placeholder
+	ti.CharLimit = 500
+	ti.SetValue(value)
+	return &formField{label: label, key: key, input: ti, initial: value}
+}
+
+// newNumberField makes a typed field for a whole number
+func newNumberField(label, key, value, placeholder string) *formField {
+	f := newTextField(label, key, value, placeholder)
+	f.number = true
+	return f
+}
+
+// newChoiceField makes a field that cycles through choices. A value that
+// is not one of them is offered as well.
+func newChoiceField(label, key string, choices []string, value string) *formField {
+	if !slices.Contains(choices, value) {
+		choices = append([]string{value}, choices...)
+	}
+	return &formField{label: label, key: key, choices: choices, choice: slices.Index(choices, value), initial: value}
+}
+
+// value returns the field's current value
+func (f *formField) value() string {
+	if f.choices != nil {
+		return f.choices[f.choice]
+	}
+	return strings.TrimSpace(f.input.Value())
+}
+
+// setChoices replaces the choices, keeping the current value if it is one
+func (f *formField) setChoices(choices []string) {
+	value := f.value()
+	f.choices = choices
+	f.choice = max(slices.Index(choices, value), 0)
+}
+
+// settingsForm edits several settings at once. Enter and Esc are left to
+// the caller, which checks the values before accepting them.
+type settingsForm struct {
+	title    string
+	fields   []*formField
+	cursor   int
+	problems []string // why the values were not accepted
+}
+
+// newSettingsForm makes a form; focus(0) puts the cursor on its first field
+func newSettingsForm(title string, fields ...*formField) *settingsForm {
+	return &settingsForm{title: title, fields: fields}
+}
+
+// field returns the field editing key, or nil
+func (f *settingsForm) field(key string) *formField {
+	for _, field := range f.fields {
+		if field.key == key {
+			return field
+		}
+	}
+	return nil
+}
+
+// focus moves the cursor to field i
+func (f *settingsForm) focus(i int) tea.Cmd {
+	if len(f.fields) == 0 {
+		return nil
+	}
+	f.fields[f.cursor].input.Blur()
+	f.cursor = (i + len(f.fields)) % len(f.fields)
+	if f.fields[f.cursor].choices == nil {
+		return f.fields[f.cursor].input.Focus()
+	}
+	return nil
+}
+
+// update moves between fields with ↑/↓ or Tab, cycles choices with ←/→
+// and passes other keys to the focused input
+func (f *settingsForm) update(msg tea.KeyMsg) tea.Cmd {
+	if len(f.fields) == 0 {
+		return nil
+	}
+	field := f.fields[f.cursor]
+	switch {
+	case msg.Type == tea.KeyUp || msg.Type == tea.KeyShiftTab:
+		return f.focus(f.cursor - 1)
+	case msg.Type == tea.KeyDown || msg.Type == tea.KeyTab:
+		return f.focus(f.cursor + 1)
+	case field.choices != nil:
+		switch msg.Type {
+		case tea.KeyLeft:
+			field.choice = (field.choice - 1 + len(field.choices)) % len(field.choices)
+		case tea.KeyRight, tea.KeySpace:
+			field.choice = (field.choice + 1) % len(field.choices)
+		}
+		return nil
+	}
+	var cmd tea.Cmd
+	field.input, cmd = field.input.Update(msg)
+	return cmd
+}
+
+// check reports values that cannot be saved as typed
+func (f *settingsForm) check() []string {
+	var problems []string
+	for _, field := range f.fields {
+		if !field.number || field.value() == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(field.value()); err != nil || n < 0 {
+			problems = append(problems, fmt.Sprintf("%s must be a whole number", field.label))
+		}
+	}
+	return problems
+}
+
+// configProblems lists the problems in a Check error, without the file
+// names, which would all be the file being saved to
+func configProblems(err error) []string {
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) {
+		return []string{err.Error()}
+	}
+	problems := make([]string, len(invalid.Problems))
+	for i, p := range invalid.Problems {
+		problems[i] = p.Message
+		if p.Key != "" {
+			problems[i] = p.Key + ": " + p.Message
+		}
+	}
+	return problems
+}
+
+func (f *settingsForm) view(b *strings.Builder) {
+	b.WriteString(selectedStyle.Render(f.title))
+	b.WriteString("\n\n")
+
+	width := 0
+	for _, field := range f.fields {
+		width = max(width, lipgloss.Width(field.label))
+	}
+	for i, field := range f.fields {
+		prefix := "  "
+		style := normalStyle
+		if i == f.cursor {
+			prefix = "▸ "
+			style = selectedStyle
+		}
+		label := style.Render(fmt.Sprintf("%s%-*s  ", prefix, width, field.label))
+		var value string
+		switch {
+		case field.choices == nil:
+			value = field.input.View()
+		case field.value() == "":
+			value = mutedStyle.Render("‹ " + notSet + " ›")
+		default:
+			value = accentStyle.Render("‹ " + field.value() + " ›")
+		}
+		b.WriteString(label + value + "\n")
+	}
+
+	if len(f.problems) > 0 {
+		b.WriteString("\n")
+		const shown = 5
+		for _, problem := range f.problems[:min(len(f.problems), shown)] {
+			b.WriteString(formProblemStyle.Render("✗ " + problem))
+			b.WriteString("\n")
+		}
+		if len(f.problems) > shown {
+			b.WriteString(formProblemStyle.Render(fmt.Sprintf("  and %d more", len(f.problems)-shown)))
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("↑/↓/tab: move • ←/→: change choice • enter: check and keep • esc: cancel"))
+}
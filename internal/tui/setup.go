@@ -69,6 +69,12 @@ func (m *SetupModel) checkStatus() tea.Msg {
 	}
 }
 
+// recheckStatus checks the Claude login again instead of using the cached result
+func (m *SetupModel) recheckStatus() tea.Msg {
+	<-agents.RecheckClaudeLogin()
+	return m.checkStatus()
+}
+
 func (m *SetupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
@@ -102,7 +108,7 @@ func (m *SetupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case autoRefreshMsg:
 		if m.waitingForLogin {
-			return m, m.checkStatus
+			return m, m.recheckStatus
 		}
 		return m, nil
 
@@ -111,9 +117,9 @@ func (m *SetupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.success {
 			m.waitingForLogin = true
 			// Start auto-refresh cycle
-			return m, tea.Batch(m.checkStatus, m.scheduleAutoRefresh())
+			return m, tea.Batch(m.recheckStatus, m.scheduleAutoRefresh())
 		}
-		return m, m.checkStatus
+		return m, m.recheckStatus
 
 	case tea.KeyMsg:
 		switch {
@@ -133,7 +139,7 @@ func (m *SetupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Refresh status
 			m.loading = true
 			m.waitingForLogin = false // Manual refresh stops auto-refresh
-			return m, m.checkStatus
+			return m, m.recheckStatus
 		}
 	}
 
@@ -159,6 +165,9 @@ func (m *SetupModel) buildItems(claudeStatus agents.ClaudeLoginStatus, cursorSta
 			claudeItem.Status = "Ready"
 			claudeItem.StatusOK = true
 			claudeItem.Action = "Logged in"
+			if claudeStatus.Account != "" {
+				claudeItem.Action = "Logged in as " + claudeStatus.Account
+			}
 		} else {
 			claudeItem.Status = "Not logged in"
 			claudeItem.StatusOK = false
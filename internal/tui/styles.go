@@ -80,4 +80,8 @@ var (
 	helpStyle = lipgloss.NewStyle().
 			Foreground(mutedColor).
 			MarginTop(1)
+
+	systemMessageStyle = lipgloss.NewStyle().
+				Foreground(mutedColor).
+				PaddingLeft(2)
 )
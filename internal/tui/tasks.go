@@ -0,0 +1,80 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ppopcode/ppopcode/internal/orchestrator"
+)
+
+// maxTaskContent is the width task content is cut to in the task tree
+const maxTaskContent = 60
+
+func cmdTasks(m *ChatModel, _ []string) tea.Cmd {
+	if m.orchestrator == nil {
+		m.addSystemMessage("No orchestrator configured.")
+		return nil
+	}
+
+	tasks := m.orchestrator.Tasks(orchestrator.TaskFilter{Since: m.sessionStart})
+	if len(tasks) == 0 {
+		m.addSystemMessage("No tasks in this session yet.")
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("Tasks:")
+	for _, task := range tasks {
+		b.WriteString("\n  " + formatTask(task))
+		writeSubtasks(&b, task.Subtasks, "  ")
+	}
+	m.addSystemMessage(b.String())
+	return nil
+}
+
+// writeSubtasks adds one tree line per subtask below its parent
+func writeSubtasks(b *strings.Builder, subtasks []*orchestrator.Task, indent string) {
+	branch, last, pipe := "├─ ", "└─ ", "│  "
+	if useASCIIBorder() {
+		branch, last, pipe = "|- ", "`- ", "|  "
+	}
+
+	for i, sub := range subtasks {
+		prefix, childIndent := branch, indent+pipe
+		if i == len(subtasks)-1 {
+			prefix, childIndent = last, indent+"   "
+		}
+		b.WriteString("\n" + indent + prefix + formatTask(sub))
+		writeSubtasks(b, sub.Subtasks, childIndent)
+	}
+}
+
+// formatTask describes a task on one line
+func formatTask(t *orchestrator.Task) string {
+	name := t.ID
+	if t.Stage != "" {
+		name = fmt.Sprintf("%s %s", t.Stage, t.ID)
+	}
+
+	agent := t.AssignedTo
+	if t.Model != "" {
+		agent = fmt.Sprintf("%s (%s)", agent, t.Model)
+	}
+
+	if len(t.Failed) > 0 {
+		agent += " after " + strings.Join(t.Failed, ", ") + " failed"
+	}
+
+	details := []string{t.Status, agent, t.Duration().Round(100 * time.Millisecond).String()}
+	if !t.Usage.IsZero() {
+		details = append(details, t.Usage.String())
+	}
+
+	content := strings.Join(strings.Fields(t.Content), " ")
+	if len([]rune(content)) > maxTaskContent {
+		content = truncateRunes(content, maxTaskContent-3) + "..."
+	}
+	return fmt.Sprintf("%s [%s] %s", name, strings.Join(details, ", "), content)
+}
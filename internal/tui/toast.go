@@ -0,0 +1,119 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ppopcode/ppopcode/internal/budget"
+	"github.com/ppopcode/ppopcode/internal/config"
+)
+
+// toastDuration is how long a toast stays on screen
+const toastDuration = 5 * time.Second
+
+var (
+	toastStyle      = lipgloss.NewStyle().Foreground(secondaryColor).PaddingLeft(2)
+	toastErrorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).PaddingLeft(2)
+)
+
+// toast is a short notice shown below the current view
+type toast struct {
+	id      int
+	message string
+	isError bool
+}
+
+// toastExpiredMsg hides the toast with the given id, unless a newer one
+// replaced it
+type toastExpiredMsg struct{ id int }
+
+// ConfigReloadedMsg is sent when the watched config files change
+type ConfigReloadedMsg config.Reloaded
+
+// showToast shows message and returns the command that hides it again
+func (a *App) showToast(message string, isError bool) tea.Cmd {
+	a.toast = toast{id: a.toast.id + 1, message: message, isError: isError}
+	id := a.toast.id
+	return tea.Tick(toastDuration, func(time.Time) tea.Msg {
+		return toastExpiredMsg{id: id}
+	})
+}
+
+func (a *App) renderToast() string {
+	if a.toast.message == "" {
+		return ""
+	}
+	if a.toast.isError {
+		return toastErrorStyle.Render("✗ " + a.toast.message)
+	}
+	return toastStyle.Render("✓ " + a.toast.message)
+}
+
+// WatchConfig applies config changes from reloads to the running
+// orchestrator. System prompt files are read relative to workDir.
+func (a *App) WatchConfig(reloads <-chan config.Reloaded, workDir string) {
+	a.reloads = reloads
+	a.workDir = workDir
+}
+
+// waitForReload waits for the next config change
+func waitForReload(reloads <-chan config.Reloaded) tea.Cmd {
+	if reloads == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		reloaded, ok := <-reloads
+		if !ok {
+			return nil
+		}
+		return ConfigReloadedMsg(reloaded)
+	}
+}
+
+// applyConfig swaps the reloaded agents, fallbacks and pipeline into the
+// orchestrator. A config that fails to load keeps the current one running.
+func (a *App) applyConfig(msg ConfigReloadedMsg) tea.Cmd {
+	if a.orchestrator == nil {
+		return nil
+	}
+	if msg.Err != nil {
+		return a.showToast("Config not reloaded: "+summarizeConfigError(msg.Err), true)
+	}
+	settings, err := msg.Config.ToSettings(a.workDir)
+	if err != nil {
+		return a.showToast("Config not reloaded: "+err.Error(), true)
+	}
+	result, err := a.orchestrator.Reconfigure(settings)
+	if err != nil {
+		return a.showToast("Config not reloaded: "+err.Error(), true)
+	}
+	a.config = msg.Config
+	a.settings.SetConfig(msg.Config)
+
+	// Budgets are not swapped; say so rather than imply they were
+	message := "Config reloaded: " + result.String()
+	var running budget.Limits
+	if tracker := a.orchestrator.Budget(); tracker != nil {
+		running = tracker.Limits()
+	}
+	if !running.Equal(msg.Config.ToBudgetLimits()) {
+		message += "; budget changes take effect after a restart"
+	}
+	return a.showToast(message, false)
+}
+
+// summarizeConfigError fits a config error on one line
+func summarizeConfigError(err error) string {
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Problems) == 0 {
+		return err.Error()
+	}
+	summary := invalid.Problems[0].String()
+	if more := len(invalid.Problems) - 1; more > 0 {
+		summary += fmt.Sprintf(" (and %d more, see 'ppopcode config validate')", more)
+	}
+	return summary
+}
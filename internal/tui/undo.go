@@ -0,0 +1,127 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ppopcode/ppopcode/internal/snapshot"
+)
+
+// snapshotLabel is the one-line description of an edit kept with its
+// snapshot
+func snapshotLabel(source, prompt string) string {
+	prompt, _, _ = strings.Cut(strings.TrimSpace(prompt), "\n")
+	return source + ": " + truncateLine(prompt, 72)
+}
+
+// beginSnapshot records the working tree before an edit. It returns nil
+// when snapshots are off, and nil with a notice when the snapshot failed.
+func beginSnapshot(h *snapshot.History, label string) (*snapshot.Snapshot, string) {
+	if h == nil {
+		return nil, ""
+	}
+	snap, err := h.Begin(label)
+	if err != nil {
+		return nil, fmt.Sprintf("Could not snapshot the working tree for /undo: %v", err)
+	}
+	return snap, ""
+}
+
+// finishSnapshot records the files an edit changed and returns a notice
+// saying how to undo them, or "" if it changed none
+func finishSnapshot(h *snapshot.History, snap *snapshot.Snapshot) string {
+	if snap == nil {
+		return ""
+	}
+	if err := h.Finish(snap); err != nil {
+		return fmt.Sprintf("Could not record the changes for /undo: %v", err)
+	}
+	if !snap.Changed() {
+		return ""
+	}
+	return fmt.Sprintf("Changed %s. /undo reverts this.", snap.Summary())
+}
+
+// SetSnapshots snapshots the working tree before each request and Cursor
+// edit so /undo can restore it. nil takes no snapshots.
+func (m *ChatModel) SetSnapshots(h *snapshot.History) {
+	m.snapshots = h
+}
+
+// turnSnapshotMsg carries the snapshot taken before a request, which is
+// sent once it arrives
+type turnSnapshotMsg struct {
+	snap   *snapshot.Snapshot
+	notice string
+	prompt string // to send
+}
+
+// turnChangesMsg reports the files a finished request changed
+type turnChangesMsg struct {
+	notice string
+}
+
+// beginTurnSnapshot snapshots the working tree before a request. Git can
+// take a while in a large tree, so it runs outside Update and the request
+// is sent when the turnSnapshotMsg arrives.
+func (m *ChatModel) beginTurnSnapshot(label, prompt string) tea.Cmd {
+	h := m.snapshots
+	return func() tea.Msg {
+		snap, notice := beginSnapshot(h, snapshotLabel("chat", label))
+		return turnSnapshotMsg{snap: snap, notice: notice, prompt: prompt}
+	}
+}
+
+// finishTurnSnapshot reports the files the finished request changed, in
+// a turnChangesMsg
+func (m *ChatModel) finishTurnSnapshot() tea.Cmd {
+	h, snap := m.snapshots, m.snapshot
+	m.snapshot = nil
+	if snap == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		return turnChangesMsg{notice: finishSnapshot(h, snap)}
+	}
+}
+
+func cmdUndo(m *ChatModel, args []string) tea.Cmd {
+	if len(args) > 1 || len(args) == 1 && args[0] != "list" && args[0] != "force" {
+		m.addSystemMessage("Usage: /undo [list|force]")
+		return nil
+	}
+	if m.snapshots == nil {
+		m.addSystemMessage("Undo needs a git repository, and undo.disabled must not be set.")
+		return nil
+	}
+	list := m.snapshots.List()
+	if len(list) == 0 {
+		m.addSystemMessage("Nothing to undo.")
+		return nil
+	}
+
+	if len(args) == 1 && args[0] == "list" {
+		var b strings.Builder
+		b.WriteString("Edits that can be undone, newest first:")
+		for _, s := range list {
+			fmt.Fprintf(&b, "\n  %s  %s\n      %s", s.Time.Format("Jan 2 15:04"), s.Label, s.Summary())
+		}
+		m.addSystemMessage(b.String())
+		return nil
+	}
+
+	s := list[0]
+	err := m.snapshots.Undo(s, len(args) == 1)
+	var changed *snapshot.ChangedError
+	switch {
+	case errors.As(err, &changed):
+		m.addSystemMessage(fmt.Sprintf("Not undone: %v. /undo force restores them anyway.", err))
+	case err != nil:
+		m.addSystemMessage(fmt.Sprintf("Undo failed: %v", err))
+	default:
+		m.addSystemMessage(fmt.Sprintf("Undid %s. Restored %s.", s.Label, s.Summary()))
+	}
+	return nil
+}
@@ -16,6 +16,9 @@ import (
 	"github.com/ppopcode/ppopcode/internal/workflow"
 )
 
+// workflowDir is where cc-wf-studio saves workflow files
+const workflowDir = ".vscode/workflows"
+
 // WorkflowItemType distinguishes special items from regular workflow files
 type WorkflowItemType int
 
@@ -96,7 +99,6 @@ func NewWorkflowModel() *WorkflowModel {
 		itemType: WorkflowTypeMakeNew,
 	})
 
-	workflowDir := ".vscode/workflows"
 	if entries, err := os.ReadDir(workflowDir); err == nil {
 		for _, entry := range entries {
 			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
@@ -3,6 +3,7 @@ package tui
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,7 +15,9 @@ import (
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/ppopcode/ppopcode/internal/agents"
 	"github.com/ppopcode/ppopcode/internal/orchestrator"
+	"github.com/ppopcode/ppopcode/internal/snapshot"
 	"github.com/ppopcode/ppopcode/internal/workflow"
 )
 
@@ -36,6 +39,8 @@ type NodeDisplayItem struct {
 	Type   string
 	Status NodeStatus
 	Output string
+	// Snapshot holds the files the node changed, until they are undone
+	Snapshot *snapshot.Snapshot
 }
 
 // WorkflowRunModel handles the workflow execution UI
@@ -45,6 +50,10 @@ type WorkflowRunModel struct {
 	nodes        []NodeDisplayItem
 	currentNode  int
 	output       strings.Builder
+	activity     []outputActivity // tool calls shown between output lines
+	showTools    bool             // expand tool activity to one line per call
+	snapshots    *snapshot.History
+	selected     int // node whose edits u undoes once the run is over
 	progressChan <-chan workflow.ExecutionProgress
 	ctx          context.Context
 	cancel       context.CancelFunc
@@ -61,6 +70,7 @@ type WorkflowRunModel struct {
 	completed bool
 	errMsg    string
 	startTime time.Time
+	usage     agents.Usage // total usage of prompt nodes in this run
 
 	// askUserQuestion support
 	waitingInput bool
@@ -92,13 +102,29 @@ type WorkflowCheckpoint struct {
 	SavedAt       time.Time              `json:"saved_at"`
 	StartedAt     time.Time              `json:"started_at"`
 	ElapsedBefore time.Duration          `json:"elapsed_before"`
+	Usage         agents.Usage           `json:"usage"`
+	Activity      []ActivityCheckpoint   `json:"activity,omitempty"`
+}
+
+// ActivityCheckpoint represents saved tool activity at an output offset
+type ActivityCheckpoint struct {
+	Offset int                `json:"offset"`
+	Tools  []agents.ToolEvent `json:"tools"`
+}
+
+// outputActivity is a block of consecutive tool calls, shown at offset
+// in the output
+type outputActivity struct {
+	offset int
+	tools  toolActivity
 }
 
 // NodeCheckpointState represents a node's saved state
 type NodeCheckpointState struct {
-	ID     string     `json:"id"`
-	Status NodeStatus `json:"status"`
-	Output string     `json:"output"`
+	ID       string     `json:"id"`
+	Status   NodeStatus `json:"status"`
+	Output   string     `json:"output"`
+	Snapshot string     `json:"snapshot,omitempty"` // ID of the node's snapshot
 }
 
 // ExecutionProgressMsg wraps workflow.ExecutionProgress for the TUI
@@ -186,6 +212,13 @@ func buildNodeDisplayList(wf *workflow.Workflow) []NodeDisplayItem {
 	return items
 }
 
+// SetSnapshots snapshots the working tree around each prompt node so its
+// edits can be undone. Call it before Init.
+func (m *WorkflowRunModel) SetSnapshots(h *snapshot.History) {
+	m.snapshots = h
+	m.executor.SetSnapshots(h)
+}
+
 func (m *WorkflowRunModel) SetSize(width, height int) {
 	m.width = width
 	m.height = height
@@ -199,7 +232,7 @@ func (m *WorkflowRunModel) SetSize(width, height int) {
 	}
 
 	m.viewport = viewport.New(outputWidth, height-10)
-	m.viewport.SetContent(m.output.String())
+	m.viewport.SetContent(m.renderOutput())
 	m.inputField.SetWidth(outputWidth - 4)
 	m.ready = true
 }
@@ -292,7 +325,7 @@ func (m *WorkflowRunModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						}
 
 						m.output.WriteString(fmt.Sprintf("\n[Answer] %s\n", answer))
-						m.viewport.SetContent(m.output.String())
+						m.viewport.SetContent(m.renderOutput())
 						m.viewport.GotoBottom()
 
 						return m, m.waitForProgress()
@@ -326,12 +359,30 @@ func (m *WorkflowRunModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			// If completed, just exit
 			return m, nil
+		case tea.KeyCtrlO:
+			m.showTools = !m.showTools
+			m.viewport.SetContent(m.renderOutput())
+			return m, nil
+		case tea.KeyTab, tea.KeyShiftTab:
+			if !m.running && len(m.nodes) > 0 {
+				step := 1
+				if msg.Type == tea.KeyShiftTab {
+					step = len(m.nodes) - 1
+				}
+				m.selected = (m.selected + step) % len(m.nodes)
+			}
+			return m, nil
+		case tea.KeyRunes:
+			if !m.running && (msg.String() == "u" || msg.String() == "U") {
+				m.undoNode(m.selected, msg.String() == "U")
+				return m, nil
+			}
 		case tea.KeyCtrlS:
 			// Manual save
 			if m.running {
 				if err := m.saveCheckpoint(); err == nil {
 					m.output.WriteString("\n[Checkpoint saved]\n")
-					m.viewport.SetContent(m.output.String())
+					m.viewport.SetContent(m.renderOutput())
 					m.viewport.GotoBottom()
 				}
 			}
@@ -412,7 +463,21 @@ func (m *WorkflowRunModel) handleProgress(msg ExecutionProgressMsg) (tea.Model,
 			case "output":
 				m.nodes[i].Output += progress.Output
 				m.output.WriteString(progress.Output)
-				m.viewport.SetContent(m.output.String())
+				m.viewport.SetContent(m.renderOutput())
+				m.viewport.GotoBottom()
+			case "changes":
+				m.nodes[i].Snapshot = progress.Snapshot
+				m.output.WriteString(progress.Output)
+				m.viewport.SetContent(m.renderOutput())
+				m.viewport.GotoBottom()
+			case "tool":
+				m.addToolEvent(progress.Tool)
+				m.viewport.SetContent(m.renderOutput())
+				m.viewport.GotoBottom()
+			case "usage":
+				m.usage.Add(progress.Usage)
+				m.output.WriteString(fmt.Sprintf("\n[Usage] %s\n", progress.Usage))
+				m.viewport.SetContent(m.renderOutput())
 				m.viewport.GotoBottom()
 			}
 			break
@@ -427,6 +492,79 @@ func (m *WorkflowRunModel) handleProgress(msg ExecutionProgressMsg) (tea.Model,
 	return m, m.waitForProgress()
 }
 
+// undoNode restores the files node i changed. Unless force is set,
+// nothing is restored if they changed since.
+func (m *WorkflowRunModel) undoNode(i int, force bool) {
+	node := &m.nodes[i]
+	if node.Snapshot == nil {
+		m.output.WriteString(fmt.Sprintf("\n[Undo] %s made no changes to undo\n", node.Name))
+		m.viewport.SetContent(m.renderOutput())
+		m.viewport.GotoBottom()
+		return
+	}
+	switch err := m.snapshots.Undo(node.Snapshot, force); {
+	case err != nil:
+		m.output.WriteString(fmt.Sprintf("\n[Undo] Not undone: %v\n", err))
+		var changed *snapshot.ChangedError
+		if errors.As(err, &changed) {
+			m.output.WriteString("[Undo] Press U to restore them anyway\n")
+		}
+	default:
+		m.output.WriteString(fmt.Sprintf("\n[Undone] %s: restored %s\n", node.Name, node.Snapshot.Summary()))
+		node.Snapshot = nil
+	}
+	m.viewport.SetContent(m.renderOutput())
+	m.viewport.GotoBottom()
+}
+
+// hasSnapshots reports whether any node has edits to undo
+func (m *WorkflowRunModel) hasSnapshots() bool {
+	for _, node := range m.nodes {
+		if node.Snapshot != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// addToolEvent records a tool call in the block at the end of the output,
+// starting a new block if output was written since the last call
+func (m *WorkflowRunModel) addToolEvent(event *agents.ToolEvent) {
+	if event == nil {
+		return
+	}
+	if n := len(m.activity); n > 0 && m.activity[n-1].offset == m.output.Len() {
+		m.activity[n-1].tools = m.activity[n-1].tools.update(event)
+		return
+	}
+	m.activity = append(m.activity, outputActivity{
+		offset: m.output.Len(),
+		tools:  toolActivity{}.update(event),
+	})
+}
+
+// renderOutput returns the output with tool activity inserted on its own lines
+func (m *WorkflowRunModel) renderOutput() string {
+	if len(m.activity) == 0 {
+		return m.output.String()
+	}
+
+	output := m.output.String()
+	var b strings.Builder
+	prev := 0
+	for _, a := range m.activity {
+		b.WriteString(output[prev:a.offset])
+		if a.offset > 0 && !strings.HasSuffix(output[:a.offset], "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString(a.tools.render(m.showTools))
+		b.WriteString("\n")
+		prev = a.offset
+	}
+	b.WriteString(output[prev:])
+	return b.String()
+}
+
 func (m *WorkflowRunModel) View() string {
 	if !m.ready {
 		return "Loading..."
@@ -467,6 +605,10 @@ func (m *WorkflowRunModel) View() string {
 		}
 	}
 
+	if !m.usage.IsZero() {
+		statusText += mutedStyle.Render(" [" + m.usage.String() + "]")
+	}
+
 	header := headerStyle.Render("Workflow: "+workflowName) + statusText
 
 	// Node list (left panel)
@@ -501,6 +643,19 @@ func (m *WorkflowRunModel) View() string {
 			style = normalStyle
 		}
 
+		// Once the run is over, mark the node u acts on and the nodes
+		// with edits to undo
+		if !m.running && m.hasSnapshots() {
+			if i == m.selected {
+				icon = "▸" + icon
+			} else {
+				icon = " " + icon
+			}
+			if node.Snapshot != nil {
+				name += " ±"
+			}
+		}
+
 		nodeList.WriteString(fmt.Sprintf("%s %s\n", icon, style.Render(name)))
 	}
 
@@ -548,9 +703,20 @@ func (m *WorkflowRunModel) View() string {
 	if m.waitingInput {
 		helpText = helpStyle.Render("Enter: submit answer | Esc: exit menu | Ctrl+S: save")
 	} else if m.running {
-		helpText = helpStyle.Render(fmt.Sprintf("Running node %d/%d | Esc: exit menu | Ctrl+S: save", m.currentNode+1, len(m.nodes)))
+		helpText = helpStyle.Render(fmt.Sprintf("Running node %d/%d | Esc: exit menu | Ctrl+S: save | Ctrl+O: tool activity", m.currentNode+1, len(m.nodes)))
 	} else {
-		helpText = helpStyle.Render("Esc: back to workflows")
+		undo := ""
+		if m.hasSnapshots() {
+			undo = " | Tab: select node | u: undo its edits (±)"
+		}
+		helpText = helpStyle.Render("Esc: back to workflows" + undo + " | Ctrl+O: tool activity")
+		if m.completed && !m.usage.IsZero() {
+			helpText = helpStyle.Render(fmt.Sprintf("Usage: %d requests, %s in / %s out tokens, $%.4f | Esc: back to workflows%s",
+				m.usage.Requests,
+				agents.FormatTokens(m.usage.InputTokens+m.usage.CacheCreationTokens+m.usage.CacheReadTokens),
+				agents.FormatTokens(m.usage.OutputTokens),
+				m.usage.CostUSD, undo))
+		}
 	}
 
 	return lipgloss.JoinVertical(
@@ -682,11 +848,19 @@ func (m *WorkflowRunModel) saveCheckpoint() error {
 			Status: node.Status,
 			Output: node.Output,
 		}
+		if node.Snapshot != nil {
+			nodeStates[i].Snapshot = node.Snapshot.ID
+		}
 	}
 
 	// Get execution context data
 	results := m.executor.GetResults()
 
+	var activity []ActivityCheckpoint
+	for _, a := range m.activity {
+		activity = append(activity, ActivityCheckpoint{Offset: a.offset, Tools: a.tools})
+	}
+
 	checkpoint := WorkflowCheckpoint{
 		WorkflowID:    m.workflow.ID,
 		WorkflowName:  m.workflow.Name,
@@ -699,6 +873,8 @@ func (m *WorkflowRunModel) saveCheckpoint() error {
 		SavedAt:       time.Now(),
 		StartedAt:     m.startTime,
 		ElapsedBefore: time.Since(m.startTime),
+		Usage:         m.usage,
+		Activity:      activity,
 	}
 
 	// Ensure directory exists
@@ -762,19 +938,29 @@ func (m *WorkflowRunModel) DeleteCheckpoint() error {
 // RestoreFromCheckpoint restores workflow state from a checkpoint
 func (m *WorkflowRunModel) RestoreFromCheckpoint(checkpoint *WorkflowCheckpoint) {
 	m.currentNode = checkpoint.CurrentNode
+	m.usage = checkpoint.Usage
+	base := m.output.Len()
+	for _, a := range checkpoint.Activity {
+		if a.Offset <= len(checkpoint.Output) {
+			m.activity = append(m.activity, outputActivity{offset: base + a.Offset, tools: a.Tools})
+		}
+	}
 	m.output.WriteString(checkpoint.Output)
-	m.viewport.SetContent(m.output.String())
+	m.viewport.SetContent(m.renderOutput())
 
 	// Restore node states
 	for i, state := range checkpoint.NodeStates {
 		if i < len(m.nodes) {
 			m.nodes[i].Status = state.Status
 			m.nodes[i].Output = state.Output
+			if state.Snapshot != "" && m.snapshots != nil {
+				m.nodes[i].Snapshot = m.snapshots.Get(state.Snapshot)
+			}
 		}
 	}
 
 	// Add resume message
 	m.output.WriteString(fmt.Sprintf("\n[Resumed from checkpoint saved at %s]\n", checkpoint.SavedAt.Format("15:04:05")))
-	m.viewport.SetContent(m.output.String())
+	m.viewport.SetContent(m.renderOutput())
 	m.viewport.GotoBottom()
 }
@@ -3,9 +3,13 @@ package workflow
 import (
 	"context"
 	"fmt"
+	"os"
 	"sync"
 
+	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/budget"
 	"github.com/ppopcode/ppopcode/internal/orchestrator"
+	"github.com/ppopcode/ppopcode/internal/snapshot"
 )
 
 // ExecutionProgress represents progress updates during workflow execution
@@ -13,10 +17,13 @@ type ExecutionProgress struct {
 	NodeID   string
 	NodeName string
 	NodeType string
-	Status   string // "started", "output", "completed", "error", "waiting_input"
+	Status   string // "started", "output", "tool", "usage", "changes", "completed", "error", "waiting_input"
 	Output   string
-	Question string   // for askUserQuestion
-	Options  []string // for askUserQuestion
+	Question string             // for askUserQuestion
+	Options  []string           // for askUserQuestion
+	Usage    agents.Usage       // usage of a prompt node, or of the whole run when Done
+	Tool     *agents.ToolEvent  // tool call made by a prompt node's agent
+	Snapshot *snapshot.Snapshot // files a prompt node changed, for "changes"
 	Done     bool
 }
 
@@ -34,6 +41,11 @@ type Executor struct {
 	answerChan    chan string
 	answerMu      sync.Mutex
 	waitingNodeID string
+
+	usageMu sync.Mutex
+	usage   agents.Usage
+
+	snapshots *snapshot.History // nil takes no snapshots
 }
 
 func NewExecutor(workflow *Workflow, orch *orchestrator.Orchestrator) *Executor {
@@ -69,6 +81,12 @@ func (e *Executor) RegisterHandler(nodeType string, handler NodeHandler) {
 	e.handlers[nodeType] = handler
 }
 
+// SetSnapshots snapshots the working tree around each prompt node so
+// its edits can be undone
+func (e *Executor) SetSnapshots(h *snapshot.History) {
+	e.snapshots = h
+}
+
 func (e *Executor) SetVariable(key string, value interface{}) {
 	e.execCtx.Set(key, value)
 }
@@ -79,7 +97,15 @@ func (e *Executor) Execute(ctx context.Context) error {
 		return fmt.Errorf("workflow has no start node")
 	}
 
-	return e.executeNode(ctx, startNode)
+	return e.executeNode(e.withBudgetScope(ctx), startNode)
+}
+
+// withBudgetScope counts the run's prompts against the per-workflow budget
+func (e *Executor) withBudgetScope(ctx context.Context) context.Context {
+	if e.orchestrator == nil || e.orchestrator.Budget() == nil {
+		return ctx
+	}
+	return budget.WithScope(ctx, e.orchestrator.Budget().NewScope(budget.ScopeWorkflow))
 }
 
 func (e *Executor) executeNode(ctx context.Context, node *Node) error {
@@ -121,7 +147,24 @@ func (e *Executor) handleEnd(ctx context.Context, node *Node, execCtx *Execution
 func (e *Executor) handlePrompt(ctx context.Context, node *Node, execCtx *ExecutionContext) error {
 	prompt := execCtx.InterpolatePrompt(node.Data.Prompt)
 
+	// There is no progress channel to note a failed snapshot on, so it is
+	// printed as a warning; like chat, the prompt runs either way
+	snap, err := e.beginSnapshot(node)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: node %s: could not snapshot the working tree: %v\n", node.ID, err)
+	}
+	if snap != nil {
+		defer func() {
+			if err := e.snapshots.Finish(snap); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: node %s: could not record the changes for undo: %v\n", node.ID, err)
+			}
+		}()
+	}
+
 	task, err := e.orchestrator.Process(ctx, prompt)
+	if task != nil {
+		e.addUsage(task.Usage)
+	}
 	if err != nil {
 		return fmt.Errorf("orchestrator failed: %w", err)
 	}
@@ -139,6 +182,53 @@ func (e *Executor) handleQuestion(ctx context.Context, node *Node, execCtx *Exec
 	return nil
 }
 
+// beginSnapshot records the working tree before a prompt node, if
+// snapshots are on
+func (e *Executor) beginSnapshot(node *Node) (*snapshot.Snapshot, error) {
+	if e.snapshots == nil {
+		return nil, nil
+	}
+	label := node.Data.Label
+	if label == "" {
+		label = node.ID
+	}
+	return e.snapshots.Begin(fmt.Sprintf("workflow %s: %s", e.workflow.Name, label))
+}
+
+// finishSnapshot reports the files a prompt node changed
+func (e *Executor) finishSnapshot(node *Node, snap *snapshot.Snapshot, progress chan<- ExecutionProgress) {
+	update := ExecutionProgress{
+		NodeID:   node.ID,
+		NodeName: node.Data.Label,
+		NodeType: node.Type,
+		Status:   "output",
+	}
+	switch err := e.snapshots.Finish(snap); {
+	case err != nil:
+		update.Output = fmt.Sprintf("\n[Undo] Could not record the changes: %v\n", err)
+	case snap.Changed():
+		update.Status = "changes"
+		update.Snapshot = snap
+		update.Output = fmt.Sprintf("\n[Changed] %s\n", snap.Summary())
+	default:
+		return
+	}
+	progress <- update
+}
+
+// Usage returns the total usage of prompts run so far
+func (e *Executor) Usage() agents.Usage {
+	e.usageMu.Lock()
+	defer e.usageMu.Unlock()
+	return e.usage
+}
+
+func (e *Executor) addUsage(u agents.Usage) {
+	e.usageMu.Lock()
+	defer e.usageMu.Unlock()
+	e.usage.Add(u)
+}
+
 func (e *Executor) GetResults() map[string]interface{} {
 	return e.execCtx.Results
 }
@@ -152,6 +242,8 @@ func (e *Executor) GetWorkflow() *Workflow {
 func (e *Executor) ExecuteAsync(ctx context.Context) <-chan ExecutionProgress {
 	progress := make(chan ExecutionProgress, 100)
 
+	ctx = e.withBudgetScope(ctx)
+
 	go func() {
 		defer close(progress)
 
@@ -170,6 +262,7 @@ func (e *Executor) ExecuteAsync(ctx context.Context) <-chan ExecutionProgress {
 			progress <- ExecutionProgress{
 				Status: "error",
 				Output: err.Error(),
+				Usage:  e.Usage(),
 				Done:   true,
 			}
 			return
@@ -178,6 +271,7 @@ func (e *Executor) ExecuteAsync(ctx context.Context) <-chan ExecutionProgress {
 		progress <- ExecutionProgress{
 			Status: "completed",
 			Output: "Workflow completed successfully",
+			Usage:  e.Usage(),
 			Done:   true,
 		}
 	}()
@@ -291,11 +385,37 @@ func (e *Executor) handlePromptAsync(ctx context.Context, node *Node, execCtx *E
 		return fmt.Errorf("orchestrator not configured")
 	}
 
+	// Snapshot the working tree so the node's edits can be undone
+	snap, err := e.beginSnapshot(node)
+	if err != nil {
+		progress <- ExecutionProgress{
+			NodeID:   node.ID,
+			NodeName: node.Data.Label,
+			NodeType: node.Type,
+			Status:   "output",
+			Output:   fmt.Sprintf("[Undo] Could not snapshot the working tree: %v\n", err),
+		}
+	}
+	if snap != nil {
+		defer e.finishSnapshot(node, snap, progress)
+	}
+
 	// Use streaming API
 	progressChan := e.orchestrator.ProcessStreamAsync(ctx, prompt)
 
 	var result string
 	for update := range progressChan {
+		if update.Done && !update.Usage.IsZero() {
+			e.addUsage(update.Usage)
+			progress <- ExecutionProgress{
+				NodeID:   node.ID,
+				NodeName: node.Data.Label,
+				NodeType: node.Type,
+				Status:   "usage",
+				Usage:    update.Usage,
+			}
+		}
+
 		if update.Type == "output" {
 			result += update.Message
 			progress <- ExecutionProgress{
@@ -305,7 +425,25 @@ func (e *Executor) handlePromptAsync(ctx context.Context, node *Node, execCtx *E
 				Status:   "output",
 				Output:   update.Message,
 			}
-		} else if update.Type == "status" || update.Type == "thinking" {
+		} else if update.Type == "status" || update.Type == "thinking" || update.Type == "warning" || (update.Type == "error" && !update.Done) {
+			progress <- ExecutionProgress{
+				NodeID:   node.ID,
+				NodeName: node.Data.Label,
+				NodeType: node.Type,
+				Status:   "output",
+				Output:   "[" + update.Agent + "] " + update.Message,
+			}
+		} else if update.Type == "tool_use" || update.Type == "tool_result" {
+			progress <- ExecutionProgress{
+				NodeID:   node.ID,
+				NodeName: node.Data.Label,
+				NodeType: node.Type,
+				Status:   "tool",
+				Tool:     update.Tool,
+			}
+		} else if update.Type == "fallback" {
+			// Start over with the answer of the next agent
+			result = ""
 			progress <- ExecutionProgress{
 				NodeID:   node.ID,
 				NodeName: node.Data.Label,
@@ -313,8 +451,12 @@ func (e *Executor) handlePromptAsync(ctx context.Context, node *Node, execCtx *E
 				Status:   "output",
 				Output:   "[" + update.Agent + "] " + update.Message,
 			}
-		} else if update.Type == "error" {
+		} else if update.Type == "error" && update.Done {
 			return fmt.Errorf("orchestrator error: %s", update.Message)
+		} else if update.Type == "cancelled" {
+			return fmt.Errorf("%s", update.Message)
+		} else if update.Type == "budget" {
+			return fmt.Errorf("%s", update.Message)
 		}
 	}
 
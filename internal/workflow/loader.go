@@ -38,13 +38,13 @@ type Connection struct {
 }
 
 type Workflow struct {
-	ID            string       `json:"id"`
-	Name          string       `json:"name"`
-	Version       string       `json:"version"`
-	Nodes         []Node       `json:"nodes"`
-	Connections   []Connection `json:"connections"`
-	CreatedAt     string       `json:"createdAt"`
-	UpdatedAt     string       `json:"updatedAt"`
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	Version       string        `json:"version"`
+	Nodes         []Node        `json:"nodes"`
+	Connections   []Connection  `json:"connections"`
+	CreatedAt     string        `json:"createdAt"`
+	UpdatedAt     string        `json:"updatedAt"`
 	SubAgentFlows []interface{} `json:"subAgentFlows"`
 }
 
@@ -3,9 +3,14 @@ package workflow
 import (
 	"context"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/ppopcode/ppopcode/internal/agents"
+	"github.com/ppopcode/ppopcode/internal/orchestrator"
+	"github.com/ppopcode/ppopcode/internal/snapshot"
 )
 
 // Test fixtures
@@ -545,6 +550,133 @@ func TestExecutor_ExecuteAsync_CancelContext(t *testing.T) {
 	}
 }
 
+func TestExecutor_ExecuteAsync_PromptWithMockAgent(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "mock.yaml")
+	script := `responses:
+  - match: "^Hello Ada!$"
+    chunks:
+      - {type: thinking, content: "Greeting"}
+      - {type: output, content: "Hi Ada"}
+    usage: {input_tokens: 3, output_tokens: 2}
+`
+	if err := os.WriteFile(fixture, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	orch := orchestrator.New(map[string]agents.AgentConfig{
+		"sonnet": {Name: "sonnet", Type: agents.AgentTypeMock, Fixture: fixture},
+	})
+
+	wf := createSimpleWorkflow()
+	wf.Nodes = append(wf.Nodes, Node{ID: "greet", Type: "prompt", Data: NodeData{Label: "Greet", Prompt: "Hello {{name}}!"}})
+	wf.Connections = []Connection{
+		{ID: "conn-1", From: "start", To: "greet"},
+		{ID: "conn-2", From: "greet", To: "end"},
+	}
+	executor := NewExecutor(wf, orch)
+	executor.SetVariable("name", "Ada")
+
+	var last ExecutionProgress
+	for progress := range executor.ExecuteAsync(context.Background()) {
+		last = progress
+	}
+
+	if last.Status != "completed" || !last.Done {
+		t.Fatalf("final progress = %+v, want completed", last)
+	}
+	if got := executor.GetResults()["greet"]; got != "Hi Ada" {
+		t.Errorf("result of greet = %v, want %q", got, "Hi Ada")
+	}
+	if usage := executor.Usage(); usage.Total() != 5 {
+		t.Errorf("Usage() = %+v, want 5 tokens", usage)
+	}
+}
+
+func TestExecutor_ExecuteAsync_PromptCancelled(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "mock.yaml")
+	script := `responses:
+  - chunks:
+      - {type: output, content: "Half"}
+      - {type: output, content: " done", delay: 10s}
+`
+	if err := os.WriteFile(fixture, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	orch := orchestrator.New(map[string]agents.AgentConfig{
+		"sonnet": {Name: "sonnet", Type: agents.AgentTypeMock, Fixture: fixture},
+	})
+
+	wf := createSimpleWorkflow()
+	wf.Nodes = append(wf.Nodes, Node{ID: "edit", Type: "prompt", Data: NodeData{Label: "Edit", Prompt: "Edit it"}})
+	wf.Connections = []Connection{
+		{ID: "conn-1", From: "start", To: "edit"},
+		{ID: "conn-2", From: "edit", To: "end"},
+	}
+	executor := NewExecutor(wf, orch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last ExecutionProgress
+	for progress := range executor.ExecuteAsync(ctx) {
+		if progress.Status == "output" && progress.Output == "Half" {
+			cancel()
+		}
+		if progress.NodeID == "end" {
+			t.Errorf("end node reached after the prompt was cancelled: %+v", progress)
+		}
+		last = progress
+	}
+
+	if last.Status != "error" || !last.Done {
+		t.Fatalf("final progress = %+v, want an error", last)
+	}
+	if _, ok := executor.GetResults()["edit"]; ok {
+		t.Error("a cancelled prompt should leave no result")
+	}
+}
+
+func TestExecutor_Execute_SnapshotFailureDoesNotFailPrompt(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	if out, err := exec.Command("git", "-C", repo, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	snapshots, err := snapshot.Open(repo, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Snapshots fail once the repository is gone
+	if err := os.RemoveAll(filepath.Join(repo, ".git")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(repo))
+
+	fixture := filepath.Join(t.TempDir(), "mock.yaml")
+	if err := os.WriteFile(fixture, []byte("responses:\n  - content: Done\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	orch := orchestrator.New(map[string]agents.AgentConfig{
+		"sonnet": {Name: "sonnet", Type: agents.AgentTypeMock, Fixture: fixture},
+	})
+
+	wf := createSimpleWorkflow()
+	wf.Nodes = append(wf.Nodes, Node{ID: "edit", Type: "prompt", Data: NodeData{Label: "Edit", Prompt: "Edit it"}})
+	wf.Connections = []Connection{
+		{ID: "conn-1", From: "start", To: "edit"},
+		{ID: "conn-2", From: "edit", To: "end"},
+	}
+	executor := NewExecutor(wf, orch)
+	executor.SetSnapshots(snapshots)
+
+	if err := executor.Execute(context.Background()); err != nil {
+		t.Fatalf("Execute() error = %v, want the snapshot failure only warned about", err)
+	}
+	if got := executor.GetResults()["edit"]; got != "Done" {
+		t.Errorf("result of edit = %v, want %q", got, "Done")
+	}
+}
+
 // ============ Loader Tests ============
 
 func TestLoader_Load(t *testing.T) {